@@ -0,0 +1,97 @@
+#!/usr/bin/env python
+"""
+Export workspace configuration to a file, or import one into this environment.
+
+    python config_sync.py export -o config/workspace.yaml
+    python config_sync.py export --format json --sections positions,templates
+    python config_sync.py plan config/workspace.yaml
+    python config_sync.py apply config/workspace.yaml --actor admin@example.com
+
+The file holds positions, templates, lead scoring rules, route group rate
+limits, volume anomaly settings and Facebook page settings. Secrets and
+customer data are never exported. `plan` prints what an import would change
+without writing anything; `apply` imports the whole file or nothing and is
+recorded in the audit log like imports made from the admin API.
+"""
+import argparse
+import sys
+from pathlib import Path
+
+from sqlalchemy import func
+
+from database import SessionLocal
+from models import User
+from routes.workspace_config import (
+    ACTION_INVALID,
+    ACTION_UNCHANGED,
+    apply_config,
+    dump_config,
+    export_config,
+    parse_config,
+    plan_config,
+    plan_summary,
+)
+
+
+def _print_plan(entries) -> None:
+    for entry in entries:
+        if entry["action"] == ACTION_UNCHANGED:
+            continue
+        line = f"{entry['action']:>9}  {entry['section']}/{entry['key']}"
+        if entry["changes"]:
+            line += f"  ({', '.join(sorted(entry['changes']))})"
+        if entry["reason"]:
+            line += f"  - {entry['reason']}"
+        print(line)
+    print(f"Summary: {plan_summary(entries)}")
+
+
+def main() -> int:
+    parser = argparse.ArgumentParser(description="Workspace configuration as code")
+    parser.add_argument("command", choices=("export", "plan", "apply"))
+    parser.add_argument("file", nargs="?", help="Configuration file to plan or apply")
+    parser.add_argument("-o", "--output", help="Write the export here instead of stdout")
+    parser.add_argument("--format", choices=("yaml", "json"), default=None, help="Export format (default: from -o, else yaml)")
+    parser.add_argument("--sections", default="", help="Comma-separated sections to export or import")
+    parser.add_argument("--actor", help="Email of the admin recorded as making the import")
+    args = parser.parse_args()
+    sections = [item for item in args.sections.split(",") if item.strip()]
+
+    with SessionLocal() as db:
+        try:
+            if args.command == "export":
+                fmt = args.format or ("json" if (args.output or "").endswith(".json") else "yaml")
+                content = dump_config(export_config(db, sections), fmt)
+                if args.output:
+                    Path(args.output).write_text(content, encoding="utf-8")
+                    print(f"Configuration written to {args.output}")
+                else:
+                    sys.stdout.write(content)
+                return 0
+
+            if not args.file:
+                parser.error("a configuration file is required for plan and apply")
+            document = parse_config(Path(args.file).read_text(encoding="utf-8"))
+            actor = None
+            if args.actor:
+                actor = db.query(User).filter(func.lower(User.email) == args.actor.strip().lower()).first()
+                if not actor:
+                    print(f"User {args.actor} not found")
+                    return 1
+
+            if args.command == "plan":
+                entries = plan_config(db, document, actor, sections)
+                _print_plan(entries)
+                return 1 if any(entry["action"] == ACTION_INVALID for entry in entries) else 0
+
+            entries = apply_config(db, document, actor, sections)
+            _print_plan(entries)
+            print("Configuration applied")
+        except (ValueError, RuntimeError) as exc:
+            print(f"Error: {exc}")
+            return 1
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
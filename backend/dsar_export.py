@@ -0,0 +1,56 @@
+#!/usr/bin/env python
+"""
+Build a data subject access export from the command line.
+
+    python dsar_export.py phone "+91 98661 18236" --reason "Email request 12 Oct"
+    python dsar_export.py email jane@example.com
+    python dsar_export.py platform_user 1784xxxxxxxxxxxx
+    python dsar_export.py --rerun <export_id>
+
+The archive is written to DSAR_EXPORT_DIR and the request is recorded in the
+audit log the same way as exports requested from the admin API.
+"""
+import argparse
+import sys
+
+from database import SessionLocal
+from models import DataExportRequest
+from routes.data_exports import create_export_request, run_data_export
+from utils.dsar import SUBJECT_TYPES
+
+
+def main() -> int:
+    parser = argparse.ArgumentParser(description="Export everything stored about one contact")
+    parser.add_argument("subject_type", nargs="?", choices=SUBJECT_TYPES)
+    parser.add_argument("subject_value", nargs="?")
+    parser.add_argument("--reason", default=None, help="Why the export was requested (kept in the audit log)")
+    parser.add_argument("--rerun", metavar="EXPORT_ID", help="Run an existing export request again")
+    args = parser.parse_args()
+
+    with SessionLocal() as db:
+        if args.rerun:
+            export = db.query(DataExportRequest).filter(DataExportRequest.id == args.rerun).first()
+            if not export:
+                print(f"Export {args.rerun} not found")
+                return 1
+        else:
+            if not args.subject_type or not args.subject_value:
+                parser.error("subject_type and subject_value are required")
+            try:
+                export = create_export_request(db, args.subject_type, args.subject_value, None, args.reason)
+            except ValueError as exc:
+                print(f"Error: {exc}")
+                return 1
+            print(f"Created export {export.id}")
+
+        export = run_data_export(db, export)
+        if export.status != "completed":
+            print(f"Export {export.id} failed: {export.error}")
+            return 1
+        print(f"Export {export.id} written to {export.file_path} ({export.file_size} bytes)")
+        print(f"Summary: {export.summary_json}")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,64 @@
+#!/usr/bin/env python
+"""
+Encrypt existing message content, attachment metadata, raw webhook payloads,
+reply previews and the last-message copies on chats and contacts in batches,
+or re-encrypt them after a key rotation.
+
+    python encrypt_fields.py                      # all tables, until done
+    python encrypt_fields.py --table instagram_messages --batch-size 200
+    python encrypt_fields.py --reindex            # also rebuild the blind search index
+
+Requires FIELD_ENCRYPTION_ENABLED=true and an active key for the workspace in
+FIELD_ENCRYPTION_KEYS. Safe to stop and re-run: rows already encrypted with the
+active key are skipped.
+"""
+import argparse
+import sys
+
+from database import SessionLocal
+from routes.field_encryption import ENCRYPTED_TABLES, run_encryption_pass
+from utils.audit import record_audit
+from utils.field_crypto import FieldEncryptionError, get_keyring
+
+
+def main() -> int:
+    parser = argparse.ArgumentParser(description="Encrypt / re-encrypt sensitive message columns")
+    parser.add_argument("--table", action="append", choices=sorted(ENCRYPTED_TABLES), help="Limit to a table (repeatable)")
+    parser.add_argument("--batch-size", type=int, default=500)
+    parser.add_argument("--max-batches", type=int, default=None, help="Stop after this many batches per table")
+    parser.add_argument("--reindex", action="store_true", help="Rebuild blind index tokens for every row")
+    args = parser.parse_args()
+
+    with SessionLocal() as db:
+        try:
+            results = run_encryption_pass(
+                db,
+                tables=args.table,
+                batch_size=args.batch_size,
+                max_batches=args.max_batches,
+                reindex=args.reindex,
+            )
+        except FieldEncryptionError as exc:
+            print(f"Error: {exc}")
+            return 1
+        record_audit(
+            db,
+            None,
+            "field_encryption.run",
+            "field_encryption",
+            get_keyring().workspace,
+            {"batch_size": args.batch_size, "max_batches": args.max_batches, "reindex": args.reindex, "results": results},
+        )
+        db.commit()
+
+    for table, totals in results.items():
+        state = "done" if totals["done"] else "more rows remaining"
+        print(
+            f"{table}: scanned {totals['scanned']}, encrypted {totals['encrypted']}, "
+            f"unreadable {totals['unreadable']} ({state})"
+        )
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -5,7 +5,7 @@ import logging
 import os
 from typing import Dict, Any, Optional, List
 from datetime import datetime
-from utils.timezone import utc_now
+from utils.timezone import parse_graph_timestamp, utc_now
 from enum import Enum
 
 GRAPH_VERSION = os.getenv("GRAPH_VERSION", "v18.0")
@@ -561,9 +561,59 @@ class FacebookMessengerClient:
             "text": message_text,
             "has_attachments": len(attachments) > 0,
             "attachments": attachments,
+            "reply_to": message_data.get("reply_to") or None,
             "timestamp": utc_now()
         }
-    
+
+    async def get_recent_conversations(
+        self,
+        page_access_token: str,
+        since: datetime,
+        limit: int = 25,
+        message_limit: int = 25,
+        max_pages: int = 4
+    ) -> Dict[str, Any]:
+        """List Facebook conversations updated since `since`, with their latest messages."""
+        if self.mode == FacebookMode.MOCK:
+            logger.info("MOCK MODE: Skipping Facebook conversations lookup")
+            return {"success": True, "conversations": [], "mode": "mock"}
+
+        url: Optional[str] = f"{self.BASE_URL}/me/conversations"
+        params: Optional[Dict[str, Any]] = {
+            "access_token": page_access_token,
+            "fields": f"id,updated_time,messages.limit({message_limit}){{id,created_time,from,to,message,attachments}}",
+            "limit": limit
+        }
+        conversations: List[Dict[str, Any]] = []
+        try:
+            for _ in range(max_pages):
+                if not url:
+                    break
+                response = await self.client.get(url, params=params)
+                data = response.json() if response.content else {}
+                if response.status_code != 200:
+                    error_message = (data.get("error") or {}).get("message", "Unknown error")
+                    logger.error(f"Failed to fetch Facebook conversations: {error_message}")
+                    return {"success": False, "error": error_message, "conversations": conversations, "mode": "real"}
+
+                reached_older = False
+                for conversation in data.get("data", []):
+                    updated_at = parse_graph_timestamp(conversation.get("updated_time"))
+                    if updated_at and updated_at < since:
+                        reached_older = True
+                        break
+                    conversations.append(conversation)
+                if reached_older:
+                    break
+                # The next link already carries the query parameters
+                url = (data.get("paging") or {}).get("next")
+                params = None
+        except Exception as e:
+            logger.error(f"Error fetching Facebook conversations: {e}")
+            return {"success": False, "error": str(e), "conversations": conversations, "mode": "real"}
+
+        return {"success": True, "conversations": conversations, "mode": "real"}
+
     async def get_user_profile(
         self,
         page_access_token: str,
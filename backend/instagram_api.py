@@ -7,7 +7,7 @@ from typing import Dict, Any, Optional, List
 from datetime import datetime, timezone, timedelta
 from enum import Enum
 import asyncio
-from utils.timezone import utc_now
+from utils.timezone import parse_graph_timestamp, utc_now
 
 logger = logging.getLogger(__name__)
 
@@ -345,7 +345,8 @@ class InstagramClient:
         attachments = message_data.get("attachments", [])
         
         # Check if this is a story reply or mention
-        is_story_reply = message_data.get("is_echo", False) is False and "story" in message_data.get("reply_to", {}).get("story", {})
+        reply_to = message_data.get("reply_to") or None
+        is_story_reply = message_data.get("is_echo", False) is False and bool((reply_to or {}).get("story"))
         
         logger.info(f"Processing Instagram message from {sender_id} on account {instagram_account_id}")
         
@@ -369,6 +370,7 @@ class InstagramClient:
             "has_attachments": len(attachments) > 0,
             "attachments": attachments,
             "is_story_reply": is_story_reply,
+            "reply_to": reply_to,
             "timestamp": utc_now(),
             "sender_name": sender_name,
             "sender_username": sender_username
@@ -445,7 +447,57 @@ class InstagramClient:
                 "error": str(e),
                 "mode": "real"
             }
-    
+
+    async def get_recent_conversations(
+        self,
+        page_access_token: str,
+        since: datetime,
+        limit: int = 25,
+        message_limit: int = 25,
+        max_pages: int = 4
+    ) -> Dict[str, Any]:
+        """List Instagram conversations updated since `since`, with their latest messages."""
+        if self.mode == InstagramMode.MOCK:
+            logger.info("MOCK MODE: Skipping Instagram conversations lookup")
+            return {"success": True, "conversations": [], "mode": "mock"}
+
+        url: Optional[str] = f"{self.BASE_URL}/me/conversations"
+        params: Optional[Dict[str, Any]] = {
+            "access_token": page_access_token,
+            "platform": "instagram",
+            "fields": f"id,updated_time,messages.limit({message_limit}){{id,created_time,from,to,message,attachments}}",
+            "limit": limit
+        }
+        conversations: List[Dict[str, Any]] = []
+        try:
+            for _ in range(max_pages):
+                if not url:
+                    break
+                response = await self.client.get(url, params=params)
+                data = response.json() if response.content else {}
+                if response.status_code != 200:
+                    error_message = (data.get("error") or {}).get("message", "Unknown error")
+                    logger.error(f"Failed to fetch Instagram conversations: {error_message}")
+                    return {"success": False, "error": error_message, "conversations": conversations, "mode": "real"}
+
+                reached_older = False
+                for conversation in data.get("data", []):
+                    updated_at = parse_graph_timestamp(conversation.get("updated_time"))
+                    if updated_at and updated_at < since:
+                        reached_older = True
+                        break
+                    conversations.append(conversation)
+                if reached_older:
+                    break
+                # The next link already carries the query parameters
+                url = (data.get("paging") or {}).get("next")
+                params = None
+        except Exception as e:
+            logger.error(f"Error fetching Instagram conversations: {e}")
+            return {"success": False, "error": str(e), "conversations": conversations, "mode": "real"}
+
+        return {"success": True, "conversations": conversations, "mode": "real"}
+
     async def handle_story_mention(
         self,
         page_access_token: str,
@@ -0,0 +1,78 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251215_120000_payment_requests"
+down_revision = "20251210_150000_can_receive_new_chats"
+branch_labels = None
+depends_on = None
+
+
+# SQLAlchemy persists Python enum members by name.
+PAYMENT_STATUS = sa.Enum("PENDING", "PAID", "FAILED", "CANCELLED", "EXPIRED", name="paymentrequeststatus")
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "payment_requests" not in tables:
+        op.create_table(
+            "payment_requests",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("chat_id", sa.String(36), sa.ForeignKey("chats.id"), nullable=False),
+            sa.Column("reference", sa.String(100), nullable=False),
+            sa.Column("inquiry_id", sa.String(100), nullable=True),
+            sa.Column("amount", sa.Numeric(12, 2), nullable=False),
+            sa.Column("currency", sa.String(10), nullable=False, server_default="INR"),
+            sa.Column("description", sa.Text(), nullable=True),
+            sa.Column("provider", sa.String(50), nullable=False),
+            sa.Column("provider_payment_id", sa.String(255), nullable=True),
+            sa.Column("payment_url", sa.Text(), nullable=True),
+            sa.Column("status", PAYMENT_STATUS, nullable=False, server_default="PENDING"),
+            sa.Column("amount_received", sa.Numeric(12, 2), nullable=True),
+            sa.Column("message_id", sa.String(36), nullable=True),
+            sa.Column("confirmation_message_id", sa.String(36), nullable=True),
+            sa.Column("created_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("expires_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("paid_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.Column("updated_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_payment_requests_chat_id", "payment_requests", ["chat_id"])
+        op.create_index("ix_payment_requests_reference", "payment_requests", ["reference"], unique=True)
+        op.create_index("ix_payment_requests_inquiry_id", "payment_requests", ["inquiry_id"])
+        op.create_index("ix_payment_requests_provider_payment_id", "payment_requests", ["provider_payment_id"])
+        op.create_index("ix_payment_requests_status", "payment_requests", ["status"])
+
+    if "payment_events" not in tables:
+        op.create_table(
+            "payment_events",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("payment_request_id", sa.String(36), sa.ForeignKey("payment_requests.id"), nullable=True),
+            sa.Column("provider", sa.String(50), nullable=False),
+            sa.Column("reference", sa.String(100), nullable=True),
+            sa.Column("provider_payment_id", sa.String(255), nullable=True),
+            sa.Column("status", sa.String(50), nullable=True),
+            sa.Column("amount", sa.Numeric(12, 2), nullable=True),
+            sa.Column("currency", sa.String(10), nullable=True),
+            sa.Column("payload_json", sa.Text(), nullable=True),
+            sa.Column("note", sa.Text(), nullable=True),
+            sa.Column("received_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_payment_events_payment_request_id", "payment_events", ["payment_request_id"])
+        op.create_index("ix_payment_events_reference", "payment_events", ["reference"])
+        op.create_index("ix_payment_events_received_at", "payment_events", ["received_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    if "payment_events" in tables:
+        op.drop_table("payment_events")
+    if "payment_requests" in tables:
+        op.drop_table("payment_requests")
+    if conn.dialect.name.lower() in ("postgresql", "postgres"):
+        PAYMENT_STATUS.drop(conn, checkfirst=True)
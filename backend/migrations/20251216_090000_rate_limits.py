@@ -0,0 +1,69 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251216_090000_rate_limits"
+down_revision = "20251215_120000_payment_requests"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "rate_limit_rules" not in tables:
+        op.create_table(
+            "rate_limit_rules",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("scope", sa.String(20), nullable=False),
+            sa.Column("subject", sa.String(255), nullable=True),
+            sa.Column("route_group", sa.String(50), nullable=False, server_default="*"),
+            sa.Column("limit", sa.Integer(), nullable=False),
+            sa.Column("window_seconds", sa.Integer(), nullable=False, server_default="60"),
+            sa.Column("note", sa.Text(), nullable=True),
+            sa.Column("created_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.Column("updated_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_rate_limit_rules_scope", "rate_limit_rules", ["scope"])
+        op.create_index("ix_rate_limit_rules_subject", "rate_limit_rules", ["subject"])
+
+    if "rate_limit_counters" not in tables:
+        op.create_table(
+            "rate_limit_counters",
+            sa.Column("bucket_key", sa.String(255), primary_key=True),
+            sa.Column("window_start", sa.BigInteger(), primary_key=True),
+            sa.Column("count", sa.Integer(), nullable=False, server_default="0"),
+        )
+
+    if "rate_limit_throttles" not in tables:
+        op.create_table(
+            "rate_limit_throttles",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("identity", sa.String(255), nullable=False),
+            sa.Column("identity_type", sa.String(20), nullable=False),
+            sa.Column("user_id", sa.String(36), nullable=True),
+            sa.Column("route_group", sa.String(50), nullable=False),
+            sa.Column("window_start", sa.BigInteger(), nullable=False),
+            sa.Column("limit", sa.Integer(), nullable=False),
+            sa.Column("window_seconds", sa.Integer(), nullable=False),
+            sa.Column("blocked_count", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("last_path", sa.String(512), nullable=True),
+            sa.Column("first_blocked_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.Column("last_blocked_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.UniqueConstraint("identity", "route_group", "window_start", name="uq_rate_limit_throttle_window"),
+        )
+        op.create_index("ix_rate_limit_throttles_identity", "rate_limit_throttles", ["identity"])
+        op.create_index("ix_rate_limit_throttles_user_id", "rate_limit_throttles", ["user_id"])
+        op.create_index("ix_rate_limit_throttles_last_blocked_at", "rate_limit_throttles", ["last_blocked_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    for table in ("rate_limit_throttles", "rate_limit_counters", "rate_limit_rules"):
+        if table in tables:
+            op.drop_table(table)
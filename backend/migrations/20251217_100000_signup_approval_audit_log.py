@@ -0,0 +1,68 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251217_100000_signup_approval_audit_log"
+down_revision = "20251216_090000_rate_limits"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    user_columns = {col["name"] for col in inspector.get_columns("users")}
+
+    if "approval_status" not in user_columns:
+        # SQLAlchemy persists Python enum members by name.
+        op.add_column(
+            "users",
+            sa.Column(
+                "approval_status",
+                sa.Enum("PENDING", "APPROVED", "REJECTED", name="userapprovalstatus"),
+                nullable=False,
+                server_default="APPROVED",
+            ),
+        )
+        op.create_index("ix_users_approval_status", "users", ["approval_status"])
+    if "approval_note" not in user_columns:
+        op.add_column("users", sa.Column("approval_note", sa.Text(), nullable=True))
+    if "approval_decided_by" not in user_columns:
+        op.add_column("users", sa.Column("approval_decided_by", sa.String(36), nullable=True))
+    if "approval_decided_at" not in user_columns:
+        op.add_column("users", sa.Column("approval_decided_at", sa.DateTime(timezone=True), nullable=True))
+
+    if "audit_logs" not in tables:
+        op.create_table(
+            "audit_logs",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("actor_id", sa.String(36), nullable=True),
+            sa.Column("actor_label", sa.String(255), nullable=True),
+            sa.Column("action", sa.String(100), nullable=False),
+            sa.Column("entity_type", sa.String(50), nullable=False),
+            sa.Column("entity_id", sa.String(255), nullable=True),
+            sa.Column("details_json", sa.Text(), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_audit_logs_actor_id", "audit_logs", ["actor_id"])
+        op.create_index("ix_audit_logs_action", "audit_logs", ["action"])
+        op.create_index("ix_audit_logs_entity_type", "audit_logs", ["entity_type"])
+        op.create_index("ix_audit_logs_entity_id", "audit_logs", ["entity_id"])
+        op.create_index("ix_audit_logs_created_at", "audit_logs", ["created_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    user_columns = {col["name"] for col in inspector.get_columns("users")}
+
+    if "audit_logs" in tables:
+        op.drop_table("audit_logs")
+    for column in ("approval_decided_at", "approval_decided_by", "approval_note"):
+        if column in user_columns:
+            op.drop_column("users", column)
+    if "approval_status" in user_columns:
+        op.drop_index("ix_users_approval_status", table_name="users")
+        op.drop_column("users", "approval_status")
@@ -0,0 +1,69 @@
+import json
+
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251218_090000_message_mids"
+down_revision = "20251217_100000_signup_approval_audit_log"
+branch_labels = None
+depends_on = None
+
+MESSAGE_TABLES = ("instagram_messages", "facebook_messages")
+BACKFILL_BATCH_SIZE = 500
+
+
+def _backfill_mids_from_metadata(conn, table_name: str) -> None:
+    """Copy facebook_mid/mid from metadata_json into the new column."""
+    select_sql = sa.text(
+        f"SELECT id, metadata_json FROM {table_name} "
+        "WHERE mid IS NULL AND metadata_json LIKE :pattern AND id > :last_id "
+        "ORDER BY id LIMIT :limit"
+    )
+    update_sql = sa.text(f"UPDATE {table_name} SET mid = :mid WHERE id = :id")
+    last_id = ""
+    while True:
+        rows = conn.execute(
+            select_sql,
+            {"pattern": '%"%mid"%', "last_id": last_id, "limit": BACKFILL_BATCH_SIZE},
+        ).fetchall()
+        if not rows:
+            break
+        for row_id, metadata_json in rows:
+            last_id = row_id
+            try:
+                meta = json.loads(metadata_json or "{}")
+            except (TypeError, ValueError):
+                continue
+            mid = (meta.get("facebook_mid") or meta.get("mid")) if isinstance(meta, dict) else None
+            if mid and len(str(mid)) <= 512:
+                conn.execute(update_sql, {"mid": str(mid), "id": row_id})
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    for table_name in MESSAGE_TABLES:
+        if table_name not in tables:
+            continue
+        columns = {col["name"] for col in inspector.get_columns(table_name)}
+        if "mid" not in columns:
+            op.add_column(table_name, sa.Column("mid", sa.String(512), nullable=True))
+            op.create_index(f"ix_{table_name}_mid", table_name, ["mid"])
+        _backfill_mids_from_metadata(conn, table_name)
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    for table_name in MESSAGE_TABLES:
+        if table_name not in tables:
+            continue
+        columns = {col["name"] for col in inspector.get_columns(table_name)}
+        if "mid" in columns:
+            op.drop_index(f"ix_{table_name}_mid", table_name=table_name)
+            op.drop_column(table_name, "mid")
@@ -0,0 +1,36 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251219_090000_chat_queue_notices"
+down_revision = "20251218_090000_message_mids"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "chat_queue_notices" not in tables:
+        op.create_table(
+            "chat_queue_notices",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("chat_id", sa.String(36), sa.ForeignKey("chats.id"), nullable=False),
+            sa.Column("message_id", sa.String(36), nullable=True),
+            sa.Column("reason", sa.String(20), nullable=False),
+            sa.Column("position", sa.Integer(), nullable=True),
+            sa.Column("estimated_wait_minutes", sa.Integer(), nullable=True),
+            sa.Column("locale", sa.String(10), nullable=True),
+            sa.Column("sent_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_chat_queue_notices_chat_id", "chat_queue_notices", ["chat_id"])
+        op.create_index("ix_chat_queue_notices_sent_at", "chat_queue_notices", ["sent_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "chat_queue_notices" in set(inspector.get_table_names()):
+        op.drop_table("chat_queue_notices")
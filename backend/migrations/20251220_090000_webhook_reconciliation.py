@@ -0,0 +1,60 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251220_090000_webhook_reconciliation"
+down_revision = "20251219_090000_chat_queue_notices"
+branch_labels = None
+depends_on = None
+
+MESSAGE_TABLES = ("instagram_messages", "facebook_messages")
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    for table_name in MESSAGE_TABLES:
+        if table_name not in tables:
+            continue
+        columns = {col["name"] for col in inspector.get_columns(table_name)}
+        if "is_reconciled" not in columns:
+            op.add_column(
+                table_name,
+                sa.Column("is_reconciled", sa.Boolean(), nullable=False, server_default="0"),
+            )
+
+    if "webhook_reconciliation_runs" not in tables:
+        op.create_table(
+            "webhook_reconciliation_runs",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("platform", sa.Enum("INSTAGRAM", "FACEBOOK", name="messageplatform"), nullable=False),
+            sa.Column("account_id", sa.String(255), nullable=False),
+            sa.Column("started_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.Column("finished_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("conversations_checked", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("messages_checked", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("missing_found", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("ingested", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("expected_hourly_rate", sa.Float(), nullable=True),
+            sa.Column("observed_recent", sa.Integer(), nullable=True),
+            sa.Column("silence_alert", sa.Boolean(), nullable=False, server_default="0"),
+            sa.Column("error", sa.Text(), nullable=True),
+        )
+        op.create_index("ix_webhook_reconciliation_runs_account_id", "webhook_reconciliation_runs", ["account_id"])
+        op.create_index("ix_webhook_reconciliation_runs_started_at", "webhook_reconciliation_runs", ["started_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    if "webhook_reconciliation_runs" in tables:
+        op.drop_table("webhook_reconciliation_runs")
+    for table_name in MESSAGE_TABLES:
+        if table_name not in tables:
+            continue
+        columns = {col["name"] for col in inspector.get_columns(table_name)}
+        if "is_reconciled" in columns:
+            op.drop_column(table_name, "is_reconciled")
@@ -0,0 +1,36 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251221_090000_agent_shifts"
+down_revision = "20251220_090000_webhook_reconciliation"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "agent_shifts" not in tables:
+        op.create_table(
+            "agent_shifts",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("user_id", sa.String(36), sa.ForeignKey("users.id"), nullable=False),
+            sa.Column("starts_at", sa.DateTime(timezone=True), nullable=False),
+            sa.Column("ends_at", sa.DateTime(timezone=True), nullable=False),
+            sa.Column("note", sa.String(255), nullable=True),
+            sa.Column("created_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_agent_shifts_user_id", "agent_shifts", ["user_id"])
+        op.create_index("ix_agent_shifts_starts_at", "agent_shifts", ["starts_at"])
+        op.create_index("ix_agent_shifts_ends_at", "agent_shifts", ["ends_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "agent_shifts" in set(inspector.get_table_names()):
+        op.drop_table("agent_shifts")
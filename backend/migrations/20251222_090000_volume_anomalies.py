@@ -0,0 +1,60 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251222_090000_volume_anomalies"
+down_revision = "20251221_090000_agent_shifts"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "volume_anomaly_settings" not in tables:
+        op.create_table(
+            "volume_anomaly_settings",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("scope", sa.String(300), nullable=False),
+            sa.Column("sensitivity", sa.String(20), nullable=True),
+            sa.Column("spikes_enabled", sa.Boolean(), nullable=False, server_default="1"),
+            sa.Column("drops_enabled", sa.Boolean(), nullable=False, server_default="1"),
+            sa.Column("snoozed_until", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("snooze_reason", sa.String(255), nullable=True),
+            sa.Column("updated_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("updated_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_volume_anomaly_settings_scope", "volume_anomaly_settings", ["scope"], unique=True)
+
+    if "volume_anomaly_events" not in tables:
+        op.create_table(
+            "volume_anomaly_events",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("scope", sa.String(300), nullable=False),
+            sa.Column("platform", sa.Enum("INSTAGRAM", "FACEBOOK", name="messageplatform"), nullable=False),
+            sa.Column("account_id", sa.String(255), nullable=True),
+            sa.Column("kind", sa.String(10), nullable=False),
+            sa.Column("window_start", sa.DateTime(timezone=True), nullable=False),
+            sa.Column("window_end", sa.DateTime(timezone=True), nullable=False),
+            sa.Column("observed", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("expected", sa.Float(), nullable=False, server_default="0"),
+            sa.Column("score", sa.Float(), nullable=False, server_default="0"),
+            sa.Column("context_json", sa.Text(), nullable=True),
+            sa.Column("alerted", sa.Boolean(), nullable=False, server_default="0"),
+            sa.Column("suppressed_reason", sa.String(50), nullable=True),
+            sa.Column("detected_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_volume_anomaly_events_scope", "volume_anomaly_events", ["scope"])
+        op.create_index("ix_volume_anomaly_events_detected_at", "volume_anomaly_events", ["detected_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    if "volume_anomaly_events" in tables:
+        op.drop_table("volume_anomaly_events")
+    if "volume_anomaly_settings" in tables:
+        op.drop_table("volume_anomaly_settings")
@@ -0,0 +1,43 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251223_090000_soft_delete"
+down_revision = "20251222_090000_volume_anomalies"
+branch_labels = None
+depends_on = None
+
+SOFT_DELETE_TABLES = ("message_templates", "positions", "facebook_pages", "instagram_accounts", "chats")
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    for table_name in SOFT_DELETE_TABLES:
+        if table_name not in tables:
+            continue
+        columns = {col["name"] for col in inspector.get_columns(table_name)}
+        if "deleted_at" not in columns:
+            op.add_column(table_name, sa.Column("deleted_at", sa.DateTime(timezone=True), nullable=True))
+            op.create_index(f"ix_{table_name}_deleted_at", table_name, ["deleted_at"])
+        if "deleted_by" not in columns:
+            op.add_column(table_name, sa.Column("deleted_by", sa.String(36), nullable=True))
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    for table_name in SOFT_DELETE_TABLES:
+        if table_name not in tables:
+            continue
+        columns = {col["name"] for col in inspector.get_columns(table_name)}
+        indexes = {index["name"] for index in inspector.get_indexes(table_name)}
+        if f"ix_{table_name}_deleted_at" in indexes:
+            op.drop_index(f"ix_{table_name}_deleted_at", table_name=table_name)
+        if "deleted_at" in columns:
+            op.drop_column(table_name, "deleted_at")
+        if "deleted_by" in columns:
+            op.drop_column(table_name, "deleted_by")
@@ -0,0 +1,43 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251224_090000_data_exports"
+down_revision = "20251223_090000_soft_delete"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "data_export_requests" not in tables:
+        op.create_table(
+            "data_export_requests",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("subject_type", sa.String(20), nullable=False),
+            sa.Column("subject_value", sa.String(255), nullable=False),
+            sa.Column("status", sa.String(20), nullable=False, server_default="pending"),
+            sa.Column("reason", sa.Text(), nullable=True),
+            sa.Column("requested_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("file_path", sa.Text(), nullable=True),
+            sa.Column("file_size", sa.BigInteger(), nullable=True),
+            sa.Column("summary_json", sa.Text(), nullable=True),
+            sa.Column("error", sa.Text(), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.Column("started_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("completed_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("expires_at", sa.DateTime(timezone=True), nullable=True),
+        )
+        op.create_index("ix_data_export_requests_subject_value", "data_export_requests", ["subject_value"])
+        op.create_index("ix_data_export_requests_status", "data_export_requests", ["status"])
+        op.create_index("ix_data_export_requests_created_at", "data_export_requests", ["created_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "data_export_requests" in set(inspector.get_table_names()):
+        op.drop_table("data_export_requests")
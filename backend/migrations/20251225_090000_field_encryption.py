@@ -0,0 +1,72 @@
+"""
+Blind index table and wider message columns for field-level encryption.
+
+This migration does not touch existing rows: they stay in plaintext until
+`python encrypt_fields.py` has run to completion (a required deployment step
+once FIELD_ENCRYPTION_ENABLED and the workspace key are configured).
+"""
+from alembic import op
+import sqlalchemy as sa
+from sqlalchemy.dialects import mysql
+
+# revision identifiers, used by Alembic.
+revision = "20251225_090000_field_encryption"
+down_revision = "20251224_090000_data_exports"
+branch_labels = None
+depends_on = None
+
+# Ciphertext is ~1.4x the plaintext; TEXT (64 KB) is too small for large payloads on MySQL.
+WIDENED_COLUMNS = {
+    "instagram_messages": ("content", "attachments_json"),
+    "facebook_messages": ("content", "attachments_json"),
+    "instagram_message_logs": ("text", "attachments_json", "raw_payload_json"),
+}
+
+
+def _alter_widened_columns(inspector, tables, from_type, to_type):
+    for table_name, column_names in WIDENED_COLUMNS.items():
+        if table_name not in tables:
+            continue
+        columns = {col["name"]: col for col in inspector.get_columns(table_name)}
+        for column_name in column_names:
+            if column_name not in columns:
+                continue
+            op.alter_column(
+                table_name,
+                column_name,
+                existing_type=from_type,
+                type_=to_type,
+                existing_nullable=columns[column_name]["nullable"],
+            )
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "blind_index_tokens" not in tables:
+        op.create_table(
+            "blind_index_tokens",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("entity_type", sa.String(50), nullable=False),
+            sa.Column("entity_id", sa.String(255), nullable=False),
+            sa.Column("token_hash", sa.String(64), nullable=False),
+        )
+        op.create_index("ix_blind_index_tokens_token_hash", "blind_index_tokens", ["token_hash"])
+        op.create_index("ix_blind_index_tokens_entity", "blind_index_tokens", ["entity_type", "entity_id"])
+
+    if conn.dialect.name == "mysql":
+        _alter_widened_columns(inspector, tables, sa.Text(), mysql.MEDIUMTEXT())
+    print("[INFO] Existing rows are not encrypted yet; run `python encrypt_fields.py` until every table is done")
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    # Values over 64 KB (mostly ciphertext of large payloads) no longer fit and fail in strict mode.
+    if conn.dialect.name == "mysql":
+        _alter_widened_columns(inspector, tables, mysql.MEDIUMTEXT(), sa.Text())
+    if "blind_index_tokens" in tables:
+        op.drop_table("blind_index_tokens")
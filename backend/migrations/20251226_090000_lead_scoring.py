@@ -0,0 +1,57 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251226_090000_lead_scoring"
+down_revision = "20251225_090000_field_encryption"
+branch_labels = None
+depends_on = None
+
+CHAT_COLUMNS = (
+    ("lead_score", lambda: sa.Column("lead_score", sa.Integer(), nullable=True)),
+    ("lead_tier", lambda: sa.Column("lead_tier", sa.String(10), nullable=True)),
+    ("lead_factors_json", lambda: sa.Column("lead_factors_json", sa.Text(), nullable=True)),
+    ("lead_scored_at", lambda: sa.Column("lead_scored_at", sa.DateTime(timezone=True), nullable=True)),
+    ("link_click_count", lambda: sa.Column("link_click_count", sa.Integer(), nullable=False, server_default="0")),
+    ("inquiry_created_at", lambda: sa.Column("inquiry_created_at", sa.DateTime(timezone=True), nullable=True)),
+)
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "chats" in tables:
+        columns = {col["name"] for col in inspector.get_columns("chats")}
+        for name, column in CHAT_COLUMNS:
+            if name not in columns:
+                op.add_column("chats", column())
+        indexes = {index["name"] for index in inspector.get_indexes("chats")}
+        if "ix_chats_lead_score" not in indexes:
+            op.create_index("ix_chats_lead_score", "chats", ["lead_score"])
+
+    if "lead_scoring_config" not in tables:
+        op.create_table(
+            "lead_scoring_config",
+            sa.Column("id", sa.Integer(), primary_key=True, autoincrement=True),
+            sa.Column("rules_json", sa.Text(), nullable=False),
+            sa.Column("updated_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("updated_at", sa.DateTime(timezone=True), nullable=False),
+        )
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    if "lead_scoring_config" in tables:
+        op.drop_table("lead_scoring_config")
+    if "chats" in tables:
+        columns = {col["name"] for col in inspector.get_columns("chats")}
+        indexes = {index["name"] for index in inspector.get_indexes("chats")}
+        if "ix_chats_lead_score" in indexes:
+            op.drop_index("ix_chats_lead_score", table_name="chats")
+        for name, _ in CHAT_COLUMNS:
+            if name in columns:
+                op.drop_column("chats", name)
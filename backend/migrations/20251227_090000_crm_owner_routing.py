@@ -0,0 +1,36 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251227_090000_crm_owner_routing"
+down_revision = "20251226_090000_lead_scoring"
+branch_labels = None
+depends_on = None
+
+CHAT_COLUMNS = (
+    ("crm_owner_phone", lambda: sa.Column("crm_owner_phone", sa.String(20), nullable=True)),
+    ("crm_owner_emp_id", lambda: sa.Column("crm_owner_emp_id", sa.String(100), nullable=True)),
+    ("crm_owner_checked_at", lambda: sa.Column("crm_owner_checked_at", sa.DateTime(timezone=True), nullable=True)),
+)
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "chats" not in set(inspector.get_table_names()):
+        return
+    columns = {col["name"] for col in inspector.get_columns("chats")}
+    for name, column in CHAT_COLUMNS:
+        if name not in columns:
+            op.add_column("chats", column())
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "chats" not in set(inspector.get_table_names()):
+        return
+    columns = {col["name"] for col in inspector.get_columns("chats")}
+    for name, _ in CHAT_COLUMNS:
+        if name in columns:
+            op.drop_column("chats", name)
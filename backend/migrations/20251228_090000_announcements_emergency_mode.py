@@ -0,0 +1,66 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251228_090000_announcements_emergency_mode"
+down_revision = "20251227_090000_crm_owner_routing"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "announcements" not in tables:
+        op.create_table(
+            "announcements",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("title", sa.String(200), nullable=False),
+            sa.Column("body", sa.Text(), nullable=True),
+            sa.Column("severity", sa.String(20), nullable=False),
+            sa.Column("expires_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("cancelled_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("cancelled_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("created_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_announcements_expires_at", "announcements", ["expires_at"])
+        op.create_index("ix_announcements_created_at", "announcements", ["created_at"])
+
+    if "announcement_acks" not in tables:
+        op.create_table(
+            "announcement_acks",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("announcement_id", sa.String(36), sa.ForeignKey("announcements.id"), nullable=False),
+            sa.Column("user_id", sa.String(36), sa.ForeignKey("users.id"), nullable=False),
+            sa.Column("acknowledged_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+            sa.UniqueConstraint("announcement_id", "user_id", name="uq_announcement_ack_user"),
+        )
+        op.create_index("ix_announcement_acks_announcement_id", "announcement_acks", ["announcement_id"])
+        op.create_index("ix_announcement_acks_user_id", "announcement_acks", ["user_id"])
+
+    if "emergency_mode_state" not in tables:
+        op.create_table(
+            "emergency_mode_state",
+            sa.Column("id", sa.Integer(), primary_key=True, autoincrement=True),
+            sa.Column("active", sa.Boolean(), nullable=False, server_default="0"),
+            sa.Column("auto_reply", sa.Text(), nullable=True),
+            sa.Column("pause_assignment", sa.Boolean(), nullable=False, server_default="1"),
+            sa.Column("reason", sa.String(500), nullable=True),
+            sa.Column("started_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("started_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("ends_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("updated_by", sa.String(36), sa.ForeignKey("users.id"), nullable=True),
+            sa.Column("updated_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    for table_name in ("announcement_acks", "announcements", "emergency_mode_state"):
+        if table_name in tables:
+            op.drop_table(table_name)
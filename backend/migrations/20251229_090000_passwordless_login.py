@@ -0,0 +1,50 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251229_090000_passwordless_login"
+down_revision = "20251228_090000_announcements_emergency_mode"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+
+    if "positions" in tables:
+        columns = {col["name"] for col in inspector.get_columns("positions")}
+        if "passwordless_login_enabled" not in columns:
+            op.add_column(
+                "positions",
+                sa.Column("passwordless_login_enabled", sa.Boolean(), nullable=False, server_default="0"),
+            )
+
+    if "login_codes" not in tables:
+        op.create_table(
+            "login_codes",
+            sa.Column("id", sa.String(36), primary_key=True),
+            sa.Column("user_id", sa.String(36), sa.ForeignKey("users.id"), nullable=False),
+            sa.Column("method", sa.String(10), nullable=False),
+            sa.Column("token_hash", sa.String(128), nullable=False, unique=True),
+            sa.Column("expires_at", sa.DateTime(timezone=True), nullable=False),
+            sa.Column("used_at", sa.DateTime(timezone=True), nullable=True),
+            sa.Column("failed_attempts", sa.Integer(), nullable=False, server_default="0"),
+            sa.Column("requested_ip", sa.String(64), nullable=True),
+            sa.Column("created_at", sa.DateTime(timezone=True), nullable=False, server_default=sa.func.now()),
+        )
+        op.create_index("ix_login_codes_user_id", "login_codes", ["user_id"])
+        op.create_index("ix_login_codes_created_at", "login_codes", ["created_at"])
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    tables = set(inspector.get_table_names())
+    if "login_codes" in tables:
+        op.drop_table("login_codes")
+    if "positions" in tables:
+        columns = {col["name"] for col in inspector.get_columns("positions")}
+        if "passwordless_login_enabled" in columns:
+            op.drop_column("positions", "passwordless_login_enabled")
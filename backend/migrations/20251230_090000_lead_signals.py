@@ -0,0 +1,28 @@
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = "20251230_090000_lead_signals"
+down_revision = "20251229_090000_passwordless_login"
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "chats" not in set(inspector.get_table_names()):
+        return
+    columns = {col["name"] for col in inspector.get_columns("chats")}
+    if "lead_signals_json" not in columns:
+        op.add_column("chats", sa.Column("lead_signals_json", sa.Text(), nullable=True))
+
+
+def downgrade():
+    conn = op.get_bind()
+    inspector = sa.inspect(conn)
+    if "chats" not in set(inspector.get_table_names()):
+        return
+    columns = {col["name"] for col in inspector.get_columns("chats")}
+    if "lead_signals_json" in columns:
+        op.drop_column("chats", "lead_signals_json")
@@ -9,20 +9,30 @@ from sqlalchemy import (
     Boolean,
     BigInteger,
     Float,
-    JSON,
+    Numeric,
+    UniqueConstraint,
+    Index,
     func,
 )
-from sqlalchemy.orm import relationship
+from sqlalchemy import event
+from sqlalchemy import inspect as sa_inspect
+from sqlalchemy.orm import Session, relationship, with_loader_criteria
 from database import Base
 import uuid
 import enum
 import json
 from utils.timezone import utc_now
+from utils.field_crypto import EncryptedJSON, EncryptedText, blind_index_enabled, blind_token, index_tokens
 
 class UserRole(str, enum.Enum):
     ADMIN = "admin"
     AGENT = "agent"
 
+class UserApprovalStatus(str, enum.Enum):
+    PENDING = "pending"
+    APPROVED = "approved"
+    REJECTED = "rejected"
+
 class ChatStatus(str, enum.Enum):
     ASSIGNED = "assigned"
     UNASSIGNED = "unassigned"
@@ -56,7 +66,45 @@ class InstagramCommentAction(str, enum.Enum):
     UPDATED = "updated"
     DELETED = "deleted"
 
-class Position(Base):
+class PaymentRequestStatus(str, enum.Enum):
+    PENDING = "pending"
+    PAID = "paid"
+    FAILED = "failed"
+    CANCELLED = "cancelled"
+    EXPIRED = "expired"
+
+
+class SoftDeleteMixin:
+    """Rows are moved to the trash instead of being deleted; see _exclude_soft_deleted."""
+
+    deleted_at = Column(DateTime(timezone=True), nullable=True, index=True)
+    deleted_by = Column(String(36), nullable=True)
+
+    @property
+    def is_deleted(self) -> bool:
+        return self.deleted_at is not None
+
+
+@event.listens_for(Session, "do_orm_execute")
+def _exclude_soft_deleted(execute_state):
+    # Hide trashed rows from every ORM query unless it opts in with
+    # .execution_options(include_deleted=True) (trash views, purge job, reconnects).
+    if (
+        execute_state.is_select
+        and not execute_state.is_column_load
+        and not execute_state.is_relationship_load
+        and not execute_state.execution_options.get("include_deleted", False)
+    ):
+        execute_state.statement = execute_state.statement.options(
+            with_loader_criteria(
+                SoftDeleteMixin,
+                lambda cls: cls.deleted_at.is_(None),
+                include_aliases=True,
+            )
+        )
+
+
+class Position(SoftDeleteMixin, Base):
     __tablename__ = "positions"
 
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
@@ -65,6 +113,8 @@ class Position(Base):
     description = Column(Text, nullable=True)
     permissions_json = Column(Text, nullable=False, default="[]", server_default="[]")
     is_system = Column(Boolean, nullable=False, default=False, server_default="0")
+    # Users in this position may sign in with an emailed magic link / one-time code
+    passwordless_login_enabled = Column(Boolean, nullable=False, default=False, server_default="0")
     created_at = Column(
         DateTime(timezone=True),
         nullable=False,
@@ -119,6 +169,16 @@ class User(Base):
     position_id = Column(String(36), ForeignKey("positions.id"), nullable=True, index=True)
     is_active = Column(Boolean, nullable=False, default=True, server_default="1")
     can_receive_new_chats = Column(Boolean, nullable=False, default=True, server_default="1")
+    approval_status = Column(
+        SQLEnum(UserApprovalStatus),
+        nullable=False,
+        default=UserApprovalStatus.APPROVED,
+        server_default=UserApprovalStatus.APPROVED.name,
+        index=True,
+    )
+    approval_note = Column(Text, nullable=True)
+    approval_decided_by = Column(String(36), nullable=True)
+    approval_decided_at = Column(DateTime(timezone=True), nullable=True)
     created_at = Column(
         DateTime(timezone=True),
         nullable=False,
@@ -164,6 +224,59 @@ class PasswordResetToken(Base):
     user = relationship("User", backref="password_reset_tokens")
 
 
+class LoginCode(Base):
+    """One-time passwordless sign-in: a 6-digit code or a magic link token, stored hashed."""
+
+    __tablename__ = "login_codes"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    user_id = Column(String(36), ForeignKey("users.id"), nullable=False, index=True)
+    method = Column(String(10), nullable=False)  # code | link
+    token_hash = Column(String(128), nullable=False, unique=True)
+    expires_at = Column(DateTime(timezone=True), nullable=False)
+    used_at = Column(DateTime(timezone=True), nullable=True)
+    failed_attempts = Column(Integer, nullable=False, default=0, server_default="0")
+    requested_ip = Column(String(64), nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+    user = relationship("User", backref="login_codes")
+
+
+class AuditLog(Base):
+    __tablename__ = "audit_logs"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    actor_id = Column(String(36), nullable=True, index=True)
+    actor_label = Column(String(255), nullable=True)
+    action = Column(String(100), nullable=False, index=True)
+    entity_type = Column(String(50), nullable=False, index=True)
+    entity_id = Column(String(255), nullable=True, index=True)
+    details_json = Column(Text, nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+    @property
+    def details(self):
+        if not self.details_json:
+            return {}
+        try:
+            data = json.loads(self.details_json)
+        except (TypeError, ValueError):
+            return {}
+        return data if isinstance(data, dict) else {}
+
+
 class DBSchemaSnapshot(Base):
     __tablename__ = "db_schema_snapshots"
 
@@ -198,7 +311,7 @@ class DBSchemaChange(Base):
 
     snapshot = relationship("DBSchemaSnapshot", back_populates="changes")
 
-class InstagramAccount(Base):
+class InstagramAccount(SoftDeleteMixin, Base):
     __tablename__ = "instagram_accounts"
     
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
@@ -215,7 +328,7 @@ class InstagramAccount(Base):
     
     user = relationship("User", back_populates="instagram_accounts")
 
-class Chat(Base):
+class Chat(SoftDeleteMixin, Base):
     __tablename__ = "chats"
     
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
@@ -223,7 +336,8 @@ class Chat(Base):
     facebook_user_id = Column(String(255), ForeignKey("facebook_users.id"), nullable=True, index=True)
     username = Column(String(255), nullable=False)
     profile_pic_url = Column(Text, nullable=True)
-    last_message = Column(Text, nullable=True)
+    # Copy of the latest message text; encrypted like the message itself
+    last_message = Column(EncryptedText, nullable=True)
     status = Column(
         SQLEnum(ChatStatus),
         nullable=False,
@@ -255,6 +369,19 @@ class Chat(Base):
     )
     last_incoming_at = Column(DateTime(timezone=True), nullable=True)
     last_outgoing_at = Column(DateTime(timezone=True), nullable=True)
+    # Rule-based lead score (routes/lead_scoring.py); factors explain each contribution
+    lead_score = Column(Integer, nullable=True, index=True)
+    lead_tier = Column(String(10), nullable=True)
+    lead_factors_json = Column(Text, nullable=True)
+    # Message-derived signals so inbound messages can be scored without rereading the chat
+    lead_signals_json = Column(Text, nullable=True)
+    lead_scored_at = Column(DateTime(timezone=True), nullable=True)
+    link_click_count = Column(Integer, nullable=False, default=0, server_default="0")
+    inquiry_created_at = Column(DateTime(timezone=True), nullable=True)
+    # Owner of the customer's phone number in the admin CRM (routes/crm_routing.py)
+    crm_owner_phone = Column(String(20), nullable=True)
+    crm_owner_emp_id = Column(String(100), nullable=True)
+    crm_owner_checked_at = Column(DateTime(timezone=True), nullable=True)
     
     instagram_chat_messages = relationship(
         "InstagramMessage",
@@ -285,6 +412,14 @@ class Chat(Base):
     def messages(self, value):
         self._messages_override = value
 
+    @property
+    def lead_factors(self):
+        try:
+            data = json.loads(self.lead_factors_json or "[]")
+        except (TypeError, ValueError):
+            data = []
+        return data if isinstance(data, list) else []
+
 
 class AssignmentCursor(Base):
     __tablename__ = "assignment_cursors"
@@ -316,7 +451,7 @@ class FacebookUser(Base):
         onupdate=utc_now,
         server_default=func.now(),
     )
-    last_message = Column(Text, nullable=True)
+    last_message = Column(EncryptedText, nullable=True)
     username = Column(String(255), nullable=True)
     name = Column(String(255), nullable=True)
     profile_pic_url = Column(Text, nullable=True)
@@ -329,7 +464,8 @@ class ChatMessageMixin:
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
     chat_id = Column(String(36), ForeignKey("chats.id"), nullable=False)
     sender = Column(SQLEnum(MessageSender), nullable=False)
-    content = Column(Text, nullable=False)
+    # Encrypted at rest when FIELD_ENCRYPTION_ENABLED is on (see utils/field_crypto.py)
+    content = Column(EncryptedText, nullable=False)
     message_type = Column(
         SQLEnum(MessageType),
         nullable=False,
@@ -342,11 +478,17 @@ class ChatMessageMixin:
         default=utc_now,
         server_default=func.now(),
     )
-    attachments_json = Column(Text, nullable=True)
+    attachments_json = Column(EncryptedText, nullable=True)
+    # Left in plaintext: referral/ad metadata is filtered with LIKE. Quoted message
+    # text (reply_preview) is encrypted inside it, see seal_metadata.
     metadata_json = Column(Text, nullable=True)
+    # Graph message id (mid); long ids are stored as "hash:<sha256>"
+    mid = Column(String(512), nullable=True, index=True)
     is_gif = Column(Boolean, nullable=False, default=False, server_default="0")
     is_ticklegram = Column(Boolean, nullable=False, default=False, server_default="0")
     is_lead_form_message = Column(Boolean, nullable=False, default=False, server_default="0")
+    # Ingested by webhook reconciliation instead of a live webhook
+    is_reconciled = Column(Boolean, nullable=False, default=False, server_default="0")
 
 
 class InstagramMessage(ChatMessageMixin, Base):
@@ -386,7 +528,7 @@ class FacebookWebhookEvent(Base):
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
     object = Column(String(64), nullable=True)
     page_id = Column(String(255), nullable=True, index=True)
-    payload = Column(JSON, nullable=False)
+    payload = Column(EncryptedJSON, nullable=False)
     received_at = Column(
         DateTime(timezone=True),
         nullable=False,
@@ -394,7 +536,7 @@ class FacebookWebhookEvent(Base):
         server_default=func.now(),
     )
 
-class FacebookPage(Base):
+class FacebookPage(SoftDeleteMixin, Base):
     __tablename__ = "facebook_pages"
     
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
@@ -454,7 +596,7 @@ class UserStatusLog(Base):
 
     user = relationship("User", back_populates="status_logs", foreign_keys=[user_id])
 
-class MessageTemplate(Base):
+class MessageTemplate(SoftDeleteMixin, Base):
     __tablename__ = "message_templates"
     
     id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
@@ -500,7 +642,7 @@ class InstagramUser(Base):
         onupdate=utc_now,
         server_default=func.now(),
     )
-    last_message = Column(Text, nullable=True)
+    last_message = Column(EncryptedText, nullable=True)
     username = Column(String(255), nullable=True)
     name = Column(String(255), nullable=True)
 
@@ -515,8 +657,8 @@ class InstagramMessageLog(Base):
     igsid = Column(String(255), ForeignKey("instagram_users.igsid"), nullable=False, index=True)
     message_id = Column(String(512), nullable=True, unique=True, index=True)
     direction = Column(SQLEnum(InstagramMessageDirection), nullable=False)
-    text = Column(Text, nullable=True)
-    attachments_json = Column(Text, nullable=True)
+    text = Column(EncryptedText, nullable=True)
+    attachments_json = Column(EncryptedText, nullable=True)
     ts = Column(BigInteger, nullable=False, index=True)
     created_at = Column(
         DateTime(timezone=True),
@@ -524,7 +666,7 @@ class InstagramMessageLog(Base):
         default=utc_now,
         server_default=func.now(),
     )
-    raw_payload_json = Column(Text, nullable=True)
+    raw_payload_json = Column(EncryptedText, nullable=True)
     metadata_json = Column(Text, nullable=True)
     is_gif = Column(Boolean, nullable=False, default=False, server_default="0")
     is_ticklegram = Column(Boolean, nullable=False, default=False, server_default="0")
@@ -597,3 +739,439 @@ class InstagramInsight(Base):
         server_default=func.now(),
         index=True,
     )
+
+
+class PaymentRequest(Base):
+    __tablename__ = "payment_requests"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    chat_id = Column(String(36), ForeignKey("chats.id"), nullable=False, index=True)
+    reference = Column(String(100), nullable=False, unique=True, index=True)
+    inquiry_id = Column(String(100), nullable=True, index=True)
+    amount = Column(Numeric(12, 2), nullable=False)
+    currency = Column(String(10), nullable=False, default="INR", server_default="INR")
+    description = Column(Text, nullable=True)
+    provider = Column(String(50), nullable=False)
+    provider_payment_id = Column(String(255), nullable=True, index=True)
+    payment_url = Column(Text, nullable=True)
+    status = Column(
+        SQLEnum(PaymentRequestStatus),
+        nullable=False,
+        default=PaymentRequestStatus.PENDING,
+        server_default=PaymentRequestStatus.PENDING.name,
+        index=True,
+    )
+    amount_received = Column(Numeric(12, 2), nullable=True)
+    message_id = Column(String(36), nullable=True)
+    confirmation_message_id = Column(String(36), nullable=True)
+    created_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    expires_at = Column(DateTime(timezone=True), nullable=True)
+    paid_at = Column(DateTime(timezone=True), nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+    )
+    updated_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        onupdate=utc_now,
+        server_default=func.now(),
+    )
+
+    chat = relationship("Chat", backref="payment_requests")
+    creator = relationship("User", foreign_keys=[created_by])
+    events = relationship("PaymentEvent", back_populates="payment_request", cascade="all, delete-orphan")
+
+
+class PaymentEvent(Base):
+    __tablename__ = "payment_events"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    payment_request_id = Column(String(36), ForeignKey("payment_requests.id"), nullable=True, index=True)
+    provider = Column(String(50), nullable=False)
+    reference = Column(String(100), nullable=True, index=True)
+    provider_payment_id = Column(String(255), nullable=True)
+    status = Column(String(50), nullable=True)
+    amount = Column(Numeric(12, 2), nullable=True)
+    currency = Column(String(10), nullable=True)
+    payload_json = Column(Text, nullable=True)
+    note = Column(Text, nullable=True)
+    received_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+    payment_request = relationship("PaymentRequest", back_populates="events")
+
+
+class RateLimitRule(Base):
+    __tablename__ = "rate_limit_rules"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    scope = Column(String(20), nullable=False, index=True)  # user, api_key, route_group
+    subject = Column(String(255), nullable=True, index=True)  # user id or API key fingerprint
+    route_group = Column(String(50), nullable=False, default="*", server_default="*")
+    limit = Column(Integer, nullable=False)
+    window_seconds = Column(Integer, nullable=False, default=60, server_default="60")
+    note = Column(Text, nullable=True)
+    created_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+    )
+    updated_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        onupdate=utc_now,
+        server_default=func.now(),
+    )
+
+
+class RateLimitCounter(Base):
+    __tablename__ = "rate_limit_counters"
+
+    bucket_key = Column(String(255), primary_key=True)
+    window_start = Column(BigInteger, primary_key=True)
+    count = Column(Integer, nullable=False, default=0, server_default="0")
+
+
+class RateLimitThrottle(Base):
+    __tablename__ = "rate_limit_throttles"
+    __table_args__ = (
+        UniqueConstraint("identity", "route_group", "window_start", name="uq_rate_limit_throttle_window"),
+    )
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    identity = Column(String(255), nullable=False, index=True)
+    identity_type = Column(String(20), nullable=False)
+    user_id = Column(String(36), nullable=True, index=True)
+    route_group = Column(String(50), nullable=False)
+    window_start = Column(BigInteger, nullable=False)
+    limit = Column(Integer, nullable=False)
+    window_seconds = Column(Integer, nullable=False)
+    blocked_count = Column(Integer, nullable=False, default=0, server_default="0")
+    last_path = Column(String(512), nullable=True)
+    first_blocked_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+    )
+    last_blocked_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+
+class ChatQueueNotice(Base):
+    __tablename__ = "chat_queue_notices"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    chat_id = Column(String(36), ForeignKey("chats.id"), nullable=False, index=True)
+    message_id = Column(String(36), nullable=True)
+    reason = Column(String(20), nullable=False)  # unassigned, agent_busy
+    position = Column(Integer, nullable=True)
+    estimated_wait_minutes = Column(Integer, nullable=True)
+    locale = Column(String(10), nullable=True)
+    sent_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+    chat = relationship("Chat")
+
+
+class WebhookReconciliationRun(Base):
+    __tablename__ = "webhook_reconciliation_runs"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    platform = Column(SQLEnum(MessagePlatform), nullable=False)
+    account_id = Column(String(255), nullable=False, index=True)  # FacebookPage.page_id / InstagramAccount.page_id
+    started_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+    finished_at = Column(DateTime(timezone=True), nullable=True)
+    conversations_checked = Column(Integer, nullable=False, default=0, server_default="0")
+    messages_checked = Column(Integer, nullable=False, default=0, server_default="0")
+    missing_found = Column(Integer, nullable=False, default=0, server_default="0")
+    ingested = Column(Integer, nullable=False, default=0, server_default="0")
+    expected_hourly_rate = Column(Float, nullable=True)
+    observed_recent = Column(Integer, nullable=True)
+    silence_alert = Column(Boolean, nullable=False, default=False, server_default="0")
+    error = Column(Text, nullable=True)
+
+
+class AgentShift(Base):
+    """Scheduled working time for an agent, used as capacity in staffing forecasts."""
+
+    __tablename__ = "agent_shifts"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    user_id = Column(String(36), ForeignKey("users.id"), nullable=False, index=True)
+    starts_at = Column(DateTime(timezone=True), nullable=False, index=True)
+    ends_at = Column(DateTime(timezone=True), nullable=False, index=True)
+    note = Column(String(255), nullable=True)
+    created_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+    )
+
+    user = relationship("User", foreign_keys=[user_id])
+
+
+class VolumeAnomalySetting(Base):
+    """Per-scope sensitivity and snooze for inbound volume anomaly alerts."""
+
+    __tablename__ = "volume_anomaly_settings"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    # "instagram" / "facebook" for a whole platform, "<platform>:<page_id>" for one page/account
+    scope = Column(String(300), nullable=False, unique=True, index=True)
+    sensitivity = Column(String(20), nullable=True)
+    spikes_enabled = Column(Boolean, nullable=False, default=True, server_default="1")
+    drops_enabled = Column(Boolean, nullable=False, default=True, server_default="1")
+    snoozed_until = Column(DateTime(timezone=True), nullable=True)
+    snooze_reason = Column(String(255), nullable=True)
+    updated_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    updated_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        onupdate=utc_now,
+        server_default=func.now(),
+    )
+
+
+class VolumeAnomalyEvent(Base):
+    __tablename__ = "volume_anomaly_events"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    scope = Column(String(300), nullable=False, index=True)
+    platform = Column(SQLEnum(MessagePlatform), nullable=False)
+    account_id = Column(String(255), nullable=True)
+    kind = Column(String(10), nullable=False)  # spike | drop
+    window_start = Column(DateTime(timezone=True), nullable=False)
+    window_end = Column(DateTime(timezone=True), nullable=False)
+    observed = Column(Integer, nullable=False, default=0)
+    expected = Column(Float, nullable=False, default=0)
+    score = Column(Float, nullable=False, default=0)
+    context_json = Column(Text, nullable=True)
+    alerted = Column(Boolean, nullable=False, default=False, server_default="0")
+    suppressed_reason = Column(String(50), nullable=True)  # "snoozed" when recorded without alerting
+    detected_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+
+class DataExportRequest(Base):
+    """Data subject access export: one archive with everything stored about a contact."""
+
+    __tablename__ = "data_export_requests"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    subject_type = Column(String(20), nullable=False)  # platform_user | phone | email
+    subject_value = Column(String(255), nullable=False, index=True)
+    status = Column(String(20), nullable=False, default="pending", server_default="pending", index=True)
+    reason = Column(Text, nullable=True)
+    requested_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    file_path = Column(Text, nullable=True)
+    file_size = Column(BigInteger, nullable=True)
+    summary_json = Column(Text, nullable=True)
+    error = Column(Text, nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+    started_at = Column(DateTime(timezone=True), nullable=True)
+    completed_at = Column(DateTime(timezone=True), nullable=True)
+    expires_at = Column(DateTime(timezone=True), nullable=True)
+
+    requester = relationship("User", foreign_keys=[requested_by])
+
+
+class BlindIndexToken(Base):
+    """HMAC of a word / phone / email found in an encrypted column, for searching without plaintext."""
+
+    __tablename__ = "blind_index_tokens"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    entity_type = Column(String(50), nullable=False)
+    entity_id = Column(String(255), nullable=False)
+    token_hash = Column(String(64), nullable=False, index=True)
+
+    __table_args__ = (
+        Index("ix_blind_index_tokens_entity", "entity_type", "entity_id"),
+    )
+
+
+# model -> (entity_type, columns feeding the blind index)
+BLIND_INDEXED_MODELS = {
+    InstagramMessage: ("instagram_message", ("content",)),
+    FacebookMessage: ("facebook_message", ("content",)),
+    InstagramMessageLog: ("instagram_message_log", ("text",)),
+    FacebookWebhookEvent: ("facebook_webhook_event", ("payload",)),
+}
+
+
+def refresh_blind_index(connection, target) -> None:
+    """Replace the blind index tokens of one row (runs inside the row's flush)."""
+    entity_type, columns = BLIND_INDEXED_MODELS[type(target)]
+    table = BlindIndexToken.__table__
+    connection.execute(
+        table.delete().where(table.c.entity_type == entity_type, table.c.entity_id == target.id)
+    )
+    tokens = set()
+    for column in columns:
+        tokens |= index_tokens(getattr(target, column))
+    if tokens:
+        connection.execute(
+            table.insert(),
+            [
+                {"id": str(uuid.uuid4()), "entity_type": entity_type, "entity_id": target.id, "token_hash": blind_token(token)}
+                for token in tokens
+            ],
+        )
+
+
+def _index_after_insert(mapper, connection, target):
+    if blind_index_enabled():
+        refresh_blind_index(connection, target)
+
+
+def _index_after_update(mapper, connection, target):
+    # Status changes (is_read, delivery_status, ...) leave the indexed text alone.
+    if not blind_index_enabled():
+        return
+    _, columns = BLIND_INDEXED_MODELS[type(target)]
+    state = sa_inspect(target)
+    if any(state.attrs[column].history.has_changes() for column in columns):
+        refresh_blind_index(connection, target)
+
+
+def _unindex_after_delete(mapper, connection, target):
+    if blind_index_enabled():
+        entity_type, _ = BLIND_INDEXED_MODELS[type(target)]
+        table = BlindIndexToken.__table__
+        connection.execute(
+            table.delete().where(table.c.entity_type == entity_type, table.c.entity_id == target.id)
+        )
+
+
+for _model in BLIND_INDEXED_MODELS:
+    event.listen(_model, "after_insert", _index_after_insert)
+    event.listen(_model, "after_update", _index_after_update)
+    event.listen(_model, "after_delete", _unindex_after_delete)
+
+
+class LeadScoringConfig(Base):
+    """Single row holding the lead scoring rules; missing keys fall back to the defaults in code."""
+
+    __tablename__ = "lead_scoring_config"
+
+    id = Column(Integer, primary_key=True, autoincrement=True)
+    rules_json = Column(Text, nullable=False, default="{}", server_default="{}")
+    updated_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    updated_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        onupdate=utc_now,
+        server_default=func.now(),
+    )
+
+
+class Announcement(Base):
+    """Workspace banner shown to every agent until it expires or is cancelled."""
+
+    __tablename__ = "announcements"
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    title = Column(String(200), nullable=False)
+    body = Column(Text, nullable=True)
+    severity = Column(String(20), nullable=False, default="info")  # info | warning | critical
+    expires_at = Column(DateTime(timezone=True), nullable=True, index=True)
+    cancelled_at = Column(DateTime(timezone=True), nullable=True)
+    cancelled_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    created_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    created_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+        index=True,
+    )
+
+    acknowledgements = relationship("AnnouncementAck", back_populates="announcement", cascade="all, delete-orphan")
+
+
+class AnnouncementAck(Base):
+    __tablename__ = "announcement_acks"
+    __table_args__ = (
+        UniqueConstraint("announcement_id", "user_id", name="uq_announcement_ack_user"),
+    )
+
+    id = Column(String(36), primary_key=True, default=lambda: str(uuid.uuid4()))
+    announcement_id = Column(String(36), ForeignKey("announcements.id"), nullable=False, index=True)
+    user_id = Column(String(36), ForeignKey("users.id"), nullable=False, index=True)
+    acknowledged_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        server_default=func.now(),
+    )
+
+    announcement = relationship("Announcement", back_populates="acknowledgements")
+    user = relationship("User")
+
+
+class EmergencyModeState(Base):
+    """Single row: while active, new inbound messages get the auto-reply and assignment can be paused."""
+
+    __tablename__ = "emergency_mode_state"
+
+    id = Column(Integer, primary_key=True, autoincrement=True)
+    active = Column(Boolean, nullable=False, default=False, server_default="0")
+    auto_reply = Column(Text, nullable=True)
+    pause_assignment = Column(Boolean, nullable=False, default=True, server_default="1")
+    reason = Column(String(500), nullable=True)
+    started_at = Column(DateTime(timezone=True), nullable=True)
+    started_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    # Emergency mode switches itself off at this time (None = until turned off)
+    ends_at = Column(DateTime(timezone=True), nullable=True)
+    updated_by = Column(String(36), ForeignKey("users.id"), nullable=True)
+    updated_at = Column(
+        DateTime(timezone=True),
+        nullable=False,
+        default=utc_now,
+        onupdate=utc_now,
+        server_default=func.now(),
+    )
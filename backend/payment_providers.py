@@ -0,0 +1,173 @@
+import hashlib
+import hmac
+import json
+import logging
+import os
+import secrets
+from datetime import datetime
+from decimal import Decimal, InvalidOperation
+from typing import Any, Dict, Mapping, Optional
+
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+PAYMENT_PROVIDER = os.getenv("PAYMENT_PROVIDER", "local").strip().lower() or "local"
+PAYMENT_WEBHOOK_SECRET = os.getenv("PAYMENT_WEBHOOK_SECRET", "")
+# Development only: mounts the local test checkout and accepts unsigned local webhooks.
+PAYMENT_LOCAL_DEV_MODE = os.getenv("PAYMENT_LOCAL_DEV_MODE", "false").lower() in {"1", "true", "yes"}
+PAYMENT_LINK_BASE_URL = (
+    os.getenv("PAYMENT_LINK_BASE_URL")
+    or os.getenv("BACKEND_BASE_URL")
+    or "http://localhost:8000"
+).rstrip("/")
+
+
+class PaymentProviderError(Exception):
+    """Raised when a provider cannot create or look up a payment."""
+
+
+class PaymentWebhookError(PaymentProviderError):
+    """Raised when a webhook body is well-formed JSON but cannot be normalized."""
+
+
+class PaymentProvider:
+    """
+    Adapter interface for payment gateways.
+
+    Providers create hosted payment links, verify and normalize their webhook
+    callbacks, and optionally report the gateway-side status for reconciliation.
+    """
+
+    name = "base"
+    signature_header = "x-payment-signature"
+
+    def create_payment_link(
+        self,
+        reference: str,
+        amount: Decimal,
+        currency: str,
+        description: Optional[str] = None,
+        customer_name: Optional[str] = None,
+        expires_at: Optional[datetime] = None,
+    ) -> Dict[str, Any]:
+        """Return {"provider_payment_id": str, "payment_url": str}."""
+        raise NotImplementedError
+
+    def verify_webhook(self, payload: bytes, headers: Mapping[str, str]) -> bool:
+        raise NotImplementedError
+
+    def parse_webhook(self, data: Dict[str, Any]) -> Dict[str, Any]:
+        """
+        Normalize a webhook body to:
+        {"reference", "provider_payment_id", "status", "amount", "currency", "paid_at"}
+        where status is one of pending/paid/failed/cancelled/expired.
+        Raise PaymentWebhookError when a field cannot be parsed.
+        """
+        raise NotImplementedError
+
+    def fetch_status(self, provider_payment_id: str) -> Optional[str]:
+        """Return the gateway-side status, or None when the provider cannot be queried."""
+        return None
+
+
+class LocalPaymentProvider(PaymentProvider):
+    """
+    Fake provider for development and tests. Links point at the backend's own
+    checkout stub (mounted only with PAYMENT_LOCAL_DEV_MODE) which applies the
+    same update a signed callback to the payments webhook would.
+    """
+
+    name = "local"
+
+    def __init__(
+        self,
+        secret: Optional[str] = None,
+        base_url: Optional[str] = None,
+        dev_mode: Optional[bool] = None,
+    ):
+        self.secret = secret if secret is not None else PAYMENT_WEBHOOK_SECRET
+        self.base_url = (base_url or PAYMENT_LINK_BASE_URL).rstrip("/")
+        self.dev_mode = PAYMENT_LOCAL_DEV_MODE if dev_mode is None else dev_mode
+
+    def create_payment_link(
+        self,
+        reference: str,
+        amount: Decimal,
+        currency: str,
+        description: Optional[str] = None,
+        customer_name: Optional[str] = None,
+        expires_at: Optional[datetime] = None,
+    ) -> Dict[str, Any]:
+        provider_payment_id = f"local_{secrets.token_hex(8)}"
+        logger.info("LOCAL PAYMENTS: created link %s for %s %s (%s)", provider_payment_id, amount, currency, reference)
+        return {
+            "provider_payment_id": provider_payment_id,
+            "payment_url": f"{self.base_url}/api/payments/local/{reference}",
+        }
+
+    def sign(self, payload: bytes) -> str:
+        return hmac.new(self.secret.encode("utf-8"), payload, hashlib.sha256).hexdigest()
+
+    def verify_webhook(self, payload: bytes, headers: Mapping[str, str]) -> bool:
+        if not self.secret:
+            if self.dev_mode:
+                logger.info("LOCAL PAYMENTS: PAYMENT_WEBHOOK_SECRET not set; accepting unsigned webhook (dev mode)")
+                return True
+            logger.warning("LOCAL PAYMENTS: PAYMENT_WEBHOOK_SECRET not set; rejecting webhook")
+            return False
+        signature = headers.get(self.signature_header) or ""
+        return hmac.compare_digest(self.sign(payload), signature.strip())
+
+    def build_callback(self, reference: str, provider_payment_id: Optional[str], status: str, amount: Decimal, currency: str) -> bytes:
+        body = {
+            "reference": reference,
+            "payment_id": provider_payment_id,
+            "status": status,
+            "amount": str(amount),
+            "currency": currency,
+            "paid_at": utc_now().isoformat() if status == "paid" else None,
+        }
+        return json.dumps(body).encode("utf-8")
+
+    def parse_webhook(self, data: Dict[str, Any]) -> Dict[str, Any]:
+        paid_at = data.get("paid_at")
+        parsed_paid_at = None
+        if paid_at:
+            try:
+                parsed_paid_at = datetime.fromisoformat(str(paid_at))
+            except ValueError:
+                parsed_paid_at = None
+        amount = data.get("amount")
+        parsed_amount = None
+        if amount not in (None, ""):
+            try:
+                parsed_amount = Decimal(str(amount))
+            except InvalidOperation:
+                raise PaymentWebhookError(f"Invalid amount: {amount!r}")
+            if not parsed_amount.is_finite():
+                raise PaymentWebhookError(f"Invalid amount: {amount!r}")
+        return {
+            "reference": data.get("reference"),
+            "provider_payment_id": data.get("payment_id"),
+            "status": str(data.get("status") or "").lower() or None,
+            "amount": parsed_amount,
+            "currency": data.get("currency"),
+            "paid_at": parsed_paid_at,
+        }
+
+
+_PROVIDERS = {
+    LocalPaymentProvider.name: LocalPaymentProvider,
+}
+_instances: Dict[str, PaymentProvider] = {}
+
+
+def get_payment_provider(name: Optional[str] = None) -> PaymentProvider:
+    key = (name or PAYMENT_PROVIDER).strip().lower()
+    provider_cls = _PROVIDERS.get(key)
+    if provider_cls is None:
+        raise PaymentProviderError(f"Unknown payment provider: {key}")
+    if key not in _instances:
+        _instances[key] = provider_cls()
+    return _instances[key]
@@ -0,0 +1,421 @@
+"""
+Sliding-window API rate limiting keyed by registered API key, user or client IP.
+
+Counters live in a shared store so limits hold across workers: Redis when
+RATE_LIMIT_REDIS_URL is set, otherwise the `rate_limit_counters` table.
+Each identity/route-group pair keeps one counter per fixed window and the
+effective count is the current window plus the overlapping share of the
+previous one.
+"""
+import hashlib
+import logging
+import math
+import os
+import re
+import threading
+import time
+from dataclasses import dataclass
+from typing import Dict, List, Optional, Set, Tuple
+
+from fastapi.responses import JSONResponse
+from sqlalchemy.exc import IntegrityError
+from starlette.concurrency import run_in_threadpool
+from starlette.middleware.base import BaseHTTPMiddleware
+from starlette.requests import Request
+
+from auth import decode_access_token
+from database import SessionLocal
+from models import RateLimitCounter, RateLimitRule, RateLimitThrottle
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+RATE_LIMIT_ENABLED = os.getenv("RATE_LIMIT_ENABLED", "true").lower() in {"1", "true", "yes"}
+RATE_LIMIT_DEFAULT = os.getenv("RATE_LIMIT_DEFAULT", "120/60")
+RATE_LIMIT_GROUP_LIMITS = os.getenv(
+    "RATE_LIMIT_GROUP_LIMITS",
+    "auth=20/60,messaging=60/60,admin=120/60,payments=60/60",
+)
+RATE_LIMIT_REDIS_URL = os.getenv("RATE_LIMIT_REDIS_URL", "").strip()
+RATE_LIMIT_RULE_CACHE_SECONDS = int(os.getenv("RATE_LIMIT_RULE_CACHE_SECONDS", "30"))
+RATE_LIMIT_TRUST_PROXY = os.getenv("RATE_LIMIT_TRUST_PROXY", "false").lower() in {"1", "true", "yes"}
+
+API_KEY_HEADER = "x-api-key"
+FINGERPRINT_RE = re.compile(r"[0-9a-f]{16}")
+
+# Meta/payment callbacks and the socket/static mounts are never throttled.
+EXEMPT_PATH_PREFIXES = (
+    "/api/webhooks/",
+    "/webhook",
+    "/ws",
+    "/attachments/",
+    "/api/health",
+)
+
+ROUTE_GROUP_PREFIXES = (
+    ("/api/auth/", "auth"),
+    ("/api/admin/", "admin"),
+    ("/api/payments", "payments"),
+    ("/messages/send", "messaging"),
+    ("/send", "messaging"),
+    ("/api/chats", "chats"),
+    ("/api/templates", "templates"),
+    ("/api/users", "users"),
+)
+
+
+@dataclass
+class RateLimit:
+    limit: int
+    window_seconds: int
+
+
+@dataclass
+class RateLimitIdentity:
+    key: str
+    kind: str
+    user_id: Optional[str] = None
+
+
+@dataclass
+class RateLimitDecision:
+    allowed: bool
+    limit: int
+    remaining: int
+    reset_seconds: int
+    window_seconds: int
+
+    def headers(self) -> Dict[str, str]:
+        values = {
+            "X-RateLimit-Limit": str(self.limit),
+            "X-RateLimit-Remaining": str(max(self.remaining, 0)),
+            "X-RateLimit-Reset": str(self.reset_seconds),
+            "RateLimit-Policy": f"{self.limit};w={self.window_seconds}",
+        }
+        if not self.allowed:
+            values["Retry-After"] = str(max(self.reset_seconds, 1))
+        return values
+
+
+def parse_limit(value: Optional[str]) -> Optional[RateLimit]:
+    """Parse "120/60" (requests per seconds) into a RateLimit."""
+    if not value:
+        return None
+    raw = str(value).strip()
+    if "/" not in raw:
+        return None
+    count_part, window_part = raw.split("/", 1)
+    try:
+        limit = int(count_part.strip())
+        window = int(window_part.strip())
+    except ValueError:
+        return None
+    if limit <= 0 or window <= 0:
+        return None
+    return RateLimit(limit=limit, window_seconds=window)
+
+
+def parse_group_limits(value: Optional[str]) -> Dict[str, RateLimit]:
+    groups: Dict[str, RateLimit] = {}
+    for item in (value or "").split(","):
+        if "=" not in item:
+            continue
+        name, spec = item.split("=", 1)
+        parsed = parse_limit(spec)
+        if name.strip() and parsed:
+            groups[name.strip().lower()] = parsed
+    return groups
+
+
+def sliding_window_count(previous: int, current: int, window_seconds: int, elapsed: float) -> float:
+    """Weighted request count for a sliding window spanning two fixed windows."""
+    overlap = max(0.0, 1.0 - (elapsed / float(window_seconds)))
+    return previous * overlap + current
+
+
+def resolve_route_group(method: str, path: str) -> str:
+    if method.upper() == "POST" and path.startswith("/api/chats/") and path.rstrip("/").endswith("/message"):
+        return "messaging"
+    for prefix, group in ROUTE_GROUP_PREFIXES:
+        if path.startswith(prefix):
+            return group
+    return "default"
+
+
+def api_key_fingerprint(api_key: str) -> str:
+    return hashlib.sha256(api_key.encode("utf-8")).hexdigest()[:16]
+
+
+def api_key_rule_subject(value: str) -> str:
+    """Subject stored on an api_key rule: the key's fingerprint, whether the key or the fingerprint is given."""
+    value = value.strip()
+    if FINGERPRINT_RE.fullmatch(value):
+        return value
+    return api_key_fingerprint(value)
+
+
+class DatabaseRateLimitStore:
+    """Counters persisted in the application database."""
+
+    cleanup_every = 500
+
+    def __init__(self):
+        self._calls = 0
+        self._lock = threading.Lock()
+
+    def hit(self, bucket_key: str, window_start: int, window_seconds: int, limit: int, elapsed: float) -> Tuple[bool, float]:
+        previous_start = window_start - window_seconds
+        with SessionLocal() as session:
+            rows = (
+                session.query(RateLimitCounter)
+                .filter(RateLimitCounter.bucket_key == bucket_key)
+                .filter(RateLimitCounter.window_start.in_([previous_start, window_start]))
+                .all()
+            )
+            counts = {row.window_start: row.count for row in rows}
+            estimate = sliding_window_count(
+                counts.get(previous_start, 0), counts.get(window_start, 0), window_seconds, elapsed
+            )
+            if estimate >= limit:
+                return False, estimate
+
+            updated = (
+                session.query(RateLimitCounter)
+                .filter(RateLimitCounter.bucket_key == bucket_key)
+                .filter(RateLimitCounter.window_start == window_start)
+                .update({RateLimitCounter.count: RateLimitCounter.count + 1}, synchronize_session=False)
+            )
+            if not updated:
+                session.add(RateLimitCounter(bucket_key=bucket_key, window_start=window_start, count=1))
+            try:
+                session.commit()
+            except IntegrityError:
+                session.rollback()
+                (
+                    session.query(RateLimitCounter)
+                    .filter(RateLimitCounter.bucket_key == bucket_key)
+                    .filter(RateLimitCounter.window_start == window_start)
+                    .update({RateLimitCounter.count: RateLimitCounter.count + 1}, synchronize_session=False)
+                )
+                session.commit()
+        self._maybe_cleanup(window_start, window_seconds)
+        return True, estimate + 1
+
+    def _maybe_cleanup(self, window_start: int, window_seconds: int) -> None:
+        with self._lock:
+            self._calls += 1
+            if self._calls % self.cleanup_every:
+                return
+        cutoff = int(time.time()) - max(window_seconds, 3600) * 2
+        try:
+            with SessionLocal() as session:
+                session.query(RateLimitCounter).filter(RateLimitCounter.window_start < cutoff).delete(
+                    synchronize_session=False
+                )
+                session.commit()
+        except Exception as exc:
+            logger.warning("Rate limit counter cleanup failed: %s", exc)
+
+
+class RedisRateLimitStore:
+    """Counters kept in Redis with per-window expiry."""
+
+    def __init__(self, url: str):
+        import redis  # Optional dependency, only needed when RATE_LIMIT_REDIS_URL is set
+
+        self.client = redis.Redis.from_url(url)
+
+    def hit(self, bucket_key: str, window_start: int, window_seconds: int, limit: int, elapsed: float) -> Tuple[bool, float]:
+        previous_key = f"rl:{bucket_key}:{window_start - window_seconds}"
+        current_key = f"rl:{bucket_key}:{window_start}"
+        previous, current = self.client.mget(previous_key, current_key)
+        estimate = sliding_window_count(int(previous or 0), int(current or 0), window_seconds, elapsed)
+        if estimate >= limit:
+            return False, estimate
+        pipe = self.client.pipeline()
+        pipe.incr(current_key)
+        pipe.expire(current_key, window_seconds * 2)
+        pipe.execute()
+        return True, estimate + 1
+
+
+def _build_store():
+    if RATE_LIMIT_REDIS_URL:
+        try:
+            return RedisRateLimitStore(RATE_LIMIT_REDIS_URL)
+        except Exception as exc:
+            logger.warning("Redis rate limit store unavailable, using database: %s", exc)
+    return DatabaseRateLimitStore()
+
+
+class RateLimiter:
+    def __init__(self, store=None):
+        self.enabled = RATE_LIMIT_ENABLED
+        self.default_limit = parse_limit(RATE_LIMIT_DEFAULT) or RateLimit(limit=120, window_seconds=60)
+        self.group_limits = parse_group_limits(RATE_LIMIT_GROUP_LIMITS)
+        self._store = store
+        self._rules: List[RateLimitRule] = []
+        self._rules_loaded_at = 0.0
+        self._rules_lock = threading.Lock()
+
+    @property
+    def store(self):
+        if self._store is None:
+            self._store = _build_store()
+        return self._store
+
+    def invalidate_rules(self) -> None:
+        with self._rules_lock:
+            self._rules_loaded_at = 0.0
+
+    def _load_rules(self) -> List[RateLimitRule]:
+        now = time.monotonic()
+        with self._rules_lock:
+            if self._rules_loaded_at and now - self._rules_loaded_at < RATE_LIMIT_RULE_CACHE_SECONDS:
+                return self._rules
+        try:
+            with SessionLocal() as session:
+                rules = session.query(RateLimitRule).all()
+                session.expunge_all()
+        except Exception as exc:
+            logger.warning("Unable to load rate limit rules: %s", exc)
+            rules = self._rules
+        with self._rules_lock:
+            self._rules = rules
+            self._rules_loaded_at = now
+        return rules
+
+    def _registered_api_keys(self) -> Set[str]:
+        return {rule.subject for rule in self._load_rules() if rule.scope == "api_key" and rule.subject}
+
+    def identify(self, request: Request) -> RateLimitIdentity:
+        # Only keys an admin registered with an api_key rule get their own bucket. Any other
+        # X-API-Key is ignored, otherwise a fresh random key per request would dodge every limit.
+        api_key = (request.headers.get(API_KEY_HEADER) or "").strip()
+        if api_key:
+            fingerprint = api_key_fingerprint(api_key)
+            if fingerprint in self._registered_api_keys():
+                return RateLimitIdentity(key=f"key:{fingerprint}", kind="api_key")
+
+        authorization = request.headers.get("authorization") or ""
+        if authorization.lower().startswith("bearer "):
+            payload = decode_access_token(authorization.split(" ", 1)[1].strip())
+            if payload and payload.get("user_id"):
+                user_id = str(payload["user_id"])
+                return RateLimitIdentity(key=f"user:{user_id}", kind="user", user_id=user_id)
+
+        client_ip = request.client.host if request.client else "unknown"
+        if RATE_LIMIT_TRUST_PROXY:
+            forwarded = request.headers.get("x-forwarded-for")
+            if forwarded:
+                client_ip = forwarded.split(",")[0].strip() or client_ip
+        return RateLimitIdentity(key=f"ip:{client_ip}", kind="ip")
+
+    def resolve_limit(self, identity: RateLimitIdentity, group: str) -> RateLimit:
+        subject = identity.key.split(":", 1)[1]
+        best: Optional[Tuple[int, RateLimitRule]] = None
+        for rule in self._load_rules():
+            rule_group = (rule.route_group or "*").lower()
+            if rule_group not in ("*", group):
+                continue
+            if rule.scope in ("user", "api_key"):
+                if rule.scope != identity.kind or rule.subject != subject:
+                    continue
+                rank = 0 if rule_group == group else 1
+            elif rule.scope == "route_group":
+                rank = 2 if rule_group == group else 3
+            else:
+                continue
+            if best is None or rank < best[0]:
+                best = (rank, rule)
+        if best:
+            return RateLimit(limit=best[1].limit, window_seconds=best[1].window_seconds)
+        return self.group_limits.get(group) or self.default_limit
+
+    def check(self, request: Request) -> Optional[RateLimitDecision]:
+        if not self.enabled or request.method.upper() == "OPTIONS":
+            return None
+        path = request.url.path
+        if any(path.startswith(prefix) for prefix in EXEMPT_PATH_PREFIXES):
+            return None
+
+        group = resolve_route_group(request.method, path)
+        identity = self.identify(request)
+        limit = self.resolve_limit(identity, group)
+
+        now = time.time()
+        window_start = int(now // limit.window_seconds) * limit.window_seconds
+        elapsed = now - window_start
+        bucket_key = f"{identity.key}|{group}"
+        try:
+            allowed, estimate = self.store.hit(bucket_key, window_start, limit.window_seconds, limit.limit, elapsed)
+        except Exception as exc:
+            # Fail open: a broken counter store must not take the API down.
+            logger.warning("Rate limit store error: %s", exc)
+            return None
+
+        reset_seconds = int(math.ceil(limit.window_seconds - elapsed))
+        decision = RateLimitDecision(
+            allowed=allowed,
+            limit=limit.limit,
+            remaining=int(limit.limit - math.ceil(estimate)),
+            reset_seconds=reset_seconds,
+            window_seconds=limit.window_seconds,
+        )
+        if not allowed:
+            self.record_throttle(identity, group, limit, path, window_start)
+        return decision
+
+    def record_throttle(self, identity: RateLimitIdentity, group: str, limit: RateLimit, path: str, window_start: int) -> None:
+        now = utc_now()
+        try:
+            with SessionLocal() as session:
+                entry = (
+                    session.query(RateLimitThrottle)
+                    .filter(RateLimitThrottle.identity == identity.key)
+                    .filter(RateLimitThrottle.route_group == group)
+                    .filter(RateLimitThrottle.window_start == window_start)
+                    .first()
+                )
+                if entry is None:
+                    entry = RateLimitThrottle(
+                        identity=identity.key,
+                        identity_type=identity.kind,
+                        user_id=identity.user_id,
+                        route_group=group,
+                        window_start=window_start,
+                        limit=limit.limit,
+                        window_seconds=limit.window_seconds,
+                        blocked_count=0,
+                        first_blocked_at=now,
+                    )
+                    session.add(entry)
+                entry.blocked_count = (entry.blocked_count or 0) + 1
+                entry.last_path = path[:512]
+                entry.last_blocked_at = now
+                try:
+                    session.commit()
+                except IntegrityError:
+                    session.rollback()
+        except Exception as exc:
+            logger.warning("Unable to record rate limit throttle for %s: %s", identity.key, exc)
+
+
+rate_limiter = RateLimiter()
+
+
+class RateLimitMiddleware(BaseHTTPMiddleware):
+    async def dispatch(self, request: Request, call_next):
+        decision = await run_in_threadpool(rate_limiter.check, request)
+        if decision is None:
+            return await call_next(request)
+        if not decision.allowed:
+            return JSONResponse(
+                status_code=429,
+                content={"detail": "Rate limit exceeded. Try again later."},
+                headers=decision.headers(),
+            )
+        response = await call_next(request)
+        for header, value in decision.headers().items():
+            response.headers[header] = value
+        return response
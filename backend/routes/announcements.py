@@ -0,0 +1,244 @@
+import logging
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional
+
+from fastapi import APIRouter, Depends, HTTPException
+from sqlalchemy import func, or_
+from sqlalchemy.exc import IntegrityError
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import Announcement, AnnouncementAck, User, UserRole
+from routes.dependencies import get_admin_only_user, get_current_user
+from schemas import AnnouncementCreate, AnnouncementResponse
+from utils.audit import record_audit
+from utils.timezone import as_utc, utc_now
+from websocket_manager import manager as ws_manager
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+SEVERITIES = ("info", "warning", "critical")
+
+
+def announcement_is_live(announcement: Announcement, now: Optional[datetime] = None) -> bool:
+    if announcement.cancelled_at:
+        return False
+    expires_at = as_utc(announcement.expires_at)
+    return expires_at is None or expires_at > (now or utc_now())
+
+
+def live_announcements_query(db: Session, now: Optional[datetime] = None):
+    now = now or utc_now()
+    return (
+        db.query(Announcement)
+        .filter(Announcement.cancelled_at.is_(None))
+        .filter(or_(Announcement.expires_at.is_(None), Announcement.expires_at > now))
+    )
+
+
+def announcement_response(
+    announcement: Announcement,
+    ack: Optional[AnnouncementAck] = None,
+    ack_count: Optional[int] = None,
+) -> AnnouncementResponse:
+    return AnnouncementResponse(
+        id=announcement.id,
+        title=announcement.title,
+        body=announcement.body,
+        severity=announcement.severity,
+        expires_at=announcement.expires_at,
+        cancelled_at=announcement.cancelled_at,
+        created_by=announcement.created_by,
+        created_at=announcement.created_at,
+        acknowledged=ack is not None,
+        acknowledged_at=ack.acknowledged_at if ack else None,
+        ack_count=ack_count,
+    )
+
+
+def create_announcement(
+    db: Session,
+    actor: Optional[User],
+    title: str,
+    body: Optional[str] = None,
+    severity: str = "info",
+    expires_at: Optional[datetime] = None,
+) -> Announcement:
+    """Stage an announcement and its audit entry; the caller commits and broadcasts."""
+    if severity not in SEVERITIES:
+        raise ValueError(f"severity must be one of {', '.join(SEVERITIES)}")
+    announcement = Announcement(
+        title=title.strip(),
+        body=(body or "").strip() or None,
+        severity=severity,
+        expires_at=expires_at,
+        created_by=actor.id if actor else None,
+    )
+    db.add(announcement)
+    db.flush()
+    record_audit(
+        db,
+        actor,
+        "announcement.create",
+        "announcement",
+        announcement.id,
+        {"title": announcement.title, "severity": severity, "expires_at": expires_at.isoformat() if expires_at else None},
+    )
+    return announcement
+
+
+async def broadcast_announcement(announcement: Announcement) -> None:
+    await ws_manager.broadcast_global({
+        "type": "announcement",
+        "announcement": announcement_response(announcement).model_dump(mode="json"),
+    })
+
+
+def _get_announcement(db: Session, announcement_id: str) -> Announcement:
+    announcement = db.query(Announcement).filter(Announcement.id == announcement_id).first()
+    if not announcement:
+        raise HTTPException(status_code=404, detail="Announcement not found")
+    return announcement
+
+
+@router.get("/announcements", response_model=List[AnnouncementResponse])
+def list_live_announcements(
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    announcements = live_announcements_query(db).order_by(Announcement.created_at.desc()).all()
+    acks: Dict[str, AnnouncementAck] = {}
+    if announcements:
+        acks = {
+            ack.announcement_id: ack
+            for ack in db.query(AnnouncementAck)
+            .filter(AnnouncementAck.user_id == current_user.id)
+            .filter(AnnouncementAck.announcement_id.in_([item.id for item in announcements]))
+            .all()
+        }
+    return [announcement_response(item, acks.get(item.id)) for item in announcements]
+
+
+def _find_ack(db: Session, announcement_id: str, user_id: str) -> Optional[AnnouncementAck]:
+    return (
+        db.query(AnnouncementAck)
+        .filter(AnnouncementAck.announcement_id == announcement_id, AnnouncementAck.user_id == user_id)
+        .first()
+    )
+
+
+@router.post("/announcements/{announcement_id}/ack", response_model=AnnouncementResponse)
+def acknowledge_announcement(
+    announcement_id: str,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    announcement = _get_announcement(db, announcement_id)
+    ack = _find_ack(db, announcement.id, current_user.id)
+    if not ack:
+        ack = AnnouncementAck(announcement_id=announcement.id, user_id=current_user.id)
+        db.add(ack)
+        try:
+            db.commit()
+        except IntegrityError:
+            # A concurrent request (double click, second tab) stored the ack first.
+            db.rollback()
+            ack = _find_ack(db, announcement.id, current_user.id)
+            if not ack:
+                raise
+        else:
+            db.refresh(ack)
+    return announcement_response(announcement, ack)
+
+
+@router.get("/admin/announcements", response_model=List[AnnouncementResponse])
+def list_announcements(
+    include_inactive: bool = False,
+    limit: int = 100,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    query = db.query(Announcement) if include_inactive else live_announcements_query(db)
+    announcements = query.order_by(Announcement.created_at.desc()).limit(max(1, min(limit, 500))).all()
+    counts: Dict[str, int] = {}
+    if announcements:
+        counts = dict(
+            db.query(AnnouncementAck.announcement_id, func.count(AnnouncementAck.id))
+            .filter(AnnouncementAck.announcement_id.in_([item.id for item in announcements]))
+            .group_by(AnnouncementAck.announcement_id)
+            .all()
+        )
+    return [announcement_response(item, ack_count=counts.get(item.id, 0)) for item in announcements]
+
+
+@router.post("/admin/announcements", response_model=AnnouncementResponse)
+async def post_announcement(
+    payload: AnnouncementCreate,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    expires_at = payload.expires_at
+    if payload.expires_in_minutes:
+        expires_at = utc_now() + timedelta(minutes=payload.expires_in_minutes)
+    if expires_at and as_utc(expires_at) <= utc_now():
+        raise HTTPException(status_code=400, detail="Expiry must be in the future")
+    try:
+        announcement = create_announcement(
+            db, current_user, payload.title, payload.body, payload.severity.lower(), expires_at
+        )
+    except ValueError as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    db.commit()
+    db.refresh(announcement)
+    await broadcast_announcement(announcement)
+    return announcement_response(announcement, ack_count=0)
+
+
+@router.get("/admin/announcements/{announcement_id}/acks")
+def list_announcement_acks(
+    announcement_id: str,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    """Who acknowledged the announcement, and which active agents have not yet."""
+    announcement = _get_announcement(db, announcement_id)
+    rows = (
+        db.query(AnnouncementAck, User)
+        .join(User, User.id == AnnouncementAck.user_id)
+        .filter(AnnouncementAck.announcement_id == announcement.id)
+        .order_by(AnnouncementAck.acknowledged_at.asc())
+        .all()
+    )
+    acknowledged_ids = {ack.user_id for ack, _ in rows}
+    pending = (
+        db.query(User)
+        .filter(User.role == UserRole.AGENT, User.is_active.is_(True))
+        .order_by(User.name.asc())
+        .all()
+    )
+    return {
+        "announcement": announcement_response(announcement, ack_count=len(rows)),
+        "acknowledged": [
+            {"user_id": user.id, "name": user.name, "acknowledged_at": ack.acknowledged_at} for ack, user in rows
+        ],
+        "pending": [{"user_id": user.id, "name": user.name} for user in pending if user.id not in acknowledged_ids],
+    }
+
+
+@router.delete("/admin/announcements/{announcement_id}", response_model=AnnouncementResponse)
+async def cancel_announcement(
+    announcement_id: str,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    announcement = _get_announcement(db, announcement_id)
+    if not announcement.cancelled_at:
+        announcement.cancelled_at = utc_now()
+        announcement.cancelled_by = current_user.id
+        record_audit(db, current_user, "announcement.cancel", "announcement", announcement.id, {"title": announcement.title})
+        db.commit()
+        db.refresh(announcement)
+        await ws_manager.broadcast_global({"type": "announcement_cancelled", "announcement_id": announcement.id})
+    return announcement_response(announcement)
@@ -1,6 +1,9 @@
 import hashlib
+import hmac
 import html
+import logging
 import secrets
+import uuid
 from datetime import timedelta
 from typing import Optional
 
@@ -9,15 +12,19 @@ from sqlalchemy import func
 from sqlalchemy.exc import IntegrityError
 from sqlalchemy.orm import Session
 
-from auth import create_access_token, get_password_hash, verify_password
+from auth import JWT_SECRET, create_access_token, get_password_hash, verify_password
 from database import get_db
-from models import PasswordResetToken, Position, User, UserRole
+from models import LoginCode, PasswordResetToken, Position, User, UserApprovalStatus, UserRole
 from permissions import get_default_position
 from routes.dependencies import _annotate_user, get_current_user, normalize_email
+from routes.signup_approvals import is_auto_approved_email, notify_signup_received
 from schemas import (
     AdminUserCreate,
     AuthConfigResponse,
     ForgotPasswordRequest,
+    PasswordlessCodeVerify,
+    PasswordlessLinkVerify,
+    PasswordlessLoginRequest,
     ResetPasswordRequest,
     TokenResponse,
     UserLogin,
@@ -31,16 +38,29 @@ from settings import (
     ALLOW_PUBLIC_SIGNUP,
     FORGOT_PASSWORD_ENABLED,
     FRONTEND_BASE_URL,
+    LOGIN_CODE_EMAIL_SUBJECT,
+    LOGIN_CODE_LIFETIME_MINUTES,
+    LOGIN_CODE_MAX_ATTEMPTS,
+    LOGIN_CODE_MAX_REQUESTS,
+    LOGIN_CODE_REQUEST_WINDOW_MINUTES,
     PASSWORD_RESET_EMAIL_CONTACT,
     PASSWORD_RESET_EMAIL_SUBJECT,
     PASSWORD_RESET_TOKEN_LIFETIME_MINUTES,
+    PASSWORDLESS_LOGIN_ENABLED,
+    SIGNUP_REQUIRE_APPROVAL,
 )
+from utils.audit import record_audit
 from utils.mailer import send_email
 from utils.timezone import utc_now
 from routes.dependencies import get_current_user
 
+logger = logging.getLogger(__name__)
+
 router = APIRouter()
 
+LOGIN_METHODS = ("code", "link")
+PASSWORDLESS_GENERIC_MESSAGE = "If passwordless sign-in is enabled for that account, a sign-in email has been sent."
+
 
 def _resolve_position_for_user(
     db: Session,
@@ -140,6 +160,100 @@ def _find_user_by_identifier(db: Session, identifier: str) -> Optional[User]:
     return None
 
 
+def _assert_login_allowed(user: User) -> None:
+    if user.approval_status == UserApprovalStatus.PENDING:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="Your account is awaiting administrator approval"
+        )
+    if user.approval_status == UserApprovalStatus.REJECTED:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="Your signup request was not approved"
+        )
+
+
+def _token_response(user: User) -> TokenResponse:
+    access_token = create_access_token(data={"user_id": user.id, "email": user.email})
+    user = _annotate_user(user)
+    return TokenResponse(
+        access_token=access_token,
+        user=UserResponse.model_validate(user)
+    )
+
+
+def passwordless_allowed(user: Optional[User]) -> bool:
+    """Passwordless sign-in is on globally and for the user's position, and we can email them."""
+    if not PASSWORDLESS_LOGIN_ENABLED or not user or not user.email:
+        return False
+    if getattr(user, "is_active", True) is False:
+        return False
+    if user.approval_status not in (None, UserApprovalStatus.APPROVED):
+        return False
+    position = getattr(user, "position", None)
+    return bool(position and position.passwordless_login_enabled)
+
+
+def generate_login_code() -> str:
+    return f"{secrets.randbelow(10 ** 6):06d}"
+
+
+def hash_login_code(code_id: str, code: str) -> str:
+    # Keyed and salted with the row id: six digits are trivial to brute force from a plain hash.
+    return hmac.new(JWT_SECRET.encode("utf-8"), f"{code_id}:{code}".encode("utf-8"), hashlib.sha256).hexdigest()
+
+
+def _build_magic_link_url(raw_token: str, request: Optional[Request]) -> str:
+    base = _reset_base_url(request)
+    return f"{base}/login/magic?token={raw_token}"
+
+
+def _purge_expired_login_codes(db: Session) -> None:
+    # Keep rows for the whole request window so they still count towards the rate limit.
+    cutoff = utc_now() - timedelta(minutes=max(LOGIN_CODE_LIFETIME_MINUTES, LOGIN_CODE_REQUEST_WINDOW_MINUTES))
+    db.query(LoginCode).filter(LoginCode.created_at < cutoff).delete(synchronize_session=False)
+
+
+def _send_login_code_email(email: str, name: Optional[str], code: Optional[str], link: Optional[str]) -> None:
+    recipient_name = name or "there"
+    minutes = LOGIN_CODE_LIFETIME_MINUTES
+    if code:
+        instruction = f"Your TickleGram sign-in code is {code}. It expires in {minutes} minutes and can be used once."
+        action_html = f'<p style="text-align:center;font-size:28px;letter-spacing:6px;margin:24px 0;"><strong>{code}</strong></p>'
+    else:
+        instruction = f"Use the link below within {minutes} minutes to sign in to TickleGram. It can be used once.\n\n{link}"
+        action_html = f"""
+        <p style="text-align:center;margin:24px 0;">
+            <a href="{link}" style="background:#a855f7;color:#fff;padding:12px 20px;border-radius:999px;text-decoration:none;display:inline-block;">
+                Sign in
+            </a>
+        </p>
+        <p>If the button doesn't work, paste this link into your browser:<br/><a href="{link}">{link}</a></p>
+        """
+    plain_body = (
+        f"Hi {recipient_name},\n\n"
+        f"{instruction}\n\n"
+        "If you didn't try to sign in, you can ignore this email; nobody can sign in without it.\n\n"
+        f"Need help? Reach out to us anytime at {PASSWORD_RESET_EMAIL_CONTACT}.\n\n"
+        "- The TickleGram Team"
+    )
+    html_body = f"""
+        <p>Hi {html.escape(recipient_name)},</p>
+        <p>{"Here is your sign-in code. It expires in " + str(minutes) + " minutes and can be used once." if code else "Click the button below within " + str(minutes) + " minutes to sign in. The link can be used once."}</p>
+        {action_html}
+        <p>If you didn't try to sign in, you can ignore this email; nobody can sign in without it.</p>
+        <p>- The TickleGram Team</p>
+    """
+    delivered = send_email(
+        subject=LOGIN_CODE_EMAIL_SUBJECT,
+        body_text=plain_body,
+        body_html=html_body,
+        to_addresses=[email],
+    )
+    if not delivered:
+        logger.warning("Sign-in email not sent (check SMTP config). Recipient=%s", email)
+
+
 @router.post("/auth/register", response_model=UserResponse)
 def register(user_data: UserRegister, db: Session = Depends(get_db)):
     normalized_email = normalize_email(user_data.email)
@@ -215,6 +329,7 @@ def signup(
             raise HTTPException(status_code=400, detail="Employee ID already registered")
 
     role = UserRole.AGENT
+    auto_approved = not SIGNUP_REQUIRE_APPROVAL or is_auto_approved_email(normalized_email)
     new_user = User(
         name=user_data.name,
         email=normalized_email,
@@ -222,15 +337,31 @@ def signup(
         country=user_data.country,
         emp_id=emp_id,
         password_hash=get_password_hash(user_data.password),
-        role=role
+        role=role,
+        approval_status=UserApprovalStatus.APPROVED if auto_approved else UserApprovalStatus.PENDING,
     )
+    if auto_approved and SIGNUP_REQUIRE_APPROVAL:
+        new_user.approval_note = "Auto-approved trusted email domain"
+        new_user.approval_decided_at = utc_now()
     position = _resolve_position_for_user(db, role, None)
     if position:
         new_user.position_id = position.id
     db.add(new_user)
+    db.flush()
+    record_audit(
+        db,
+        None,
+        "signup.auto_approve" if auto_approved else "signup.request",
+        "user",
+        new_user.id,
+        {"email": normalized_email},
+    )
     db.commit()
     db.refresh(new_user)
 
+    if new_user.approval_status == UserApprovalStatus.PENDING:
+        notify_signup_received(db, new_user)
+
     return _annotate_user(new_user)
 
 
@@ -239,6 +370,8 @@ def auth_config():
     return AuthConfigResponse(
         allow_public_signup=ALLOW_PUBLIC_SIGNUP,
         forgot_password_enabled=FORGOT_PASSWORD_ENABLED,
+        signup_requires_approval=ALLOW_PUBLIC_SIGNUP and SIGNUP_REQUIRE_APPROVAL,
+        passwordless_login_enabled=PASSWORDLESS_LOGIN_ENABLED,
     )
 
 
@@ -388,14 +521,135 @@ def login(credentials: UserLogin, db: Session = Depends(get_db)):
             status_code=status.HTTP_401_UNAUTHORIZED,
             detail="Incorrect email/contact or password"
         )
+    _assert_login_allowed(user)
+    return _token_response(user)
 
-    access_token = create_access_token(data={"user_id": user.id, "email": user.email})
-    user = _annotate_user(user)
 
-    return TokenResponse(
-        access_token=access_token,
-        user=UserResponse.model_validate(user)
+@router.post("/auth/passwordless/request")
+def request_passwordless_login(
+    payload: PasswordlessLoginRequest,
+    request: Request,
+    db: Session = Depends(get_db),
+):
+    """Email a one-time code or magic link. The response never reveals whether the account exists."""
+    if not PASSWORDLESS_LOGIN_ENABLED:
+        raise HTTPException(status_code=status.HTTP_404_NOT_FOUND, detail="Not found")
+    method = (payload.method or "code").lower()
+    if method not in LOGIN_METHODS:
+        raise HTTPException(status_code=400, detail="method must be 'code' or 'link'")
+
+    _purge_expired_login_codes(db)
+    user = _find_user_by_identifier(db, payload.identifier)
+    if not passwordless_allowed(user):
+        db.commit()
+        return {"message": PASSWORDLESS_GENERIC_MESSAGE}
+
+    now = utc_now()
+    recent_requests = (
+        db.query(func.count(LoginCode.id))
+        .filter(LoginCode.user_id == user.id)
+        .filter(LoginCode.created_at >= now - timedelta(minutes=LOGIN_CODE_REQUEST_WINDOW_MINUTES))
+        .scalar()
+        or 0
+    )
+    if recent_requests >= LOGIN_CODE_MAX_REQUESTS:
+        logger.info("Passwordless sign-in request limit reached for user %s", user.id)
+        db.commit()
+        return {"message": PASSWORDLESS_GENERIC_MESSAGE}
+
+    # Only the newest code/link is valid; used ones stay until expiry so they count towards the limit.
+    db.query(LoginCode).filter(
+        LoginCode.user_id == user.id,
+        LoginCode.used_at.is_(None),
+    ).update({LoginCode.used_at: now}, synchronize_session=False)
+
+    login_code = LoginCode(
+        user_id=user.id,
+        method=method,
+        expires_at=now + timedelta(minutes=LOGIN_CODE_LIFETIME_MINUTES),
+        requested_ip=request.client.host if request.client else None,
+    )
+    raw_code: Optional[str] = None
+    raw_token: Optional[str] = None
+    if method == "code":
+        login_code.id = str(uuid.uuid4())
+        raw_code = generate_login_code()
+        login_code.token_hash = hash_login_code(login_code.id, raw_code)
+    else:
+        raw_token = secrets.token_urlsafe(48)
+        login_code.token_hash = _hash_reset_token(raw_token)
+    db.add(login_code)
+    record_audit(db, user, "auth.passwordless_request", "user", user.id, {"method": method})
+    try:
+        db.commit()
+    except IntegrityError:
+        db.rollback()
+        raise HTTPException(status_code=500, detail="Unable to process request at this time")
+
+    link = _build_magic_link_url(raw_token, request) if raw_token else None
+    _send_login_code_email(user.email, user.name, raw_code, link)
+    return {"message": PASSWORDLESS_GENERIC_MESSAGE}
+
+
+def _complete_passwordless_login(db: Session, user: Optional[User], login_code: LoginCode) -> TokenResponse:
+    if not passwordless_allowed(user):
+        raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Passwordless sign-in is not enabled for this account")
+    _assert_login_allowed(user)
+    login_code.used_at = utc_now()
+    record_audit(db, user, "auth.passwordless_login", "user", user.id, {"method": login_code.method})
+    db.commit()
+    return _token_response(user)
+
+
+@router.post("/auth/passwordless/verify", response_model=TokenResponse)
+def verify_login_code(payload: PasswordlessCodeVerify, db: Session = Depends(get_db)):
+    if not PASSWORDLESS_LOGIN_ENABLED:
+        raise HTTPException(status_code=status.HTTP_404_NOT_FOUND, detail="Not found")
+    invalid = HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail="Invalid or expired code")
+    user = _find_user_by_identifier(db, payload.identifier)
+    if not user:
+        raise invalid
+    login_code = (
+        db.query(LoginCode)
+        .filter(
+            LoginCode.user_id == user.id,
+            LoginCode.method == "code",
+            LoginCode.used_at.is_(None),
+            LoginCode.expires_at > utc_now(),
+        )
+        .order_by(LoginCode.created_at.desc())
+        .first()
+    )
+    if not login_code:
+        raise invalid
+    if not hmac.compare_digest(login_code.token_hash, hash_login_code(login_code.id, payload.code.strip())):
+        login_code.failed_attempts = (login_code.failed_attempts or 0) + 1
+        if login_code.failed_attempts >= LOGIN_CODE_MAX_ATTEMPTS:
+            # Burn the code; the user has to request a new one.
+            login_code.used_at = utc_now()
+        db.commit()
+        raise invalid
+    return _complete_passwordless_login(db, user, login_code)
+
+
+@router.post("/auth/passwordless/verify-link", response_model=TokenResponse)
+def verify_magic_link(payload: PasswordlessLinkVerify, db: Session = Depends(get_db)):
+    if not PASSWORDLESS_LOGIN_ENABLED:
+        raise HTTPException(status_code=status.HTTP_404_NOT_FOUND, detail="Not found")
+    login_code = (
+        db.query(LoginCode)
+        .filter(
+            LoginCode.token_hash == _hash_reset_token(payload.token),
+            LoginCode.method == "link",
+            LoginCode.used_at.is_(None),
+            LoginCode.expires_at > utc_now(),
+        )
+        .first()
     )
+    if not login_code:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail="Invalid or expired link")
+    user = db.query(User).filter(User.id == login_code.user_id).first()
+    return _complete_passwordless_login(db, user, login_code)
 
 
 @router.get("/auth/me", response_model=UserResponse)
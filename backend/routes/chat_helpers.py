@@ -1,28 +1,43 @@
+import hashlib
 import json
 import re
 from typing import Any, Dict, List, Optional, Set, Tuple, Type, Union
 
-from sqlalchemy import func
+from fastapi import HTTPException, status
+from sqlalchemy import and_, func, or_
 from sqlalchemy.orm import Session, joinedload
 
+from facebook_api import facebook_client, FacebookMode
+from instagram_api import instagram_client, InstagramMode
 from models import (
     AssignmentCursor,
     Chat,
     ChatStatus,
     FacebookMessage,
+    FacebookPage,
     FacebookUser,
+    InstagramAccount,
     InstagramMessage as InstagramChatMessage,
     InstagramUser,
     MessagePlatform,
     MessageSender,
     MessageType,
     User,
+    UserApprovalStatus,
     UserRole,
 )
+from permissions import PermissionCode, user_has_any_permission
+from schemas import MessageResponse
+from settings import AGENT_CHAT_CAPACITY, LEAD_PRIORITY_ROUTING_ENABLED
+from utils.emergency import assignment_paused
+from utils.field_crypto import seal_metadata
 from utils.timezone import utc_now
+from websocket_manager import manager as ws_manager
 
 ChatMessageModel = Union[InstagramChatMessage, FacebookMessage]
 
+MESSAGE_MID_MAX_LENGTH = 512
+
 
 def _message_model_for_platform(platform: MessagePlatform) -> Type[ChatMessageModel]:
     return InstagramChatMessage if platform == MessagePlatform.INSTAGRAM else FacebookMessage
@@ -52,6 +67,57 @@ def message_query_for_chat(db: Session, chat: Chat):
     return db.query(_message_model_for_platform(chat.platform))
 
 
+def normalize_message_mid(mid: Optional[str]) -> Optional[str]:
+    """Return the stored form of a Graph message id; overly long ids are hashed."""
+    if not mid:
+        return None
+    mid = str(mid).strip()
+    if not mid:
+        return None
+    if len(mid) > MESSAGE_MID_MAX_LENGTH:
+        return f"hash:{hashlib.sha256(mid.encode('utf-8')).hexdigest()}"
+    return mid
+
+
+def find_message_by_mid(db: Session, chat: Chat, mid: Optional[str]) -> Optional[ChatMessageModel]:
+    """
+    Locate a chat message by its Graph message id.
+
+    Falls back to the facebook_mid kept in metadata_json for rows stored before
+    the mid column existed.
+    """
+    normalized = normalize_message_mid(mid)
+    if not normalized:
+        return None
+    model = _message_model_for_platform(chat.platform)
+    message = (
+        db.query(model)
+        .filter(model.chat_id == chat.id, model.mid == normalized)
+        .first()
+    )
+    if message or normalized.startswith("hash:"):
+        return message
+    candidates = (
+        db.query(model)
+        .filter(
+            model.chat_id == chat.id,
+            model.mid.is_(None),
+            model.metadata_json.contains(normalized),
+        )
+        .order_by(model.timestamp.desc())
+        .limit(5)
+        .all()
+    )
+    for candidate in candidates:
+        try:
+            meta = json.loads(candidate.metadata_json or "{}")
+        except (TypeError, ValueError):
+            continue
+        if normalized in (meta.get("facebook_mid"), meta.get("mid")):
+            return candidate
+    return None
+
+
 def _requires_sqlite_instagram_fallback(db: Session) -> bool:
     bind = getattr(db, "bind", None)
     if bind is None:
@@ -78,7 +144,7 @@ def _merge_message_metadata(
             "role": sent_by.role.value if isinstance(sent_by.role, UserRole) else str(sent_by.role),
         }
     if extra:
-        payload.update(extra)
+        payload.update(seal_metadata(extra))
     try:
         return json.dumps(payload)
     except (TypeError, ValueError):
@@ -94,6 +160,8 @@ def _is_assignable_agent(user: Optional[User]) -> bool:
         return False
     if getattr(user, "can_receive_new_chats", True) is False:
         return False
+    if getattr(user, "approval_status", None) not in (None, UserApprovalStatus.APPROVED):
+        return False
     return True
 
 
@@ -123,12 +191,20 @@ def _get_assignment_cursor(db: Session, name: str = "default") -> AssignmentCurs
 
 
 def _assign_chat_round_robin(db: Session, chat: Chat) -> Optional[User]:
-    agents = _get_assignable_agents(db)
+    agents = [] if assignment_paused(db) else _get_assignable_agents(db)
     if not agents:
         chat.assigned_to = None
         chat.status = ChatStatus.UNASSIGNED
         return None
 
+    if LEAD_PRIORITY_ROUTING_ENABLED and chat.lead_tier == "hot":
+        priority_agent = pick_priority_agent(db, agents)
+        if priority_agent:
+            # Hot leads skip the rotation; the cursor stays where it was.
+            chat.assigned_to = priority_agent.id
+            chat.status = ChatStatus.ASSIGNED
+            return priority_agent
+
     cursor = _get_assignment_cursor(db)
     ordered_agents = sorted(
         agents,
@@ -156,6 +232,50 @@ def _chat_requires_agent_reply(chat: Chat) -> bool:
     return chat.last_outgoing_at < chat.last_incoming_at
 
 
+def awaiting_agent_reply_clause():
+    """SQL counterpart of _chat_requires_agent_reply."""
+    return and_(
+        Chat.last_incoming_at.isnot(None),
+        or_(Chat.last_outgoing_at.is_(None), Chat.last_outgoing_at < Chat.last_incoming_at),
+    )
+
+
+def agent_open_chat_counts(db: Session) -> Dict[str, int]:
+    """Number of assigned chats still waiting on an agent reply, keyed by agent id."""
+    rows = (
+        db.query(Chat.assigned_to, func.count(Chat.id))
+        .filter(Chat.status == ChatStatus.ASSIGNED)
+        .filter(Chat.assigned_to.isnot(None))
+        .filter(awaiting_agent_reply_clause())
+        .group_by(Chat.assigned_to)
+        .all()
+    )
+    return {str(agent_id): int(count) for agent_id, count in rows}
+
+
+def agent_at_capacity(
+    db: Session,
+    agent_id: Optional[str],
+    open_counts: Optional[Dict[str, int]] = None,
+    capacity: Optional[int] = None,
+) -> bool:
+    """True when the agent already holds AGENT_CHAT_CAPACITY open chats (0 means unlimited)."""
+    limit = AGENT_CHAT_CAPACITY if capacity is None else capacity
+    if not agent_id or limit <= 0:
+        return False
+    counts = open_counts if open_counts is not None else agent_open_chat_counts(db)
+    return counts.get(str(agent_id), 0) >= limit
+
+
+def pick_priority_agent(db: Session, agents: List[User]) -> Optional[User]:
+    """Least loaded agent with room for another chat, or None when everyone is at capacity."""
+    open_counts = agent_open_chat_counts(db)
+    available = [agent for agent in agents if not agent_at_capacity(db, agent.id, open_counts)]
+    if not available:
+        return None
+    return min(available, key=lambda agent: (open_counts.get(str(agent.id), 0), agent.id))
+
+
 def gather_dm_notify_users(db: Session, chat: Optional[Chat] = None) -> Set[str]:
     """Collect user IDs that should receive DM notifications."""
     notify_users: Set[str] = set()
@@ -167,6 +287,20 @@ def gather_dm_notify_users(db: Session, chat: Optional[Chat] = None) -> Set[str]
     return notify_users
 
 
+async def broadcast_chat_assignment(db: Session, chat: Chat, previous_assigned_to: Optional[str] = None) -> None:
+    """Tell admins and the new and previous assignee that a chat changed hands (after the caller committed)."""
+    notify_users = gather_dm_notify_users(db, chat)
+    if previous_assigned_to:
+        notify_users.add(str(previous_assigned_to))
+    await ws_manager.broadcast_to_users(notify_users, {
+        "type": "chat_assigned",
+        "chat_id": str(chat.id),
+        "assigned_to": str(chat.assigned_to) if chat.assigned_to else None,
+        "previous_assigned_to": str(previous_assigned_to) if previous_assigned_to else None,
+        "status": chat.status.value if chat.status else None,
+    })
+
+
 def normalize_message_sender(message: ChatMessageModel) -> None:
     """Ensure message sender has a valid enum value."""
     if not message:
@@ -176,6 +310,30 @@ def normalize_message_sender(message: ChatMessageModel) -> None:
         message.sender = MessageSender.INSTAGRAM_PAGE
 
 
+_CHAT_VIEW_ALL_PERMISSIONS = [
+    PermissionCode.CHAT_VIEW_ALL.value,
+    PermissionCode.CHAT_VIEW_TEAM.value,
+]
+
+
+def user_can_view_all_chats(user: User) -> bool:
+    if not user:
+        return False
+    if user.role == UserRole.ADMIN:
+        return True
+    return user_has_any_permission(user, _CHAT_VIEW_ALL_PERMISSIONS)
+
+
+def assert_chat_access(user: User, chat: Chat) -> None:
+    if not user or not chat:
+        raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Access denied")
+    if user_can_view_all_chats(user):
+        return
+    if chat.assigned_to == user.id:
+        return
+    raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Access denied")
+
+
 def _serialize_user_light(user: Optional[User]) -> Optional[Dict[str, str]]:
     if not user:
         return None
@@ -186,6 +344,89 @@ def _serialize_user_light(user: Optional[User]) -> Optional[Dict[str, str]]:
     }
 
 
+class ChatDeliveryError(Exception):
+    """Raised when an outbound message cannot be delivered to the platform."""
+
+
+async def deliver_chat_text(
+    db: Session,
+    chat: Chat,
+    text: str,
+    sent_by: Optional[User] = None,
+    extra_metadata: Optional[Dict[str, Any]] = None,
+    automated: bool = False,
+) -> ChatMessageModel:
+    """
+    Send a plain-text message to the chat's customer and stage the message row.
+
+    Automated messages (confirmations, notices) leave last_outgoing_at untouched
+    so the chat still counts as waiting for an agent. The caller commits.
+    """
+    metadata_extra: Dict[str, Any] = dict(extra_metadata or {})
+    if automated:
+        metadata_extra.setdefault("automated", True)
+
+    sent_mid: Optional[str] = None
+    if not (instagram_client.mode == InstagramMode.MOCK and facebook_client.mode == FacebookMode.MOCK):
+        if chat.platform == MessagePlatform.FACEBOOK:
+            page = None
+            if chat.facebook_page_id:
+                page = db.query(FacebookPage).filter(FacebookPage.page_id == chat.facebook_page_id).first()
+            if not page or not page.is_active:
+                raise ChatDeliveryError("Facebook page not found or inactive")
+            result = await facebook_client.send_text_message(
+                page_access_token=page.access_token,
+                recipient_id=chat.facebook_user_id,
+                text=text,
+            )
+        else:
+            account = None
+            if chat.facebook_page_id:
+                account = db.query(InstagramAccount).filter(InstagramAccount.page_id == chat.facebook_page_id).first()
+            if not account:
+                raise ChatDeliveryError("Instagram account not found")
+            result = await instagram_client.send_text_message(
+                page_access_token=account.access_token,
+                recipient_id=chat.instagram_user_id,
+                text=text,
+            )
+        if not result.get("success"):
+            raise ChatDeliveryError(result.get("error") or "Failed to send message")
+        sent_mid = result.get("message_id")
+        if chat.platform == MessagePlatform.FACEBOOK and sent_mid:
+            metadata_extra.setdefault("facebook_mid", sent_mid)
+
+    event_time = utc_now()
+    message = create_chat_message_record(
+        chat,
+        sender=MessageSender.AGENT,
+        content=text,
+        message_type=MessageType.TEXT,
+        timestamp=event_time,
+        is_ticklegram=True,
+        mid=normalize_message_mid(sent_mid),
+        metadata_json=_merge_message_metadata(None, sent_by=sent_by, extra=metadata_extra or None),
+    )
+    db.add(message)
+    chat.last_message = text
+    if not automated:
+        chat.last_outgoing_at = event_time
+    chat.updated_at = event_time
+    return message
+
+
+async def broadcast_chat_message(db: Session, chat: Chat, message: ChatMessageModel, sender: str = "agent") -> None:
+    """Push a committed chat message to the assigned agent and admins."""
+    payload = MessageResponse.model_validate(message).model_dump(mode="json")
+    await ws_manager.broadcast_to_users(gather_dm_notify_users(db, chat), {
+        "type": "new_message",
+        "chat_id": str(chat.id),
+        "platform": chat.platform.value,
+        "sender": sender,
+        "message": payload,
+    })
+
+
 def reassign_chats_from_inactive_agents(db: Session) -> int:
     """
     Move chats away from inactive agents to active agents in round-robin order.
@@ -0,0 +1,240 @@
+import asyncio
+import logging
+import threading
+import time
+from typing import Any, Dict, Optional, Tuple
+
+from fastapi import APIRouter, Depends, HTTPException
+from sqlalchemy import func
+from sqlalchemy.orm import Session, joinedload
+
+from database import get_db
+from models import Chat, ChatStatus, MessageSender, User
+from permissions import PermissionCode
+from routes.chat_helpers import (
+    _is_assignable_agent,
+    _message_model_for_platform,
+    agent_at_capacity,
+    broadcast_chat_assignment,
+)
+from routes.dependencies import get_admin_only_user, require_permissions
+from schemas import CrmOwnerRouteResult
+from settings import (
+    CRM_OWNER_CACHE_MINUTES,
+    CRM_OWNER_COUNTRY_CODE,
+    CRM_OWNER_NEGATIVE_CACHE_MINUTES,
+    CRM_OWNER_ROUTING_ENABLED,
+)
+from utils.admin_bridge import post_admin_route
+from utils.audit import record_audit
+from utils.contact_identifiers import extract_phones
+from utils.emergency import assignment_paused
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+CHECK_DUPLICATE_ROUTE = "/routes/contactRoute.php?action=checkDuplicateMobile"
+# Same fields the inquiry modal reads the owner from
+OWNER_EMP_ID_KEYS = ("c_employee_id", "c_employeeid", "employee_id", "emp_id")
+CUSTOMER_SENDERS = (MessageSender.INSTAGRAM_USER, MessageSender.FACEBOOK_USER)
+
+LOOKUP_FOUND = "found"
+LOOKUP_NOT_FOUND = "not_found"
+LOOKUP_UNAVAILABLE = "unavailable"
+
+OUTCOME_ASSIGNED = "assigned"
+OUTCOME_ALREADY_OWNER = "already_owner"
+OUTCOME_NOT_IN_CRM = "not_in_crm"
+OUTCOME_NO_USER = "no_matching_user"
+OUTCOME_INELIGIBLE = "owner_ineligible"
+OUTCOME_AT_CAPACITY = "owner_at_capacity"
+OUTCOME_ENGAGED = "kept_current_agent"
+OUTCOME_UNAVAILABLE = "crm_unavailable"
+OUTCOME_NO_PHONE = "no_phone"
+OUTCOME_PAUSED = "assignment_paused"
+
+# phone -> (expires at (monotonic), owner emp_id or None)
+_owner_cache: Dict[str, Tuple[float, Optional[str]]] = {}
+_owner_cache_lock = threading.Lock()
+
+
+def owner_emp_id_from_response(result: Optional[Dict[str, Any]]) -> Optional[str]:
+    """Owning employee id from a checkDuplicateMobile response (first matching contact)."""
+    if not isinstance(result, dict):
+        return None
+    data = result.get("data")
+    candidates = []
+    if isinstance(data, list) and data and isinstance(data[0], dict):
+        candidates.append(data[0])
+    elif isinstance(data, dict):
+        candidates.append(data)
+    candidates.append(result)
+    for candidate in candidates:
+        for key in OWNER_EMP_ID_KEYS:
+            value = candidate.get(key)
+            if value not in (None, "", 0, "0"):
+                return str(value).strip()
+    return None
+
+
+def clear_owner_cache() -> int:
+    with _owner_cache_lock:
+        count = len(_owner_cache)
+        _owner_cache.clear()
+    return count
+
+
+def lookup_crm_owner(phone: str) -> Tuple[str, Optional[str]]:
+    """
+    (status, emp_id) for a normalized phone number. Blocking HTTP call; run it in a
+    thread from async code. Bridge failures are not cached so the next message retries.
+    """
+    now = time.monotonic()
+    with _owner_cache_lock:
+        cached = _owner_cache.get(phone)
+    if cached and cached[0] > now:
+        return (LOOKUP_FOUND if cached[1] else LOOKUP_NOT_FOUND), cached[1]
+
+    result = post_admin_route(CHECK_DUPLICATE_ROUTE, {"mobile": phone, "country_code": CRM_OWNER_COUNTRY_CODE})
+    if result is None:
+        return LOOKUP_UNAVAILABLE, None
+    emp_id = owner_emp_id_from_response(result)
+    ttl_minutes = CRM_OWNER_CACHE_MINUTES if emp_id else CRM_OWNER_NEGATIVE_CACHE_MINUTES
+    with _owner_cache_lock:
+        _owner_cache[phone] = (now + ttl_minutes * 60, emp_id)
+    return (LOOKUP_FOUND if emp_id else LOOKUP_NOT_FOUND), emp_id
+
+
+def find_owner_user(db: Session, emp_id: str) -> Optional[User]:
+    return (
+        db.query(User)
+        .options(joinedload(User.position))
+        .filter(func.lower(User.emp_id) == emp_id.strip().lower())
+        .first()
+    )
+
+
+def apply_crm_owner(
+    db: Session,
+    chat: Chat,
+    phone: str,
+    emp_id: Optional[str],
+    actor: Optional[User] = None,
+    keep_engaged: bool = True,
+) -> Dict[str, Any]:
+    """
+    Assign the chat to the CRM owner when they can take it. Otherwise the current
+    assignment (round-robin or manual) stays. The caller commits.
+    """
+    chat.crm_owner_phone = phone
+    chat.crm_owner_emp_id = emp_id
+    chat.crm_owner_checked_at = utc_now()
+    result: Dict[str, Any] = {"chat_id": chat.id, "phone": phone, "emp_id": emp_id, "assigned_to": chat.assigned_to}
+    if not emp_id:
+        return {**result, "outcome": OUTCOME_NOT_IN_CRM}
+
+    owner = find_owner_user(db, emp_id)
+    if not owner:
+        return {**result, "outcome": OUTCOME_NO_USER}
+    if chat.assigned_to == owner.id:
+        return {**result, "outcome": OUTCOME_ALREADY_OWNER}
+    # Emergency mode holds every assignment, the CRM owner's included.
+    if assignment_paused(db):
+        return {**result, "outcome": OUTCOME_PAUSED, "owner_id": owner.id}
+    if not _is_assignable_agent(owner):
+        return {**result, "outcome": OUTCOME_INELIGIBLE, "owner_id": owner.id}
+    if agent_at_capacity(db, owner.id):
+        return {**result, "outcome": OUTCOME_AT_CAPACITY, "owner_id": owner.id}
+    # Do not pull a conversation away from an agent who has already replied.
+    if keep_engaged and chat.assigned_to and chat.last_outgoing_at:
+        return {**result, "outcome": OUTCOME_ENGAGED, "owner_id": owner.id}
+
+    previous = chat.assigned_to
+    chat.assigned_to = owner.id
+    chat.status = ChatStatus.ASSIGNED
+    record_audit(
+        db,
+        actor,
+        "chat.crm_owner_assign",
+        "chat",
+        chat.id,
+        {"emp_id": emp_id, "previous_assigned_to": previous, "assigned_to": owner.id},
+    )
+    logger.info("Chat %s routed to CRM owner %s (emp_id %s)", chat.id, owner.id, emp_id)
+    return {**result, "outcome": OUTCOME_ASSIGNED, "owner_id": owner.id, "assigned_to": owner.id}
+
+
+async def route_to_crm_owner(db: Session, chat: Chat, text: Optional[str]) -> Optional[Dict[str, Any]]:
+    """Webhook hook: route when an inbound message carries a phone number not checked yet for this chat."""
+    if not CRM_OWNER_ROUTING_ENABLED or not chat or assignment_paused(db):
+        return None
+    phones = extract_phones(text)
+    if not phones or chat.crm_owner_phone == phones[0]:
+        return None
+    status, emp_id = await asyncio.to_thread(lookup_crm_owner, phones[0])
+    if status == LOOKUP_UNAVAILABLE:
+        return None
+    previous = chat.assigned_to
+    result = apply_crm_owner(db, chat, phones[0], emp_id)
+    db.commit()
+    if result["outcome"] == OUTCOME_ASSIGNED:
+        await broadcast_chat_assignment(db, chat, previous)
+    return result
+
+
+def latest_customer_phone(db: Session, chat: Chat) -> Optional[str]:
+    model = _message_model_for_platform(chat.platform)
+    rows = (
+        db.query(model.content)
+        .filter(model.chat_id == chat.id, model.sender.in_(CUSTOMER_SENDERS))
+        .order_by(model.timestamp.desc())
+        .limit(200)
+        .all()
+    )
+    for (content,) in rows:
+        phones = extract_phones(content)
+        if phones:
+            return phones[0]
+    return None
+
+
+@router.post("/chats/{chat_id}/crm-owner/route", response_model=CrmOwnerRouteResult)
+async def route_chat_to_crm_owner(
+    chat_id: str,
+    phone: Optional[str] = None,
+    refresh: bool = False,
+    current_user: User = Depends(require_permissions(PermissionCode.CHAT_ASSIGN)),
+    db: Session = Depends(get_db),
+):
+    """Look up the CRM owner for the chat's phone number (or `phone`) and assign the chat to them."""
+    chat = db.query(Chat).filter(Chat.id == chat_id).first()
+    if not chat:
+        raise HTTPException(status_code=404, detail="Chat not found")
+    if phone:
+        phones = extract_phones(phone)
+        if not phones:
+            raise HTTPException(status_code=400, detail="Invalid phone number")
+        target = phones[0]
+    else:
+        target = latest_customer_phone(db, chat)
+    if not target:
+        return CrmOwnerRouteResult(chat_id=chat.id, outcome=OUTCOME_NO_PHONE, assigned_to=chat.assigned_to)
+    if refresh:
+        with _owner_cache_lock:
+            _owner_cache.pop(target, None)
+    status, emp_id = await asyncio.to_thread(lookup_crm_owner, target)
+    if status == LOOKUP_UNAVAILABLE:
+        raise HTTPException(status_code=502, detail="Admin CRM lookup failed")
+    previous = chat.assigned_to
+    result = apply_crm_owner(db, chat, target, emp_id, actor=current_user, keep_engaged=False)
+    db.commit()
+    if result["outcome"] == OUTCOME_ASSIGNED:
+        await broadcast_chat_assignment(db, chat, previous)
+    return CrmOwnerRouteResult(**result)
+
+
+@router.delete("/admin/crm-owner/cache")
+def clear_crm_owner_cache(current_user: User = Depends(get_admin_only_user)):
+    return {"success": True, "cleared": clear_owner_cache()}
@@ -0,0 +1,213 @@
+import json
+import logging
+from datetime import timedelta
+from pathlib import Path
+from typing import List, Optional
+
+from fastapi import APIRouter, BackgroundTasks, Depends, HTTPException, status
+from fastapi.responses import FileResponse
+from sqlalchemy.orm import Session
+
+from database import SessionLocal, get_db
+from models import DataExportRequest, User
+from routes.dependencies import get_admin_only_user
+from schemas import DataExportCreate, DataExportResponse
+from settings import DSAR_CRM_INQUIRY_ROUTE, DSAR_EXPORT_DIR, DSAR_EXPORT_RETENTION_DAYS, ROOT_DIR
+from utils.audit import record_audit
+from utils.dsar import (
+    SUBJECT_EMAIL,
+    SUBJECT_PHONE,
+    collect_subject_data,
+    normalize_email,
+    normalize_phone,
+    write_export_archive,
+)
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+ATTACHMENTS_ROOT = ROOT_DIR / "attachments"
+
+
+def export_dir() -> Path:
+    return Path(DSAR_EXPORT_DIR)
+
+
+def create_export_request(
+    db: Session,
+    subject_type: str,
+    subject_value: str,
+    actor: Optional[User],
+    reason: Optional[str] = None,
+) -> DataExportRequest:
+    """Validate the subject and queue an export; the caller runs it."""
+    subject_value = (subject_value or "").strip()
+    if subject_type == SUBJECT_PHONE and not normalize_phone(subject_value):
+        raise ValueError("Phone number is too short")
+    if subject_type == SUBJECT_EMAIL:
+        subject_value = normalize_email(subject_value) or ""
+        if not subject_value:
+            raise ValueError("Invalid email address")
+    export = DataExportRequest(
+        subject_type=subject_type,
+        subject_value=subject_value,
+        reason=reason,
+        requested_by=actor.id if actor else None,
+    )
+    db.add(export)
+    db.flush()
+    record_audit(
+        db,
+        actor,
+        "data_export.request",
+        "data_export",
+        export.id,
+        {"subject_type": subject_type, "subject_value": subject_value, "reason": reason},
+    )
+    db.commit()
+    db.refresh(export)
+    return export
+
+
+def run_data_export(db: Session, export: DataExportRequest) -> DataExportRequest:
+    """Build the archive for a queued export and record the outcome."""
+    export.status = "running"
+    export.started_at = utc_now()
+    export.error = None
+    db.commit()
+
+    target = export_dir() / f"dsar-{export.id}.zip"
+    try:
+        data = collect_subject_data(
+            db,
+            export.subject_type,
+            export.subject_value,
+            crm_inquiry_route=DSAR_CRM_INQUIRY_ROUTE or None,
+        )
+        counts = write_export_archive(data, target, ATTACHMENTS_ROOT, export.id)
+    except Exception as exc:
+        db.rollback()
+        logger.exception("Data export %s failed", export.id)
+        export.status = "failed"
+        export.error = str(exc)[:1000]
+        export.completed_at = utc_now()
+        record_audit(db, None, "data_export.fail", "data_export", export.id, {"error": export.error})
+        db.commit()
+        return export
+
+    export.status = "completed"
+    export.file_path = str(target)
+    export.file_size = target.stat().st_size
+    export.summary_json = json.dumps({"contacts": data["contacts"], "records": counts})
+    export.completed_at = utc_now()
+    export.expires_at = export.completed_at + timedelta(days=DSAR_EXPORT_RETENTION_DAYS)
+    record_audit(
+        db,
+        None,
+        "data_export.complete",
+        "data_export",
+        export.id,
+        {"file_size": export.file_size, "records": counts},
+    )
+    db.commit()
+    db.refresh(export)
+    logger.info("Data export %s completed (%s bytes)", export.id, export.file_size)
+    return export
+
+
+def _run_in_background(export_id: str) -> None:
+    with SessionLocal() as session:
+        export = session.query(DataExportRequest).filter(DataExportRequest.id == export_id).first()
+        if export:
+            run_data_export(session, export)
+
+
+def cleanup_expired_exports(db: Session) -> int:
+    """Delete archive files past their retention; the request rows stay for the audit trail."""
+    now = utc_now()
+    expired = (
+        db.query(DataExportRequest)
+        .filter(
+            DataExportRequest.file_path.isnot(None),
+            DataExportRequest.expires_at.isnot(None),
+            DataExportRequest.expires_at < now,
+        )
+        .all()
+    )
+    for export in expired:
+        try:
+            Path(export.file_path).unlink(missing_ok=True)
+        except OSError as exc:
+            logger.warning("Failed to remove export archive %s: %s", export.file_path, exc)
+            continue
+        export.file_path = None
+        export.status = "expired"
+    if expired:
+        db.commit()
+    return len(expired)
+
+
+def _get_export(db: Session, export_id: str) -> DataExportRequest:
+    export = db.query(DataExportRequest).filter(DataExportRequest.id == export_id).first()
+    if not export:
+        raise HTTPException(status_code=404, detail="Export not found")
+    return export
+
+
+@router.post("/admin/data-exports", response_model=DataExportResponse, status_code=status.HTTP_202_ACCEPTED)
+def request_data_export(
+    payload: DataExportCreate,
+    background_tasks: BackgroundTasks,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    try:
+        export = create_export_request(db, payload.subject_type, payload.subject_value, current_user, payload.reason)
+    except ValueError as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    background_tasks.add_task(_run_in_background, export.id)
+    logger.info("Data export %s requested by %s", export.id, current_user.email)
+    return export
+
+
+@router.get("/admin/data-exports", response_model=List[DataExportResponse])
+def list_data_exports(
+    limit: int = 50,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    limit = max(1, min(limit, 200))
+    return (
+        db.query(DataExportRequest)
+        .order_by(DataExportRequest.created_at.desc())
+        .limit(limit)
+        .all()
+    )
+
+
+@router.get("/admin/data-exports/{export_id}", response_model=DataExportResponse)
+def get_data_export(
+    export_id: str,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    return _get_export(db, export_id)
+
+
+@router.get("/admin/data-exports/{export_id}/download")
+def download_data_export(
+    export_id: str,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    export = _get_export(db, export_id)
+    if export.status != "completed" or not export.file_path:
+        raise HTTPException(status_code=status.HTTP_409_CONFLICT, detail=f"Export is {export.status}")
+    path = Path(export.file_path)
+    if not path.is_file():
+        raise HTTPException(status_code=status.HTTP_410_GONE, detail="Export archive is no longer available")
+    record_audit(db, current_user, "data_export.download", "data_export", export.id)
+    db.commit()
+    return FileResponse(path, media_type="application/zip", filename=f"data-export-{export.id}.zip")
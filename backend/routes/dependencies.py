@@ -6,7 +6,7 @@ from sqlalchemy.orm import Session
 
 from auth import decode_access_token
 from database import get_db
-from models import User, UserRole
+from models import User, UserApprovalStatus, UserRole
 from permissions import (
     annotate_user_with_permissions,
     is_super_admin_user,
@@ -49,6 +49,8 @@ def _is_assignable_agent(user: Optional[User]) -> bool:
         return False
     if getattr(user, "can_receive_new_chats", True) is False:
         return False
+    if getattr(user, "approval_status", None) not in (None, UserApprovalStatus.APPROVED):
+        return False
     return True
 
 
@@ -81,6 +83,11 @@ async def get_current_user(
             status_code=status.HTTP_403_FORBIDDEN,
             detail="User account is inactive",
         )
+    if getattr(user, "approval_status", None) not in (None, UserApprovalStatus.APPROVED):
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="User account is awaiting approval",
+        )
     return _annotate_user(user)
 
 
@@ -0,0 +1,218 @@
+import logging
+from datetime import datetime, timedelta
+from typing import Any, Dict, Optional
+
+from fastapi import APIRouter, Depends, HTTPException
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import Chat, ChatStatus, EmergencyModeState, User
+from routes.chat_helpers import (
+    ChatDeliveryError,
+    _assign_chat_round_robin,
+    _message_model_for_platform,
+    awaiting_agent_reply_clause,
+    broadcast_chat_message,
+    deliver_chat_text,
+)
+from routes.dependencies import get_admin_only_user, get_current_user
+from schemas import EmergencyModeResponse, EmergencyModeUpdate
+from settings import EMERGENCY_AUTO_REPLY_COOLDOWN_MINUTES, EMERGENCY_DEFAULT_AUTO_REPLY
+from utils.audit import record_audit
+from utils.emergency import active_emergency, get_emergency_state, state_is_active
+from utils.timezone import as_utc, utc_now
+from websocket_manager import manager as ws_manager
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+AUTO_REPLY_METADATA_KEY = "emergency_auto_reply"
+
+
+def emergency_response(state: Optional[EmergencyModeState]) -> EmergencyModeResponse:
+    if not state:
+        return EmergencyModeResponse(active=False)
+    return EmergencyModeResponse(
+        active=state_is_active(state),
+        auto_reply=state.auto_reply,
+        pause_assignment=bool(state.pause_assignment),
+        reason=state.reason,
+        started_at=state.started_at,
+        started_by=state.started_by,
+        ends_at=state.ends_at,
+        updated_by=state.updated_by,
+        updated_at=state.updated_at,
+    )
+
+
+def _state_details(state: EmergencyModeState) -> Dict[str, Any]:
+    return {
+        "auto_reply": state.auto_reply,
+        "pause_assignment": bool(state.pause_assignment),
+        "reason": state.reason,
+        "ends_at": state.ends_at.isoformat() if state.ends_at else None,
+    }
+
+
+def resume_paused_chats(db: Session, since: Optional[datetime]) -> int:
+    """Assign chats that arrived while assignment was paused (lead-form chats stay unassigned)."""
+    if not since:
+        return 0
+    chats = (
+        db.query(Chat)
+        .filter(Chat.status == ChatStatus.UNASSIGNED, Chat.assigned_to.is_(None))
+        .filter(awaiting_agent_reply_clause())
+        .filter(Chat.last_incoming_at >= since)
+        .order_by(Chat.last_incoming_at.asc())
+        .all()
+    )
+    assigned = 0
+    for chat in chats:
+        model = _message_model_for_platform(chat.platform)
+        is_lead_form = (
+            db.query(model.id)
+            .filter(model.chat_id == chat.id, model.is_lead_form_message.is_(True))
+            .first()
+        )
+        if is_lead_form:
+            continue
+        if _assign_chat_round_robin(db, chat):
+            assigned += 1
+    return assigned
+
+
+def deactivate_emergency(db: Session, state: EmergencyModeState, actor: Optional[User], action: str) -> int:
+    """Switch emergency mode off and hand out the chats that queued up. The caller commits."""
+    was_paused = bool(state.pause_assignment)
+    started_at = state.started_at
+    details = _state_details(state)
+    state.active = False
+    state.updated_by = actor.id if actor else None
+    db.flush()
+    resumed = resume_paused_chats(db, started_at) if was_paused else 0
+    details["chats_assigned_on_resume"] = resumed
+    record_audit(db, actor, action, "emergency_mode", str(state.id), details)
+    return resumed
+
+
+async def broadcast_emergency_state(state: Optional[EmergencyModeState]) -> None:
+    await ws_manager.broadcast_global({
+        "type": "emergency_mode",
+        "state": emergency_response(state).model_dump(mode="json"),
+    })
+
+
+async def expire_emergency_mode(db: Session, now: Optional[datetime] = None) -> bool:
+    """Worker hook: automatically resume once ends_at has passed."""
+    now = now or utc_now()
+    state = get_emergency_state(db)
+    if not state or not state.active or state_is_active(state, now):
+        return False
+    resumed = deactivate_emergency(db, state, None, "emergency_mode.auto_resume")
+    db.commit()
+    logger.info("Emergency mode ended automatically; %s waiting chats assigned", resumed)
+    await broadcast_emergency_state(state)
+    return True
+
+
+def _recent_auto_reply(db: Session, chat: Chat, now: datetime):
+    model = _message_model_for_platform(chat.platform)
+    return (
+        db.query(model.id)
+        .filter(model.chat_id == chat.id)
+        .filter(model.timestamp >= now - timedelta(minutes=max(0, EMERGENCY_AUTO_REPLY_COOLDOWN_MINUTES)))
+        .filter(model.metadata_json.like(f'%"{AUTO_REPLY_METADATA_KEY}"%'))
+        .first()
+    )
+
+
+async def send_emergency_auto_reply(db: Session, chat: Optional[Chat]) -> bool:
+    """Webhook hook: answer a new inbound message with the emergency auto-reply."""
+    if not chat:
+        return False
+    state = active_emergency(db)
+    if not state or not state.auto_reply:
+        return False
+    now = utc_now()
+    if _recent_auto_reply(db, chat, now):
+        return False
+    try:
+        message = await deliver_chat_text(
+            db,
+            chat,
+            state.auto_reply,
+            extra_metadata={AUTO_REPLY_METADATA_KEY: {"started_at": state.started_at.isoformat() if state.started_at else None}},
+            automated=True,
+        )
+    except ChatDeliveryError as exc:
+        db.rollback()
+        logger.warning("Emergency auto-reply for chat %s not delivered: %s", chat.id, exc)
+        return False
+    db.commit()
+    db.refresh(message)
+    await broadcast_chat_message(db, chat, message, sender="system")
+    return True
+
+
+@router.get("/emergency-mode", response_model=EmergencyModeResponse)
+def get_emergency_mode(
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    return emergency_response(get_emergency_state(db))
+
+
+@router.put("/admin/emergency-mode", response_model=EmergencyModeResponse)
+async def update_emergency_mode(
+    payload: EmergencyModeUpdate,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    state = get_emergency_state(db)
+    if not payload.active:
+        if not state or not state.active:
+            return emergency_response(state)
+        deactivate_emergency(db, state, current_user, "emergency_mode.deactivate")
+        db.commit()
+        db.refresh(state)
+        await broadcast_emergency_state(state)
+        return emergency_response(state)
+
+    now = utc_now()
+    ends_at = payload.ends_at
+    if payload.duration_minutes:
+        ends_at = now + timedelta(minutes=payload.duration_minutes)
+    if ends_at and as_utc(ends_at) <= now:
+        raise HTTPException(status_code=400, detail="End time must be in the future")
+
+    if not state:
+        state = EmergencyModeState()
+        db.add(state)
+    already_active = state_is_active(state, now)
+    was_paused = already_active and bool(state.pause_assignment)
+    state.active = True
+    state.auto_reply = (payload.auto_reply or "").strip() or EMERGENCY_DEFAULT_AUTO_REPLY
+    state.pause_assignment = payload.pause_assignment
+    state.reason = payload.reason
+    state.ends_at = ends_at
+    state.updated_by = current_user.id
+    if not already_active:
+        state.started_at = now
+        state.started_by = current_user.id
+    db.flush()
+    details = _state_details(state)
+    if was_paused and not state.pause_assignment:
+        details["chats_assigned_on_resume"] = resume_paused_chats(db, state.started_at)
+    record_audit(
+        db,
+        current_user,
+        "emergency_mode.update" if already_active else "emergency_mode.activate",
+        "emergency_mode",
+        str(state.id),
+        details,
+    )
+    db.commit()
+    db.refresh(state)
+    await broadcast_emergency_state(state)
+    return emergency_response(state)
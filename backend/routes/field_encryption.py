@@ -0,0 +1,294 @@
+import json
+import logging
+from typing import Any, Dict, List, Optional, Sequence
+
+from fastapi import APIRouter, Depends, HTTPException, Query
+from sqlalchemy import Text, cast
+from sqlalchemy import inspect as sa_inspect
+from sqlalchemy.orm import Session
+from sqlalchemy.orm.attributes import flag_modified
+
+from database import get_db
+from models import (
+    BLIND_INDEXED_MODELS,
+    Chat,
+    FacebookMessage,
+    FacebookUser,
+    FacebookWebhookEvent,
+    InstagramMessage,
+    InstagramMessageLog,
+    InstagramUser,
+    User,
+    refresh_blind_index,
+)
+from routes.dependencies import get_admin_only_user
+from routes.trash import include_deleted
+from schemas import FieldEncryptionStatusResponse, FieldEncryptionTableStatus, MessageSearchResult
+from utils.audit import record_audit
+from utils.blind_index import blind_index_subquery
+from utils.field_crypto import (
+    CIPHERTEXT_PREFIX,
+    ENCRYPTED_METADATA_KEYS,
+    FieldEncryptionError,
+    blind_index_enabled,
+    encryption_enabled,
+    get_keyring,
+    key_reference,
+    open_metadata,
+    query_tokens,
+    require_blind_index_for_search,
+    seal_metadata,
+)
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+# table -> (model, encrypted columns); the first column is used for status counts
+ENCRYPTED_TABLES: Dict[str, Any] = {
+    "instagram_messages": (InstagramMessage, ("content", "attachments_json")),
+    "facebook_messages": (FacebookMessage, ("content", "attachments_json")),
+    "instagram_message_logs": (InstagramMessageLog, ("text", "attachments_json", "raw_payload_json")),
+    "facebook_webhook_events": (FacebookWebhookEvent, ("payload",)),
+    "chats": (Chat, ("last_message",)),
+    "facebook_users": (FacebookUser, ("last_message",)),
+    "instagram_users": (InstagramUser, ("last_message",)),
+}
+# Tables whose plaintext metadata_json carries encrypted keys (ENCRYPTED_METADATA_KEYS)
+SEALED_METADATA_TABLES = ("instagram_messages", "facebook_messages")
+
+
+def _primary_key(model: Any) -> Any:
+    return getattr(model, sa_inspect(model).primary_key[0].key)
+
+
+def _stored(value: Any) -> Any:
+    """Raw column value as stored; encrypted JSON documents come back as a quoted JSON string."""
+    if isinstance(value, str) and value.startswith(f'"{CIPHERTEXT_PREFIX}'):
+        try:
+            return json.loads(value)
+        except ValueError:
+            return value
+    return value
+
+
+def needs_encryption(values: Sequence[Any], active_prefix: str) -> bool:
+    return any(value is not None and not (isinstance(value, str) and value.startswith(active_prefix)) for value in values)
+
+
+def _metadata_values(raw: Optional[str]) -> List[Any]:
+    """Values of the encrypted keys inside a stored metadata_json document."""
+    try:
+        metadata = json.loads(raw) if raw else None
+    except ValueError:
+        return []
+    if not isinstance(metadata, dict):
+        return []
+    return [metadata[key] for key in ENCRYPTED_METADATA_KEYS if isinstance(metadata.get(key), str)]
+
+
+def encrypt_batch(
+    db: Session,
+    table: str,
+    batch_size: int = 500,
+    after_id: Optional[str] = None,
+    reindex: bool = False,
+) -> Dict[str, Any]:
+    """
+    Encrypt (or re-encrypt with the active key) one page of rows ordered by id.
+    Returns counts and the last id seen so the caller can continue from there.
+    """
+    ring = get_keyring()
+    active_prefix = ring.active_prefix()
+    model, columns = ENCRYPTED_TABLES[table]
+    key = _primary_key(model)
+    sealed_metadata = table in SEALED_METADATA_TABLES
+    # cast() skips the ORM decryption so we see what is actually stored.
+    raw_columns = [cast(getattr(model, column), Text) for column in columns]
+    if sealed_metadata:
+        raw_columns.append(model.metadata_json)
+    query = include_deleted(db.query(key, *raw_columns))
+    if after_id:
+        query = query.filter(key > after_id)
+    rows = query.order_by(key.asc()).limit(batch_size).all()
+
+    pending: Dict[str, Any] = {}
+    unreadable = 0
+    for row in rows:
+        values = [_stored(value) for value in row[1:len(columns) + 1]]
+        metadata_values = _metadata_values(row[-1]) if sealed_metadata else []
+        if any(ref and not ring.has_key(*ref) for ref in map(key_reference, values + metadata_values)):
+            # Never rewrite a value we cannot decrypt; it would be replaced by the placeholder.
+            unreadable += 1
+            continue
+        if reindex or needs_encryption(values + metadata_values, active_prefix):
+            pending[row[0]] = (values, needs_encryption(metadata_values, active_prefix))
+
+    encrypted = 0
+    if pending:
+        indexed = set(BLIND_INDEXED_MODELS[model][1]) if model in BLIND_INDEXED_MODELS else set()
+        for obj in include_deleted(db.query(model)).filter(key.in_(list(pending))).all():
+            values, metadata_stale = pending[getattr(obj, key.key)]
+            stale = [
+                column
+                for column, stored in zip(columns, values)
+                if needs_encryption([stored], active_prefix)
+            ]
+            # Re-assigning the decrypted value makes the ORM write it back encrypted with
+            # the active key (and refreshes the blind index via after_update).
+            for column in stale:
+                flag_modified(obj, column)
+            if metadata_stale:
+                obj.metadata_json = json.dumps(seal_metadata(open_metadata(json.loads(obj.metadata_json))))
+            if stale or metadata_stale:
+                encrypted += 1
+            if reindex and indexed and blind_index_enabled() and not indexed & set(stale):
+                refresh_blind_index(db.connection(), obj)
+        db.commit()
+
+    return {
+        "table": table,
+        "scanned": len(rows),
+        "encrypted": encrypted,
+        "unreadable": unreadable,
+        "last_id": rows[-1][0] if rows else after_id,
+        "done": len(rows) < batch_size,
+    }
+
+
+def run_encryption_pass(
+    db: Session,
+    tables: Optional[Sequence[str]] = None,
+    batch_size: int = 500,
+    max_batches: Optional[int] = None,
+    reindex: bool = False,
+) -> Dict[str, Dict[str, Any]]:
+    """Walk the tables in batches until done (or max_batches per table)."""
+    if not encryption_enabled():
+        raise FieldEncryptionError("FIELD_ENCRYPTION_ENABLED is off")
+    results: Dict[str, Dict[str, Any]] = {}
+    for table in tables or ENCRYPTED_TABLES:
+        totals = {"scanned": 0, "encrypted": 0, "unreadable": 0, "batches": 0, "done": False}
+        after_id: Optional[str] = None
+        while max_batches is None or totals["batches"] < max_batches:
+            result = encrypt_batch(db, table, batch_size, after_id, reindex)
+            totals["batches"] += 1
+            for key in ("scanned", "encrypted", "unreadable"):
+                totals[key] += result[key]
+            after_id = result["last_id"]
+            if result["done"]:
+                totals["done"] = True
+                break
+        results[table] = totals
+        logger.info("Field encryption pass on %s: %s", table, totals)
+    return results
+
+
+def table_status(db: Session, table: str, active_prefix: Optional[str]) -> FieldEncryptionTableStatus:
+    model, columns = ENCRYPTED_TABLES[table]
+    key = _primary_key(model)
+    raw = cast(getattr(model, columns[0]), Text)
+    total = include_deleted(db.query(key)).filter(raw.isnot(None)).count()
+    encrypted = include_deleted(db.query(key)).filter(
+        raw.like(f"{CIPHERTEXT_PREFIX}%") | raw.like(f'"{CIPHERTEXT_PREFIX}%')
+    ).count()
+    active = 0
+    if active_prefix:
+        active = include_deleted(db.query(key)).filter(
+            raw.like(f"{active_prefix}%") | raw.like(f'"{active_prefix}%')
+        ).count()
+    return FieldEncryptionTableStatus(
+        table=table,
+        columns=list(columns),
+        rows=total,
+        plaintext=total - encrypted,
+        encrypted=encrypted,
+        active_key=active,
+    )
+
+
+@router.get("/admin/field-encryption", response_model=FieldEncryptionStatusResponse)
+def field_encryption_status(
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    try:
+        ring = get_keyring()
+    except FieldEncryptionError as exc:
+        raise HTTPException(status_code=500, detail=str(exc))
+    try:
+        active_key_id, _ = ring.active_key()
+        active_prefix = ring.active_prefix()
+    except FieldEncryptionError:
+        active_key_id, active_prefix = None, None
+    key_ids = sorted(((ring.workspaces.get(ring.workspace) or {}).get("keys") or {}).keys())
+    return FieldEncryptionStatusResponse(
+        enabled=encryption_enabled(),
+        workspace=ring.workspace,
+        active_key_id=active_key_id,
+        key_ids=key_ids,
+        blind_index_enabled=blind_index_enabled(),
+        tables=[table_status(db, table, active_prefix) for table in ENCRYPTED_TABLES],
+    )
+
+
+@router.post("/admin/field-encryption/run")
+def run_field_encryption(
+    batch_size: int = Query(500, ge=10, le=5000),
+    max_batches: int = Query(20, ge=1, le=500),
+    reindex: bool = False,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    try:
+        results = run_encryption_pass(db, batch_size=batch_size, max_batches=max_batches, reindex=reindex)
+    except FieldEncryptionError as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    record_audit(
+        db,
+        current_user,
+        "field_encryption.run",
+        "field_encryption",
+        get_keyring().workspace,
+        {"batch_size": batch_size, "max_batches": max_batches, "reindex": reindex, "results": results},
+    )
+    db.commit()
+    return {"success": True, "results": results}
+
+
+@router.get("/admin/messages/search", response_model=List[MessageSearchResult])
+def search_messages(
+    q: str = Query(..., min_length=3, max_length=200),
+    limit: int = Query(50, ge=1, le=200),
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    """Find messages by word, phone number or email; uses the blind index when content is encrypted."""
+    tokens = query_tokens(q)
+    if not tokens:
+        raise HTTPException(status_code=400, detail="Search term has no searchable words")
+    try:
+        require_blind_index_for_search()
+    except FieldEncryptionError as exc:
+        raise HTTPException(status_code=503, detail=str(exc))
+    results: List[MessageSearchResult] = []
+    for model, entity_type in ((InstagramMessage, "instagram_message"), (FacebookMessage, "facebook_message")):
+        query = db.query(model).join(Chat, Chat.id == model.chat_id)
+        if blind_index_enabled():
+            # Join the matches so the newest messages are picked, not an arbitrary set of ids.
+            matches = blind_index_subquery(db, entity_type, tokens)
+            query = query.join(matches, matches.c.entity_id == model.id)
+        else:
+            for token in tokens:
+                query = query.filter(model.content.like(f"%{token.split(':', 1)[-1]}%"))
+        for message in query.order_by(model.timestamp.desc()).limit(limit).all():
+            results.append(MessageSearchResult(
+                id=message.id,
+                chat_id=message.chat_id,
+                platform=message.platform.value,
+                sender=message.sender.value,
+                content=message.content,
+                timestamp=message.timestamp,
+            ))
+    results.sort(key=lambda item: item.timestamp, reverse=True)
+    return results[:limit]
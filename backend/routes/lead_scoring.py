@@ -0,0 +1,527 @@
+import copy
+import json
+import logging
+import re
+from dataclasses import dataclass, field
+from datetime import datetime, timedelta
+from typing import Any, Dict, List, Optional, Set, Tuple
+
+from fastapi import APIRouter, Depends, HTTPException
+from sqlalchemy.orm import Session
+from sqlalchemy.orm.attributes import flag_modified
+
+from database import SessionLocal, get_db
+from models import Chat, LeadScoringConfig, MessageSender, PaymentRequest, User
+from routes.chat_helpers import _message_model_for_platform, assert_chat_access, gather_dm_notify_users
+from routes.dependencies import get_admin_only_user, get_current_user
+from schemas import LeadEventRequest, LeadScoreResponse, LeadScoringRulesUpdate
+from settings import LEAD_SCORE_ACTIVE_DAYS
+from utils.alerts import send_admin_alert
+from utils.audit import record_audit
+from utils.contact_identifiers import extract_phones
+from utils.timezone import as_utc, utc_now
+from websocket_manager import manager as ws_manager
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+CUSTOMER_SENDERS = (MessageSender.INSTAGRAM_USER, MessageSender.FACEBOOK_USER)
+TIER_HOT = "hot"
+TIER_WARM = "warm"
+TIER_COLD = "cold"
+TIER_RANK = {TIER_COLD: 0, TIER_WARM: 1, TIER_HOT: 2}
+LEAD_EVENTS = ("link_click", "inquiry_created")
+
+DEFAULT_LEAD_RULES: Dict[str, Any] = {
+    "ad_referral": {"points": 25},
+    # Clicked an ig.me / m.me link (referral source SHORTLINK) or a tracked link
+    "link_clicks": {"points_per_click": 5, "max_points": 15},
+    "phone_shared": {"points": 20},
+    "keywords": {
+        "dates": {
+            "points": 10,
+            "terms": [
+                "date", "dates", "when", "available", "availability", "book", "booking", "tomorrow",
+                "weekend", "next week", "next month", "tarikh", "kab",
+            ],
+            "patterns": [
+                r"\b\d{1,2}\s*(st|nd|rd|th)?\s*(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\b",
+                r"\b\d{1,2}[/-]\d{1,2}([/-]\d{2,4})?\b",
+            ],
+        },
+        "prices": {
+            "points": 15,
+            "terms": [
+                "price", "prices", "pricing", "cost", "rate", "rates", "charges", "fee", "fees", "package",
+                "packages", "budget", "quotation", "quote", "how much", "kitna", "rs", "inr", "₹",
+            ],
+            "patterns": [],
+        },
+    },
+    "replies": {"points_per_reply": 2, "max_points": 20},
+    "inquiry_created": {"points": 30},
+    "recency": {"max_points": 20, "half_life_hours": 48},
+    "tiers": {TIER_HOT: 70, TIER_WARM: 40},
+    "automation": {
+        # Tier at which assigned agents/admins get a "lead_score" WebSocket event
+        "notify_tier": TIER_HOT,
+        "alert_admins_on_hot": False,
+    },
+}
+
+
+@dataclass
+class LeadSignals:
+    ad_referral: Optional[str] = None
+    shortlink_referrals: int = 0
+    link_clicks: int = 0
+    phone_shared: bool = False
+    keyword_classes: Set[str] = field(default_factory=set)
+    customer_messages: int = 0
+    inquiry_created: bool = False
+    last_incoming_at: Optional[datetime] = None
+
+
+def merge_rules(overrides: Optional[Dict[str, Any]]) -> Dict[str, Any]:
+    """Defaults with stored overrides applied (two levels deep, so one keyword class can be replaced)."""
+    rules = copy.deepcopy(DEFAULT_LEAD_RULES)
+    for key, value in (overrides or {}).items():
+        if isinstance(value, dict) and isinstance(rules.get(key), dict):
+            for sub_key, sub_value in value.items():
+                if isinstance(sub_value, dict) and isinstance(rules[key].get(sub_key), dict):
+                    rules[key][sub_key] = {**rules[key][sub_key], **sub_value}
+                else:
+                    rules[key][sub_key] = sub_value
+        else:
+            rules[key] = value
+    return rules
+
+
+def _config_row(db: Session) -> Optional[LeadScoringConfig]:
+    return db.query(LeadScoringConfig).order_by(LeadScoringConfig.id.asc()).first()
+
+
+def stored_rule_overrides(db: Session) -> Dict[str, Any]:
+    row = _config_row(db)
+    if not row:
+        return {}
+    try:
+        overrides = json.loads(row.rules_json or "{}")
+    except (TypeError, ValueError):
+        return {}
+    return overrides if isinstance(overrides, dict) else {}
+
+
+def save_rule_overrides(db: Session, overrides: Dict[str, Any], actor: Optional[User]) -> None:
+    """Store the overrides (not the merged rules); the caller audits and commits."""
+    row = _config_row(db)
+    if not row:
+        row = LeadScoringConfig()
+        db.add(row)
+    row.rules_json = json.dumps(overrides)
+    row.updated_by = actor.id if actor else None
+
+
+def invalid_rule_pattern(rules: Dict[str, Any]) -> Optional[str]:
+    for name, config in (rules.get("keywords") or {}).items():
+        for expression in (config or {}).get("patterns") or []:
+            try:
+                re.compile(expression)
+            except re.error as exc:
+                return f"Invalid pattern in keyword class '{name}': {exc}"
+    return None
+
+
+def load_rules(db: Session) -> Dict[str, Any]:
+    row = _config_row(db)
+    overrides: Dict[str, Any] = {}
+    if row:
+        try:
+            overrides = json.loads(row.rules_json or "{}")
+        except (TypeError, ValueError):
+            logger.warning("Invalid lead scoring rules in database; using defaults")
+    return merge_rules(overrides if isinstance(overrides, dict) else {})
+
+
+def _term_regex(term: str) -> str:
+    return r"(?<!\w)" + re.escape(term.lower()) + r"(?!\w)"
+
+
+def keyword_classes(text: Optional[str], rules: Dict[str, Any]) -> Set[str]:
+    text = (text or "").lower()
+    matched: Set[str] = set()
+    if not text:
+        return matched
+    for name, config in (rules.get("keywords") or {}).items():
+        expressions = [_term_regex(term) for term in config.get("terms") or []] + list(config.get("patterns") or [])
+        for expression in expressions:
+            try:
+                if re.search(expression, text):
+                    matched.add(name)
+                    break
+            except re.error:
+                logger.warning("Invalid lead keyword pattern %r in class %s", expression, name)
+    return matched
+
+
+def referral_of(metadata_json: Optional[str]) -> Optional[Dict[str, Any]]:
+    if not metadata_json:
+        return None
+    try:
+        metadata = json.loads(metadata_json)
+    except (TypeError, ValueError):
+        return None
+    referral = metadata.get("referral") if isinstance(metadata, dict) else None
+    return referral if isinstance(referral, dict) else None
+
+
+def add_message_signals(signals: LeadSignals, content: Optional[str], metadata_json: Optional[str], rules: Dict[str, Any]) -> None:
+    """Fold one customer message into the signals."""
+    signals.customer_messages += 1
+    referral = referral_of(metadata_json)
+    if referral:
+        source = str(referral.get("source") or "").upper()
+        if source == "ADS" or referral.get("ad_id"):
+            signals.ad_referral = str(referral.get("ad_id") or referral.get("campaign_id") or "ad")
+        elif source == "SHORTLINK":
+            signals.shortlink_referrals += 1
+    if extract_phones(content):
+        signals.phone_shared = True
+    signals.keyword_classes |= keyword_classes(content, rules)
+
+
+def score_lead(signals: LeadSignals, rules: Dict[str, Any], now: Optional[datetime] = None) -> Tuple[int, List[Dict[str, Any]]]:
+    """Score 0-100 and the factors that produced it, largest first."""
+    now = now or utc_now()
+    factors: List[Dict[str, Any]] = []
+
+    def add(signal: str, points: float, detail: str) -> None:
+        if points:
+            factors.append({"signal": signal, "points": round(points, 1), "detail": detail})
+
+    if signals.ad_referral:
+        add("ad_referral", rules["ad_referral"].get("points", 0), f"Came from ad {signals.ad_referral}")
+    clicks = signals.link_clicks + signals.shortlink_referrals
+    if clicks:
+        config = rules["link_clicks"]
+        add("link_clicks", min(clicks * config.get("points_per_click", 0), config.get("max_points", 0)), f"{clicks} link click(s)")
+    if signals.phone_shared:
+        add("phone_shared", rules["phone_shared"].get("points", 0), "Shared a phone number")
+    for name in sorted(signals.keyword_classes):
+        config = (rules.get("keywords") or {}).get(name) or {}
+        add(f"keywords:{name}", config.get("points", 0), f"Asked about {name}")
+    if signals.customer_messages:
+        config = rules["replies"]
+        add(
+            "replies",
+            min(signals.customer_messages * config.get("points_per_reply", 0), config.get("max_points", 0)),
+            f"{signals.customer_messages} message(s) from the customer",
+        )
+    if signals.inquiry_created:
+        add("inquiry_created", rules["inquiry_created"].get("points", 0), "Inquiry created")
+    last_incoming = as_utc(signals.last_incoming_at)
+    if last_incoming:
+        config = rules["recency"]
+        hours = max(0.0, (now - last_incoming).total_seconds() / 3600)
+        half_life = max(float(config.get("half_life_hours", 48)), 1.0)
+        add("recency", config.get("max_points", 0) * 0.5 ** (hours / half_life), f"Last message {hours:.0f}h ago")
+
+    factors.sort(key=lambda item: item["points"], reverse=True)
+    score = int(round(sum(item["points"] for item in factors)))
+    return max(0, min(100, score)), factors
+
+
+def lead_tier(score: Optional[int], rules: Dict[str, Any]) -> Optional[str]:
+    if score is None:
+        return None
+    tiers = rules.get("tiers") or {}
+    if score >= tiers.get(TIER_HOT, 101):
+        return TIER_HOT
+    if score >= tiers.get(TIER_WARM, 101):
+        return TIER_WARM
+    return TIER_COLD
+
+
+def _apply_score(chat: Chat, score: int, factors: List[Dict[str, Any]], rules: Dict[str, Any], now: datetime) -> Optional[Dict[str, Any]]:
+    previous_tier = chat.lead_tier
+    previous_score = chat.lead_score
+    chat.lead_score = score
+    chat.lead_tier = lead_tier(score, rules)
+    chat.lead_factors_json = json.dumps(factors)
+    chat.lead_scored_at = now
+    # Scoring must not move the chat up the inbox (updated_at has onupdate=utc_now).
+    flag_modified(chat, "updated_at")
+    if previous_score == score and previous_tier == chat.lead_tier:
+        return None
+    return {"previous_score": previous_score, "previous_tier": previous_tier, "score": score, "tier": chat.lead_tier}
+
+
+def _store_message_signals(chat: Chat, signals: LeadSignals) -> None:
+    chat.lead_signals_json = json.dumps({
+        "ad_referral": signals.ad_referral,
+        "shortlink_referrals": signals.shortlink_referrals,
+        "phone_shared": signals.phone_shared,
+        "keyword_classes": sorted(signals.keyword_classes),
+        "customer_messages": signals.customer_messages,
+    })
+
+
+def _stored_message_signals(chat: Chat) -> Optional[LeadSignals]:
+    try:
+        data = json.loads(chat.lead_signals_json or "null")
+    except (TypeError, ValueError):
+        return None
+    if not isinstance(data, dict):
+        return None
+    return LeadSignals(
+        ad_referral=data.get("ad_referral"),
+        shortlink_referrals=int(data.get("shortlink_referrals") or 0),
+        phone_shared=bool(data.get("phone_shared")),
+        keyword_classes=set(data.get("keyword_classes") or []),
+        customer_messages=int(data.get("customer_messages") or 0),
+    )
+
+
+def _add_chat_signals(db: Session, chat: Chat, signals: LeadSignals) -> LeadSignals:
+    """Fill in the signals that live on the chat rather than in its messages."""
+    signals.link_clicks = chat.link_click_count or 0
+    signals.last_incoming_at = chat.last_incoming_at
+    signals.inquiry_created = bool(chat.inquiry_created_at) or bool(
+        db.query(PaymentRequest.id)
+        .filter(PaymentRequest.chat_id == chat.id, PaymentRequest.inquiry_id.isnot(None))
+        .first()
+    )
+    return signals
+
+
+def collect_signals(db: Session, chat: Chat, rules: Dict[str, Any]) -> LeadSignals:
+    """Rebuild the signals from every customer message and store the message-derived part."""
+    model = _message_model_for_platform(chat.platform)
+    signals = LeadSignals()
+    rows = (
+        db.query(model.content, model.metadata_json)
+        .filter(model.chat_id == chat.id, model.sender.in_(CUSTOMER_SENDERS))
+        .all()
+    )
+    for content, metadata_json in rows:
+        add_message_signals(signals, content, metadata_json, rules)
+    _store_message_signals(chat, signals)
+    return _add_chat_signals(db, chat, signals)
+
+
+def recompute_lead_score(
+    db: Session,
+    chat: Chat,
+    rules: Optional[Dict[str, Any]] = None,
+    now: Optional[datetime] = None,
+) -> Optional[Dict[str, Any]]:
+    """Recompute and store a chat's score; returns the change (or None). The caller commits."""
+    rules = rules or load_rules(db)
+    now = now or utc_now()
+    db.flush()
+    score, factors = score_lead(collect_signals(db, chat, rules), rules, now)
+    return _apply_score(chat, score, factors, rules, now)
+
+
+def score_inbound_message(
+    db: Session,
+    chat: Chat,
+    content: Optional[str],
+    metadata_json: Optional[str],
+) -> Optional[Dict[str, Any]]:
+    """
+    Fold one new customer message into the chat's stored signals and rescore; returns the change (or None).
+    Chats without stored signals get a full recompute, which must already see the message. The caller commits.
+    """
+    signals = _stored_message_signals(chat)
+    if signals is None:
+        return recompute_lead_score(db, chat)
+    rules = load_rules(db)
+    now = utc_now()
+    add_message_signals(signals, content, metadata_json, rules)
+    _store_message_signals(chat, signals)
+    score, factors = score_lead(_add_chat_signals(db, chat, signals), rules, now)
+    return _apply_score(chat, score, factors, rules, now)
+
+
+def prescore_new_chat(db: Session, chat: Chat, content: Optional[str], metadata_json: Optional[str]) -> None:
+    """Score a brand-new chat from its first message so assignment can route hot leads."""
+    rules = load_rules(db)
+    now = utc_now()
+    signals = LeadSignals(last_incoming_at=now)
+    add_message_signals(signals, content, metadata_json, rules)
+    _store_message_signals(chat, signals)
+    score, factors = score_lead(signals, rules, now)
+    _apply_score(chat, score, factors, rules, now)
+
+
+def tier_raised_to_notify(change: Optional[Dict[str, Any]], rules: Dict[str, Any]) -> bool:
+    if not change or not change.get("tier"):
+        return False
+    notify_tier = (rules.get("automation") or {}).get("notify_tier") or TIER_HOT
+    new_rank = TIER_RANK.get(change["tier"], 0)
+    old_rank = TIER_RANK.get(change.get("previous_tier") or TIER_COLD, 0)
+    return new_rank >= TIER_RANK.get(notify_tier, 2) and new_rank > old_rank
+
+
+async def announce_lead_change(db: Session, chat: Chat, change: Optional[Dict[str, Any]]) -> None:
+    """Automation hooks once a chat reaches the notify tier (after the caller committed)."""
+    rules = load_rules(db)
+    if not tier_raised_to_notify(change, rules):
+        return
+    payload = {
+        "type": "lead_score",
+        "chat_id": str(chat.id),
+        "score": chat.lead_score,
+        "tier": chat.lead_tier,
+        "previous_tier": change.get("previous_tier"),
+        "factors": chat.lead_factors,
+    }
+    await ws_manager.broadcast_to_users(gather_dm_notify_users(db, chat), payload)
+    if chat.lead_tier == TIER_HOT and (rules.get("automation") or {}).get("alert_admins_on_hot"):
+        await send_admin_alert(
+            db,
+            "lead_hot",
+            f"Hot lead: {chat.username}",
+            f"Lead score {chat.lead_score} on {chat.platform.value.lower()} chat {chat.username}.",
+            details={"chat_id": chat.id, "factors": chat.lead_factors[:5]},
+            severity="info",
+        )
+
+
+async def refresh_lead_score(db: Session, chat: Chat) -> None:
+    """Recompute, commit and run automation; used by event endpoints."""
+    change = recompute_lead_score(db, chat)
+    db.commit()
+    await announce_lead_change(db, chat, change)
+
+
+async def announce_lead_change_in_background(chat_id: str, change: Optional[Dict[str, Any]]) -> None:
+    """Background task for sync endpoints, which cannot await announce_lead_change on the request session."""
+    with SessionLocal() as session:
+        chat = session.query(Chat).filter(Chat.id == chat_id).first()
+        if chat:
+            await announce_lead_change(session, chat, change)
+
+
+def mark_inquiry_created(db: Session, chat_id: str) -> Optional[Dict[str, Any]]:
+    """Record that an inquiry was created from the chat and rescore it; returns the change to announce."""
+    chat = db.query(Chat).filter(Chat.id == chat_id).first()
+    if not chat:
+        return None
+    if not chat.inquiry_created_at:
+        chat.inquiry_created_at = utc_now()
+    change = recompute_lead_score(db, chat)
+    db.commit()
+    return change
+
+
+def recompute_active_lead_scores(db: Session, now: Optional[datetime] = None) -> int:
+    """Periodic pass so recency decays for chats without new events."""
+    now = now or utc_now()
+    rules = load_rules(db)
+    chats = (
+        db.query(Chat)
+        .filter(Chat.last_incoming_at >= now - timedelta(days=LEAD_SCORE_ACTIVE_DAYS))
+        .all()
+    )
+    changed = 0
+    for chat in chats:
+        if recompute_lead_score(db, chat, rules, now):
+            changed += 1
+    db.commit()
+    return changed
+
+
+def _get_chat(db: Session, chat_id: str, current_user: User) -> Chat:
+    chat = db.query(Chat).filter(Chat.id == chat_id).first()
+    if not chat:
+        raise HTTPException(status_code=404, detail="Chat not found")
+    assert_chat_access(current_user, chat)
+    return chat
+
+
+def _score_response(chat: Chat) -> LeadScoreResponse:
+    return LeadScoreResponse(
+        chat_id=chat.id,
+        lead_score=chat.lead_score,
+        lead_tier=chat.lead_tier,
+        lead_factors=chat.lead_factors,
+        lead_scored_at=chat.lead_scored_at,
+        link_click_count=chat.link_click_count or 0,
+        inquiry_created_at=chat.inquiry_created_at,
+    )
+
+
+@router.get("/chats/{chat_id}/lead-score", response_model=LeadScoreResponse)
+async def get_lead_score(
+    chat_id: str,
+    refresh: bool = False,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    chat = _get_chat(db, chat_id, current_user)
+    if refresh or chat.lead_score is None:
+        await refresh_lead_score(db, chat)
+    return _score_response(chat)
+
+
+@router.post("/chats/{chat_id}/lead-events", response_model=LeadScoreResponse)
+async def record_lead_event(
+    chat_id: str,
+    payload: LeadEventRequest,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    """Record a lead signal that does not arrive by webhook (tracked link click, inquiry created elsewhere)."""
+    if payload.event not in LEAD_EVENTS:
+        raise HTTPException(status_code=400, detail=f"Unknown lead event; expected one of {', '.join(LEAD_EVENTS)}")
+    chat = _get_chat(db, chat_id, current_user)
+    if payload.event == "link_click":
+        chat.link_click_count = (chat.link_click_count or 0) + 1
+    elif not chat.inquiry_created_at:
+        chat.inquiry_created_at = utc_now()
+    flag_modified(chat, "updated_at")
+    await refresh_lead_score(db, chat)
+    return _score_response(chat)
+
+
+@router.get("/admin/lead-scoring/rules")
+def get_lead_scoring_rules(
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    row = _config_row(db)
+    return {
+        "rules": load_rules(db),
+        "defaults": DEFAULT_LEAD_RULES,
+        "updated_by": row.updated_by if row else None,
+        "updated_at": row.updated_at if row else None,
+    }
+
+
+@router.put("/admin/lead-scoring/rules")
+def update_lead_scoring_rules(
+    payload: LeadScoringRulesUpdate,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    rules = merge_rules(payload.rules)
+    error = invalid_rule_pattern(rules)
+    if error:
+        raise HTTPException(status_code=400, detail=error)
+    save_rule_overrides(db, payload.rules, current_user)
+    record_audit(db, current_user, "lead_scoring.rules_update", "lead_scoring", None, {"rules": payload.rules})
+    db.commit()
+    return {"rules": rules}
+
+
+@router.post("/admin/lead-scoring/recompute")
+def recompute_lead_scores(
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    changed = recompute_active_lead_scores(db)
+    logger.info("Lead scores recomputed by %s (%s changed)", current_user.email, changed)
+    return {"success": True, "changed": changed}
@@ -0,0 +1,518 @@
+import json
+import logging
+import secrets
+from datetime import datetime, timedelta
+from decimal import Decimal, ROUND_HALF_UP
+from html import escape
+from typing import Any, Dict, List, Optional, Tuple
+
+from fastapi import APIRouter, Depends, HTTPException, Query, Request
+from fastapi.responses import HTMLResponse
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import Chat, PaymentEvent, PaymentRequest, PaymentRequestStatus, User
+from payment_providers import (
+    LocalPaymentProvider,
+    PaymentProvider,
+    PaymentProviderError,
+    PaymentWebhookError,
+    get_payment_provider,
+)
+from permissions import PermissionCode
+from routes.chat_helpers import (
+    ChatDeliveryError,
+    assert_chat_access,
+    broadcast_chat_message,
+    deliver_chat_text,
+    gather_dm_notify_users,
+)
+from routes.dependencies import get_current_user, require_permissions
+from schemas import (
+    PaymentDiscrepancy,
+    PaymentInquirySummary,
+    PaymentReconciliationReport,
+    PaymentRequestCreate,
+    PaymentRequestResponse,
+    PaymentStatusTotal,
+)
+from settings import (
+    PAYMENT_CONFIRMATION_MESSAGE,
+    PAYMENT_CRM_UPDATE_ROUTE,
+    PAYMENT_DEFAULT_CURRENCY,
+    PAYMENT_LINK_EXPIRY_MINUTES,
+    PAYMENT_LINK_MESSAGE,
+)
+from utils.admin_bridge import post_admin_route
+from utils.timezone import as_utc, utc_now
+from websocket_manager import manager as ws_manager
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+# Test checkout for the local provider; server.py mounts it only with PAYMENT_LOCAL_DEV_MODE.
+local_checkout_router = APIRouter()
+
+_TERMINAL_STATUSES = {
+    PaymentRequestStatus.PAID,
+    PaymentRequestStatus.CANCELLED,
+}
+
+
+def _quantize(value: Any) -> Decimal:
+    return Decimal(str(value)).quantize(Decimal("0.01"), rounding=ROUND_HALF_UP)
+
+
+def _format_amount(value: Any) -> str:
+    return f"{_quantize(value):,.2f}"
+
+
+def _generate_reference(inquiry_id: Optional[str]) -> str:
+    suffix = secrets.token_hex(3).upper()
+    if inquiry_id:
+        safe_inquiry = "".join(ch for ch in inquiry_id if ch.isalnum())[:40]
+        return f"INQ{safe_inquiry}-{suffix}"
+    return f"PAY-{utc_now():%Y%m%d}-{suffix}"
+
+
+def _render_message(template: str, payment: PaymentRequest, amount: Optional[Any] = None) -> str:
+    values = {
+        "amount": _format_amount(amount if amount is not None else payment.amount),
+        "currency": payment.currency,
+        "reference": payment.reference,
+        "url": payment.payment_url or "",
+        "inquiry_id": payment.inquiry_id or "",
+    }
+    try:
+        return template.format(**values)
+    except (KeyError, IndexError, ValueError):
+        logger.warning("Invalid payment message template; falling back to defaults")
+        return f"{values['amount']} {values['currency']} (Ref: {values['reference']}) {values['url']}".strip()
+
+
+def _payment_status_payload(payment: PaymentRequest) -> Dict[str, Any]:
+    return {
+        "type": "payment_status",
+        "chat_id": payment.chat_id,
+        "payment": PaymentRequestResponse.model_validate(payment).model_dump(mode="json"),
+    }
+
+
+def _sync_inquiry_payment(payment: PaymentRequest) -> None:
+    """Report the payment outcome back to the CRM inquiry when a bridge route is configured."""
+    if not PAYMENT_CRM_UPDATE_ROUTE or not payment.inquiry_id:
+        return
+    post_admin_route(
+        PAYMENT_CRM_UPDATE_ROUTE,
+        {
+            "inquiry_id": payment.inquiry_id,
+            "reference": payment.reference,
+            "status": payment.status.value,
+            "amount": str(payment.amount_received or payment.amount),
+            "currency": payment.currency,
+            "paid_at": payment.paid_at.isoformat() if payment.paid_at else None,
+        },
+    )
+
+
+def _find_payment(db: Session, reference: Optional[str], provider_payment_id: Optional[str]) -> Optional[PaymentRequest]:
+    if reference:
+        payment = db.query(PaymentRequest).filter(PaymentRequest.reference == reference).first()
+        if payment:
+            return payment
+    if provider_payment_id:
+        return (
+            db.query(PaymentRequest)
+            .filter(PaymentRequest.provider_payment_id == provider_payment_id)
+            .first()
+        )
+    return None
+
+
+async def apply_payment_update(
+    db: Session,
+    provider: PaymentProvider,
+    update: Dict[str, Any],
+    raw_payload: Optional[str] = None,
+) -> Tuple[Optional[PaymentRequest], bool]:
+    """
+    Record a provider status update and move the payment request forward.
+    Returns (payment_request, status_changed).
+    """
+    event = PaymentEvent(
+        provider=provider.name,
+        reference=update.get("reference"),
+        provider_payment_id=update.get("provider_payment_id"),
+        status=update.get("status"),
+        amount=update.get("amount"),
+        currency=update.get("currency"),
+        payload_json=raw_payload,
+    )
+    db.add(event)
+
+    payment = _find_payment(db, update.get("reference"), update.get("provider_payment_id"))
+    if not payment:
+        event.note = "unmatched"
+        db.commit()
+        logger.warning("Payment webhook for unknown reference %s", update.get("reference"))
+        return None, False
+    event.payment_request_id = payment.id
+
+    try:
+        new_status = PaymentRequestStatus(update.get("status") or "")
+    except ValueError:
+        event.note = "unknown status"
+        db.commit()
+        return payment, False
+
+    if payment.status == new_status or payment.status in _TERMINAL_STATUSES:
+        if payment.status != new_status:
+            event.note = f"ignored; payment already {payment.status.value}"
+        db.commit()
+        return payment, False
+
+    payment.status = new_status
+    if update.get("provider_payment_id") and not payment.provider_payment_id:
+        payment.provider_payment_id = update.get("provider_payment_id")
+
+    confirmation = None
+    chat = payment.chat
+    if new_status == PaymentRequestStatus.PAID:
+        received = update.get("amount")
+        payment.amount_received = _quantize(received) if received is not None else payment.amount
+        payment.paid_at = update.get("paid_at") or utc_now()
+        if _quantize(payment.amount_received) != _quantize(payment.amount):
+            event.note = "amount mismatch"
+        if chat:
+            try:
+                confirmation = await deliver_chat_text(
+                    db,
+                    chat,
+                    _render_message(PAYMENT_CONFIRMATION_MESSAGE, payment, payment.amount_received),
+                    extra_metadata={"payment_request_id": payment.id, "payment_reference": payment.reference},
+                    automated=True,
+                )
+                db.flush()
+                payment.confirmation_message_id = confirmation.id
+            except ChatDeliveryError as exc:
+                logger.warning("Payment confirmation for %s not delivered: %s", payment.reference, exc)
+                confirmation = None
+
+    db.commit()
+    db.refresh(payment)
+
+    if chat:
+        notify_users = gather_dm_notify_users(db, chat)
+        if payment.created_by:
+            notify_users.add(str(payment.created_by))
+        await ws_manager.broadcast_to_users(notify_users, _payment_status_payload(payment))
+        if confirmation is not None:
+            await broadcast_chat_message(db, chat, confirmation)
+
+    _sync_inquiry_payment(payment)
+    return payment, True
+
+
+async def expire_stale_payments(db: Session) -> int:
+    """Mark pending payment requests past their expires_at as expired. Returns how many changed."""
+    now = utc_now()
+    stale = (
+        db.query(PaymentRequest)
+        .filter(PaymentRequest.status == PaymentRequestStatus.PENDING)
+        .filter(PaymentRequest.expires_at < now)
+        .all()
+    )
+    if not stale:
+        return 0
+    for payment in stale:
+        payment.status = PaymentRequestStatus.EXPIRED
+    db.commit()
+
+    for payment in stale:
+        if payment.chat:
+            notify_users = gather_dm_notify_users(db, payment.chat)
+            if payment.created_by:
+                notify_users.add(str(payment.created_by))
+            await ws_manager.broadcast_to_users(notify_users, _payment_status_payload(payment))
+        _sync_inquiry_payment(payment)
+    return len(stale)
+
+
+@router.post("/chats/{chat_id}/payment-requests", response_model=PaymentRequestResponse)
+async def create_payment_request(
+    chat_id: str,
+    payload: PaymentRequestCreate,
+    current_user: User = Depends(require_permissions(PermissionCode.CHAT_MESSAGE)),
+    db: Session = Depends(get_db),
+):
+    chat = db.query(Chat).filter(Chat.id == chat_id).first()
+    if not chat:
+        raise HTTPException(status_code=404, detail="Chat not found")
+    assert_chat_access(current_user, chat)
+
+    try:
+        provider = get_payment_provider()
+    except PaymentProviderError as exc:
+        raise HTTPException(status_code=500, detail=str(exc))
+
+    amount = _quantize(payload.amount)
+    currency = payload.currency or PAYMENT_DEFAULT_CURRENCY
+    expires_at = utc_now() + timedelta(minutes=payload.expires_in_minutes or PAYMENT_LINK_EXPIRY_MINUTES)
+    reference = _generate_reference(payload.inquiry_id)
+
+    try:
+        link = provider.create_payment_link(
+            reference=reference,
+            amount=amount,
+            currency=currency,
+            description=payload.description,
+            customer_name=chat.username,
+            expires_at=expires_at,
+        )
+    except PaymentProviderError as exc:
+        logger.error("Payment link creation failed for chat %s: %s", chat_id, exc)
+        raise HTTPException(status_code=502, detail=f"Failed to create payment link: {exc}")
+
+    payment = PaymentRequest(
+        chat_id=chat.id,
+        reference=reference,
+        inquiry_id=payload.inquiry_id,
+        amount=amount,
+        currency=currency,
+        description=payload.description,
+        provider=provider.name,
+        provider_payment_id=link.get("provider_payment_id"),
+        payment_url=link.get("payment_url"),
+        status=PaymentRequestStatus.PENDING,
+        created_by=current_user.id,
+        expires_at=expires_at,
+    )
+    db.add(payment)
+    db.flush()
+
+    try:
+        message = await deliver_chat_text(
+            db,
+            chat,
+            _render_message(PAYMENT_LINK_MESSAGE, payment),
+            sent_by=current_user,
+            extra_metadata={"payment_request_id": payment.id, "payment_reference": payment.reference},
+        )
+    except ChatDeliveryError as exc:
+        db.rollback()
+        raise HTTPException(status_code=502, detail=f"Failed to send payment link: {exc}")
+    db.flush()
+    payment.message_id = message.id
+    db.commit()
+    db.refresh(payment)
+    db.refresh(message)
+
+    await broadcast_chat_message(db, chat, message)
+    logger.info("Payment request %s created in chat %s by %s", payment.reference, chat.id, current_user.id)
+    return payment
+
+
+@router.get("/chats/{chat_id}/payment-requests", response_model=List[PaymentRequestResponse])
+def list_chat_payment_requests(
+    chat_id: str,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    chat = db.query(Chat).filter(Chat.id == chat_id).first()
+    if not chat:
+        raise HTTPException(status_code=404, detail="Chat not found")
+    assert_chat_access(current_user, chat)
+    return (
+        db.query(PaymentRequest)
+        .filter(PaymentRequest.chat_id == chat.id)
+        .order_by(PaymentRequest.created_at.desc())
+        .all()
+    )
+
+
+@router.post("/payments/{payment_id}/cancel", response_model=PaymentRequestResponse)
+async def cancel_payment_request(
+    payment_id: str,
+    current_user: User = Depends(require_permissions(PermissionCode.CHAT_MESSAGE)),
+    db: Session = Depends(get_db),
+):
+    payment = db.query(PaymentRequest).filter(PaymentRequest.id == payment_id).first()
+    if not payment:
+        raise HTTPException(status_code=404, detail="Payment request not found")
+    assert_chat_access(current_user, payment.chat)
+    if payment.status != PaymentRequestStatus.PENDING:
+        raise HTTPException(status_code=400, detail=f"Payment request is already {payment.status.value}")
+    payment.status = PaymentRequestStatus.CANCELLED
+    db.commit()
+    db.refresh(payment)
+    await ws_manager.broadcast_to_users(gather_dm_notify_users(db, payment.chat), _payment_status_payload(payment))
+    _sync_inquiry_payment(payment)
+    return payment
+
+
+@router.post("/webhooks/payments/{provider_name}")
+async def handle_payment_webhook(provider_name: str, request: Request, db: Session = Depends(get_db)):
+    try:
+        provider = get_payment_provider(provider_name)
+    except PaymentProviderError:
+        raise HTTPException(status_code=404, detail="Unknown payment provider")
+
+    body = await request.body()
+    if not provider.verify_webhook(body, request.headers):
+        logger.warning("Invalid %s payment webhook signature", provider.name)
+        raise HTTPException(status_code=403, detail="Invalid signature")
+    try:
+        data = json.loads(body or b"{}")
+    except ValueError:
+        raise HTTPException(status_code=400, detail="Invalid JSON payload")
+
+    try:
+        update = provider.parse_webhook(data)
+    except PaymentWebhookError as exc:
+        logger.warning("Rejected %s payment webhook: %s", provider.name, exc)
+        raise HTTPException(status_code=400, detail=str(exc))
+    payment, changed = await apply_payment_update(db, provider, update, raw_payload=body.decode("utf-8", "replace"))
+    return {
+        "success": True,
+        "matched": payment is not None,
+        "changed": changed,
+        "status": payment.status.value if payment else None,
+    }
+
+
+@local_checkout_router.get("/payments/local/{reference}", response_class=HTMLResponse, include_in_schema=False)
+def local_checkout_page(reference: str, db: Session = Depends(get_db)):
+    payment = db.query(PaymentRequest).filter(PaymentRequest.reference == reference).first()
+    if not payment or payment.provider != LocalPaymentProvider.name:
+        raise HTTPException(status_code=404, detail="Payment not found")
+    amount = escape(f"{_format_amount(payment.amount)} {payment.currency}")
+    ref = escape(payment.reference)
+    state = escape(payment.status.value)
+    return HTMLResponse(
+        f"""<!doctype html>
+<html><head><title>Payment {ref}</title></head>
+<body style="font-family: sans-serif; max-width: 28rem; margin: 3rem auto;">
+  <h2>Test checkout</h2>
+  <p>Reference: <strong>{ref}</strong></p>
+  <p>Amount: <strong>{amount}</strong></p>
+  <p>Status: {state}</p>
+  <form method="post" action="{ref}/complete?outcome=paid"><button type="submit">Pay now</button></form>
+  <form method="post" action="{ref}/complete?outcome=failed"><button type="submit">Simulate failure</button></form>
+</body></html>"""
+    )
+
+
+@local_checkout_router.post("/payments/local/{reference}/complete", include_in_schema=False)
+async def local_checkout_complete(
+    reference: str,
+    outcome: str = Query("paid"),
+    db: Session = Depends(get_db),
+):
+    payment = db.query(PaymentRequest).filter(PaymentRequest.reference == reference).first()
+    if not payment or payment.provider != LocalPaymentProvider.name:
+        raise HTTPException(status_code=404, detail="Payment not found")
+    if outcome not in {"paid", "failed"}:
+        raise HTTPException(status_code=400, detail="outcome must be paid or failed")
+
+    provider = get_payment_provider(LocalPaymentProvider.name)
+    body = provider.build_callback(payment.reference, payment.provider_payment_id, outcome, payment.amount, payment.currency)
+    update = provider.parse_webhook(json.loads(body))
+    payment, _ = await apply_payment_update(db, provider, update, raw_payload=body.decode("utf-8"))
+    return {"success": True, "reference": reference, "status": payment.status.value if payment else None}
+
+
+@router.get("/payments/reconciliation", response_model=PaymentReconciliationReport)
+def payment_reconciliation_report(
+    start: Optional[datetime] = Query(None),
+    end: Optional[datetime] = Query(None),
+    current_user: User = Depends(require_permissions(PermissionCode.STATS_VIEW)),
+    db: Session = Depends(get_db),
+):
+    now = utc_now()
+    window_end = end or now
+    window_start = start or (window_end - timedelta(days=30))
+
+    payments = (
+        db.query(PaymentRequest)
+        .filter(PaymentRequest.created_at >= window_start)
+        .filter(PaymentRequest.created_at <= window_end)
+        .order_by(PaymentRequest.created_at.asc())
+        .all()
+    )
+
+    totals: Dict[PaymentRequestStatus, PaymentStatusTotal] = {
+        status_value: PaymentStatusTotal(status=status_value) for status_value in PaymentRequestStatus
+    }
+    currency_totals: Dict[str, Decimal] = {}
+    inquiries: Dict[Optional[str], PaymentInquirySummary] = {}
+    discrepancies: List[PaymentDiscrepancy] = []
+
+    for payment in payments:
+        bucket = totals[payment.status]
+        bucket.count += 1
+        bucket.amount = float(_quantize(bucket.amount) + _quantize(payment.amount))
+
+        summary = inquiries.setdefault(payment.inquiry_id, PaymentInquirySummary(inquiry_id=payment.inquiry_id))
+        summary.references.append(payment.reference)
+        summary.requested_amount = float(_quantize(summary.requested_amount) + _quantize(payment.amount))
+
+        if payment.status == PaymentRequestStatus.PAID:
+            received = _quantize(payment.amount_received if payment.amount_received is not None else payment.amount)
+            summary.collected_amount = float(_quantize(summary.collected_amount) + received)
+            currency_totals[payment.currency] = currency_totals.get(payment.currency, Decimal("0")) + received
+            if received != _quantize(payment.amount):
+                discrepancies.append(PaymentDiscrepancy(
+                    issue="amount_mismatch",
+                    reference=payment.reference,
+                    payment_request_id=payment.id,
+                    detail=f"requested {_format_amount(payment.amount)}, received {_format_amount(received)}",
+                ))
+            if not payment.confirmation_message_id:
+                discrepancies.append(PaymentDiscrepancy(
+                    issue="confirmation_missing",
+                    reference=payment.reference,
+                    payment_request_id=payment.id,
+                ))
+        elif payment.status == PaymentRequestStatus.PENDING:
+            expires_at = as_utc(payment.expires_at)
+            if expires_at and expires_at < now:
+                discrepancies.append(PaymentDiscrepancy(
+                    issue="pending_past_expiry",
+                    reference=payment.reference,
+                    payment_request_id=payment.id,
+                ))
+            if payment.provider_payment_id:
+                try:
+                    remote_status = get_payment_provider(payment.provider).fetch_status(payment.provider_payment_id)
+                except PaymentProviderError:
+                    remote_status = None
+                if remote_status and remote_status != payment.status.value:
+                    discrepancies.append(PaymentDiscrepancy(
+                        issue="provider_status_mismatch",
+                        reference=payment.reference,
+                        payment_request_id=payment.id,
+                        detail=f"provider reports {remote_status}",
+                    ))
+
+    unmatched_events = (
+        db.query(PaymentEvent)
+        .filter(PaymentEvent.payment_request_id.is_(None))
+        .filter(PaymentEvent.received_at >= window_start)
+        .filter(PaymentEvent.received_at <= window_end)
+        .all()
+    )
+    for event in unmatched_events:
+        discrepancies.append(PaymentDiscrepancy(
+            issue="unmatched_event",
+            reference=event.reference,
+            detail=f"{event.provider} event with status {event.status or 'unknown'}",
+        ))
+
+    return PaymentReconciliationReport(
+        start=window_start,
+        end=window_end,
+        currency_totals={code: float(value) for code, value in currency_totals.items()},
+        totals=list(totals.values()),
+        inquiries=list(inquiries.values()),
+        discrepancies=discrepancies,
+    )
@@ -0,0 +1,336 @@
+import json
+import logging
+import math
+import re
+from datetime import datetime, timedelta
+from typing import Any, Dict, List, Optional
+
+from fastapi import APIRouter, Depends
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import Chat, ChatQueueNotice, MessageSender, User
+from permissions import PermissionCode
+from routes.chat_helpers import (
+    ChatDeliveryError,
+    _get_assignable_agents,
+    _message_model_for_platform,
+    agent_at_capacity,
+    agent_open_chat_counts,
+    awaiting_agent_reply_clause,
+    broadcast_chat_message,
+    deliver_chat_text,
+)
+from routes.dependencies import require_permissions
+from schemas import QueueEntryResponse, QueueStatusResponse
+from settings import (
+    AGENT_CHAT_CAPACITY,
+    QUEUE_NOTICE_DEFAULT_HANDLE_MINUTES,
+    QUEUE_NOTICE_DEFAULT_LOCALE,
+    QUEUE_NOTICE_ENABLED,
+    QUEUE_NOTICE_INITIAL_DELAY_SECONDS,
+    QUEUE_NOTICE_MAX_PER_WAIT,
+    QUEUE_NOTICE_RATE_WINDOW_MINUTES,
+    QUEUE_NOTICE_REPEAT_MINUTES,
+    QUEUE_NOTICE_TEMPLATES,
+)
+from utils.emergency import active_emergency
+from utils.timezone import as_utc, utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+REASON_UNASSIGNED = "unassigned"
+REASON_AGENT_BUSY = "agent_busy"
+
+# Meta only allows standard messages inside the 24h window, keep a safety margin.
+MESSAGING_WINDOW_HOURS = 23
+
+DEFAULT_QUEUE_NOTICE_TEMPLATES: Dict[str, Dict[str, str]] = {
+    "en": {
+        REASON_UNASSIGNED: (
+            "Thanks for reaching out! You are number {position} in our queue. "
+            "The expected wait is about {wait_minutes} minutes and an agent will reply as soon as possible."
+        ),
+        REASON_AGENT_BUSY: (
+            "Thanks for your patience! Our team is helping other customers right now. "
+            "The expected wait is about {wait_minutes} minutes."
+        ),
+    },
+    "hi": {
+        REASON_UNASSIGNED: (
+            "संपर्क करने के लिए धन्यवाद! कतार में आपका नंबर {position} है। "
+            "अनुमानित प्रतीक्षा समय लगभग {wait_minutes} मिनट है, हमारी टीम जल्द ही जवाब देगी।"
+        ),
+        REASON_AGENT_BUSY: (
+            "धैर्य रखने के लिए धन्यवाद! हमारी टीम अभी अन्य ग्राहकों की सहायता कर रही है। "
+            "अनुमानित प्रतीक्षा समय लगभग {wait_minutes} मिनट है।"
+        ),
+    },
+}
+
+_DEVANAGARI_RE = re.compile(r"[\u0900-\u097F]")
+
+
+def load_queue_templates() -> Dict[str, Dict[str, str]]:
+    templates = {locale: dict(texts) for locale, texts in DEFAULT_QUEUE_NOTICE_TEMPLATES.items()}
+    if not QUEUE_NOTICE_TEMPLATES:
+        return templates
+    try:
+        overrides = json.loads(QUEUE_NOTICE_TEMPLATES)
+    except (TypeError, ValueError):
+        logger.warning("QUEUE_NOTICE_TEMPLATES is not valid JSON; using built-in texts")
+        return templates
+    if isinstance(overrides, dict):
+        for locale, texts in overrides.items():
+            if isinstance(texts, dict):
+                templates.setdefault(str(locale).lower(), {}).update(
+                    {str(reason): str(text) for reason, text in texts.items() if text}
+                )
+    return templates
+
+
+def detect_locale(text: Optional[str], templates: Dict[str, Dict[str, str]]) -> str:
+    """Pick a template locale from the customer's own wording."""
+    if text and _DEVANAGARI_RE.search(text) and "hi" in templates:
+        return "hi"
+    if QUEUE_NOTICE_DEFAULT_LOCALE in templates:
+        return QUEUE_NOTICE_DEFAULT_LOCALE
+    return "en"
+
+
+def estimate_wait_minutes(position: int, rate_per_minute: float, available_agents: int) -> int:
+    """
+    Expected wait for the given queue position, rounded up to 5 minutes.
+
+    Uses the recent handling rate when there is one, otherwise assumes each
+    available agent clears one chat every QUEUE_NOTICE_DEFAULT_HANDLE_MINUTES.
+    """
+    position = max(1, position)
+    if rate_per_minute > 0:
+        minutes = position / rate_per_minute
+    else:
+        minutes = position * QUEUE_NOTICE_DEFAULT_HANDLE_MINUTES / max(1, available_agents)
+    return max(5, int(math.ceil(minutes / 5.0)) * 5)
+
+
+def _wait_started_at(db: Session, chat: Chat) -> Optional[datetime]:
+    """First customer message since the last agent reply."""
+    model = _message_model_for_platform(chat.platform)
+    query = db.query(func.min(model.timestamp)).filter(
+        model.chat_id == chat.id,
+        model.sender != MessageSender.AGENT,
+    )
+    if chat.last_outgoing_at:
+        query = query.filter(model.timestamp > chat.last_outgoing_at)
+    started = query.scalar()
+    return as_utc(started or chat.last_incoming_at)
+
+
+def _latest_customer_text(db: Session, chat: Chat) -> Optional[str]:
+    model = _message_model_for_platform(chat.platform)
+    message = (
+        db.query(model)
+        .filter(model.chat_id == chat.id, model.sender != MessageSender.AGENT)
+        .order_by(model.timestamp.desc())
+        .first()
+    )
+    return message.content if message else None
+
+
+def handling_rate_per_minute(db: Session, now: datetime, agent_id: Optional[str] = None) -> float:
+    """Chats answered per minute over the recent rate window."""
+    window = max(1, QUEUE_NOTICE_RATE_WINDOW_MINUTES)
+    query = db.query(func.count(Chat.id)).filter(Chat.last_outgoing_at >= now - timedelta(minutes=window))
+    if agent_id:
+        query = query.filter(Chat.assigned_to == agent_id)
+    handled = query.scalar() or 0
+    return handled / float(window)
+
+
+def compute_queue(db: Session, now: Optional[datetime] = None) -> Dict[str, Any]:
+    """
+    Build the live queue: unassigned chats waiting for an agent, and chats held
+    by agents who are at capacity. Positions are ordered by wait start.
+    """
+    now = now or utc_now()
+    window_start = now - timedelta(hours=MESSAGING_WINDOW_HOURS)
+    waiting_chats = (
+        db.query(Chat)
+        .filter(awaiting_agent_reply_clause())
+        .filter(Chat.last_incoming_at >= window_start)
+        .all()
+    )
+
+    open_counts = agent_open_chat_counts(db)
+    agents = _get_assignable_agents(db)
+    available_agents = [agent for agent in agents if not agent_at_capacity(db, agent.id, open_counts)]
+    rate = handling_rate_per_minute(db, now)
+
+    unassigned: List[Dict[str, Any]] = []
+    busy_by_agent: Dict[str, List[Dict[str, Any]]] = {}
+    for chat in waiting_chats:
+        waiting_since = _wait_started_at(db, chat)
+        if waiting_since is None:
+            continue
+        if not chat.assigned_to:
+            unassigned.append({"chat": chat, "waiting_since": waiting_since})
+        elif agent_at_capacity(db, chat.assigned_to, open_counts):
+            busy_by_agent.setdefault(str(chat.assigned_to), []).append({"chat": chat, "waiting_since": waiting_since})
+
+    entries: List[Dict[str, Any]] = []
+    unassigned.sort(key=lambda item: item["waiting_since"])
+    for idx, item in enumerate(unassigned):
+        position = idx + 1
+        entries.append({
+            **item,
+            "reason": REASON_UNASSIGNED,
+            "position": position,
+            "wait_minutes": estimate_wait_minutes(position, rate, len(available_agents)),
+        })
+
+    for agent_id, items in busy_by_agent.items():
+        items.sort(key=lambda item: item["waiting_since"])
+        agent_rate = handling_rate_per_minute(db, now, agent_id=agent_id)
+        for idx, item in enumerate(items):
+            position = idx + 1
+            entries.append({
+                **item,
+                "reason": REASON_AGENT_BUSY,
+                "position": position,
+                "wait_minutes": estimate_wait_minutes(position, agent_rate, 1),
+            })
+
+    return {
+        "entries": entries,
+        "rate_per_minute": rate,
+        "available_agents": len(available_agents),
+        "total_agents": len(agents),
+    }
+
+
+def _notices_this_wait(db: Session, chat: Chat, waiting_since: datetime) -> List[ChatQueueNotice]:
+    return (
+        db.query(ChatQueueNotice)
+        .filter(ChatQueueNotice.chat_id == chat.id)
+        .filter(ChatQueueNotice.sent_at >= waiting_since)
+        .order_by(ChatQueueNotice.sent_at.desc())
+        .all()
+    )
+
+
+async def send_queue_notices(db: Session, now: Optional[datetime] = None) -> int:
+    """
+    Send due queue position / expected wait messages. Chats drop out of the queue
+    as soon as an agent replies, so pending notices are effectively cancelled.
+    """
+    if not QUEUE_NOTICE_ENABLED:
+        return 0
+    if active_emergency(db):
+        # Customers already get the emergency auto-reply.
+        return 0
+    now = now or utc_now()
+    templates = load_queue_templates()
+    queue = compute_queue(db, now)
+    sent = 0
+
+    for entry in queue["entries"]:
+        chat: Chat = entry["chat"]
+        waiting_since: datetime = entry["waiting_since"]
+        if (now - waiting_since).total_seconds() < QUEUE_NOTICE_INITIAL_DELAY_SECONDS:
+            continue
+        previous = _notices_this_wait(db, chat, waiting_since)
+        if len(previous) >= QUEUE_NOTICE_MAX_PER_WAIT:
+            continue
+        if previous:
+            last_sent = as_utc(previous[0].sent_at)
+            if last_sent and now - last_sent < timedelta(minutes=QUEUE_NOTICE_REPEAT_MINUTES):
+                continue
+            # Only repeat when the position or estimate actually changed.
+            if (
+                previous[0].reason == entry["reason"]
+                and previous[0].position == entry["position"]
+                and previous[0].estimated_wait_minutes == entry["wait_minutes"]
+            ):
+                continue
+
+        locale = detect_locale(_latest_customer_text(db, chat), templates)
+        template = templates.get(locale, {}).get(entry["reason"]) or templates["en"][entry["reason"]]
+        try:
+            text = template.format(
+                position=entry["position"],
+                wait_minutes=entry["wait_minutes"],
+                username=chat.username or "",
+            )
+        except (KeyError, IndexError, ValueError):
+            logger.warning("Queue notice template for %s/%s is invalid", locale, entry["reason"])
+            continue
+
+        try:
+            message = await deliver_chat_text(
+                db,
+                chat,
+                text,
+                extra_metadata={
+                    "queue_notice": {
+                        "reason": entry["reason"],
+                        "position": entry["position"],
+                        "estimated_wait_minutes": entry["wait_minutes"],
+                    }
+                },
+                automated=True,
+            )
+        except ChatDeliveryError as exc:
+            db.rollback()
+            logger.warning("Queue notice for chat %s not delivered: %s", chat.id, exc)
+            continue
+        db.flush()
+        db.add(ChatQueueNotice(
+            chat_id=chat.id,
+            message_id=message.id,
+            reason=entry["reason"],
+            position=entry["position"],
+            estimated_wait_minutes=entry["wait_minutes"],
+            locale=locale,
+            sent_at=now,
+        ))
+        db.commit()
+        db.refresh(message)
+        await broadcast_chat_message(db, chat, message, sender="system")
+        sent += 1
+    return sent
+
+
+@router.get("/queue/status", response_model=QueueStatusResponse)
+def get_queue_status(
+    current_user: User = Depends(require_permissions(PermissionCode.STATS_VIEW)),
+    db: Session = Depends(get_db),
+):
+    now = utc_now()
+    queue = compute_queue(db, now)
+    entries: List[QueueEntryResponse] = []
+    for entry in queue["entries"]:
+        chat: Chat = entry["chat"]
+        entries.append(QueueEntryResponse(
+            chat_id=chat.id,
+            username=chat.username,
+            platform=chat.platform,
+            assigned_to=chat.assigned_to,
+            reason=entry["reason"],
+            position=entry["position"],
+            estimated_wait_minutes=entry["wait_minutes"],
+            waiting_since=entry["waiting_since"],
+            notices_sent=len(_notices_this_wait(db, chat, entry["waiting_since"])),
+        ))
+    return QueueStatusResponse(
+        notices_enabled=QUEUE_NOTICE_ENABLED,
+        agent_chat_capacity=AGENT_CHAT_CAPACITY,
+        handled_per_hour=round(queue["rate_per_minute"] * 60, 2),
+        available_agents=queue["available_agents"],
+        total_agents=queue["total_agents"],
+        unassigned_waiting=sum(1 for entry in entries if entry.reason == REASON_UNASSIGNED),
+        busy_waiting=sum(1 for entry in entries if entry.reason == REASON_AGENT_BUSY),
+        entries=entries,
+    )
@@ -0,0 +1,129 @@
+from datetime import timedelta
+from typing import Dict, List, Tuple
+
+from fastapi import APIRouter, Depends, HTTPException, Query
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import RateLimitRule, RateLimitThrottle, User
+from rate_limiter import api_key_rule_subject, rate_limiter
+from routes.dependencies import get_admin_user
+from schemas import (
+    RateLimitConfigResponse,
+    RateLimitRuleCreate,
+    RateLimitRuleResponse,
+    RateLimitThrottleEntry,
+)
+from utils.timezone import utc_now
+
+router = APIRouter()
+
+
+@router.get("/admin/rate-limits", response_model=RateLimitConfigResponse)
+def get_rate_limit_config(
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    rules = db.query(RateLimitRule).order_by(RateLimitRule.created_at.asc()).all()
+    return RateLimitConfigResponse(
+        enabled=rate_limiter.enabled,
+        default_limit=rate_limiter.default_limit.limit,
+        default_window_seconds=rate_limiter.default_limit.window_seconds,
+        route_groups={
+            name: {"limit": value.limit, "window_seconds": value.window_seconds}
+            for name, value in rate_limiter.group_limits.items()
+        },
+        rules=rules,
+    )
+
+
+@router.post("/admin/rate-limits/rules", response_model=RateLimitRuleResponse)
+def create_rate_limit_rule(
+    payload: RateLimitRuleCreate,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    subject = payload.subject.strip() if payload.subject else None
+    if subject and payload.scope == "api_key":
+        # identify() only ever sees the fingerprint, never the key itself.
+        subject = api_key_rule_subject(subject)
+    existing = (
+        db.query(RateLimitRule)
+        .filter(RateLimitRule.scope == payload.scope)
+        .filter(RateLimitRule.subject == subject if subject else RateLimitRule.subject.is_(None))
+        .filter(RateLimitRule.route_group == payload.route_group)
+        .first()
+    )
+    rule = existing or RateLimitRule(scope=payload.scope, subject=subject, route_group=payload.route_group)
+    rule.limit = payload.limit
+    rule.window_seconds = payload.window_seconds
+    rule.note = payload.note
+    rule.created_by = current_user.id
+    if not existing:
+        db.add(rule)
+    db.commit()
+    db.refresh(rule)
+    rate_limiter.invalidate_rules()
+    return rule
+
+
+@router.delete("/admin/rate-limits/rules/{rule_id}")
+def delete_rate_limit_rule(
+    rule_id: str,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    rule = db.query(RateLimitRule).filter(RateLimitRule.id == rule_id).first()
+    if not rule:
+        raise HTTPException(status_code=404, detail="Rate limit rule not found")
+    db.delete(rule)
+    db.commit()
+    rate_limiter.invalidate_rules()
+    return {"success": True}
+
+
+@router.get("/admin/rate-limits/throttled", response_model=List[RateLimitThrottleEntry])
+def list_throttled_clients(
+    minutes: int = Query(60, ge=1, le=60 * 24 * 7),
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    since = utc_now() - timedelta(minutes=minutes)
+    rows = (
+        db.query(RateLimitThrottle)
+        .filter(RateLimitThrottle.last_blocked_at >= since)
+        .order_by(RateLimitThrottle.last_blocked_at.desc())
+        .all()
+    )
+
+    aggregated: Dict[Tuple[str, str], RateLimitThrottle] = {}
+    totals: Dict[Tuple[str, str], int] = {}
+    for row in rows:
+        key = (row.identity, row.route_group)
+        totals[key] = totals.get(key, 0) + (row.blocked_count or 0)
+        aggregated.setdefault(key, row)  # rows are newest first
+
+    user_ids = {row.user_id for row in aggregated.values() if row.user_id}
+    users = {
+        user.id: user
+        for user in db.query(User).filter(User.id.in_(user_ids)).all()
+    } if user_ids else {}
+
+    entries: List[RateLimitThrottleEntry] = []
+    for key, row in aggregated.items():
+        user = users.get(row.user_id) if row.user_id else None
+        entries.append(RateLimitThrottleEntry(
+            identity=row.identity,
+            identity_type=row.identity_type,
+            user_id=row.user_id,
+            user_name=user.name if user else None,
+            user_email=user.email if user else None,
+            route_group=row.route_group,
+            limit=row.limit,
+            window_seconds=row.window_seconds,
+            blocked_count=totals[key],
+            last_path=row.last_path,
+            last_blocked_at=row.last_blocked_at,
+        ))
+    entries.sort(key=lambda entry: entry.blocked_count, reverse=True)
+    return entries
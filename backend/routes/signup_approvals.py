@@ -0,0 +1,191 @@
+import html
+import logging
+from typing import List, Optional
+
+from fastapi import APIRouter, Depends, HTTPException, Query, status
+from sqlalchemy.orm import Session, joinedload
+
+from database import get_db
+from models import Position, User, UserApprovalStatus, UserRole
+from permissions import DEFAULT_POSITION_SLUGS, PermissionCode, is_super_admin_user, user_has_permissions
+from routes.dependencies import _annotate_user, require_permissions
+from schemas import SignupApprovalRequest, SignupRejectionRequest, UserResponse
+from settings import FRONTEND_BASE_URL, PASSWORD_RESET_EMAIL_CONTACT, SIGNUP_AUTO_APPROVE_DOMAINS
+from utils.audit import record_audit
+from utils.mailer import send_email
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+
+def is_auto_approved_email(email: Optional[str]) -> bool:
+    if not email or "@" not in email:
+        return False
+    domain = email.rsplit("@", 1)[1].strip().lower()
+    return domain in SIGNUP_AUTO_APPROVE_DOMAINS
+
+
+def _approver_emails(db: Session) -> List[str]:
+    users = (
+        db.query(User)
+        .options(joinedload(User.position))
+        .filter(User.is_active.is_(True))
+        .filter(User.approval_status == UserApprovalStatus.APPROVED)
+        .filter(User.email.isnot(None))
+        .all()
+    )
+    return [user.email for user in users if user_has_permissions(user, [PermissionCode.USER_INVITE.value])]
+
+
+def _login_url() -> str:
+    base = (FRONTEND_BASE_URL or "").rstrip("/")
+    return f"{base}/login" if base else "/login"
+
+
+def notify_signup_received(db: Session, user: User) -> None:
+    """Email the applicant and every approver when a signup lands in the queue."""
+    name = user.name or "there"
+    if user.email:
+        send_email(
+            subject="Your TickleGram signup is awaiting approval",
+            body_text=(
+                f"Hi {name},\n\n"
+                "Thanks for signing up. An administrator will review your request shortly; "
+                "you'll receive another email once it has been approved.\n\n"
+                f"Questions? Contact {PASSWORD_RESET_EMAIL_CONTACT}.\n\n"
+                "- The TickleGram Team"
+            ),
+            to_addresses=[user.email],
+        )
+    approvers = _approver_emails(db)
+    if approvers:
+        send_email(
+            subject=f"New signup pending approval: {user.name}",
+            body_text=(
+                f"{user.name} ({user.email or user.contact_number or 'no contact'}) signed up and is waiting for approval.\n\n"
+                "Review pending signups from the admin user management screen."
+            ),
+            to_addresses=approvers,
+        )
+
+
+def _notify_decision(user: User, approved: bool, reason: Optional[str]) -> None:
+    if not user.email:
+        return
+    name = user.name or "there"
+    if approved:
+        login_url = _login_url()
+        delivered = send_email(
+            subject="Your TickleGram account has been approved",
+            body_text=(
+                f"Hi {name},\n\n"
+                f"Your account has been approved. You can now sign in at {login_url}.\n\n"
+                "- The TickleGram Team"
+            ),
+            body_html=(
+                f"<p>Hi {html.escape(name)},</p>"
+                f"<p>Your account has been approved. You can now <a href=\"{login_url}\">sign in</a>.</p>"
+                "<p>- The TickleGram Team</p>"
+            ),
+            to_addresses=[user.email],
+        )
+    else:
+        delivered = send_email(
+            subject="Your TickleGram signup request",
+            body_text=(
+                f"Hi {name},\n\n"
+                "Unfortunately your signup request was not approved.\n\n"
+                f"Reason: {reason or 'Not specified'}\n\n"
+                f"If you think this is a mistake, contact {PASSWORD_RESET_EMAIL_CONTACT}.\n\n"
+                "- The TickleGram Team"
+            ),
+            to_addresses=[user.email],
+        )
+    if not delivered:
+        logger.warning("Signup decision email not sent (check SMTP config). Recipient=%s", user.email)
+
+
+def _get_pending_user(db: Session, user_id: str) -> User:
+    user = db.query(User).options(joinedload(User.position)).filter(User.id == user_id).first()
+    if not user:
+        raise HTTPException(status_code=404, detail="User not found")
+    if user.approval_status != UserApprovalStatus.PENDING:
+        raise HTTPException(status_code=400, detail=f"Signup is already {user.approval_status.value}")
+    return user
+
+
+@router.get("/admin/signups", response_model=List[UserResponse])
+def list_signups(
+    status_filter: str = Query("pending", alias="status"),
+    current_user: User = Depends(require_permissions(PermissionCode.USER_INVITE)),
+    db: Session = Depends(get_db),
+):
+    try:
+        approval_status = UserApprovalStatus(status_filter.lower())
+    except ValueError:
+        raise HTTPException(status_code=400, detail="status must be pending, approved or rejected")
+    users = (
+        db.query(User)
+        .options(joinedload(User.position))
+        .filter(User.approval_status == approval_status)
+        .order_by(User.created_at.asc())
+        .all()
+    )
+    return [_annotate_user(user) for user in users]
+
+
+@router.post("/admin/signups/{user_id}/approve", response_model=UserResponse)
+def approve_signup(
+    user_id: str,
+    payload: SignupApprovalRequest,
+    current_user: User = Depends(require_permissions(PermissionCode.USER_INVITE)),
+    db: Session = Depends(get_db),
+):
+    user = _get_pending_user(db, user_id)
+    position = db.query(Position).filter(Position.id == payload.position_id).first()
+    if not position:
+        raise HTTPException(status_code=404, detail="Position not found")
+    if position.slug == DEFAULT_POSITION_SLUGS["super_admin"] and not is_super_admin_user(current_user):
+        raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Only Super Admins can assign this position")
+
+    user.position_id = position.id
+    if position.slug in (DEFAULT_POSITION_SLUGS["super_admin"], DEFAULT_POSITION_SLUGS["admin"]):
+        user.role = UserRole.ADMIN
+    user.approval_status = UserApprovalStatus.APPROVED
+    user.approval_note = payload.note
+    user.approval_decided_by = current_user.id
+    user.approval_decided_at = utc_now()
+    record_audit(
+        db,
+        current_user,
+        "signup.approve",
+        "user",
+        user.id,
+        {"position_id": position.id, "position_slug": position.slug, "note": payload.note},
+    )
+    db.commit()
+    db.refresh(user)
+    _notify_decision(user, approved=True, reason=None)
+    return UserResponse.model_validate(_annotate_user(user))
+
+
+@router.post("/admin/signups/{user_id}/reject", response_model=UserResponse)
+def reject_signup(
+    user_id: str,
+    payload: SignupRejectionRequest,
+    current_user: User = Depends(require_permissions(PermissionCode.USER_INVITE)),
+    db: Session = Depends(get_db),
+):
+    user = _get_pending_user(db, user_id)
+    user.approval_status = UserApprovalStatus.REJECTED
+    user.approval_note = payload.reason
+    user.approval_decided_by = current_user.id
+    user.approval_decided_at = utc_now()
+    user.can_receive_new_chats = False
+    record_audit(db, current_user, "signup.reject", "user", user.id, {"reason": payload.reason})
+    db.commit()
+    db.refresh(user)
+    _notify_decision(user, approved=False, reason=payload.reason)
+    return UserResponse.model_validate(_annotate_user(user))
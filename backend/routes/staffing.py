@@ -0,0 +1,390 @@
+import json
+import logging
+import math
+from collections import defaultdict
+from datetime import date, datetime, time, timedelta, timezone
+from typing import Dict, Iterable, List, Optional, Sequence, Tuple
+
+from fastapi import APIRouter, Depends, HTTPException, Query, status
+from sqlalchemy.orm import Session, joinedload
+
+from database import get_db
+from models import AgentShift, Chat, FacebookMessage, InstagramMessage, MessageSender, User
+from permissions import PermissionCode
+from routes.dependencies import require_permissions
+from schemas import (
+    AgentShiftCreate,
+    AgentShiftResponse,
+    StaffingForecastInterval,
+    StaffingForecastResponse,
+)
+from settings import (
+    STAFFING_CHAT_CONCURRENCY,
+    STAFFING_DEFAULT_HANDLE_SECONDS,
+    STAFFING_HISTORY_WEEKS,
+    STAFFING_HOLIDAY_DEFAULT_FACTOR,
+    STAFFING_HOLIDAYS,
+    STAFFING_MAX_HANDLE_MINUTES,
+    STAFFING_MAX_OCCUPANCY,
+    STAFFING_SESSION_GAP_MINUTES,
+    STAFFING_TARGET_FIRST_RESPONSE_SECONDS,
+    STAFFING_TARGET_SERVICE_LEVEL,
+    STAFFING_WEEK_DECAY,
+)
+from utils.audit import record_audit
+from utils.erlang import average_speed_of_answer, required_agents, service_level
+from utils.timezone import IST, as_utc, utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+INTERVAL_SECONDS = 3600
+CUSTOMER_SENDERS = {MessageSender.INSTAGRAM_USER, MessageSender.FACEBOOK_USER}
+
+# (weekday, hour) in IST -> expected count for that hour
+Profile = Dict[Tuple[int, int], float]
+
+
+def load_holidays(raw: Optional[str] = None) -> Dict[date, float]:
+    """Parse STAFFING_HOLIDAYS into {date: volume factor}."""
+    raw = STAFFING_HOLIDAYS if raw is None else raw
+    if not raw:
+        return {}
+    try:
+        parsed = json.loads(raw)
+    except (TypeError, ValueError):
+        logger.warning("STAFFING_HOLIDAYS is not valid JSON; ignoring holidays")
+        return {}
+    items = parsed.items() if isinstance(parsed, dict) else ((value, None) for value in parsed or [])
+    holidays: Dict[date, float] = {}
+    for key, factor in items:
+        try:
+            day = date.fromisoformat(str(key))
+            holidays[day] = STAFFING_HOLIDAY_DEFAULT_FACTOR if factor is None else max(0.0, float(factor))
+        except (TypeError, ValueError):
+            logger.warning("Ignoring invalid staffing holiday entry %r", key)
+    return holidays
+
+
+def split_contacts(
+    events: Iterable[Tuple[str, datetime, bool]],
+    gap_seconds: int,
+    max_handle_seconds: int,
+) -> List[Dict[str, Optional[float]]]:
+    """
+    Group messages into contacts that each need a first response.
+
+    events are (chat_id, timestamp, from_customer) tuples. A contact starts with a
+    customer message after gap_seconds of silence in the chat (or with the chat's
+    first message). first_response is the wait until the first agent message;
+    handle is the time from start until the last agent message of the contact.
+    """
+    by_chat: Dict[str, List[Tuple[datetime, bool]]] = defaultdict(list)
+    for chat_id, ts, from_customer in events:
+        if ts is not None:
+            by_chat[chat_id].append((as_utc(ts), from_customer))
+
+    contacts: List[Dict[str, Optional[float]]] = []
+    for messages in by_chat.values():
+        messages.sort(key=lambda item: item[0])
+        current: Optional[Dict] = None
+        previous_ts: Optional[datetime] = None
+        for ts, from_customer in messages:
+            gap = (ts - previous_ts).total_seconds() if previous_ts else None
+            previous_ts = ts
+            if from_customer and (current is None or gap is None or gap > gap_seconds):
+                current = {"start": ts, "first_response": None, "last_agent": None}
+                contacts.append(current)
+                continue
+            if current is None or from_customer:
+                continue
+            if current["first_response"] is None:
+                current["first_response"] = ts
+            current["last_agent"] = ts
+
+    result: List[Dict[str, Optional[float]]] = []
+    for contact in contacts:
+        start = contact["start"]
+        first = contact["first_response"]
+        last = contact["last_agent"]
+        result.append({
+            "start": start,
+            "first_response_seconds": (first - start).total_seconds() if first else None,
+            "handle_seconds": min(max_handle_seconds, (last - start).total_seconds()) if last else None,
+        })
+    return result
+
+
+def seasonal_profile(
+    timestamps: Iterable[datetime],
+    history_start: date,
+    history_end: date,
+    holidays: Optional[Dict[date, float]] = None,
+    decay: float = STAFFING_WEEK_DECAY,
+) -> Profile:
+    """
+    Weighted average count per (weekday, hour) over [history_start, history_end).
+
+    Dates are IST. Holidays are left out of the baseline so they do not drag
+    down the regular weekday shape; each week back weighs `decay` times less.
+    """
+    holidays = holidays or {}
+    counts: Dict[Tuple[date, int], int] = defaultdict(int)
+    for ts in timestamps:
+        local = as_utc(ts).astimezone(IST)
+        counts[(local.date(), local.hour)] += 1
+
+    totals: Dict[Tuple[int, int], float] = defaultdict(float)
+    weights: Dict[Tuple[int, int], float] = defaultdict(float)
+    day = history_start
+    while day < history_end:
+        if day not in holidays:
+            weight = decay ** ((history_end - day).days // 7)
+            for hour in range(24):
+                key = (day.weekday(), hour)
+                totals[key] += weight * counts.get((day, hour), 0)
+                weights[key] += weight
+        day += timedelta(days=1)
+    return {key: totals[key] / weights[key] for key in weights if weights[key] > 0}
+
+
+def project_hourly(
+    profile: Profile,
+    start: datetime,
+    hours: int,
+    holidays: Optional[Dict[date, float]] = None,
+) -> List[Tuple[datetime, float, Optional[float]]]:
+    """Expected volume per hour from start (IST hour boundary); returns (interval_start, volume, holiday_factor)."""
+    holidays = holidays or {}
+    projected: List[Tuple[datetime, float, Optional[float]]] = []
+    for offset in range(hours):
+        interval_start = start + timedelta(hours=offset)
+        local = interval_start.astimezone(IST)
+        factor = holidays.get(local.date())
+        volume = profile.get((local.weekday(), local.hour), 0.0)
+        if factor is not None:
+            volume *= factor
+        projected.append((interval_start, volume, factor))
+    return projected
+
+
+def scheduled_agents(shifts: Sequence[Tuple[datetime, datetime]], interval_start: datetime, interval_seconds: int) -> float:
+    """Agent count for the interval, counting partial shifts by their overlap."""
+    interval_end = interval_start + timedelta(seconds=interval_seconds)
+    covered = 0.0
+    for starts_at, ends_at in shifts:
+        overlap = (min(as_utc(ends_at), interval_end) - max(as_utc(starts_at), interval_start)).total_seconds()
+        if overlap > 0:
+            covered += overlap / interval_seconds
+    return covered
+
+
+def _message_events(db: Session, since: datetime, until: datetime) -> List[Tuple[str, datetime, bool]]:
+    events: List[Tuple[str, datetime, bool]] = []
+    for model in (InstagramMessage, FacebookMessage):
+        rows = (
+            db.query(model.chat_id, model.timestamp, model.sender)
+            .filter(model.timestamp >= since, model.timestamp < until)
+            .all()
+        )
+        events.extend((chat_id, ts, sender in CUSTOMER_SENDERS) for chat_id, ts, sender in rows)
+    return events
+
+
+def _mean(values: Sequence[float]) -> Optional[float]:
+    return sum(values) / len(values) if values else None
+
+
+def build_forecast(
+    db: Session,
+    start: datetime,
+    hours: int,
+    target_seconds: int,
+    target_service_level: float,
+    history_weeks: int,
+) -> StaffingForecastResponse:
+    holidays = load_holidays()
+    history_end_local = start.astimezone(IST).date()
+    history_start_local = history_end_local - timedelta(weeks=history_weeks)
+    history_since = datetime.combine(history_start_local, time.min, tzinfo=IST).astimezone(timezone.utc)
+    history_until = datetime.combine(history_end_local, time.min, tzinfo=IST).astimezone(timezone.utc)
+
+    events = _message_events(db, history_since, history_until)
+    contacts = split_contacts(events, STAFFING_SESSION_GAP_MINUTES * 60, STAFFING_MAX_HANDLE_MINUTES * 60)
+    new_chat_times = [
+        row[0]
+        for row in db.query(Chat.created_at)
+        .filter(Chat.created_at >= history_since, Chat.created_at < history_until)
+        .all()
+    ]
+
+    def _profile(timestamps: Iterable[datetime]) -> Profile:
+        return seasonal_profile(timestamps, history_start_local, history_end_local, holidays)
+
+    contact_profile = _profile(contact["start"] for contact in contacts)
+    message_profile = _profile(ts for _, ts, from_customer in events if from_customer)
+    new_chat_profile = _profile(new_chat_times)
+
+    handle_samples = [c["handle_seconds"] for c in contacts if c["handle_seconds"]]
+    handle_seconds = _mean(handle_samples) or float(STAFFING_DEFAULT_HANDLE_SECONDS)
+    effective_handle = handle_seconds / max(1.0, STAFFING_CHAT_CONCURRENCY)
+    answered = [c["first_response_seconds"] for c in contacts if c["first_response_seconds"] is not None]
+    historical_sl = (
+        sum(1 for value in answered if value <= target_seconds) / len(answered) if answered else None
+    )
+
+    end = start + timedelta(hours=hours)
+    shifts = (
+        db.query(AgentShift.starts_at, AgentShift.ends_at)
+        .filter(AgentShift.starts_at < end, AgentShift.ends_at > start)
+        .all()
+    )
+    shift_windows = [(row[0], row[1]) for row in shifts]
+
+    intervals: List[StaffingForecastInterval] = []
+    projections = zip(
+        project_hourly(contact_profile, start, hours, holidays),
+        project_hourly(message_profile, start, hours, holidays),
+        project_hourly(new_chat_profile, start, hours, holidays),
+    )
+    for (interval_start, contacts_expected, factor), (_, messages_expected, _), (_, chats_expected, _) in projections:
+        recommended = required_agents(
+            contacts_expected,
+            effective_handle,
+            INTERVAL_SECONDS,
+            target_seconds,
+            target_service_level,
+            max_occupancy=STAFFING_MAX_OCCUPANCY,
+        )
+        scheduled = scheduled_agents(shift_windows, interval_start, INTERVAL_SECONDS)
+        traffic = contacts_expected * effective_handle / INTERVAL_SECONDS
+        scheduled_whole = int(math.floor(scheduled + 1e-9))
+        scheduled_sl = service_level(traffic, scheduled_whole, effective_handle, target_seconds)
+        asa = average_speed_of_answer(traffic, recommended, effective_handle) if recommended else 0.0
+        intervals.append(StaffingForecastInterval(
+            start=interval_start,
+            end=interval_start + timedelta(seconds=INTERVAL_SECONDS),
+            expected_contacts=round(contacts_expected, 2),
+            expected_inbound_messages=round(messages_expected, 2),
+            expected_new_chats=round(chats_expected, 2),
+            holiday_factor=factor,
+            workload_erlangs=round(traffic, 3),
+            recommended_agents=recommended,
+            expected_answer_seconds=None if math.isinf(asa) else round(asa, 1),
+            scheduled_agents=round(scheduled, 2),
+            staffing_gap=round(scheduled - recommended, 2),
+            scheduled_service_level=round(scheduled_sl, 3),
+        ))
+
+    return StaffingForecastResponse(
+        generated_at=utc_now(),
+        start=start,
+        end=end,
+        interval_minutes=INTERVAL_SECONDS // 60,
+        history_weeks=history_weeks,
+        target_first_response_seconds=target_seconds,
+        target_service_level=target_service_level,
+        average_handle_seconds=round(handle_seconds, 1),
+        chat_concurrency=STAFFING_CHAT_CONCURRENCY,
+        historical_contacts=len(contacts),
+        historical_service_level=None if historical_sl is None else round(historical_sl, 3),
+        understaffed_intervals=sum(1 for interval in intervals if interval.staffing_gap < 0),
+        total_recommended_agent_hours=sum(interval.recommended_agents for interval in intervals),
+        total_scheduled_agent_hours=round(sum(interval.scheduled_agents for interval in intervals), 2),
+        intervals=intervals,
+    )
+
+
+@router.get("/staffing/forecast", response_model=StaffingForecastResponse)
+def get_staffing_forecast(
+    start_date: Optional[date] = Query(None, description="First day (IST) to forecast; defaults to today"),
+    days: int = Query(14, ge=1, le=42),
+    target_seconds: int = Query(STAFFING_TARGET_FIRST_RESPONSE_SECONDS, ge=10, le=86400),
+    service_level_target: float = Query(STAFFING_TARGET_SERVICE_LEVEL, gt=0, lt=1, alias="service_level"),
+    history_weeks: int = Query(STAFFING_HISTORY_WEEKS, ge=1, le=52),
+    current_user: User = Depends(require_permissions(PermissionCode.STATS_VIEW)),
+    db: Session = Depends(get_db),
+):
+    first_day = start_date or utc_now().astimezone(IST).date()
+    start = datetime.combine(first_day, time.min, tzinfo=IST).astimezone(timezone.utc)
+    return build_forecast(db, start, days * 24, target_seconds, service_level_target, history_weeks)
+
+
+@router.get("/staffing/shifts", response_model=List[AgentShiftResponse])
+def list_shifts(
+    start: Optional[datetime] = None,
+    end: Optional[datetime] = None,
+    user_id: Optional[str] = None,
+    current_user: User = Depends(require_permissions(PermissionCode.STATS_VIEW)),
+    db: Session = Depends(get_db),
+):
+    query = db.query(AgentShift).options(joinedload(AgentShift.user))
+    if start:
+        query = query.filter(AgentShift.ends_at > as_utc(start))
+    if end:
+        query = query.filter(AgentShift.starts_at < as_utc(end))
+    if user_id:
+        query = query.filter(AgentShift.user_id == user_id)
+    return query.order_by(AgentShift.starts_at.asc()).limit(2000).all()
+
+
+@router.post("/staffing/shifts", response_model=AgentShiftResponse, status_code=status.HTTP_201_CREATED)
+def create_shift(
+    payload: AgentShiftCreate,
+    current_user: User = Depends(require_permissions(PermissionCode.STATS_VIEW, PermissionCode.CHAT_ASSIGN)),
+    db: Session = Depends(get_db),
+):
+    starts_at = as_utc(payload.starts_at)
+    ends_at = as_utc(payload.ends_at)
+    if ends_at <= starts_at:
+        raise HTTPException(status_code=400, detail="Shift must end after it starts")
+    if ends_at - starts_at > timedelta(hours=24):
+        raise HTTPException(status_code=400, detail="Shifts cannot be longer than 24 hours")
+    agent = db.query(User).filter(User.id == payload.user_id).first()
+    if not agent:
+        raise HTTPException(status_code=404, detail="User not found")
+    overlapping = (
+        db.query(AgentShift.id)
+        .filter(AgentShift.user_id == agent.id, AgentShift.starts_at < ends_at, AgentShift.ends_at > starts_at)
+        .first()
+    )
+    if overlapping:
+        raise HTTPException(status_code=409, detail="Shift overlaps an existing shift for this user")
+
+    shift = AgentShift(
+        user_id=agent.id,
+        starts_at=starts_at,
+        ends_at=ends_at,
+        note=(payload.note or "").strip() or None,
+        created_by=current_user.id,
+    )
+    db.add(shift)
+    db.flush()
+    record_audit(db, current_user, "staffing.shift_create", "agent_shift", shift.id, {
+        "user_id": agent.id,
+        "starts_at": starts_at.isoformat(),
+        "ends_at": ends_at.isoformat(),
+    })
+    db.commit()
+    db.refresh(shift)
+    return shift
+
+
+@router.delete("/staffing/shifts/{shift_id}")
+def delete_shift(
+    shift_id: str,
+    current_user: User = Depends(require_permissions(PermissionCode.STATS_VIEW, PermissionCode.CHAT_ASSIGN)),
+    db: Session = Depends(get_db),
+):
+    shift = db.query(AgentShift).filter(AgentShift.id == shift_id).first()
+    if not shift:
+        raise HTTPException(status_code=404, detail="Shift not found")
+    record_audit(db, current_user, "staffing.shift_delete", "agent_shift", shift.id, {
+        "user_id": shift.user_id,
+        "starts_at": as_utc(shift.starts_at).isoformat(),
+        "ends_at": as_utc(shift.ends_at).isoformat(),
+    })
+    db.delete(shift)
+    db.commit()
+    return {"success": True}
@@ -0,0 +1,306 @@
+import logging
+from datetime import datetime, timedelta
+from typing import Any, Dict, List, Optional, Type
+
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import (
+    Chat,
+    ChatQueueNotice,
+    FacebookPage,
+    InstagramAccount,
+    MessagePlatform,
+    MessageTemplate,
+    PaymentRequest,
+    Position,
+    SoftDeleteMixin,
+    User,
+    UserRole,
+)
+from permissions import PermissionCode, user_has_permissions
+from routes.dependencies import get_current_user
+from schemas import TrashItemResponse, TrashSummaryResponse
+from settings import TRASH_RETENTION_DAYS
+from utils.audit import record_audit
+from utils.timezone import as_utc, utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+# entity_type (URL segment) -> model, audit entity name and who may see/restore/purge it.
+# Access mirrors the matching delete endpoint.
+TRASH_TYPES: Dict[str, Dict[str, Any]] = {
+    "templates": {"model": MessageTemplate, "entity": "message_template", "admin_only": True},
+    "positions": {"model": Position, "entity": "position", "permission": PermissionCode.POSITION_MANAGE},
+    "facebook-pages": {"model": FacebookPage, "entity": "facebook_page", "admin_only": True},
+    "instagram-accounts": {"model": InstagramAccount, "entity": "instagram_account", "admin_only": True},
+    "chats": {"model": Chat, "entity": "chat", "admin_only": True},
+}
+
+
+def include_deleted(query):
+    """Let a query see trashed rows (they are hidden from ORM queries by default)."""
+    return query.execution_options(include_deleted=True)
+
+
+def soft_delete(db: Session, obj: SoftDeleteMixin, actor: Optional[User], entity_type: str, details: Optional[Dict[str, Any]] = None) -> None:
+    """Move a row to the trash and stage an audit entry; the caller commits."""
+    obj.deleted_at = utc_now()
+    obj.deleted_by = actor.id if actor else None
+    record_audit(db, actor, f"{entity_type}.delete", entity_type, obj.id, details)
+
+
+def purge_after(deleted_at: Optional[datetime]) -> Optional[datetime]:
+    deleted_at = as_utc(deleted_at)
+    return deleted_at + timedelta(days=TRASH_RETENTION_DAYS) if deleted_at else None
+
+
+def can_access_trash(user: User, entity_type: str) -> bool:
+    config = TRASH_TYPES[entity_type]
+    if config.get("admin_only"):
+        return user.role == UserRole.ADMIN
+    return user_has_permissions(user, [config["permission"].value])
+
+
+def _config_or_404(entity_type: str, user: User) -> Dict[str, Any]:
+    config = TRASH_TYPES.get(entity_type)
+    if not config:
+        raise HTTPException(status_code=404, detail="Unknown trash type")
+    if not can_access_trash(user, entity_type):
+        raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Insufficient permissions")
+    return config
+
+
+def _label(obj: Any) -> str:
+    if isinstance(obj, MessageTemplate):
+        return f"{obj.name} ({obj.platform.value.lower()})"
+    if isinstance(obj, Position):
+        return obj.name
+    if isinstance(obj, FacebookPage):
+        return obj.page_name or obj.page_id
+    if isinstance(obj, InstagramAccount):
+        return f"@{obj.username}" if obj.username else obj.page_id
+    if isinstance(obj, Chat):
+        return f"{obj.username} ({obj.platform.value.lower()})"
+    return str(obj.id)
+
+
+def _details(obj: Any) -> Dict[str, Any]:
+    if isinstance(obj, MessageTemplate):
+        return {"category": obj.category, "is_meta_approved": obj.is_meta_approved, "meta_template_id": obj.meta_template_id}
+    if isinstance(obj, Position):
+        return {"slug": obj.slug}
+    if isinstance(obj, FacebookPage):
+        return {"page_id": obj.page_id, "is_active": obj.is_active}
+    if isinstance(obj, InstagramAccount):
+        return {"page_id": obj.page_id}
+    if isinstance(obj, Chat):
+        return {"facebook_page_id": obj.facebook_page_id, "assigned_to": obj.assigned_to, "last_message": obj.last_message}
+    return {}
+
+
+def _to_item(entity_type: str, obj: Any, deleters: Dict[str, User]) -> TrashItemResponse:
+    deleter = deleters.get(obj.deleted_by) if obj.deleted_by else None
+    return TrashItemResponse(
+        id=obj.id,
+        entity_type=entity_type,
+        label=_label(obj),
+        deleted_at=obj.deleted_at,
+        deleted_by=obj.deleted_by,
+        deleted_by_name=deleter.name if deleter else None,
+        purge_after=purge_after(obj.deleted_at),
+        details=_details(obj),
+    )
+
+
+def _get_trashed(db: Session, model: Type[Any], item_id: str) -> Any:
+    obj = include_deleted(db.query(model)).filter(model.id == item_id, model.deleted_at.isnot(None)).first()
+    if not obj:
+        raise HTTPException(status_code=404, detail="Item not found in trash")
+    return obj
+
+
+def _restore_conflict(db: Session, obj: Any) -> Optional[str]:
+    """Reason the row cannot come back (an active duplicate took its place), if any."""
+    if isinstance(obj, Chat):
+        query = db.query(Chat.id).filter(Chat.platform == obj.platform, Chat.id != obj.id)
+        if obj.instagram_user_id:
+            query = query.filter(Chat.instagram_user_id == obj.instagram_user_id)
+        elif obj.facebook_user_id:
+            query = query.filter(Chat.facebook_user_id == obj.facebook_user_id)
+        else:
+            return None
+        if obj.facebook_page_id:
+            query = query.filter(Chat.facebook_page_id == obj.facebook_page_id)
+        if query.first():
+            return "The customer has started a new chat since this one was deleted"
+    if isinstance(obj, InstagramAccount):
+        if db.query(InstagramAccount.id).filter(InstagramAccount.page_id == obj.page_id).first():
+            return "This Instagram account has been connected again"
+    return None
+
+
+def reattach_trashed_chat(
+    db: Session,
+    platform: MessagePlatform,
+    account_id: Optional[str],
+    instagram_user_id: Optional[str] = None,
+    facebook_user_id: Optional[str] = None,
+) -> Optional[Chat]:
+    """
+    Bring back the customer's trashed chat when the conversation with them resumes.
+
+    Without this a new chat would take its place and the trashed one could
+    never be restored. The caller commits.
+    """
+    query = include_deleted(db.query(Chat)).filter(Chat.platform == platform, Chat.deleted_at.isnot(None))
+    if instagram_user_id:
+        query = query.filter(Chat.instagram_user_id == instagram_user_id)
+    elif facebook_user_id:
+        query = query.filter(Chat.facebook_user_id == facebook_user_id)
+    else:
+        return None
+    if account_id:
+        query = query.filter(Chat.facebook_page_id == account_id)
+    chat = query.order_by(Chat.deleted_at.desc()).first()
+    if not chat:
+        return None
+    chat.deleted_at = None
+    chat.deleted_by = None
+    record_audit(db, None, "chat.restore", "chat", chat.id, {"label": _label(chat), "reason": "conversation resumed"})
+    logger.info("Restored trashed chat %s because the conversation resumed", chat.id)
+    return chat
+
+
+def _purge_blocker(db: Session, obj: Any) -> Optional[str]:
+    if isinstance(obj, Chat):
+        if db.query(PaymentRequest.id).filter(PaymentRequest.chat_id == obj.id).first():
+            return "chat has payment requests"
+    if isinstance(obj, Position):
+        if include_deleted(db.query(User.id)).filter(User.position_id == obj.id).first():
+            return "position is assigned to users"
+    return None
+
+
+def purge_item(db: Session, entity_type: str, obj: Any, actor: Optional[User] = None) -> Optional[str]:
+    """Permanently delete a trashed row. Returns a reason when it has to be kept."""
+    blocker = _purge_blocker(db, obj)
+    if blocker:
+        return blocker
+    if isinstance(obj, Chat):
+        db.query(ChatQueueNotice).filter(ChatQueueNotice.chat_id == obj.id).delete(synchronize_session=False)
+    config = TRASH_TYPES[entity_type]
+    record_audit(db, actor, f"{config['entity']}.purge", config["entity"], obj.id, {"label": _label(obj)})
+    db.delete(obj)
+    db.commit()
+    return None
+
+
+def purge_expired_trash(db: Session, now: Optional[datetime] = None) -> int:
+    """Permanently delete everything that has been in the trash longer than TRASH_RETENTION_DAYS."""
+    cutoff = (now or utc_now()) - timedelta(days=TRASH_RETENTION_DAYS)
+    purged = 0
+    for entity_type, config in TRASH_TYPES.items():
+        model = config["model"]
+        expired = (
+            include_deleted(db.query(model))
+            .filter(model.deleted_at.isnot(None), model.deleted_at < cutoff)
+            .limit(500)
+            .all()
+        )
+        for obj in expired:
+            try:
+                blocker = purge_item(db, entity_type, obj)
+            except Exception as exc:
+                db.rollback()
+                logger.warning("Failed to purge %s %s: %s", entity_type, obj.id, exc)
+                continue
+            if blocker:
+                logger.info("Keeping trashed %s %s: %s", entity_type, obj.id, blocker)
+            else:
+                purged += 1
+    return purged
+
+
+@router.get("/trash", response_model=List[TrashSummaryResponse])
+def trash_summary(
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    summary: List[TrashSummaryResponse] = []
+    for entity_type, config in TRASH_TYPES.items():
+        if not can_access_trash(current_user, entity_type):
+            continue
+        model = config["model"]
+        count = include_deleted(db.query(model.id)).filter(model.deleted_at.isnot(None)).count()
+        summary.append(TrashSummaryResponse(entity_type=entity_type, count=count, retention_days=TRASH_RETENTION_DAYS))
+    if not summary:
+        raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Insufficient permissions")
+    return summary
+
+
+@router.get("/trash/{entity_type}", response_model=List[TrashItemResponse])
+def list_trash(
+    entity_type: str,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    config = _config_or_404(entity_type, current_user)
+    model = config["model"]
+    rows = (
+        include_deleted(db.query(model))
+        .filter(model.deleted_at.isnot(None))
+        .order_by(model.deleted_at.desc())
+        .limit(500)
+        .all()
+    )
+    deleter_ids = {row.deleted_by for row in rows if row.deleted_by}
+    deleters = {
+        user.id: user
+        for user in (include_deleted(db.query(User)).filter(User.id.in_(deleter_ids)).all() if deleter_ids else [])
+    }
+    return [_to_item(entity_type, row, deleters) for row in rows]
+
+
+@router.post("/trash/{entity_type}/{item_id}/restore", response_model=TrashItemResponse)
+def restore_trash_item(
+    entity_type: str,
+    item_id: str,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    config = _config_or_404(entity_type, current_user)
+    obj = _get_trashed(db, config["model"], item_id)
+    expires_at = purge_after(obj.deleted_at)
+    if expires_at and expires_at <= utc_now():
+        raise HTTPException(status_code=status.HTTP_410_GONE, detail="The retention period has passed; this item will be purged")
+    conflict = _restore_conflict(db, obj)
+    if conflict:
+        raise HTTPException(status_code=status.HTTP_409_CONFLICT, detail=conflict)
+
+    obj.deleted_at = None
+    obj.deleted_by = None
+    record_audit(db, current_user, f"{config['entity']}.restore", config["entity"], obj.id, {"label": _label(obj)})
+    db.commit()
+    db.refresh(obj)
+    logger.info("Restored %s %s from trash by %s", entity_type, item_id, current_user.email)
+    return _to_item(entity_type, obj, {})
+
+
+@router.delete("/trash/{entity_type}/{item_id}")
+def purge_trash_item(
+    entity_type: str,
+    item_id: str,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
+):
+    config = _config_or_404(entity_type, current_user)
+    obj = _get_trashed(db, config["model"], item_id)
+    blocker = purge_item(db, entity_type, obj, current_user)
+    if blocker:
+        raise HTTPException(status_code=status.HTTP_409_CONFLICT, detail=f"Cannot purge: {blocker}")
+    logger.info("Purged %s %s from trash by %s", entity_type, item_id, current_user.email)
+    return {"success": True, "entity_type": entity_type, "id": item_id}
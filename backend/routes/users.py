@@ -43,6 +43,7 @@ from schemas import (
 )
 from utils.timezone import utc_now
 from routes.chat_helpers import reassign_chats_from_inactive_agents
+from routes.trash import include_deleted, soft_delete
 
 router = APIRouter()
 
@@ -321,7 +322,9 @@ def create_position(
     slug = _normalize_slug(position_data.slug or position_data.name)
     if slug == DEFAULT_POSITION_SLUGS["super_admin"] and not is_super_admin_user(current_user):
         raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Only Super Admins can create this position")
-    existing = db.query(Position).filter(Position.slug == slug).first()
+    existing = include_deleted(db.query(Position)).filter(Position.slug == slug).first()
+    if existing and existing.is_deleted:
+        raise HTTPException(status_code=400, detail="A position with this slug is in the trash; restore it instead")
     if existing:
         raise HTTPException(status_code=400, detail="Position slug already exists")
     position = Position(
@@ -329,6 +332,7 @@ def create_position(
         slug=slug,
         description=position_data.description,
         is_system=position_data.is_system,
+        passwordless_login_enabled=position_data.passwordless_login_enabled,
     )
     position.permissions = validate_permissions_payload(position_data.permissions)
     db.add(position)
@@ -355,6 +359,8 @@ def update_position(
         position.description = position_data.description
     if position_data.permissions is not None:
         position.permissions = validate_permissions_payload(position_data.permissions)
+    if position_data.passwordless_login_enabled is not None:
+        position.passwordless_login_enabled = position_data.passwordless_login_enabled
     db.commit()
     db.refresh(position)
     return position
@@ -377,7 +383,7 @@ def delete_position(
             status_code=400,
             detail="Cannot delete a position that is currently assigned to users"
         )
-    db.delete(position)
+    soft_delete(db, position, current_user, "position", {"slug": position.slug, "name": position.name})
     db.commit()
     return {"success": True, "position_id": position_id}
 
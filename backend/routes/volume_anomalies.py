@@ -0,0 +1,516 @@
+import json
+import logging
+import math
+from collections import Counter
+from datetime import datetime, timedelta
+from typing import Any, Dict, List, Optional, Sequence, Tuple
+
+from fastapi import APIRouter, Depends, HTTPException, Query
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import (
+    Chat,
+    FacebookPage,
+    InstagramAccount,
+    InstagramComment,
+    MessagePlatform,
+    MessageSender,
+    User,
+    VolumeAnomalyEvent,
+    VolumeAnomalySetting,
+    WebhookReconciliationRun,
+)
+from routes.chat_helpers import _message_model_for_platform
+from routes.dependencies import get_admin_user
+from schemas import (
+    VolumeAnomalyEventResponse,
+    VolumeAnomalySettingResponse,
+    VolumeAnomalySettingUpdate,
+    VolumeAnomalySnoozeRequest,
+)
+from settings import (
+    VOLUME_ANOMALY_ALERT_COOLDOWN_MINUTES,
+    VOLUME_ANOMALY_BASELINE_DAYS,
+    VOLUME_ANOMALY_MIN_DROP_EXPECTED,
+    VOLUME_ANOMALY_MIN_SPIKE_COUNT,
+    VOLUME_ANOMALY_SENSITIVITY,
+    VOLUME_ANOMALY_WINDOW_MINUTES,
+)
+from utils.alerts import send_admin_alert
+from utils.audit import record_audit
+from utils.timezone import as_utc, utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+KIND_SPIKE = "spike"
+KIND_DROP = "drop"
+SENSITIVITY_THRESHOLDS = {"low": 4.0, "medium": 3.0, "high": 2.0}
+INBOUND_SENDERS = (MessageSender.INSTAGRAM_USER, MessageSender.FACEBOOK_USER)
+TOP_CONTEXT_ITEMS = 5
+
+
+def scope_key(platform: MessagePlatform, account_id: Optional[str] = None) -> str:
+    base = platform.value.lower()
+    return f"{base}:{account_id}" if account_id else base
+
+
+def parse_scope(scope: str) -> Tuple[MessagePlatform, Optional[str]]:
+    platform_part, _, account_id = (scope or "").strip().partition(":")
+    try:
+        platform = MessagePlatform(platform_part.upper())
+    except ValueError:
+        raise ValueError(f"Unknown platform in scope '{scope}'")
+    return platform, (account_id.strip() or None)
+
+
+def sensitivity_threshold(sensitivity: Optional[str]) -> float:
+    """Z-score threshold for a sensitivity preset (low/medium/high) or an explicit number."""
+    value = (sensitivity or VOLUME_ANOMALY_SENSITIVITY or "medium").strip().lower()
+    if value in SENSITIVITY_THRESHOLDS:
+        return SENSITIVITY_THRESHOLDS[value]
+    try:
+        return max(0.5, float(value))
+    except ValueError:
+        return SENSITIVITY_THRESHOLDS["medium"]
+
+
+def score_window(observed: int, baseline: Sequence[int]) -> Tuple[float, float]:
+    """
+    Expected count and z-score of the observed window against baseline windows.
+
+    The spread never drops below the Poisson noise of the mean (or 1), so quiet
+    pages with a flat history do not alert on a couple of extra messages.
+    """
+    if not baseline:
+        return 0.0, 0.0
+    expected = sum(baseline) / float(len(baseline))
+    variance = sum((count - expected) ** 2 for count in baseline) / float(len(baseline))
+    sigma = max(1.0, math.sqrt(max(variance, expected)))
+    return expected, (observed - expected) / sigma
+
+
+def classify_anomaly(
+    observed: int,
+    expected: float,
+    score: float,
+    threshold: float,
+    spikes_enabled: bool = True,
+    drops_enabled: bool = True,
+    min_spike_count: int = VOLUME_ANOMALY_MIN_SPIKE_COUNT,
+    min_drop_expected: float = VOLUME_ANOMALY_MIN_DROP_EXPECTED,
+) -> Optional[str]:
+    if spikes_enabled and score >= threshold and observed >= min_spike_count:
+        return KIND_SPIKE
+    if drops_enabled and score <= -threshold and expected >= min_drop_expected:
+        return KIND_DROP
+    return None
+
+
+def _inbound_query(db: Session, platform: MessagePlatform, start: datetime, end: datetime):
+    model = _message_model_for_platform(platform)
+    return (
+        db.query(model)
+        .join(Chat, Chat.id == model.chat_id)
+        .filter(model.sender.in_(INBOUND_SENDERS))
+        .filter(model.is_reconciled.is_(False))
+        .filter(model.timestamp >= start, model.timestamp < end)
+    )
+
+
+def _counts_by_page(db: Session, platform: MessagePlatform, start: datetime, end: datetime) -> Dict[str, int]:
+    model = _message_model_for_platform(platform)
+    rows = (
+        _inbound_query(db, platform, start, end)
+        .with_entities(Chat.facebook_page_id, func.count(model.id))
+        .group_by(Chat.facebook_page_id)
+        .all()
+    )
+    return {str(page_id): int(count) for page_id, count in rows if page_id}
+
+
+def _connected_accounts(db: Session) -> Dict[MessagePlatform, Dict[str, Optional[str]]]:
+    accounts: Dict[MessagePlatform, Dict[str, Optional[str]]] = {
+        MessagePlatform.FACEBOOK: {},
+        MessagePlatform.INSTAGRAM: {},
+    }
+    for page in db.query(FacebookPage).filter(FacebookPage.is_active.is_(True)).all():
+        accounts[MessagePlatform.FACEBOOK][str(page.page_id)] = page.page_name
+    for account in db.query(InstagramAccount).all():
+        accounts[MessagePlatform.INSTAGRAM][str(account.page_id)] = account.username
+    return accounts
+
+
+def _referral_label(referral: Dict[str, Any]) -> Optional[Tuple[str, str]]:
+    ads_context = referral.get("ads_context_data") if isinstance(referral.get("ads_context_data"), dict) else {}
+    if referral.get("ad_id"):
+        title = ads_context.get("ad_title")
+        return f"ad:{referral['ad_id']}", f"Ad {referral['ad_id']}" + (f" ({title})" if title else "")
+    post_id = ads_context.get("post_id")
+    if post_id:
+        return f"post:{post_id}", f"Post {post_id}"
+    if referral.get("ref"):
+        return f"ref:{referral['ref']}", f"Link ref {referral['ref']}"
+    if referral.get("referer_uri"):
+        return f"uri:{referral['referer_uri']}", str(referral["referer_uri"])
+    if referral.get("source"):
+        return f"source:{referral['source']}", f"Source {referral['source']}"
+    return None
+
+
+def _top_referrals(
+    db: Session,
+    platform: MessagePlatform,
+    account_id: Optional[str],
+    start: datetime,
+    end: datetime,
+) -> List[Dict[str, Any]]:
+    """Ads/posts/links that the window's inbound messages came from, most frequent first."""
+    model = _message_model_for_platform(platform)
+    query = _inbound_query(db, platform, start, end).with_entities(model.metadata_json)
+    query = query.filter(model.metadata_json.like('%"referral"%'))
+    if account_id:
+        query = query.filter(Chat.facebook_page_id == account_id)
+    counts: Counter = Counter()
+    labels: Dict[str, str] = {}
+    for (metadata_json,) in query.limit(5000).all():
+        try:
+            metadata = json.loads(metadata_json or "{}")
+        except (TypeError, ValueError):
+            continue
+        referral = metadata.get("referral") if isinstance(metadata, dict) else None
+        label = _referral_label(referral) if isinstance(referral, dict) else None
+        if label:
+            counts[label[0]] += 1
+            labels[label[0]] = label[1]
+    return [{"key": key, "label": labels[key], "messages": count} for key, count in counts.most_common(TOP_CONTEXT_ITEMS)]
+
+
+def _top_commented_media(db: Session, start: datetime, end: datetime) -> List[Dict[str, Any]]:
+    rows = (
+        db.query(InstagramComment.media_id, func.count(InstagramComment.id))
+        .filter(InstagramComment.created_at >= start, InstagramComment.created_at < end)
+        .group_by(InstagramComment.media_id)
+        .order_by(func.count(InstagramComment.id).desc())
+        .limit(TOP_CONTEXT_ITEMS)
+        .all()
+    )
+    return [{"media_id": media_id, "comments": int(count)} for media_id, count in rows]
+
+
+def _drop_context(db: Session, platform: MessagePlatform, account_id: Optional[str]) -> Dict[str, Any]:
+    """Hints for a drop: when the last webhook message arrived and what reconciliation saw."""
+    model = _message_model_for_platform(platform)
+    last_query = (
+        db.query(func.max(model.timestamp))
+        .join(Chat, Chat.id == model.chat_id)
+        .filter(model.sender.in_(INBOUND_SENDERS))
+        .filter(model.is_reconciled.is_(False))
+    )
+    runs_query = db.query(WebhookReconciliationRun).filter(WebhookReconciliationRun.platform == platform)
+    if account_id:
+        last_query = last_query.filter(Chat.facebook_page_id == account_id)
+        runs_query = runs_query.filter(WebhookReconciliationRun.account_id == account_id)
+    last_inbound = as_utc(last_query.scalar())
+    context: Dict[str, Any] = {"last_webhook_message_at": last_inbound.isoformat() if last_inbound else None}
+    latest_run = runs_query.order_by(WebhookReconciliationRun.started_at.desc()).first()
+    if latest_run:
+        context["last_reconciliation"] = {
+            "started_at": as_utc(latest_run.started_at).isoformat(),
+            "missing_found": latest_run.missing_found,
+            "error": latest_run.error,
+        }
+    if platform == MessagePlatform.FACEBOOK and account_id:
+        page = db.query(FacebookPage).filter(FacebookPage.page_id == account_id).first()
+        context["page_connected"] = bool(page and page.is_active)
+    return context
+
+
+def _settings_by_scope(db: Session) -> Dict[str, VolumeAnomalySetting]:
+    return {row.scope: row for row in db.query(VolumeAnomalySetting).all()}
+
+
+def _is_snoozed(setting: Optional[VolumeAnomalySetting], now: datetime) -> bool:
+    return bool(setting and setting.snoozed_until and as_utc(setting.snoozed_until) > now)
+
+
+def resolve_setting(field: str, *settings: Optional[VolumeAnomalySetting], default: Any = None) -> Any:
+    """First non-null `field` across the settings, most specific scope first."""
+    for setting in settings:
+        value = getattr(setting, field, None) if setting else None
+        if value is not None:
+            return value
+    return default
+
+
+async def _evaluate_scope(
+    db: Session,
+    platform: MessagePlatform,
+    account_id: Optional[str],
+    account_label: Optional[str],
+    observed: int,
+    baseline: List[int],
+    window_start: datetime,
+    now: datetime,
+    settings_map: Dict[str, VolumeAnomalySetting],
+) -> Optional[VolumeAnomalyEvent]:
+    scope = scope_key(platform, account_id)
+    setting = settings_map.get(scope)
+    platform_setting = settings_map.get(scope_key(platform))
+    expected, score = score_window(observed, baseline)
+    kind = classify_anomaly(
+        observed,
+        expected,
+        score,
+        sensitivity_threshold(resolve_setting("sensitivity", setting, platform_setting)),
+        spikes_enabled=resolve_setting("spikes_enabled", setting, platform_setting, default=True),
+        drops_enabled=resolve_setting("drops_enabled", setting, platform_setting, default=True),
+    )
+    if not kind:
+        return None
+
+    # An ongoing anomaly is reported once per cooldown.
+    cooldown_start = now - timedelta(minutes=VOLUME_ANOMALY_ALERT_COOLDOWN_MINUTES)
+    recent = (
+        db.query(VolumeAnomalyEvent.id)
+        .filter(VolumeAnomalyEvent.scope == scope, VolumeAnomalyEvent.kind == kind)
+        .filter(VolumeAnomalyEvent.detected_at >= cooldown_start)
+        .first()
+    )
+    if recent:
+        return None
+
+    if kind == KIND_SPIKE:
+        context: Dict[str, Any] = {"top_referrals": _top_referrals(db, platform, account_id, window_start, now)}
+        if platform == MessagePlatform.INSTAGRAM and not account_id:
+            context["top_commented_media"] = _top_commented_media(db, window_start, now)
+    else:
+        context = _drop_context(db, platform, account_id)
+
+    snoozed = _is_snoozed(setting, now) or _is_snoozed(platform_setting, now)
+    event = VolumeAnomalyEvent(
+        scope=scope,
+        platform=platform,
+        account_id=account_id,
+        kind=kind,
+        window_start=window_start,
+        window_end=now,
+        observed=observed,
+        expected=round(expected, 2),
+        score=round(score, 2),
+        context_json=json.dumps(context, default=str),
+        alerted=not snoozed,
+        suppressed_reason="snoozed" if snoozed else None,
+        detected_at=now,
+    )
+    db.add(event)
+    db.commit()
+    db.refresh(event)
+    if snoozed:
+        return event
+
+    target = f"{platform.value.title()} {account_label or account_id}" if account_id else f"all {platform.value.title()} pages"
+    if kind == KIND_SPIKE:
+        title = f"Inbound spike on {target}"
+        message = (
+            f"{observed} customer messages in the last {VOLUME_ANOMALY_WINDOW_MINUTES} minutes, "
+            f"about {expected:.1f} expected."
+        )
+    else:
+        title = f"Inbound drop on {target}"
+        message = (
+            f"Only {observed} customer messages in the last {VOLUME_ANOMALY_WINDOW_MINUTES} minutes, "
+            f"about {expected:.1f} expected. Check the webhook subscription and page access token."
+        )
+    details: Dict[str, Any] = {
+        "scope": scope,
+        "observed": observed,
+        "expected": round(expected, 1),
+        "z_score": round(score, 2),
+    }
+    if context.get("top_referrals"):
+        details["top_referrals"] = [f"{item['label']}: {item['messages']}" for item in context["top_referrals"]]
+    if context.get("top_commented_media"):
+        details["top_commented_media"] = [
+            f"{item['media_id']}: {item['comments']} comments" for item in context["top_commented_media"]
+        ]
+    for key in ("last_webhook_message_at", "page_connected"):
+        if key in context:
+            details[key] = context[key]
+    await send_admin_alert(
+        db,
+        f"volume_{kind}",
+        title,
+        message,
+        details=details,
+        severity="critical" if kind == KIND_DROP else "warning",
+    )
+    return event
+
+
+async def detect_volume_anomalies(db: Session, now: Optional[datetime] = None) -> List[VolumeAnomalyEvent]:
+    """Compare the last window of inbound messages with the same window on previous days, per page and platform."""
+    now = now or utc_now()
+    window = timedelta(minutes=VOLUME_ANOMALY_WINDOW_MINUTES)
+    window_start = now - window
+    settings_map = _settings_by_scope(db)
+    accounts = _connected_accounts(db)
+    events: List[VolumeAnomalyEvent] = []
+
+    for platform in (MessagePlatform.INSTAGRAM, MessagePlatform.FACEBOOK):
+        current = _counts_by_page(db, platform, window_start, now)
+        history = [
+            _counts_by_page(db, platform, window_start - timedelta(days=day), now - timedelta(days=day))
+            for day in range(1, VOLUME_ANOMALY_BASELINE_DAYS + 1)
+        ]
+        page_ids = set(accounts[platform]) | set(current)
+        for counts in history:
+            page_ids |= set(counts)
+
+        scopes: List[Tuple[Optional[str], int, List[int]]] = [
+            (None, sum(current.values()), [sum(counts.values()) for counts in history])
+        ]
+        for page_id in sorted(page_ids):
+            scopes.append((page_id, current.get(page_id, 0), [counts.get(page_id, 0) for counts in history]))
+
+        for account_id, observed, baseline in scopes:
+            try:
+                event = await _evaluate_scope(
+                    db,
+                    platform,
+                    account_id,
+                    accounts[platform].get(account_id) if account_id else None,
+                    observed,
+                    baseline,
+                    window_start,
+                    now,
+                    settings_map,
+                )
+            except Exception as exc:
+                db.rollback()
+                logger.warning("Volume anomaly check failed for %s: %s", scope_key(platform, account_id), exc)
+                continue
+            if event:
+                events.append(event)
+    return events
+
+
+def _scope_or_400(scope: str) -> str:
+    try:
+        platform, account_id = parse_scope(scope)
+    except ValueError as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    return scope_key(platform, account_id)
+
+
+def _get_or_create_setting(db: Session, scope: str) -> VolumeAnomalySetting:
+    setting = db.query(VolumeAnomalySetting).filter(VolumeAnomalySetting.scope == scope).first()
+    if not setting:
+        setting = VolumeAnomalySetting(scope=scope)
+        db.add(setting)
+        db.flush()
+    return setting
+
+
+@router.get("/admin/volume-anomalies", response_model=List[VolumeAnomalyEventResponse])
+def list_volume_anomalies(
+    limit: int = Query(50, ge=1, le=500),
+    scope: Optional[str] = None,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    query = db.query(VolumeAnomalyEvent)
+    if scope:
+        query = query.filter(VolumeAnomalyEvent.scope == _scope_or_400(scope))
+    return query.order_by(VolumeAnomalyEvent.detected_at.desc()).limit(limit).all()
+
+
+@router.post("/admin/volume-anomalies/run", response_model=List[VolumeAnomalyEventResponse])
+async def run_volume_anomaly_detection(
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    return await detect_volume_anomalies(db)
+
+
+@router.get("/admin/volume-anomalies/settings", response_model=List[VolumeAnomalySettingResponse])
+def list_volume_anomaly_settings(
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    return db.query(VolumeAnomalySetting).order_by(VolumeAnomalySetting.scope.asc()).all()
+
+
+@router.put("/admin/volume-anomalies/settings/{scope}", response_model=VolumeAnomalySettingResponse)
+def update_volume_anomaly_setting(
+    scope: str,
+    payload: VolumeAnomalySettingUpdate,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    scope = _scope_or_400(scope)
+    # A null page sensitivity falls back to the platform row, then VOLUME_ANOMALY_SENSITIVITY; the toggles are never nulled.
+    changes = {
+        field: value
+        for field, value in payload.model_dump(exclude_unset=True).items()
+        if value is not None or field == "sensitivity"
+    }
+    if "sensitivity" in changes and changes["sensitivity"]:
+        value = changes["sensitivity"].strip().lower()
+        if value not in SENSITIVITY_THRESHOLDS:
+            try:
+                float(value)
+            except ValueError:
+                raise HTTPException(status_code=400, detail="Sensitivity must be low, medium, high or a z-score")
+        changes["sensitivity"] = value
+    setting = _get_or_create_setting(db, scope)
+    for field, value in changes.items():
+        setattr(setting, field, value)
+    setting.updated_by = current_user.id
+    record_audit(db, current_user, "volume_anomaly.settings_update", "volume_anomaly_setting", scope, changes)
+    db.commit()
+    db.refresh(setting)
+    return setting
+
+
+@router.post("/admin/volume-anomalies/settings/{scope}/snooze", response_model=VolumeAnomalySettingResponse)
+def snooze_volume_anomalies(
+    scope: str,
+    payload: VolumeAnomalySnoozeRequest,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    scope = _scope_or_400(scope)
+    setting = _get_or_create_setting(db, scope)
+    setting.snoozed_until = utc_now() + timedelta(minutes=payload.minutes)
+    setting.snooze_reason = (payload.reason or "").strip() or None
+    setting.updated_by = current_user.id
+    record_audit(db, current_user, "volume_anomaly.snooze", "volume_anomaly_setting", scope, {
+        "minutes": payload.minutes,
+        "reason": setting.snooze_reason,
+    })
+    db.commit()
+    db.refresh(setting)
+    return setting
+
+
+@router.delete("/admin/volume-anomalies/settings/{scope}/snooze", response_model=VolumeAnomalySettingResponse)
+def clear_volume_anomaly_snooze(
+    scope: str,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    scope = _scope_or_400(scope)
+    setting = db.query(VolumeAnomalySetting).filter(VolumeAnomalySetting.scope == scope).first()
+    if not setting:
+        raise HTTPException(status_code=404, detail="No settings for this scope")
+    setting.snoozed_until = None
+    setting.snooze_reason = None
+    setting.updated_by = current_user.id
+    record_audit(db, current_user, "volume_anomaly.unsnooze", "volume_anomaly_setting", scope)
+    db.commit()
+    db.refresh(setting)
+    return setting
@@ -0,0 +1,313 @@
+import logging
+from datetime import datetime, timedelta, timezone
+from typing import Any, Awaitable, Callable, Dict, Iterable, List, Optional
+
+from fastapi import APIRouter, Depends, HTTPException, Query
+from sqlalchemy.orm import Session
+
+from database import get_db
+from facebook_api import facebook_client
+from instagram_api import instagram_client
+from models import (
+    Chat,
+    FacebookPage,
+    InstagramAccount,
+    InstagramMessageLog,
+    MessagePlatform,
+    MessageSender,
+    User,
+    WebhookReconciliationRun,
+)
+from routes.chat_helpers import _message_model_for_platform, find_message_by_mid, normalize_message_mid
+from routes.dependencies import get_admin_user
+from schemas import WebhookReconciliationRunResponse
+from settings import (
+    WEBHOOK_RECONCILE_LOOKBACK_HOURS,
+    WEBHOOK_SILENCE_ALERT_COOLDOWN_HOURS,
+    WEBHOOK_SILENCE_BASELINE_DAYS,
+    WEBHOOK_SILENCE_MIN_EXPECTED,
+    WEBHOOK_SILENCE_WINDOW_HOURS,
+)
+from utils.alerts import send_admin_alert
+from utils.timezone import as_utc, parse_graph_timestamp, utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+# server.py registers its webhook payload processors here so reconciled messages
+# go through exactly the same pipeline as live webhooks.
+PayloadIngestor = Callable[..., Awaitable[Dict[str, Any]]]
+_ingestors: Dict[MessagePlatform, PayloadIngestor] = {}
+
+INBOUND_SENDERS = (MessageSender.INSTAGRAM_USER, MessageSender.FACEBOOK_USER)
+
+
+def register_ingestor(platform: MessagePlatform, ingestor: PayloadIngestor) -> None:
+    _ingestors[platform] = ingestor
+
+
+def hourly_profile(timestamps: Iterable[datetime], baseline_days: int) -> List[float]:
+    """Average number of messages per hour-of-day (UTC) over the baseline period."""
+    counts = [0] * 24
+    for ts in timestamps:
+        if ts is None:
+            continue
+        counts[as_utc(ts).astimezone(timezone.utc).hour] += 1
+    days = max(1, baseline_days)
+    return [count / float(days) for count in counts]
+
+
+def expected_in_window(profile: List[float], start: datetime, end: datetime) -> float:
+    """Expected message count between start and end given an hourly profile."""
+    expected = 0.0
+    cursor = start.astimezone(timezone.utc)
+    end_utc = end.astimezone(timezone.utc)
+    while cursor < end_utc:
+        hour_end = cursor.replace(minute=0, second=0, microsecond=0) + timedelta(hours=1)
+        segment_end = min(hour_end, end_utc)
+        expected += profile[cursor.hour] * (segment_end - cursor).total_seconds() / 3600.0
+        cursor = segment_end
+    return expected
+
+
+def is_unusually_silent(expected: float, observed: int, min_expected: float = WEBHOOK_SILENCE_MIN_EXPECTED) -> bool:
+    """No webhook traffic at all while the baseline predicts a meaningful volume."""
+    return observed == 0 and expected >= min_expected
+
+
+def _graph_attachments_to_webhook(attachments: Optional[Dict[str, Any]]) -> List[Dict[str, Any]]:
+    """Convert conversations API attachments into the webhook attachment shape."""
+    converted: List[Dict[str, Any]] = []
+    for item in (attachments or {}).get("data", []) or []:
+        if not isinstance(item, dict):
+            continue
+        if item.get("image_data"):
+            url = item["image_data"].get("url")
+            att_type = "animated_image" if item["image_data"].get("animated_gif_url") else "image"
+        elif item.get("video_data"):
+            url = item["video_data"].get("url")
+            att_type = "video"
+        elif item.get("file_url"):
+            url = item.get("file_url")
+            att_type = "audio" if str(item.get("mime_type") or "").startswith("audio") else "file"
+        else:
+            continue
+        if url:
+            converted.append({"type": att_type, "payload": {"url": url}})
+    return converted
+
+
+def _message_is_stored(db: Session, platform: MessagePlatform, account_id: str, sender_id: str, mid: str) -> bool:
+    normalized = normalize_message_mid(mid)
+    model = _message_model_for_platform(platform)
+    if db.query(model.id).filter(model.mid == normalized).first():
+        return True
+    if platform == MessagePlatform.INSTAGRAM:
+        if db.query(InstagramMessageLog.id).filter(InstagramMessageLog.message_id == normalized).first():
+            return True
+        chat = db.query(Chat).filter(
+            Chat.platform == platform,
+            Chat.instagram_user_id == sender_id,
+            Chat.facebook_page_id == account_id,
+        ).first()
+    else:
+        chat = db.query(Chat).filter(
+            Chat.platform == platform,
+            Chat.facebook_user_id == sender_id,
+            Chat.facebook_page_id == account_id,
+        ).first()
+    return bool(chat and find_message_by_mid(db, chat, mid))
+
+
+def _inbound_timestamps(db: Session, platform: MessagePlatform, account_id: str, since: datetime) -> List[datetime]:
+    """Timestamps of webhook-delivered (not reconciled) customer messages for an account."""
+    model = _message_model_for_platform(platform)
+    rows = (
+        db.query(model.timestamp)
+        .join(Chat, Chat.id == model.chat_id)
+        .filter(Chat.facebook_page_id == account_id)
+        .filter(model.sender.in_(INBOUND_SENDERS))
+        .filter(model.is_reconciled.is_(False))
+        .filter(model.timestamp >= since)
+        .all()
+    )
+    return [row[0] for row in rows]
+
+
+async def _fetch_conversations(platform: MessagePlatform, access_token: str, since: datetime) -> Dict[str, Any]:
+    client = instagram_client if platform == MessagePlatform.INSTAGRAM else facebook_client
+    return await client.get_recent_conversations(page_access_token=access_token, since=since)
+
+
+async def reconcile_account(
+    db: Session,
+    platform: MessagePlatform,
+    account_id: str,
+    access_token: str,
+    now: Optional[datetime] = None,
+) -> WebhookReconciliationRun:
+    """Poll recent conversations for one page/account, ingest missed inbound messages and check for silence."""
+    now = now or utc_now()
+    since = now - timedelta(hours=WEBHOOK_RECONCILE_LOOKBACK_HOURS)
+    run = WebhookReconciliationRun(platform=platform, account_id=account_id, started_at=now)
+    db.add(run)
+    db.flush()
+
+    missing: List[Dict[str, Any]] = []
+    result = await _fetch_conversations(platform, access_token, since)
+    if not result.get("success"):
+        run.error = str(result.get("error") or "Conversations lookup failed")[:2000]
+    for conversation in result.get("conversations", []):
+        run.conversations_checked += 1
+        for message in (conversation.get("messages") or {}).get("data", []) or []:
+            created_at = parse_graph_timestamp(message.get("created_time"))
+            if created_at and created_at < since:
+                continue
+            sender_id = str((message.get("from") or {}).get("id") or "")
+            mid = message.get("id")
+            # Only customer messages are replayed; page-sent messages have no inbound side effects.
+            if not mid or not sender_id or sender_id == str(account_id):
+                continue
+            run.messages_checked += 1
+            if _message_is_stored(db, platform, account_id, sender_id, mid):
+                continue
+            missing.append({
+                "sender": {"id": sender_id},
+                "recipient": {"id": account_id},
+                "timestamp": int((created_at or now).timestamp() * 1000),
+                "message": {
+                    "mid": mid,
+                    "text": message.get("message") or "",
+                    "attachments": _graph_attachments_to_webhook(message.get("attachments")),
+                },
+            })
+    run.missing_found = len(missing)
+    db.commit()
+
+    ingestor = _ingestors.get(platform)
+    if missing and ingestor is None:
+        run.error = "No ingestor registered for platform"
+    elif missing:
+        missing.sort(key=lambda event: event["timestamp"])
+        payload = {
+            "object": "instagram" if platform == MessagePlatform.INSTAGRAM else "page",
+            "entry": [{"id": account_id, "time": int(now.timestamp() * 1000), "messaging": missing}],
+        }
+        try:
+            await ingestor(db, payload, reconciled=True)
+        except Exception as exc:
+            db.rollback()
+            run.error = f"Ingestion failed: {exc}"[:2000]
+            logger.warning("Reconciliation ingest failed for %s %s: %s", platform.value, account_id, exc)
+        model = _message_model_for_platform(platform)
+        run.ingested = (
+            db.query(model.id)
+            .filter(model.mid.in_([normalize_message_mid(event["message"]["mid"]) for event in missing]))
+            .filter(model.is_reconciled.is_(True))
+            .count()
+        )
+        if run.ingested:
+            logger.info("Reconciled %s missed %s messages for %s", run.ingested, platform.value, account_id)
+
+    await _check_silence(db, run, platform, account_id, now)
+    run.finished_at = utc_now()
+    db.commit()
+    db.refresh(run)
+    return run
+
+
+async def _check_silence(
+    db: Session,
+    run: WebhookReconciliationRun,
+    platform: MessagePlatform,
+    account_id: str,
+    now: datetime,
+) -> None:
+    window_start = now - timedelta(hours=WEBHOOK_SILENCE_WINDOW_HOURS)
+    baseline_start = window_start - timedelta(days=WEBHOOK_SILENCE_BASELINE_DAYS)
+    timestamps = _inbound_timestamps(db, platform, account_id, baseline_start)
+
+    baseline = [ts for ts in timestamps if as_utc(ts) < window_start]
+    observed = sum(1 for ts in timestamps if as_utc(ts) >= window_start)
+    profile = hourly_profile(baseline, WEBHOOK_SILENCE_BASELINE_DAYS)
+    expected = expected_in_window(profile, window_start, now)
+    run.expected_hourly_rate = round(expected / max(1, WEBHOOK_SILENCE_WINDOW_HOURS), 3)
+    run.observed_recent = observed
+    if not is_unusually_silent(expected, observed):
+        return
+
+    cooldown_start = now - timedelta(hours=WEBHOOK_SILENCE_ALERT_COOLDOWN_HOURS)
+    recently_alerted = (
+        db.query(WebhookReconciliationRun.id)
+        .filter(WebhookReconciliationRun.account_id == account_id)
+        .filter(WebhookReconciliationRun.platform == platform)
+        .filter(WebhookReconciliationRun.silence_alert.is_(True))
+        .filter(WebhookReconciliationRun.started_at >= cooldown_start)
+        .first()
+    )
+    run.silence_alert = True
+    if recently_alerted:
+        return
+    await send_admin_alert(
+        db,
+        "webhook_silence",
+        f"No {platform.value} webhooks for {account_id}",
+        (
+            f"No customer messages arrived by webhook for {platform.value} account {account_id} "
+            f"in the last {WEBHOOK_SILENCE_WINDOW_HOURS}h, while about {expected:.1f} were expected. "
+            "Check the webhook subscription and page access token."
+        ),
+        details={
+            "platform": platform.value,
+            "account_id": account_id,
+            "expected_messages": round(expected, 1),
+            "window_hours": WEBHOOK_SILENCE_WINDOW_HOURS,
+            "reconciled_messages": run.ingested,
+            "error": run.error,
+        },
+    )
+
+
+async def run_webhook_reconciliation(db: Session, now: Optional[datetime] = None) -> List[WebhookReconciliationRun]:
+    """Reconcile every connected Facebook page and Instagram account."""
+    now = now or utc_now()
+    targets: List[tuple] = []
+    for page in db.query(FacebookPage).filter(FacebookPage.is_active.is_(True)).all():
+        if page.access_token:
+            targets.append((MessagePlatform.FACEBOOK, page.page_id, page.access_token))
+    for account in db.query(InstagramAccount).all():
+        if account.access_token:
+            targets.append((MessagePlatform.INSTAGRAM, account.page_id, account.access_token))
+
+    runs: List[WebhookReconciliationRun] = []
+    for platform, account_id, access_token in targets:
+        try:
+            runs.append(await reconcile_account(db, platform, account_id, access_token, now))
+        except Exception as exc:
+            db.rollback()
+            logger.warning("Webhook reconciliation failed for %s %s: %s", platform.value, account_id, exc)
+    return runs
+
+
+@router.get("/admin/webhook-reconciliation/runs", response_model=List[WebhookReconciliationRunResponse])
+def list_reconciliation_runs(
+    limit: int = Query(50, ge=1, le=500),
+    account_id: Optional[str] = None,
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    query = db.query(WebhookReconciliationRun)
+    if account_id:
+        query = query.filter(WebhookReconciliationRun.account_id == account_id)
+    return query.order_by(WebhookReconciliationRun.started_at.desc()).limit(limit).all()
+
+
+@router.post("/admin/webhook-reconciliation/run", response_model=List[WebhookReconciliationRunResponse])
+async def trigger_reconciliation_run(
+    current_user: User = Depends(get_admin_user),
+    db: Session = Depends(get_db),
+):
+    if not _ingestors:
+        raise HTTPException(status_code=503, detail="Webhook ingestion is not available")
+    return await run_webhook_reconciliation(db)
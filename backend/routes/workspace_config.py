@@ -0,0 +1,712 @@
+import json
+import logging
+import re
+from typing import Any, Callable, Dict, Iterable, List, Optional
+
+from fastapi import APIRouter, Depends, HTTPException, Query
+from fastapi.responses import Response
+from sqlalchemy.orm import Session
+
+from database import get_db
+from models import (
+    FacebookPage,
+    FacebookPageStatusLog,
+    MessagePlatform,
+    MessageTemplate,
+    Position,
+    RateLimitRule,
+    User,
+    UserRole,
+    VolumeAnomalySetting,
+)
+from permissions import ALL_PERMISSION_SET, DEFAULT_POSITION_SLUGS, is_super_admin_user, normalize_permissions
+from rate_limiter import rate_limiter
+from routes.dependencies import get_admin_only_user
+from routes.lead_scoring import invalid_rule_pattern, merge_rules, save_rule_overrides, stored_rule_overrides
+from routes.trash import include_deleted
+from routes.volume_anomalies import SENSITIVITY_THRESHOLDS, parse_scope, scope_key
+from schemas import ConfigImportRequest, ConfigPlanEntry, ConfigPlanResponse
+from utils.audit import record_audit
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+router = APIRouter()
+
+CONFIG_VERSION = 1
+# Order matters on import: positions before anything that could reference them.
+SECTIONS = ("positions", "templates", "lead_scoring", "rate_limits", "volume_anomalies", "pages")
+FORMATS = ("yaml", "json")
+
+ACTION_CREATE = "create"
+ACTION_UPDATE = "update"
+ACTION_UNCHANGED = "unchanged"
+ACTION_SKIP = "skip"
+ACTION_INVALID = "invalid"
+ACTION_UNMANAGED = "unmanaged"  # exists here but not in the file; imports never delete
+
+
+def _yaml():
+    try:
+        import yaml  # Only needed for YAML; JSON works without it
+    except ImportError:
+        raise RuntimeError("PyYAML is not installed; use JSON instead")
+    return yaml
+
+
+def select_sections(sections: Optional[Iterable[str]]) -> List[str]:
+    requested = [item.strip().lower() for item in (sections or []) if item and item.strip()]
+    unknown = sorted(set(requested) - set(SECTIONS))
+    if unknown:
+        raise ValueError(f"Unknown section(s): {', '.join(unknown)}. Choose from {', '.join(SECTIONS)}")
+    return [section for section in SECTIONS if not requested or section in requested]
+
+
+def field_changes(
+    current: Dict[str, Any],
+    desired: Dict[str, Any],
+    keys: Optional[Iterable[str]] = None,
+) -> Dict[str, Dict[str, Any]]:
+    """{field: {"from", "to"}} for every field whose desired value differs from the current one."""
+    changes: Dict[str, Dict[str, Any]] = {}
+    for key in keys if keys is not None else desired.keys():
+        before, after = current.get(key), desired.get(key)
+        if before != after:
+            changes[key] = {"from": before, "to": after}
+    return changes
+
+
+def plan_summary(entries: List[Dict[str, Any]]) -> Dict[str, int]:
+    summary: Dict[str, int] = {}
+    for entry in entries:
+        summary[entry["action"]] = summary.get(entry["action"], 0) + 1
+    return summary
+
+
+def _entry(
+    section: str,
+    key: str,
+    action: str,
+    changes: Optional[Dict[str, Any]] = None,
+    reason: Optional[str] = None,
+) -> Dict[str, Any]:
+    return {"section": section, "key": key, "action": action, "changes": changes or {}, "reason": reason}
+
+
+def _text(item: Dict[str, Any], field: str, required: bool = False) -> Optional[str]:
+    value = item.get(field)
+    if value is None:
+        if required:
+            raise ValueError(f"{field} is required")
+        return None
+    if not isinstance(value, str):
+        raise ValueError(f"{field} must be text")
+    value = value.strip()
+    if required and not value:
+        raise ValueError(f"{field} is required")
+    return value
+
+
+def _body(item: Dict[str, Any], field: str) -> Optional[str]:
+    # Message text and descriptions are kept exactly as written.
+    value = item.get(field)
+    if value is not None and not isinstance(value, str):
+        raise ValueError(f"{field} must be text")
+    return value
+
+
+def _flag(item: Dict[str, Any], field: str) -> bool:
+    value = item.get(field)
+    if not isinstance(value, bool):
+        raise ValueError(f"{field} must be true or false")
+    return value
+
+
+def _positive_int(item: Dict[str, Any], field: str) -> int:
+    value = item.get(field)
+    if isinstance(value, bool) or not isinstance(value, int) or value <= 0:
+        raise ValueError(f"{field} must be a positive whole number")
+    return value
+
+
+def _present(item: Dict[str, Any], parsers: Dict[str, Callable[[Dict[str, Any], str], Any]]) -> Dict[str, Any]:
+    """Parse the fields the item sets; fields left out of the file are left alone on import."""
+    return {field: parse(item, field) for field, parse in parsers.items() if field in item}
+
+
+# --- Export -------------------------------------------------------------------
+
+
+def _position_values(position: Position) -> Dict[str, Any]:
+    return {
+        "name": position.name,
+        "description": position.description,
+        "permissions": sorted(position.permissions),
+        "passwordless_login_enabled": bool(position.passwordless_login_enabled),
+    }
+
+
+def _template_key(platform: MessagePlatform, name: str) -> str:
+    return f"{platform.value}:{name}"
+
+
+def _live_templates(db: Session) -> Dict[str, MessageTemplate]:
+    # Template names are not unique; the oldest one with a given name is the managed one.
+    templates: Dict[str, MessageTemplate] = {}
+    for template in db.query(MessageTemplate).order_by(MessageTemplate.created_at.asc()).all():
+        templates.setdefault(_template_key(template.platform, template.name), template)
+    return templates
+
+
+def _route_group_rules(db: Session) -> Dict[str, RateLimitRule]:
+    # Per-user and per-API-key rules name subjects that only exist in one environment.
+    rules = db.query(RateLimitRule).filter(RateLimitRule.scope == "route_group").all()
+    return {rule.route_group: rule for rule in rules}
+
+
+def _volume_values(setting: VolumeAnomalySetting) -> Dict[str, Any]:
+    return {
+        "sensitivity": setting.sensitivity,
+        "spikes_enabled": bool(setting.spikes_enabled),
+        "drops_enabled": bool(setting.drops_enabled),
+    }
+
+
+def _export_positions(db: Session) -> List[Dict[str, Any]]:
+    positions = db.query(Position).order_by(Position.slug.asc()).all()
+    return [{"slug": position.slug, **_position_values(position)} for position in positions]
+
+
+def _export_templates(db: Session) -> List[Dict[str, Any]]:
+    # Meta template ids and approval state belong to the Meta app of each environment.
+    templates = sorted(_live_templates(db).items())
+    return [
+        {
+            "platform": template.platform.value,
+            "name": template.name,
+            "category": template.category,
+            "content": template.content,
+        }
+        for _, template in templates
+    ]
+
+
+def _export_lead_scoring(db: Session) -> Dict[str, Any]:
+    return {"rules": stored_rule_overrides(db)}
+
+
+def _export_rate_limits(db: Session) -> List[Dict[str, Any]]:
+    return [
+        {"route_group": group, "limit": rule.limit, "window_seconds": rule.window_seconds, "note": rule.note}
+        for group, rule in sorted(_route_group_rules(db).items())
+    ]
+
+
+def _export_volume_anomalies(db: Session) -> List[Dict[str, Any]]:
+    # Snoozes are temporary and stay out of the file.
+    settings = db.query(VolumeAnomalySetting).order_by(VolumeAnomalySetting.scope.asc()).all()
+    return [{"scope": setting.scope, **_volume_values(setting)} for setting in settings]
+
+
+def _export_pages(db: Session) -> List[Dict[str, Any]]:
+    # Never the access token or the user who connected the page.
+    pages = db.query(FacebookPage).order_by(FacebookPage.page_id.asc()).all()
+    return [{"page_id": page.page_id, "page_name": page.page_name, "is_active": bool(page.is_active)} for page in pages]
+
+
+EXPORTERS: Dict[str, Callable[[Session], Any]] = {
+    "positions": _export_positions,
+    "templates": _export_templates,
+    "lead_scoring": _export_lead_scoring,
+    "rate_limits": _export_rate_limits,
+    "volume_anomalies": _export_volume_anomalies,
+    "pages": _export_pages,
+}
+
+
+def export_config(db: Session, sections: Optional[Iterable[str]] = None) -> Dict[str, Any]:
+    """Workspace configuration as a plain document. No timestamps, so unchanged config exports identically."""
+    document: Dict[str, Any] = {"version": CONFIG_VERSION}
+    for section in select_sections(sections):
+        document[section] = EXPORTERS[section](db)
+    return document
+
+
+def dump_config(document: Dict[str, Any], fmt: str = "yaml") -> str:
+    if fmt == "json":
+        return json.dumps(document, indent=2, ensure_ascii=False) + "\n"
+    if fmt == "yaml":
+        return _yaml().safe_dump(document, sort_keys=False, allow_unicode=True, default_flow_style=False)
+    raise ValueError(f"format must be one of {', '.join(FORMATS)}")
+
+
+def parse_config(text: str) -> Dict[str, Any]:
+    text = (text or "").strip()
+    if text.startswith("{"):
+        try:
+            document = json.loads(text)
+        except ValueError as exc:
+            raise ValueError(f"Invalid JSON: {exc}")
+    else:
+        yaml = _yaml()
+        try:
+            document = yaml.safe_load(text)
+        except yaml.YAMLError as exc:
+            raise ValueError(f"Invalid YAML: {exc}")
+    if not isinstance(document, dict):
+        raise ValueError("The configuration must be a mapping of sections")
+    return document
+
+
+def validate_document(document: Dict[str, Any], sections: Optional[Iterable[str]] = None) -> Dict[str, Any]:
+    """The selected sections present in the document, after checking its shape."""
+    if not isinstance(document, dict):
+        raise ValueError("The configuration must be a mapping of sections")
+    if document.get("version") != CONFIG_VERSION:
+        raise ValueError(f"Unsupported configuration version {document.get('version')!r}; expected {CONFIG_VERSION}")
+    unknown = sorted(set(document) - set(SECTIONS) - {"version"})
+    if unknown:
+        raise ValueError(f"Unknown section(s): {', '.join(unknown)}")
+    selected = {}
+    for section in select_sections(sections):
+        if section not in document:
+            continue
+        value = document[section]
+        if section == "lead_scoring":
+            if not isinstance(value, dict) or not isinstance(value.get("rules", {}), dict):
+                raise ValueError("lead_scoring must be a mapping with a rules mapping")
+        elif not isinstance(value, list) or not all(isinstance(item, dict) for item in value):
+            raise ValueError(f"{section} must be a list of mappings")
+        selected[section] = value
+    return selected
+
+
+# --- Import -------------------------------------------------------------------
+
+
+def _sync_positions(db: Session, items: List[Dict[str, Any]], actor: Optional[User], apply: bool) -> List[Dict[str, Any]]:
+    entries: List[Dict[str, Any]] = []
+    positions = {
+        position.slug: position
+        for position in include_deleted(db.query(Position)).all()
+    }
+    seen = set()
+    for index, item in enumerate(items):
+        slug = re.sub(r"[^a-z0-9]+", "-", str(item.get("slug") or "").strip().lower()).strip("-")
+        key = slug or f"#{index + 1}"
+        try:
+            if not slug:
+                raise ValueError("slug is required")
+            if slug in seen:
+                raise ValueError("listed more than once")
+            desired = _present(item, {"name": _text, "description": _body, "passwordless_login_enabled": _flag})
+            if "name" in desired and not desired["name"]:
+                raise ValueError("name is required")
+            if "permissions" in item:
+                if not isinstance(item["permissions"], list):
+                    raise ValueError("permissions must be a list")
+                unknown = sorted({str(code) for code in item["permissions"]} - ALL_PERMISSION_SET)
+                if unknown:
+                    raise ValueError(f"Unknown permissions: {', '.join(unknown)}")
+                desired["permissions"] = normalize_permissions(item["permissions"])
+        except ValueError as exc:
+            entries.append(_entry("positions", key, ACTION_INVALID, reason=str(exc)))
+            continue
+        seen.add(slug)
+
+        position = positions.get(slug)
+        if position is not None and position.deleted_at is not None:
+            entries.append(_entry("positions", slug, ACTION_SKIP, reason="In the trash; restore it first"))
+            continue
+        if position is None:
+            desired.setdefault("name", slug)
+            changes = field_changes({}, desired)
+            if slug == DEFAULT_POSITION_SLUGS["super_admin"] and actor and not is_super_admin_user(actor):
+                entries.append(_entry("positions", slug, ACTION_SKIP, changes, "Only Super Admins can create this position"))
+                continue
+            entries.append(_entry("positions", slug, ACTION_CREATE, changes))
+            if apply:
+                position = Position(
+                    slug=slug,
+                    name=desired["name"],
+                    description=desired.get("description"),
+                    passwordless_login_enabled=desired.get("passwordless_login_enabled", False),
+                )
+                position.permissions = desired.get("permissions", [])
+                db.add(position)
+            continue
+
+        changes = field_changes(_position_values(position), desired)
+        if not changes:
+            entries.append(_entry("positions", slug, ACTION_UNCHANGED))
+            continue
+        if slug == DEFAULT_POSITION_SLUGS["super_admin"] and actor and not is_super_admin_user(actor):
+            entries.append(_entry("positions", slug, ACTION_SKIP, changes, "Only Super Admins can edit this position"))
+            continue
+        entries.append(_entry("positions", slug, ACTION_UPDATE, changes))
+        if apply:
+            for field in changes:
+                setattr(position, field, desired[field])
+
+    for slug, position in sorted(positions.items()):
+        if slug not in seen and position.deleted_at is None:
+            entries.append(_entry("positions", slug, ACTION_UNMANAGED))
+    return entries
+
+
+def _template_owner(db: Session, actor: Optional[User]) -> Optional[User]:
+    if actor:
+        return actor
+    # Imports from the command line have no user; new templates belong to the oldest admin.
+    return (
+        db.query(User)
+        .filter(User.role == UserRole.ADMIN, User.is_active.is_(True))
+        .order_by(User.created_at.asc())
+        .first()
+    )
+
+
+def _sync_templates(db: Session, items: List[Dict[str, Any]], actor: Optional[User], apply: bool) -> List[Dict[str, Any]]:
+    entries: List[Dict[str, Any]] = []
+    templates = _live_templates(db)
+    owner: Optional[User] = None
+    seen = set()
+    for index, item in enumerate(items):
+        key = f"#{index + 1}"
+        try:
+            name = _text(item, "name", required=True)
+            try:
+                platform = MessagePlatform(str(item.get("platform") or "").strip().upper())
+            except ValueError:
+                raise ValueError("platform must be INSTAGRAM or FACEBOOK")
+            key = _template_key(platform, name)
+            if key in seen:
+                raise ValueError("listed more than once")
+            desired = _present(item, {"category": _text, "content": _body})
+            template = templates.get(key)
+            if template is None:
+                if not (desired.get("content") or "").strip() or not desired.get("category"):
+                    raise ValueError("content and category are required for a new template")
+                owner = owner or _template_owner(db, actor)
+                if not owner:
+                    raise ValueError("No active admin to own new templates")
+        except ValueError as exc:
+            entries.append(_entry("templates", key, ACTION_INVALID, reason=str(exc)))
+            continue
+        seen.add(key)
+
+        if template is None:
+            entries.append(_entry("templates", key, ACTION_CREATE, field_changes({}, desired)))
+            if apply:
+                db.add(MessageTemplate(
+                    name=name,
+                    platform=platform,
+                    content=desired["content"],
+                    category=desired["category"],
+                    created_by=owner.id,
+                ))
+            continue
+
+        changes = field_changes({"category": template.category, "content": template.content}, desired)
+        if not changes:
+            entries.append(_entry("templates", key, ACTION_UNCHANGED))
+            continue
+        reason = None
+        if "content" in changes and template.is_meta_approved:
+            reason = "Meta-approved template; submit it to Meta again after the change"
+        entries.append(_entry("templates", key, ACTION_UPDATE, changes, reason))
+        if apply:
+            for field in changes:
+                setattr(template, field, desired[field])
+            template.updated_at = utc_now()
+
+    for key in sorted(templates):
+        if key not in seen:
+            entries.append(_entry("templates", key, ACTION_UNMANAGED))
+    return entries
+
+
+def _sync_lead_scoring(db: Session, section: Dict[str, Any], actor: Optional[User], apply: bool) -> List[Dict[str, Any]]:
+    desired = section.get("rules") or {}
+    current = stored_rule_overrides(db)
+    error = invalid_rule_pattern(merge_rules(desired))
+    if error:
+        return [_entry("lead_scoring", "rules", ACTION_INVALID, reason=error)]
+    # Overrides are replaced as a whole, so keys dropped from the file are reverted to the defaults.
+    changes = field_changes(current, desired, sorted(set(current) | set(desired)))
+    if not changes:
+        return [_entry("lead_scoring", "rules", ACTION_UNCHANGED)]
+    if apply:
+        save_rule_overrides(db, desired, actor)
+    return [_entry("lead_scoring", "rules", ACTION_UPDATE, changes)]
+
+
+def _sync_rate_limits(db: Session, items: List[Dict[str, Any]], actor: Optional[User], apply: bool) -> List[Dict[str, Any]]:
+    entries: List[Dict[str, Any]] = []
+    rules = _route_group_rules(db)
+    seen = set()
+    for index, item in enumerate(items):
+        group = str(item.get("route_group") or "").strip().lower()
+        key = group or f"#{index + 1}"
+        try:
+            if not group or group == "*":
+                raise ValueError("route_group must name a route group")
+            if group in seen:
+                raise ValueError("listed more than once")
+            desired = _present(item, {"limit": _positive_int, "window_seconds": _positive_int, "note": _text})
+            if group not in rules and "limit" not in desired:
+                raise ValueError("limit is required for a new rule")
+        except ValueError as exc:
+            entries.append(_entry("rate_limits", key, ACTION_INVALID, reason=str(exc)))
+            continue
+        seen.add(group)
+
+        rule = rules.get(group)
+        if rule is None:
+            desired.setdefault("window_seconds", 60)
+            entries.append(_entry("rate_limits", group, ACTION_CREATE, field_changes({}, desired)))
+            if apply:
+                db.add(RateLimitRule(
+                    scope="route_group",
+                    route_group=group,
+                    limit=desired["limit"],
+                    window_seconds=desired["window_seconds"],
+                    note=desired.get("note"),
+                    created_by=actor.id if actor else None,
+                ))
+            continue
+
+        changes = field_changes(
+            {"limit": rule.limit, "window_seconds": rule.window_seconds, "note": rule.note}, desired
+        )
+        if not changes:
+            entries.append(_entry("rate_limits", group, ACTION_UNCHANGED))
+            continue
+        entries.append(_entry("rate_limits", group, ACTION_UPDATE, changes))
+        if apply:
+            for field in changes:
+                setattr(rule, field, desired[field])
+
+    for group in sorted(rules):
+        if group not in seen:
+            entries.append(_entry("rate_limits", group, ACTION_UNMANAGED))
+    return entries
+
+
+def _sensitivity(item: Dict[str, Any], field: str) -> Optional[str]:
+    value = item.get(field)
+    if value is None:
+        return None
+    value = str(value).strip().lower()
+    if value not in SENSITIVITY_THRESHOLDS:
+        try:
+            float(value)
+        except ValueError:
+            raise ValueError("sensitivity must be low, medium, high or a z-score")
+    return value
+
+
+def _sync_volume_anomalies(db: Session, items: List[Dict[str, Any]], actor: Optional[User], apply: bool) -> List[Dict[str, Any]]:
+    entries: List[Dict[str, Any]] = []
+    settings = {setting.scope: setting for setting in db.query(VolumeAnomalySetting).all()}
+    seen = set()
+    for index, item in enumerate(items):
+        key = str(item.get("scope") or "").strip() or f"#{index + 1}"
+        try:
+            key = scope_key(*parse_scope(key))
+            if key in seen:
+                raise ValueError("listed more than once")
+            desired = _present(item, {"sensitivity": _sensitivity, "spikes_enabled": _flag, "drops_enabled": _flag})
+        except ValueError as exc:
+            entries.append(_entry("volume_anomalies", key, ACTION_INVALID, reason=str(exc)))
+            continue
+        seen.add(key)
+
+        setting = settings.get(key)
+        current = _volume_values(setting) if setting else {"sensitivity": None, "spikes_enabled": True, "drops_enabled": True}
+        changes = field_changes(current, desired)
+        if setting and not changes:
+            entries.append(_entry("volume_anomalies", key, ACTION_UNCHANGED))
+            continue
+        entries.append(_entry("volume_anomalies", key, ACTION_UPDATE if setting else ACTION_CREATE, changes))
+        if apply:
+            if not setting:
+                setting = VolumeAnomalySetting(scope=key)
+                db.add(setting)
+            for field in changes:
+                setattr(setting, field, desired[field])
+            setting.updated_by = actor.id if actor else None
+
+    for key in sorted(settings):
+        if key not in seen:
+            entries.append(_entry("volume_anomalies", key, ACTION_UNMANAGED))
+    return entries
+
+
+def _sync_pages(db: Session, items: List[Dict[str, Any]], actor: Optional[User], apply: bool) -> List[Dict[str, Any]]:
+    entries: List[Dict[str, Any]] = []
+    pages = {page.page_id: page for page in db.query(FacebookPage).all()}
+    seen = set()
+    for index, item in enumerate(items):
+        page_id = str(item.get("page_id") or "").strip()
+        key = page_id or f"#{index + 1}"
+        try:
+            if not page_id:
+                raise ValueError("page_id is required")
+            if page_id in seen:
+                raise ValueError("listed more than once")
+            desired = _present(item, {"page_name": _text, "is_active": _flag})
+        except ValueError as exc:
+            entries.append(_entry("pages", key, ACTION_INVALID, reason=str(exc)))
+            continue
+        seen.add(page_id)
+
+        page = pages.get(page_id)
+        if page is None:
+            # Connecting a page needs its access token, which is never part of the file.
+            entries.append(_entry("pages", page_id, ACTION_SKIP, reason="Page is not connected here; connect it first"))
+            continue
+        changes = field_changes({"page_name": page.page_name, "is_active": bool(page.is_active)}, desired)
+        if not changes:
+            entries.append(_entry("pages", page_id, ACTION_UNCHANGED))
+            continue
+        entries.append(_entry("pages", page_id, ACTION_UPDATE, changes))
+        if apply:
+            for field in changes:
+                setattr(page, field, desired[field])
+            page.updated_at = utc_now()
+            if "is_active" in changes:
+                db.add(FacebookPageStatusLog(
+                    page_id=page.page_id,
+                    changed_by=(actor.email or actor.id) if actor else "config import",
+                    changed_to=desired["is_active"],
+                    note=f"Status changed from {changes['is_active']['from']} to {desired['is_active']} by configuration import",
+                ))
+
+    for page_id in sorted(pages):
+        if page_id not in seen:
+            entries.append(_entry("pages", page_id, ACTION_UNMANAGED))
+    return entries
+
+
+SYNCERS = {
+    "positions": _sync_positions,
+    "templates": _sync_templates,
+    "lead_scoring": _sync_lead_scoring,
+    "rate_limits": _sync_rate_limits,
+    "volume_anomalies": _sync_volume_anomalies,
+    "pages": _sync_pages,
+}
+
+
+def plan_config(
+    db: Session,
+    document: Dict[str, Any],
+    actor: Optional[User] = None,
+    sections: Optional[Iterable[str]] = None,
+    apply: bool = False,
+) -> List[Dict[str, Any]]:
+    """What importing the document would change. With apply=True the changes are staged too."""
+    selected = validate_document(document, sections)
+    entries: List[Dict[str, Any]] = []
+    for section, value in selected.items():
+        entries.extend(SYNCERS[section](db, value, actor, apply))
+    return entries
+
+
+def apply_config(
+    db: Session,
+    document: Dict[str, Any],
+    actor: Optional[User] = None,
+    sections: Optional[Iterable[str]] = None,
+) -> List[Dict[str, Any]]:
+    """Import the document: all of it or nothing. Importing the same file again changes nothing."""
+    invalid = [entry for entry in plan_config(db, document, actor, sections) if entry["action"] == ACTION_INVALID]
+    if invalid:
+        first = invalid[0]
+        raise ValueError(
+            f"{len(invalid)} invalid entr{'y' if len(invalid) == 1 else 'ies'}; "
+            f"first: {first['section']} {first['key']}: {first['reason']}"
+        )
+    entries = plan_config(db, document, actor, sections, apply=True)
+    changed = [entry for entry in entries if entry["action"] in (ACTION_CREATE, ACTION_UPDATE)]
+    if changed:
+        record_audit(
+            db,
+            actor,
+            "config.import",
+            "workspace_config",
+            None,
+            {
+                "summary": plan_summary(entries),
+                "changes": [
+                    {"section": entry["section"], "key": entry["key"], "action": entry["action"], "fields": sorted(entry["changes"])}
+                    for entry in changed
+                ],
+            },
+        )
+    db.commit()
+    if any(entry["section"] == "rate_limits" for entry in changed):
+        rate_limiter.invalidate_rules()
+    logger.info("Workspace configuration imported: %s", plan_summary(entries))
+    return entries
+
+
+def _payload_document(payload: ConfigImportRequest) -> Dict[str, Any]:
+    if payload.config is not None:
+        return payload.config
+    return parse_config(payload.content)
+
+
+def _plan_response(entries: List[Dict[str, Any]], applied: bool) -> ConfigPlanResponse:
+    return ConfigPlanResponse(
+        applied=applied,
+        summary=plan_summary(entries),
+        entries=[ConfigPlanEntry(**entry) for entry in entries],
+    )
+
+
+@router.get("/admin/config/export")
+def export_workspace_config(
+    fmt: str = Query("yaml", alias="format", pattern="^(yaml|json)$"),
+    sections: Optional[str] = None,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    """Download positions, templates, routing settings and page configuration (no secrets, no customer data)."""
+    try:
+        content = dump_config(export_config(db, (sections or "").split(",")), fmt)
+    except (ValueError, RuntimeError) as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    return Response(
+        content=content,
+        media_type="application/json" if fmt == "json" else "application/x-yaml",
+        headers={"Content-Disposition": f'attachment; filename="workspace-config.{fmt}"'},
+    )
+
+
+@router.post("/admin/config/plan", response_model=ConfigPlanResponse)
+def plan_workspace_config(
+    payload: ConfigImportRequest,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    try:
+        entries = plan_config(db, _payload_document(payload), current_user, payload.sections)
+    except (ValueError, RuntimeError) as exc:
+        raise HTTPException(status_code=400, detail=str(exc))
+    return _plan_response(entries, applied=False)
+
+
+@router.post("/admin/config/apply", response_model=ConfigPlanResponse)
+def apply_workspace_config(
+    payload: ConfigImportRequest,
+    current_user: User = Depends(get_admin_only_user),
+    db: Session = Depends(get_db),
+):
+    try:
+        entries = apply_config(db, _payload_document(payload), current_user, payload.sections)
+    except (ValueError, RuntimeError) as exc:
+        db.rollback()
+        raise HTTPException(status_code=400, detail=str(exc))
+    return _plan_response(entries, applied=True)
@@ -11,8 +11,11 @@ from models import (
     MessagePlatform,
     InstagramMessageDirection,
     InstagramInsightScope,
-    InstagramCommentAction
+    InstagramCommentAction,
+    PaymentRequestStatus,
+    UserApprovalStatus,
 )
+from utils.field_crypto import open_metadata
 
 def convert_to_ist(dt: datetime) -> datetime:
     """Convert UTC datetime to IST"""
@@ -37,11 +40,13 @@ class PositionBase(BaseModel):
 
 class PositionCreate(PositionBase):
     is_system: bool = False
+    passwordless_login_enabled: bool = False
 
 class PositionUpdate(BaseModel):
     name: Optional[str] = None
     description: Optional[str] = None
     permissions: Optional[List[str]] = None
+    passwordless_login_enabled: Optional[bool] = None
 
 class PositionResponse(BaseModel):
     id: str
@@ -50,6 +55,7 @@ class PositionResponse(BaseModel):
     description: Optional[str] = None
     permissions: List[str] = Field(default_factory=list)
     is_system: bool
+    passwordless_login_enabled: bool = False
     created_at: datetime
     updated_at: datetime
 
@@ -140,6 +146,8 @@ class AdminUserCreate(BaseModel):
 class AuthConfigResponse(BaseModel):
     allow_public_signup: bool
     forgot_password_enabled: bool = True
+    signup_requires_approval: bool = False
+    passwordless_login_enabled: bool = False
 
 
 class AdminUserPasswordReset(BaseModel):
@@ -179,6 +187,8 @@ class UserResponse(BaseModel):
     role: UserRole
     is_active: bool = True
     can_receive_new_chats: bool = True
+    approval_status: Optional[UserApprovalStatus] = None
+    approval_note: Optional[str] = None
     position: Optional[PositionResponse] = None
     permissions: List[str] = Field(default_factory=list)
     created_at: datetime
@@ -208,6 +218,15 @@ class UserActiveUpdate(BaseModel):
 class UserChatRoutingUpdate(BaseModel):
     can_receive_new_chats: bool
 
+
+class SignupApprovalRequest(BaseModel):
+    position_id: str
+    note: Optional[str] = None
+
+
+class SignupRejectionRequest(BaseModel):
+    reason: str = Field(min_length=1)
+
 # Database Visualizer Schemas
 
 class TableColumnMeta(BaseModel):
@@ -334,6 +353,37 @@ class MessageCreate(BaseModel):
     reply_preview: Optional[str] = None
     attachments: Optional[List[Dict[str, Any]]] = Field(default_factory=list)
 
+class MessageReplyInfo(BaseModel):
+    type: str = "message"
+    message_id: Optional[str] = None
+    mid: Optional[str] = None
+    preview: Optional[str] = None
+    sender: Optional[str] = None
+    sender_type: Optional[str] = None
+    story_id: Optional[str] = None
+    story_url: Optional[str] = None
+
+    @classmethod
+    def from_metadata(cls, metadata: Optional[Dict[str, Any]]) -> Optional["MessageReplyInfo"]:
+        if not isinstance(metadata, dict):
+            return None
+        story = metadata.get("reply_story") if isinstance(metadata.get("reply_story"), dict) else {}
+        message_id = metadata.get("reply_to")
+        mid = metadata.get("reply_mid") or metadata.get("reply_facebook_mid")
+        if not (message_id or mid or story):
+            return None
+        return cls(
+            type=metadata.get("reply_type") or ("story" if story else "message"),
+            message_id=message_id,
+            mid=mid,
+            preview=metadata.get("reply_preview"),
+            sender=metadata.get("reply_sender"),
+            sender_type=metadata.get("reply_sender_type"),
+            story_id=story.get("id"),
+            story_url=story.get("url"),
+        )
+
+
 class MessageResponse(BaseModel):
     id: str
     chat_id: str
@@ -344,12 +394,14 @@ class MessageResponse(BaseModel):
     timestamp: datetime
     is_ticklegram: bool = False
     is_lead_form_message: Optional[bool] = False
+    is_reconciled: Optional[bool] = False
     attachments: List[Dict[str, Any]] = Field(default_factory=list)
     attachments_json: Optional[str] = Field(default=None, exclude=True)
     metadata_json: Optional[str] = Field(default=None, exclude=True)
     metadata: Optional[Dict[str, Any]] = None
     is_gif: Optional[bool] = Field(default=False, exclude=True)
     sent_by: Optional[Dict[str, Any]] = None
+    reply_to: Optional[MessageReplyInfo] = None
 
     @model_validator(mode="before")
     def default_lead_form_flag(cls, values):
@@ -375,6 +427,9 @@ class MessageResponse(BaseModel):
                     self.metadata = json.loads(self.metadata_json)
                 except (TypeError, ValueError):
                     self.metadata = None
+        self.metadata = open_metadata(self.metadata)
+        if self.reply_to is None:
+            self.reply_to = MessageReplyInfo.from_metadata(self.metadata)
         # Backfill sent_by from metadata to support sender display in clients
         if self.sent_by is None and self.metadata:
             maybe_sent_by = self.metadata.get("sent_by") if isinstance(self.metadata, dict) else None
@@ -590,6 +645,10 @@ class ChatResponse(BaseModel):
     last_incoming_at: Optional[datetime] = None
     last_outgoing_at: Optional[datetime] = None
     pending_agent_reply: bool = False
+    lead_score: Optional[int] = None
+    lead_tier: Optional[str] = None
+    lead_factors: List[Dict[str, Any]] = []
+    crm_owner_emp_id: Optional[str] = None
     assigned_agent: Optional[UserResponse] = None
     instagram_user: Optional[InstagramUserSchema] = None
     facebook_user: Optional[FacebookUserSchema] = None
@@ -689,3 +748,592 @@ class TemplateSendRequest(BaseModel):
     variables: Optional[dict] = Field(default_factory=dict)
     reply_to_message_id: Optional[str] = None
     reply_preview: Optional[str] = None
+
+
+# Payment Schemas
+class PaymentRequestCreate(BaseModel):
+    amount: float = Field(..., gt=0)
+    currency: Optional[str] = None
+    inquiry_id: Optional[str] = None
+    description: Optional[str] = None
+    expires_in_minutes: Optional[int] = Field(default=None, gt=0)
+
+    @field_validator("currency")
+    @classmethod
+    def _normalize_currency(cls, value: Optional[str]) -> Optional[str]:
+        if value is None:
+            return value
+        value = value.strip().upper()
+        return value or None
+
+    @field_validator("inquiry_id")
+    @classmethod
+    def _strip_inquiry(cls, value: Optional[str]) -> Optional[str]:
+        if value is None:
+            return value
+        value = str(value).strip()
+        return value or None
+
+class PaymentRequestResponse(BaseModel):
+    id: str
+    chat_id: str
+    reference: str
+    inquiry_id: Optional[str] = None
+    amount: float
+    currency: str
+    description: Optional[str] = None
+    provider: str
+    provider_payment_id: Optional[str] = None
+    payment_url: Optional[str] = None
+    status: PaymentRequestStatus
+    amount_received: Optional[float] = None
+    message_id: Optional[str] = None
+    confirmation_message_id: Optional[str] = None
+    created_by: Optional[str] = None
+    expires_at: Optional[datetime] = None
+    paid_at: Optional[datetime] = None
+    created_at: datetime
+    updated_at: datetime
+
+    class Config:
+        from_attributes = True
+
+    def model_post_init(self, _):
+        self.created_at = convert_to_ist(self.created_at)
+        self.updated_at = convert_to_ist(self.updated_at)
+        if self.expires_at:
+            self.expires_at = convert_to_ist(self.expires_at)
+        if self.paid_at:
+            self.paid_at = convert_to_ist(self.paid_at)
+
+class PaymentStatusTotal(BaseModel):
+    status: PaymentRequestStatus
+    count: int = 0
+    amount: float = 0.0
+
+class PaymentInquirySummary(BaseModel):
+    inquiry_id: Optional[str] = None
+    requested_amount: float = 0.0
+    collected_amount: float = 0.0
+    references: List[str] = Field(default_factory=list)
+
+class PaymentDiscrepancy(BaseModel):
+    issue: str
+    reference: Optional[str] = None
+    payment_request_id: Optional[str] = None
+    detail: Optional[str] = None
+
+class PaymentReconciliationReport(BaseModel):
+    start: datetime
+    end: datetime
+    currency_totals: Dict[str, float] = Field(default_factory=dict)
+    totals: List[PaymentStatusTotal] = Field(default_factory=list)
+    inquiries: List[PaymentInquirySummary] = Field(default_factory=list)
+    discrepancies: List[PaymentDiscrepancy] = Field(default_factory=list)
+
+
+# Rate Limit Schemas
+class RateLimitRuleCreate(BaseModel):
+    scope: str = Field(..., pattern="^(user|api_key|route_group)$")
+    # user: user id; api_key: the key or its 16-hex fingerprint (only the fingerprint is stored)
+    subject: Optional[str] = None
+    route_group: str = "*"
+    limit: int = Field(..., gt=0)
+    window_seconds: int = Field(60, gt=0)
+    note: Optional[str] = None
+
+    @model_validator(mode="after")
+    def _require_subject(self):
+        self.route_group = (self.route_group or "*").strip().lower() or "*"
+        if self.scope in ("user", "api_key") and not (self.subject or "").strip():
+            raise ValueError("subject is required for user and api_key rules")
+        if self.scope == "route_group":
+            self.subject = None
+            if self.route_group == "*":
+                raise ValueError("route_group rules must name a route group")
+        return self
+
+class RateLimitRuleResponse(BaseModel):
+    id: str
+    scope: str
+    subject: Optional[str] = None
+    route_group: str
+    limit: int
+    window_seconds: int
+    note: Optional[str] = None
+    created_by: Optional[str] = None
+    created_at: datetime
+
+    class Config:
+        from_attributes = True
+
+    def model_post_init(self, _):
+        self.created_at = convert_to_ist(self.created_at)
+
+class RateLimitConfigResponse(BaseModel):
+    enabled: bool
+    default_limit: int
+    default_window_seconds: int
+    route_groups: Dict[str, Dict[str, int]] = Field(default_factory=dict)
+    rules: List[RateLimitRuleResponse] = Field(default_factory=list)
+
+class RateLimitThrottleEntry(BaseModel):
+    identity: str
+    identity_type: str
+    user_id: Optional[str] = None
+    user_name: Optional[str] = None
+    user_email: Optional[str] = None
+    route_group: str
+    limit: int
+    window_seconds: int
+    blocked_count: int
+    last_path: Optional[str] = None
+    last_blocked_at: datetime
+
+    def model_post_init(self, _):
+        self.last_blocked_at = convert_to_ist(self.last_blocked_at)
+
+
+class QueueEntryResponse(BaseModel):
+    chat_id: str
+    username: Optional[str] = None
+    platform: MessagePlatform
+    assigned_to: Optional[str] = None
+    reason: str
+    position: int
+    estimated_wait_minutes: int
+    waiting_since: datetime
+    notices_sent: int = 0
+
+    def model_post_init(self, _):
+        self.waiting_since = convert_to_ist(self.waiting_since)
+
+
+class QueueStatusResponse(BaseModel):
+    notices_enabled: bool
+    agent_chat_capacity: int
+    handled_per_hour: float
+    available_agents: int
+    total_agents: int
+    unassigned_waiting: int
+    busy_waiting: int
+    entries: List[QueueEntryResponse] = Field(default_factory=list)
+
+
+class WebhookReconciliationRunResponse(BaseModel):
+    id: str
+    platform: MessagePlatform
+    account_id: str
+    started_at: datetime
+    finished_at: Optional[datetime] = None
+    conversations_checked: int = 0
+    messages_checked: int = 0
+    missing_found: int = 0
+    ingested: int = 0
+    expected_hourly_rate: Optional[float] = None
+    observed_recent: Optional[int] = None
+    silence_alert: bool = False
+    error: Optional[str] = None
+
+    class Config:
+        from_attributes = True
+
+    def model_post_init(self, _):
+        self.started_at = convert_to_ist(self.started_at)
+        if self.finished_at:
+            self.finished_at = convert_to_ist(self.finished_at)
+
+
+class AgentShiftCreate(BaseModel):
+    user_id: str
+    starts_at: datetime
+    ends_at: datetime
+    note: Optional[str] = Field(None, max_length=255)
+
+
+class AgentShiftResponse(BaseModel):
+    id: str
+    user_id: str
+    user_name: Optional[str] = None
+    starts_at: datetime
+    ends_at: datetime
+    note: Optional[str] = None
+    created_by: Optional[str] = None
+    created_at: datetime
+
+    class Config:
+        from_attributes = True
+
+    @model_validator(mode="before")
+    def _attach_user_name(cls, data):
+        user = getattr(data, "user", None)
+        if user is not None and not isinstance(data, dict):
+            return {
+                "id": data.id,
+                "user_id": data.user_id,
+                "user_name": user.name,
+                "starts_at": data.starts_at,
+                "ends_at": data.ends_at,
+                "note": data.note,
+                "created_by": data.created_by,
+                "created_at": data.created_at,
+            }
+        return data
+
+    def model_post_init(self, _):
+        self.starts_at = convert_to_ist(self.starts_at)
+        self.ends_at = convert_to_ist(self.ends_at)
+        self.created_at = convert_to_ist(self.created_at)
+
+
+class StaffingForecastInterval(BaseModel):
+    start: datetime
+    end: datetime
+    expected_contacts: float
+    expected_inbound_messages: float
+    expected_new_chats: float
+    holiday_factor: Optional[float] = None
+    workload_erlangs: float
+    recommended_agents: int
+    expected_answer_seconds: Optional[float] = None
+    scheduled_agents: float
+    staffing_gap: float
+    scheduled_service_level: float
+
+    def model_post_init(self, _):
+        self.start = convert_to_ist(self.start)
+        self.end = convert_to_ist(self.end)
+
+
+class StaffingForecastResponse(BaseModel):
+    generated_at: datetime
+    start: datetime
+    end: datetime
+    interval_minutes: int
+    history_weeks: int
+    target_first_response_seconds: int
+    target_service_level: float
+    average_handle_seconds: float
+    chat_concurrency: float
+    historical_contacts: int
+    historical_service_level: Optional[float] = None
+    understaffed_intervals: int
+    total_recommended_agent_hours: int
+    total_scheduled_agent_hours: float
+    intervals: List[StaffingForecastInterval] = Field(default_factory=list)
+
+    def model_post_init(self, _):
+        self.generated_at = convert_to_ist(self.generated_at)
+        self.start = convert_to_ist(self.start)
+        self.end = convert_to_ist(self.end)
+
+
+class VolumeAnomalyEventResponse(BaseModel):
+    id: str
+    scope: str
+    platform: MessagePlatform
+    account_id: Optional[str] = None
+    kind: str
+    window_start: datetime
+    window_end: datetime
+    observed: int
+    expected: float
+    score: float
+    context_json: Optional[str] = Field(default=None, exclude=True)
+    context: Optional[Dict[str, Any]] = None
+    alerted: bool
+    suppressed_reason: Optional[str] = None
+    detected_at: datetime
+
+    class Config:
+        from_attributes = True
+
+    def model_post_init(self, _):
+        self.window_start = convert_to_ist(self.window_start)
+        self.window_end = convert_to_ist(self.window_end)
+        self.detected_at = convert_to_ist(self.detected_at)
+        if self.context is None and self.context_json:
+            try:
+                self.context = json.loads(self.context_json)
+            except (TypeError, ValueError):
+                self.context = None
+
+
+class VolumeAnomalySettingResponse(BaseModel):
+    id: str
+    scope: str
+    sensitivity: Optional[str] = None
+    spikes_enabled: bool
+    drops_enabled: bool
+    snoozed_until: Optional[datetime] = None
+    snooze_reason: Optional[str] = None
+    updated_by: Optional[str] = None
+    updated_at: datetime
+
+    class Config:
+        from_attributes = True
+
+    def model_post_init(self, _):
+        self.updated_at = convert_to_ist(self.updated_at)
+        if self.snoozed_until:
+            self.snoozed_until = convert_to_ist(self.snoozed_until)
+
+
+class VolumeAnomalySettingUpdate(BaseModel):
+    sensitivity: Optional[str] = Field(None, max_length=20)
+    spikes_enabled: Optional[bool] = None
+    drops_enabled: Optional[bool] = None
+
+
+class VolumeAnomalySnoozeRequest(BaseModel):
+    minutes: int = Field(..., ge=5, le=10080)
+    reason: Optional[str] = Field(None, max_length=255)
+
+
+class TrashItemResponse(BaseModel):
+    id: str
+    entity_type: str
+    label: str
+    deleted_at: Optional[datetime] = None
+    deleted_by: Optional[str] = None
+    deleted_by_name: Optional[str] = None
+    purge_after: Optional[datetime] = None
+    details: Dict[str, Any] = Field(default_factory=dict)
+
+    def model_post_init(self, _):
+        if self.deleted_at:
+            self.deleted_at = convert_to_ist(self.deleted_at)
+        if self.purge_after:
+            self.purge_after = convert_to_ist(self.purge_after)
+
+
+class TrashSummaryResponse(BaseModel):
+    entity_type: str
+    count: int
+    retention_days: int
+
+
+class DataExportCreate(BaseModel):
+    subject_type: str = Field(..., pattern="^(platform_user|phone|email)$")
+    subject_value: str = Field(..., min_length=3, max_length=255)
+    reason: Optional[str] = Field(None, max_length=1000)
+
+
+class DataExportResponse(BaseModel):
+    id: str
+    subject_type: str
+    subject_value: str
+    status: str
+    reason: Optional[str] = None
+    requested_by: Optional[str] = None
+    file_size: Optional[int] = None
+    summary: Dict[str, Any] = Field(default_factory=dict)
+    error: Optional[str] = None
+    created_at: datetime
+    started_at: Optional[datetime] = None
+    completed_at: Optional[datetime] = None
+    expires_at: Optional[datetime] = None
+
+    class Config:
+        from_attributes = True
+
+    @model_validator(mode="before")
+    def _load_summary(cls, data):
+        if isinstance(data, dict):
+            return data
+        try:
+            summary = json.loads(data.summary_json or "{}")
+        except (TypeError, ValueError):
+            summary = {}
+        return {
+            "id": data.id,
+            "subject_type": data.subject_type,
+            "subject_value": data.subject_value,
+            "status": data.status,
+            "reason": data.reason,
+            "requested_by": data.requested_by,
+            "file_size": data.file_size,
+            "summary": summary if isinstance(summary, dict) else {},
+            "error": data.error,
+            "created_at": data.created_at,
+            "started_at": data.started_at,
+            "completed_at": data.completed_at,
+            "expires_at": data.expires_at,
+        }
+
+    def model_post_init(self, _):
+        self.created_at = convert_to_ist(self.created_at)
+        if self.started_at:
+            self.started_at = convert_to_ist(self.started_at)
+        if self.completed_at:
+            self.completed_at = convert_to_ist(self.completed_at)
+        if self.expires_at:
+            self.expires_at = convert_to_ist(self.expires_at)
+
+
+class FieldEncryptionTableStatus(BaseModel):
+    table: str
+    columns: List[str]
+    rows: int
+    plaintext: int
+    encrypted: int
+    active_key: int
+
+
+class FieldEncryptionStatusResponse(BaseModel):
+    enabled: bool
+    workspace: str
+    active_key_id: Optional[str] = None
+    key_ids: List[str] = Field(default_factory=list)
+    blind_index_enabled: bool
+    tables: List[FieldEncryptionTableStatus] = Field(default_factory=list)
+
+
+class MessageSearchResult(BaseModel):
+    id: str
+    chat_id: str
+    platform: str
+    sender: str
+    content: str
+    timestamp: datetime
+
+    def model_post_init(self, _):
+        self.timestamp = convert_to_ist(self.timestamp)
+
+
+class LeadScoreResponse(BaseModel):
+    chat_id: str
+    lead_score: Optional[int] = None
+    lead_tier: Optional[str] = None
+    lead_factors: List[Dict[str, Any]] = Field(default_factory=list)
+    lead_scored_at: Optional[datetime] = None
+    link_click_count: int = 0
+    inquiry_created_at: Optional[datetime] = None
+
+    def model_post_init(self, _):
+        if self.lead_scored_at:
+            self.lead_scored_at = convert_to_ist(self.lead_scored_at)
+        if self.inquiry_created_at:
+            self.inquiry_created_at = convert_to_ist(self.inquiry_created_at)
+
+
+class LeadEventRequest(BaseModel):
+    event: str  # link_click | inquiry_created
+    reference: Optional[str] = Field(None, max_length=500)
+
+
+class LeadScoringRulesUpdate(BaseModel):
+    # Overrides merged over the default rules; see DEFAULT_LEAD_RULES in routes/lead_scoring.py
+    rules: Dict[str, Any] = Field(default_factory=dict)
+
+
+class CrmOwnerRouteResult(BaseModel):
+    chat_id: str
+    outcome: str
+    phone: Optional[str] = None
+    emp_id: Optional[str] = None
+    owner_id: Optional[str] = None
+    assigned_to: Optional[str] = None
+
+
+class AnnouncementCreate(BaseModel):
+    title: str = Field(..., min_length=1, max_length=200)
+    body: Optional[str] = Field(None, max_length=5000)
+    severity: str = "info"  # info | warning | critical
+    expires_in_minutes: Optional[int] = Field(None, ge=5, le=43200)
+    expires_at: Optional[datetime] = None
+
+
+class AnnouncementResponse(BaseModel):
+    id: str
+    title: str
+    body: Optional[str] = None
+    severity: str
+    expires_at: Optional[datetime] = None
+    cancelled_at: Optional[datetime] = None
+    created_by: Optional[str] = None
+    created_at: datetime
+    acknowledged: bool = False
+    acknowledged_at: Optional[datetime] = None
+    ack_count: Optional[int] = None
+
+    class Config:
+        from_attributes = True
+
+    def model_post_init(self, _):
+        self.created_at = convert_to_ist(self.created_at)
+        if self.expires_at:
+            self.expires_at = convert_to_ist(self.expires_at)
+        if self.cancelled_at:
+            self.cancelled_at = convert_to_ist(self.cancelled_at)
+        if self.acknowledged_at:
+            self.acknowledged_at = convert_to_ist(self.acknowledged_at)
+
+
+class EmergencyModeUpdate(BaseModel):
+    active: bool
+    auto_reply: Optional[str] = Field(None, max_length=1000)
+    pause_assignment: bool = True
+    reason: Optional[str] = Field(None, max_length=500)
+    duration_minutes: Optional[int] = Field(None, ge=5, le=10080)
+    ends_at: Optional[datetime] = None
+
+
+class EmergencyModeResponse(BaseModel):
+    active: bool
+    auto_reply: Optional[str] = None
+    pause_assignment: bool = False
+    reason: Optional[str] = None
+    started_at: Optional[datetime] = None
+    started_by: Optional[str] = None
+    ends_at: Optional[datetime] = None
+    updated_by: Optional[str] = None
+    updated_at: Optional[datetime] = None
+
+    def model_post_init(self, _):
+        if self.started_at:
+            self.started_at = convert_to_ist(self.started_at)
+        if self.ends_at:
+            self.ends_at = convert_to_ist(self.ends_at)
+        if self.updated_at:
+            self.updated_at = convert_to_ist(self.updated_at)
+
+
+class PasswordlessLoginRequest(BaseModel):
+    identifier: str = Field(..., min_length=3, max_length=255)  # email or contact number
+    method: str = "code"  # code | link
+
+
+class PasswordlessCodeVerify(BaseModel):
+    identifier: str = Field(..., min_length=3, max_length=255)
+    code: str = Field(..., min_length=6, max_length=6)
+
+
+class PasswordlessLinkVerify(BaseModel):
+    token: str = Field(..., min_length=20, max_length=200)
+
+
+class ConfigImportRequest(BaseModel):
+    content: Optional[str] = None  # YAML or JSON text as exported
+    config: Optional[Dict[str, Any]] = None  # or the parsed document
+    sections: Optional[List[str]] = None  # limit the import to these sections
+
+    @model_validator(mode="after")
+    def _require_document(self):
+        if not (self.content or "").strip() and self.config is None:
+            raise ValueError("content or config is required")
+        return self
+
+
+class ConfigPlanEntry(BaseModel):
+    section: str
+    key: str
+    action: str  # create | update | unchanged | skip | invalid | unmanaged
+    changes: Dict[str, Any] = Field(default_factory=dict)
+    reason: Optional[str] = None
+
+
+class ConfigPlanResponse(BaseModel):
+    applied: bool
+    summary: Dict[str, int]
+    entries: List[ConfigPlanEntry]
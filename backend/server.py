@@ -1,4 +1,4 @@
-from fastapi import FastAPI, APIRouter, Depends, HTTPException, status, Header, Request, Query, WebSocket, WebSocketDisconnect, Response
+from fastapi import FastAPI, APIRouter, BackgroundTasks, Depends, HTTPException, status, Header, Request, Query, WebSocket, WebSocketDisconnect, Response
 from starlette.requests import ClientDisconnect
 from starlette.websockets import WebSocketState
 from fastapi.middleware.cors import CORSMiddleware
@@ -19,16 +19,16 @@ from datetime import datetime, timezone, timedelta
 from urllib.parse import urlparse
 import re
 import requests
-import hashlib
 import uuid
 from database import engine, get_db, Base, SessionLocal
-from utils.timezone import utc_now
+from utils.timezone import as_utc, utc_now
 from migrations.runner import run_all_migrations as ensure_instagram_profile_schema
 from models import (
     User,
     InstagramAccount,
     Chat,
     UserRole,
+    UserApprovalStatus,
     ChatStatus,
     MessageSender,
     MessageType,
@@ -87,9 +87,37 @@ from permissions import (
     DEFAULT_POSITION_SLUGS
 )
 from utils.mailer import send_email
+from utils.audit import record_audit
+from utils.field_crypto import seal_metadata, validate_configuration as validate_field_encryption
+from utils.emergency import assignment_paused
+from payment_providers import PAYMENT_LOCAL_DEV_MODE
 from routes import auth as auth_routes
 from routes import users as user_routes
-from routes.chat_helpers import reassign_chats_from_inactive_agents
+from routes import payments as payment_routes
+from routes import rate_limits as rate_limit_routes
+from routes import signup_approvals as signup_approval_routes
+from routes import queue_notices as queue_notice_routes
+from routes import webhook_reconciliation as webhook_reconciliation_routes
+from routes import staffing as staffing_routes
+from routes import volume_anomalies as volume_anomaly_routes
+from routes import trash as trash_routes
+from routes import data_exports as data_export_routes
+from routes import field_encryption as field_encryption_routes
+from routes import lead_scoring as lead_scoring_routes
+from routes import crm_routing as crm_routing_routes
+from routes import announcements as announcement_routes
+from routes import emergency_mode as emergency_mode_routes
+from routes import workspace_config as workspace_config_routes
+from rate_limiter import RateLimitMiddleware
+from routes.chat_helpers import (
+    assert_chat_access,
+    broadcast_chat_assignment,
+    find_message_by_mid,
+    normalize_message_mid,
+    pick_priority_agent,
+    reassign_chats_from_inactive_agents,
+    user_can_view_all_chats,
+)
 from routes.dependencies import get_current_user, get_admin_user, get_admin_only_user, require_super_admin, require_permissions, require_any_permissions, _annotate_user, normalize_email
 
 try:
@@ -110,7 +138,6 @@ INSTAGRAM_ATTACHMENTS_DIR = ATTACHMENTS_ROOT / "instagram"
 ATTACHMENTS_ROOT.mkdir(parents=True, exist_ok=True)
 INSTAGRAM_ATTACHMENTS_DIR.mkdir(parents=True, exist_ok=True)
 ATTACHMENT_DOWNLOAD_TIMEOUT = int(os.getenv("ATTACHMENT_DOWNLOAD_TIMEOUT", "20"))
-INSTAGRAM_MESSAGE_ID_MAX_LENGTH = 512
 
 INSTAGRAM_PAGE_ID = os.getenv("INSTAGRAM_PAGE_ID") or os.getenv("PAGE_ID") or os.getenv("FACEBOOK_PAGE_ID")
 FACEBOOK_APP_ID = os.getenv("FACEBOOK_APP_ID") or os.getenv("META_APP_ID")
@@ -127,6 +154,14 @@ from settings import (
     PASSWORD_RESET_EMAIL_CONTACT,
     PASSWORD_RESET_EMAIL_SUBJECT,
     PASSWORD_RESET_TOKEN_LIFETIME_MINUTES,
+    QUEUE_NOTICE_ENABLED,
+    WEBHOOK_RECONCILE_ENABLED,
+    WEBHOOK_RECONCILE_INTERVAL_MINUTES,
+    VOLUME_ANOMALY_ENABLED,
+    VOLUME_ANOMALY_INTERVAL_MINUTES,
+    TRASH_PURGE_INTERVAL_HOURS,
+    LEAD_PRIORITY_ROUTING_ENABLED,
+    LEAD_SCORE_RECOMPUTE_INTERVAL_MINUTES,
 )
 
 class DuplicateMobileCheckRequest(BaseModel):
@@ -143,6 +178,7 @@ class InquiryInsertRequest(BaseModel):
     comment: Optional[str] = None
     existingContact: Optional[bool] = False  # noqa: N815
     updateContact: Optional[bool] = True    # noqa: N815
+    chat_id: Optional[str] = None  # chat the inquiry was created from (lead scoring)
 
 
 def _coerce_numeric_id(value: Any) -> Optional[int]:
@@ -251,7 +287,19 @@ app.mount(
 
 @app.on_event("startup")
 async def _start_background_tasks():
+    # Refuse to start with encryption on but no usable key, rather than failing on the first message.
+    validate_field_encryption()
     asyncio.create_task(_inactive_agent_reassignment_worker())
+    asyncio.create_task(_queue_notice_worker())
+    webhook_reconciliation_routes.register_ingestor(MessagePlatform.INSTAGRAM, process_instagram_webhook_payload)
+    webhook_reconciliation_routes.register_ingestor(MessagePlatform.FACEBOOK, process_facebook_webhook_payload)
+    asyncio.create_task(_webhook_reconciliation_worker())
+    asyncio.create_task(_volume_anomaly_worker())
+    asyncio.create_task(_trash_purge_worker())
+    asyncio.create_task(_data_export_cleanup_worker())
+    asyncio.create_task(_lead_score_worker())
+    asyncio.create_task(_emergency_mode_worker())
+    asyncio.create_task(_payment_expiry_worker())
 
 
 # Create a router with the /api prefix
@@ -537,7 +585,9 @@ def list_followup_interests():
 def insert_inquiry(
     payload: InquiryInsertRequest,
     response: Response,
+    background_tasks: BackgroundTasks,
     current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db),
 ):
     admin_url = os.environ.get("ADMIN_URL")
     form_token = payload.form_token or os.environ.get("FORM_TOKEN")
@@ -588,6 +638,7 @@ def insert_inquiry(
 
     insert_target = admin_url.rstrip("/") + "/routes/inquiryRoute.php?action=insert"
     request_body = payload.model_dump()
+    request_body.pop("chat_id", None)
     # Enforce env tokens/bid (bid resolved from select if available)
     request_body["form_token"] = form_token
     request_body["bid"] = headers.get("bid") or payload.bid or os.environ.get("BID")
@@ -645,6 +696,12 @@ def insert_inquiry(
         if response is not None and resp.cookies:
             for key, val in resp.cookies.items():
                 response.set_cookie(key=key, value=val, httponly=False, samesite="Lax")
+        if payload.chat_id:
+            lead_change = lead_scoring_routes.mark_inquiry_created(db, payload.chat_id)
+            if lead_change:
+                background_tasks.add_task(
+                    lead_scoring_routes.announce_lead_change_in_background, payload.chat_id, lead_change
+                )
         return result
     except requests.RequestException as exc:
         logging.exception("Inquiry insert failed: %s", exc)
@@ -914,10 +971,124 @@ async def _inactive_agent_reassignment_worker():
         except Exception as exc:
             logger.warning("Inactive agent reassignment failed: %s", exc)
 
+
+async def _queue_notice_worker():
+    """Periodically tell waiting customers their queue position and expected wait."""
+    interval_seconds = int(os.getenv("QUEUE_NOTICE_INTERVAL_SECONDS", "60"))
+    while True:
+        await asyncio.sleep(interval_seconds)
+        if not QUEUE_NOTICE_ENABLED:
+            continue
+        try:
+            with SessionLocal() as session:
+                sent = await queue_notice_routes.send_queue_notices(session)
+                if sent:
+                    logger.info("Sent %s queue notices", sent)
+        except Exception as exc:
+            logger.warning("Queue notice run failed: %s", exc)
+
+
+async def _webhook_reconciliation_worker():
+    """Periodically backfill DMs whose webhooks never arrived and flag silent pages."""
+    interval_seconds = WEBHOOK_RECONCILE_INTERVAL_MINUTES * 60
+    while True:
+        await asyncio.sleep(interval_seconds)
+        if not WEBHOOK_RECONCILE_ENABLED:
+            continue
+        try:
+            with SessionLocal() as session:
+                runs = await webhook_reconciliation_routes.run_webhook_reconciliation(session)
+                ingested = sum(run.ingested for run in runs)
+                if ingested:
+                    logger.info("Webhook reconciliation ingested %s missed messages", ingested)
+        except Exception as exc:
+            logger.warning("Webhook reconciliation failed: %s", exc)
+
+
+async def _volume_anomaly_worker():
+    """Periodically compare inbound volume per page/platform with its history and alert on spikes/drops."""
+    interval_seconds = VOLUME_ANOMALY_INTERVAL_MINUTES * 60
+    while True:
+        await asyncio.sleep(interval_seconds)
+        if not VOLUME_ANOMALY_ENABLED:
+            continue
+        try:
+            with SessionLocal() as session:
+                events = await volume_anomaly_routes.detect_volume_anomalies(session)
+                if events:
+                    logger.info("Detected %s inbound volume anomalies", len(events))
+        except Exception as exc:
+            logger.warning("Volume anomaly detection failed: %s", exc)
+
+
+async def _trash_purge_worker():
+    """Permanently delete trashed rows once TRASH_RETENTION_DAYS have passed."""
+    interval_seconds = TRASH_PURGE_INTERVAL_HOURS * 3600
+    while True:
+        await asyncio.sleep(interval_seconds)
+        try:
+            with SessionLocal() as session:
+                purged = trash_routes.purge_expired_trash(session)
+                if purged:
+                    logger.info("Purged %s expired trash items", purged)
+        except Exception as exc:
+            logger.warning("Trash purge failed: %s", exc)
+
+
+async def _data_export_cleanup_worker():
+    """Delete data export archives once DSAR_EXPORT_RETENTION_DAYS have passed."""
+    while True:
+        await asyncio.sleep(3600)
+        try:
+            with SessionLocal() as session:
+                removed = data_export_routes.cleanup_expired_exports(session)
+                if removed:
+                    logger.info("Removed %s expired data export archives", removed)
+        except Exception as exc:
+            logger.warning("Data export cleanup failed: %s", exc)
+
+
+async def _lead_score_worker():
+    """Recompute lead scores of recently active chats so recency decays."""
+    interval_seconds = LEAD_SCORE_RECOMPUTE_INTERVAL_MINUTES * 60
+    while True:
+        await asyncio.sleep(interval_seconds)
+        try:
+            with SessionLocal() as session:
+                changed = lead_scoring_routes.recompute_active_lead_scores(session)
+                if changed:
+                    logger.info("Recomputed %s lead scores", changed)
+        except Exception as exc:
+            logger.warning("Lead score recompute failed: %s", exc)
+
+
+async def _emergency_mode_worker():
+    """Resume normal operation once emergency mode reaches its end time."""
+    while True:
+        await asyncio.sleep(60)
+        try:
+            with SessionLocal() as session:
+                await emergency_mode_routes.expire_emergency_mode(session)
+        except Exception as exc:
+            logger.warning("Emergency mode check failed: %s", exc)
+
     relative_path = file_path.relative_to(ATTACHMENTS_ROOT)
     return str(relative_path).replace(os.sep, "/")
 
 
+async def _payment_expiry_worker():
+    """Mark payment links that were never paid as expired once they pass expires_at."""
+    while True:
+        await asyncio.sleep(300)
+        try:
+            with SessionLocal() as session:
+                expired = await payment_routes.expire_stale_payments(session)
+                if expired:
+                    logger.info("Expired %s payment requests", expired)
+        except Exception as exc:
+            logger.warning("Payment expiry check failed: %s", exc)
+
+
 def prepare_instagram_attachments(
     igsid: str,
     message_identifier: str,
@@ -1437,28 +1608,6 @@ def _get_schema_changes_payload(db: Session) -> Dict[str, Any]:
             }
             latest_summary_computed = True
     return payload
-_CHAT_VIEW_ALL_PERMISSIONS = [
-    PermissionCode.CHAT_VIEW_ALL.value,
-    PermissionCode.CHAT_VIEW_TEAM.value,
-]
-
-
-def _user_can_view_all_chats(user: User) -> bool:
-    if not user:
-        return False
-    if user.role == UserRole.ADMIN:
-        return True
-    return user_has_any_permission(user, _CHAT_VIEW_ALL_PERMISSIONS)
-
-
-def _assert_chat_access(user: User, chat: Chat) -> None:
-    if not user or not chat:
-        raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Access denied")
-    if _user_can_view_all_chats(user):
-        return
-    if chat.assigned_to == user.id:
-        return
-    raise HTTPException(status_code=status.HTTP_403_FORBIDDEN, detail="Access denied")
 
 
 def _serialize_user_light(user: Optional[User]) -> Optional[Dict[str, str]]:
@@ -1487,7 +1636,7 @@ def _merge_message_metadata(
         if info:
             payload["sent_by"] = info
     if extra:
-        for key, value in extra.items():
+        for key, value in seal_metadata(extra).items():
             if value is not None:
                 payload[key] = value
     return json.dumps(payload) if payload else None
@@ -1515,35 +1664,96 @@ def _build_reply_metadata(
     if not reply_target:
         raise HTTPException(status_code=404, detail="Reply target not found")
 
+    sender_lower, reply_sender_label = _reply_sender_info(db, chat, reply_target)
+    metadata_payload: Dict[str, Any] = {
+        "reply_to": reply_target.id,
+        "reply_preview": _reply_preview_text(reply_preview or reply_target.content),
+        "reply_sender": reply_sender_label,
+        "reply_sender_type": sender_lower,
+    }
+    fb_mid = _extract_facebook_mid(getattr(reply_target, "metadata_json", None))
+    if not fb_mid and reply_target.mid and not reply_target.mid.startswith("hash:"):
+        fb_mid = reply_target.mid
+    if fb_mid:
+        metadata_payload["reply_facebook_mid"] = fb_mid
+    return metadata_payload
+
+
+def _reply_sender_info(db: Session, chat: Chat, reply_target: ChatMessageModel) -> Tuple[str, str]:
+    """Return (sender_type, display label) for the message being replied to."""
     sender_value = reply_target.sender.value if isinstance(reply_target.sender, MessageSender) else str(reply_target.sender or "")
     sender_lower = sender_value.lower()
     if sender_lower in {"agent", "instagram_page"}:
-        reply_sender_label = "You"
-    else:
-        if chat.platform == MessagePlatform.FACEBOOK and not chat.facebook_user and chat.facebook_user_id:
-            chat.facebook_user = db.query(FacebookUser).filter(FacebookUser.id == chat.facebook_user_id).first()
-        if chat.platform == MessagePlatform.INSTAGRAM and not chat.instagram_user and chat.instagram_user_id:
-            chat.instagram_user = db.query(InstagramUser).filter(InstagramUser.igsid == chat.instagram_user_id).first()
-        reply_sender_label = (
-            (chat.facebook_user.name if chat.facebook_user else None)
-            or (chat.instagram_user.name if chat.instagram_user else None)
-            or chat.username
-            or "User"
-        )
+        return sender_lower, "You"
+    if chat.platform == MessagePlatform.FACEBOOK and not chat.facebook_user and chat.facebook_user_id:
+        chat.facebook_user = db.query(FacebookUser).filter(FacebookUser.id == chat.facebook_user_id).first()
+    if chat.platform == MessagePlatform.INSTAGRAM and not chat.instagram_user and chat.instagram_user_id:
+        chat.instagram_user = db.query(InstagramUser).filter(InstagramUser.igsid == chat.instagram_user_id).first()
+    reply_sender_label = (
+        (chat.facebook_user.name if chat.facebook_user else None)
+        or (chat.instagram_user.name if chat.instagram_user else None)
+        or chat.username
+        or "User"
+    )
+    return sender_lower, reply_sender_label
+
+
+def _is_newer_or_unset(candidate: datetime, current: Optional[datetime]) -> bool:
+    """Compare timestamps that may come back naive from the database."""
+    if current is None:
+        return True
+    return as_utc(candidate) >= as_utc(current)
+
 
-    preview_text = (reply_preview or reply_target.content or "[attachment]").strip() or "[attachment]"
+def _reply_preview_text(text: Optional[str]) -> str:
+    preview_text = (text or "[attachment]").strip() or "[attachment]"
     if len(preview_text) > 200:
         preview_text = f"{preview_text[:197]}..."
+    return preview_text
+
+
+def _build_inbound_reply_metadata(
+    db: Session,
+    chat: Chat,
+    reply_ref: Optional[Dict[str, Any]]
+) -> Dict[str, Any]:
+    """
+    Resolve a webhook `reply_to` block (message mid or story) to structured reply metadata.
+
+    Unresolvable mids are kept as `reply_mid` so the quote can still be matched
+    once the original message is backfilled.
+    """
+    if not isinstance(reply_ref, dict) or not reply_ref:
+        return {}
+
+    story = reply_ref.get("story")
+    if isinstance(story, dict) and (story.get("id") or story.get("url")):
+        return {
+            "reply_type": "story",
+            "reply_story": {"id": story.get("id"), "url": story.get("url")},
+            "reply_preview": "Story",
+            "reply_sender": "You",
+            "reply_sender_type": "instagram_page" if chat.platform == MessagePlatform.INSTAGRAM else "agent",
+        }
 
+    reply_mid = reply_ref.get("mid")
+    if not reply_mid:
+        return {}
     metadata_payload: Dict[str, Any] = {
-        "reply_to": reply_target.id,
-        "reply_preview": preview_text,
-        "reply_sender": reply_sender_label,
-        "reply_sender_type": sender_lower,
+        "reply_type": "message",
+        "reply_mid": reply_mid,
     }
-    fb_mid = _extract_facebook_mid(getattr(reply_target, "metadata_json", None))
-    if fb_mid:
-        metadata_payload["reply_facebook_mid"] = fb_mid
+    reply_target = find_message_by_mid(db, chat, reply_mid)
+    if reply_target:
+        sender_lower, reply_sender_label = _reply_sender_info(db, chat, reply_target)
+        metadata_payload.update({
+            "reply_to": reply_target.id,
+            "reply_preview": _reply_preview_text(reply_target.content),
+            "reply_sender": reply_sender_label,
+            "reply_sender_type": sender_lower,
+        })
+    else:
+        logger.info("Reply target mid=%s not found in chat %s", reply_mid, chat.id)
     return metadata_payload
 
 
@@ -1646,6 +1856,8 @@ def _is_assignable_agent(user: Optional[User]) -> bool:
         return False
     if getattr(user, "can_receive_new_chats", True) is False:
         return False
+    if getattr(user, "approval_status", None) not in (None, UserApprovalStatus.APPROVED):
+        return False
     position = getattr(user, "position", None)
     if position and position.slug != DEFAULT_POSITION_SLUGS["agent"]:
         return False
@@ -1675,12 +1887,20 @@ def _get_assignment_cursor(db: Session, name: str = "default") -> AssignmentCurs
     return cursor
 
 def _assign_chat_round_robin(db: Session, chat: Chat) -> Optional[User]:
-    agents = _get_assignable_agents(db)
+    agents = [] if assignment_paused(db) else _get_assignable_agents(db)
     if not agents:
         chat.assigned_to = None
         chat.status = ChatStatus.UNASSIGNED
         return None
 
+    if LEAD_PRIORITY_ROUTING_ENABLED and chat.lead_tier == "hot":
+        priority_agent = pick_priority_agent(db, agents)
+        if priority_agent:
+            # Hot leads skip the rotation; the cursor stays where it was.
+            chat.assigned_to = priority_agent.id
+            chat.status = ChatStatus.ASSIGNED
+            return priority_agent
+
     cursor = _get_assignment_cursor(db)
     ordered_agents = sorted(
         agents,
@@ -2283,6 +2503,11 @@ async def get_current_user(authorization: Optional[str] = Header(None), db: Sess
             status_code=status.HTTP_401_UNAUTHORIZED,
             detail="User not found"
         )
+    if getattr(user, "approval_status", None) not in (None, UserApprovalStatus.APPROVED):
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="User account is awaiting approval"
+        )
     
     return _annotate_user(user)
 
@@ -2982,12 +3207,12 @@ async def connect_instagram_account(
 ):
     """Connect an Instagram Business Account"""
     
-    # Check if account already exists
-    existing = db.query(InstagramAccount).filter(
+    # Check if account already exists (a trashed connection is restored below instead of duplicated)
+    existing = trash_routes.include_deleted(db.query(InstagramAccount)).filter(
         InstagramAccount.page_id == data.page_id
     ).first()
     
-    if existing:
+    if existing and not existing.is_deleted:
         raise HTTPException(status_code=400, detail="Instagram account already connected")
     
     # In REAL mode, verify the account with Instagram API
@@ -3009,6 +3234,18 @@ async def connect_instagram_account(
         # Mock mode
         username = data.username or f"ig_user_{data.page_id[:8]}"
     
+    if existing:
+        existing.user_id = current_user.id
+        existing.access_token = data.access_token
+        existing.username = username
+        existing.deleted_at = None
+        existing.deleted_by = None
+        record_audit(db, current_user, "instagram_account.restore", "instagram_account", existing.id, {"reconnected": True})
+        db.commit()
+        db.refresh(existing)
+        logger.info(f"Instagram account reconnected from trash: {existing.page_id} (@{username})")
+        return existing
+
     # Create new Instagram account
     new_account = InstagramAccount(
         user_id=current_user.id,
@@ -3061,10 +3298,10 @@ def delete_instagram_account(
     if not account:
         raise HTTPException(status_code=404, detail="Instagram account not found")
     
-    db.delete(account)
+    trash_routes.soft_delete(db, account, current_user, "instagram_account", {"page_id": account.page_id, "username": account.username})
     db.commit()
     
-    logger.info(f"Deleted Instagram account: {account.page_id} (@{account.username})")
+    logger.info(f"Moved Instagram account to trash: {account.page_id} (@{account.username})")
     return {"success": True, "message": "Instagram account disconnected"}
 
 # Instagram Webhook Endpoints
@@ -3129,468 +3366,500 @@ async def _handle_instagram_webhook(request: Request, db: Session) -> Dict[str,
         print("Instagram webhook payload:", data)
         logger.info(f"Received Instagram webhook: {data.get('object')}")
 
-        processed_events = 0
-        profile_cache: Dict[str, Dict[str, Any]] = {}
+        return await process_instagram_webhook_payload(db, data)
 
-        if data.get("object") != "instagram":
-            logger.info("Ignoring non-Instagram webhook payload")
-            return {"status": "ignored"}
+    except HTTPException:
+        raise
+    except Exception as exc:
+        logger.error(f"Error processing Instagram webhook: {exc}", exc_info=True)
+        # Return 200 to avoid Facebook retrying
+        return {"status": "error", "message": str(exc)}
 
-        for entry in data.get("entry", []):
-            instagram_account_id = entry.get("id")
-            if not instagram_account_id:
-                continue
-            if str(instagram_account_id) == "0":
-                logger.debug("Skipping Meta test webhook entry with id=0")
-                continue
 
-            page_access_token = resolve_instagram_access_token(db, instagram_account_id)
-            if not page_access_token:
-                logger.warning(f"No access token found for Instagram account {instagram_account_id}")
-                continue
+async def process_instagram_webhook_payload(db: Session, data: Dict[str, Any], reconciled: bool = False) -> Dict[str, Any]:
+    """
+    Ingest an Instagram webhook payload.
 
-            for messaging_event in entry.get("messaging", []):
-                message_data = messaging_event.get("message")
-                if not message_data:
-                    continue
+    Also used by webhook reconciliation to replay missed messages; those are
+    flagged as reconciled.
+    """
+    processed_events = 0
+    profile_cache: Dict[str, Dict[str, Any]] = {}
 
-                sender_id = messaging_event.get("sender", {}).get("id")
-                recipient_id = messaging_event.get("recipient", {}).get("id")
-                if not sender_id or not recipient_id:
-                    continue
+    if data.get("object") != "instagram":
+        logger.info("Ignoring non-Instagram webhook payload")
+        return {"status": "ignored"}
 
-                is_echo = message_data.get("is_echo", False)
-                event_app_id = str(message_data.get("app_id") or messaging_event.get("app_id") or "")
-                direction = InstagramMessageDirection.OUTBOUND if is_echo or sender_id == instagram_account_id else InstagramMessageDirection.INBOUND
-                igsid = sender_id if direction == InstagramMessageDirection.INBOUND else recipient_id
+    for entry in data.get("entry", []):
+        instagram_account_id = entry.get("id")
+        if not instagram_account_id:
+            continue
+        if str(instagram_account_id) == "0":
+            logger.debug("Skipping Meta test webhook entry with id=0")
+            continue
 
-                processed_payload = await instagram_client.process_webhook_message(
-                    sender_id=sender_id,
-                    recipient_id=recipient_id,
-                    message_data=message_data,
-                    instagram_account_id=instagram_account_id
-                )
+        page_access_token = resolve_instagram_access_token(db, instagram_account_id)
+        if not page_access_token:
+            logger.warning(f"No access token found for Instagram account {instagram_account_id}")
+            continue
 
-                message_id = processed_payload.get("message_id")
-                normalized_message_id = None
-                if message_id:
-                    if len(message_id) > INSTAGRAM_MESSAGE_ID_MAX_LENGTH:
-                        normalized_message_id = f"hash:{hashlib.sha256(message_id.encode('utf-8')).hexdigest()}"
-                    else:
-                        normalized_message_id = message_id
-                raw_timestamp = messaging_event.get("timestamp")
-                if not raw_timestamp:
-                    raw_timestamp = utc_now().timestamp() * 1000
-                timestamp_seconds = int(raw_timestamp / 1000)
-                event_datetime = datetime.fromtimestamp(timestamp_seconds, tz=timezone.utc)
-
-                referral_raw = _extract_messaging_referral(messaging_event)
-                referral_payload = _normalize_referral_payload(referral_raw)
-                metadata_extra = {"referral": referral_payload} if referral_payload else None
-                referral_metadata_json = _merge_message_metadata(None, extra=metadata_extra)
-
-                raw_attachments = processed_payload.get("attachments") or []
-                if not isinstance(raw_attachments, list):
-                    raw_attachments = [raw_attachments]
-                raw_text_content = processed_payload.get("text")
-                lead_form = is_lead_form_message(raw_text_content)
-                if _is_useless_template_attachments(raw_attachments) and not (raw_text_content or "").strip():
-                    logger.info(
-                        "Skipping template-only Instagram webhook message igsid=%s mid=%s",
-                        igsid,
-                        message_id or normalized_message_id,
-                    )
-                    continue
-                attachments = prepare_instagram_attachments(
-                    igsid=igsid,
-                    message_identifier=normalized_message_id or f"{timestamp_seconds}",
-                    attachments=raw_attachments
-                )
+        for messaging_event in entry.get("messaging", []):
+            message_data = messaging_event.get("message")
+            if not message_data:
+                continue
 
-                profile_data: Optional[Dict[str, Any]] = profile_cache.get(igsid)
-                if profile_data is None:
-                    fetched_profile = await instagram_client.get_user_profile(
-                        page_access_token=page_access_token,
-                        user_id=igsid
-                    )
-                    if fetched_profile.get("success"):
-                        profile_data = fetched_profile
-                        profile_cache[igsid] = profile_data
-
-                resolved_text = resolve_message_text(raw_text_content, attachments)
-                last_message_preview = resolved_text
-
-                profile_username = None
-                profile_name = None
-                if profile_data:
-                    profile_username = profile_data.get("username")
-                    profile_name = profile_data.get("name") or profile_username
-                if not profile_username:
-                    profile_username = processed_payload.get("sender_username")
-                if not profile_name:
-                    profile_name = processed_payload.get("sender_name") or profile_username
-
-                instagram_user = ensure_instagram_user(
-                    db=db,
-                    igsid=igsid,
-                    event_datetime=event_datetime,
-                    last_message_preview=last_message_preview,
-                    profile_username=profile_username,
-                    profile_name=profile_name
-                )
+            sender_id = messaging_event.get("sender", {}).get("id")
+            recipient_id = messaging_event.get("recipient", {}).get("id")
+            if not sender_id or not recipient_id:
+                continue
 
-                if normalized_message_id:
-                    existing_message = db.query(InstagramMessageLog).filter(
-                        InstagramMessageLog.message_id == normalized_message_id
-                    ).first()
-                    if existing_message:
-                        logger.info(
-                            "Duplicate Instagram message %s for user %s; skipping event",
-                            message_id,
-                            igsid
-                        )
-                        continue
+            is_echo = message_data.get("is_echo", False)
+            event_app_id = str(message_data.get("app_id") or messaging_event.get("app_id") or "")
+            direction = InstagramMessageDirection.OUTBOUND if is_echo or sender_id == instagram_account_id else InstagramMessageDirection.INBOUND
+            igsid = sender_id if direction == InstagramMessageDirection.INBOUND else recipient_id
 
-                try:
-                    raw_message_json = json.dumps(message_data)
-                except (TypeError, ValueError):
-                    raw_message_json = None
+            processed_payload = await instagram_client.process_webhook_message(
+                sender_id=sender_id,
+                recipient_id=recipient_id,
+                message_data=message_data,
+                instagram_account_id=instagram_account_id
+            )
 
-                is_ticklegram_event = False
-                if is_echo and FACEBOOK_APP_ID:
-                    is_ticklegram_event = event_app_id == str(FACEBOOK_APP_ID)
+            message_id = processed_payload.get("message_id")
+            normalized_message_id = normalize_message_mid(message_id)
+            raw_timestamp = messaging_event.get("timestamp")
+            if not raw_timestamp:
+                raw_timestamp = utc_now().timestamp() * 1000
+            timestamp_seconds = int(raw_timestamp / 1000)
+            event_datetime = datetime.fromtimestamp(timestamp_seconds, tz=timezone.utc)
+
+            referral_raw = _extract_messaging_referral(messaging_event)
+            referral_payload = _normalize_referral_payload(referral_raw)
+            metadata_extra: Dict[str, Any] = {}
+            if referral_payload:
+                metadata_extra["referral"] = referral_payload
+            if reconciled:
+                metadata_extra["reconciled"] = True
+            referral_metadata_json = _merge_message_metadata(None, extra=metadata_extra or None)
+
+            raw_attachments = processed_payload.get("attachments") or []
+            if not isinstance(raw_attachments, list):
+                raw_attachments = [raw_attachments]
+            raw_text_content = processed_payload.get("text")
+            lead_form = is_lead_form_message(raw_text_content)
+            if _is_useless_template_attachments(raw_attachments) and not (raw_text_content or "").strip():
+                logger.info(
+                    "Skipping template-only Instagram webhook message igsid=%s mid=%s",
+                    igsid,
+                    message_id or normalized_message_id,
+                )
+                continue
+            attachments = prepare_instagram_attachments(
+                igsid=igsid,
+                message_identifier=normalized_message_id or f"{timestamp_seconds}",
+                attachments=raw_attachments
+            )
+
+            profile_data: Optional[Dict[str, Any]] = profile_cache.get(igsid)
+            if profile_data is None:
+                fetched_profile = await instagram_client.get_user_profile(
+                    page_access_token=page_access_token,
+                    user_id=igsid
+                )
+                if fetched_profile.get("success"):
+                    profile_data = fetched_profile
+                    profile_cache[igsid] = profile_data
+
+            resolved_text = resolve_message_text(raw_text_content, attachments)
+            last_message_preview = resolved_text
+
+            profile_username = None
+            profile_name = None
+            if profile_data:
+                profile_username = profile_data.get("username")
+                profile_name = profile_data.get("name") or profile_username
+            if not profile_username:
+                profile_username = processed_payload.get("sender_username")
+            if not profile_name:
+                profile_name = processed_payload.get("sender_name") or profile_username
+
+            instagram_user = ensure_instagram_user(
+                db=db,
+                igsid=igsid,
+                event_datetime=event_datetime,
+                last_message_preview=last_message_preview,
+                profile_username=profile_username,
+                profile_name=profile_name
+            )
 
-                if direction == InstagramMessageDirection.OUTBOUND and is_ticklegram_event:
-                    logger.debug(
-                        "Skipping outbound echo from TickleGram app for message %s (igsid=%s)",
+            if normalized_message_id:
+                existing_message = db.query(InstagramMessageLog).filter(
+                    InstagramMessageLog.message_id == normalized_message_id
+                ).first()
+                if existing_message:
+                    logger.info(
+                        "Duplicate Instagram message %s for user %s; skipping event",
                         message_id,
                         igsid
                     )
                     continue
 
-                ig_message = InstagramMessageLog(
-                    igsid=igsid,
-                    message_id=normalized_message_id,
-                    direction=direction,
-                    text=resolved_text,
-                    attachments_json=json.dumps(attachments) if attachments else None,
-                    ts=timestamp_seconds,
-                    created_at=event_datetime,
-                    raw_payload_json=json.dumps(messaging_event),
-                    is_ticklegram=is_ticklegram_event,
-                    metadata_json=referral_metadata_json
+            try:
+                raw_message_json = json.dumps(message_data)
+            except (TypeError, ValueError):
+                raw_message_json = None
+
+            is_ticklegram_event = False
+            if is_echo and FACEBOOK_APP_ID:
+                is_ticklegram_event = event_app_id == str(FACEBOOK_APP_ID)
+
+            if direction == InstagramMessageDirection.OUTBOUND and is_ticklegram_event:
+                logger.debug(
+                    "Skipping outbound echo from TickleGram app for message %s (igsid=%s)",
+                    message_id,
+                    igsid
                 )
-                db.add(ig_message)
-                try:
-                    db.flush()
-                except IntegrityError:
-                    db.rollback()
-                    logger.info(
-                        "Duplicate instagram_message %s detected; skipping insert",
-                        normalized_message_id or message_id
-                    )
-                    continue
-
+                continue
 
-                chat: Optional[Chat] = None
-                new_message: Optional[InstagramChatMessage] = None
-                existing_chat: Optional[Chat] = None
+            ig_message = InstagramMessageLog(
+                igsid=igsid,
+                message_id=normalized_message_id,
+                direction=direction,
+                text=resolved_text,
+                attachments_json=json.dumps(attachments) if attachments else None,
+                ts=timestamp_seconds,
+                created_at=event_datetime,
+                raw_payload_json=json.dumps(messaging_event),
+                is_ticklegram=is_ticklegram_event,
+                metadata_json=referral_metadata_json
+            )
+            db.add(ig_message)
+            try:
+                db.flush()
+            except IntegrityError:
+                db.rollback()
+                logger.info(
+                    "Duplicate instagram_message %s detected; skipping insert",
+                    normalized_message_id or message_id
+                )
+                continue
 
-                if direction == InstagramMessageDirection.INBOUND:
-                    chat = db.query(Chat).filter(
-                        Chat.instagram_user_id == sender_id,
-                        Chat.platform == MessagePlatform.INSTAGRAM,
-                        Chat.facebook_page_id == instagram_account_id
-                    ).first()
 
-                    if not chat:
-                        profile = profile_data
-                        if not profile:
-                            profile = await instagram_client.get_user_profile(
-                                page_access_token=page_access_token,
-                                user_id=sender_id
-                            )
-                            if profile.get("success"):
-                                profile_cache[igsid] = profile
-                        print("profile", profile)
-                        username = (profile or {}).get("username") or (profile or {}).get("name") or f"IG User {sender_id[:8]}"
-                        profile_pic_url = (profile or {}).get("profile_pic_url") or (profile or {}).get("profile_pic")
-                        if not profile_pic_url:
-                            profile_pic_url = f"https://via.placeholder.com/150?text={sender_id[:8]}"
+            chat: Optional[Chat] = None
+            new_message: Optional[InstagramChatMessage] = None
+            existing_chat: Optional[Chat] = None
+            lead_change: Optional[Dict[str, Any]] = None
 
-                        instagram_user.username = (profile or {}).get("username") or instagram_user.username
-                        instagram_user.name = (profile or {}).get("name") or instagram_user.name
+            if direction == InstagramMessageDirection.INBOUND:
+                chat = db.query(Chat).filter(
+                    Chat.instagram_user_id == sender_id,
+                    Chat.platform == MessagePlatform.INSTAGRAM,
+                    Chat.facebook_page_id == instagram_account_id
+                ).first() or trash_routes.reattach_trashed_chat(
+                    db, MessagePlatform.INSTAGRAM, instagram_account_id, instagram_user_id=sender_id
+                )
 
-                        chat = Chat(
-                            instagram_user_id=sender_id,
-                            username=username,
-                            profile_pic_url=profile_pic_url,
-                            platform=MessagePlatform.INSTAGRAM,
-                            facebook_page_id=instagram_account_id,
-                            status=ChatStatus.UNASSIGNED
+                if not chat:
+                    profile = profile_data
+                    if not profile:
+                        profile = await instagram_client.get_user_profile(
+                            page_access_token=page_access_token,
+                            user_id=sender_id
                         )
-                        db.add(chat)
-                        db.flush()
-                        if not lead_form:
-                            assigned_agent = _assign_chat_round_robin(db, chat)
-                            if assigned_agent:
-                                chat.assigned_agent = assigned_agent
-                        else:
-                            _clear_assignment_for_lead_form(chat)
-                    else:
-                        if profile_data:
-                            updated_username = profile_data.get("username") or profile_data.get("name")
-                            if updated_username:
-                                chat.username = updated_username
-                            updated_pic = profile_data.get("profile_pic_url") or profile_data.get("profile_pic")
-                            if updated_pic:
-                                chat.profile_pic_url = updated_pic
-
-                    inbound_content = resolved_text or ("[attachment]" if attachments else "")
-                    inferred_type = MessageType.IMAGE if attachments else MessageType.TEXT
-                    new_message = create_chat_message_record(
-                        chat,
-                        sender=MessageSender.INSTAGRAM_USER,
-                        content=inbound_content,
-                        message_type=inferred_type,
-                        timestamp=event_datetime,
-                        is_ticklegram=False,
-                        attachments_json=_dump_attachments_json(attachments),
-                        is_lead_form_message=lead_form,
-                        metadata_json=referral_metadata_json
+                        if profile.get("success"):
+                            profile_cache[igsid] = profile
+                    print("profile", profile)
+                    username = (profile or {}).get("username") or (profile or {}).get("name") or f"IG User {sender_id[:8]}"
+                    profile_pic_url = (profile or {}).get("profile_pic_url") or (profile or {}).get("profile_pic")
+                    if not profile_pic_url:
+                        profile_pic_url = f"https://via.placeholder.com/150?text={sender_id[:8]}"
+
+                    instagram_user.username = (profile or {}).get("username") or instagram_user.username
+                    instagram_user.name = (profile or {}).get("name") or instagram_user.name
+
+                    chat = Chat(
+                        instagram_user_id=sender_id,
+                        username=username,
+                        profile_pic_url=profile_pic_url,
+                        platform=MessagePlatform.INSTAGRAM,
+                        facebook_page_id=instagram_account_id,
+                        status=ChatStatus.UNASSIGNED
                     )
-                    new_message.attachments = attachments
-                    db.add(new_message)
-
-                    if lead_form:
+                    db.add(chat)
+                    db.flush()
+                    lead_scoring_routes.prescore_new_chat(db, chat, resolved_text, referral_metadata_json)
+                    if not lead_form:
+                        assigned_agent = _assign_chat_round_robin(db, chat)
+                        if assigned_agent:
+                            chat.assigned_agent = assigned_agent
+                    else:
                         _clear_assignment_for_lead_form(chat)
+                else:
+                    if profile_data:
+                        updated_username = profile_data.get("username") or profile_data.get("name")
+                        if updated_username:
+                            chat.username = updated_username
+                        updated_pic = profile_data.get("profile_pic_url") or profile_data.get("profile_pic")
+                        if updated_pic:
+                            chat.profile_pic_url = updated_pic
+
+                inbound_content = resolved_text or ("[attachment]" if attachments else "")
+                inferred_type = MessageType.IMAGE if attachments else MessageType.TEXT
+                reply_metadata = _build_inbound_reply_metadata(db, chat, processed_payload.get("reply_to"))
+                new_message = create_chat_message_record(
+                    chat,
+                    sender=MessageSender.INSTAGRAM_USER,
+                    content=inbound_content,
+                    message_type=inferred_type,
+                    timestamp=event_datetime,
+                    is_ticklegram=False,
+                    attachments_json=_dump_attachments_json(attachments),
+                    is_lead_form_message=lead_form,
+                    mid=normalized_message_id,
+                    is_reconciled=reconciled,
+                    metadata_json=_merge_message_metadata(referral_metadata_json, extra=reply_metadata or None)
+                )
+                new_message.attachments = attachments
+                db.add(new_message)
 
+                if lead_form:
+                    _clear_assignment_for_lead_form(chat)
+
+                chat.unread_count += 1
+                if _is_newer_or_unset(event_datetime, chat.last_incoming_at):
                     chat.last_message = inbound_content
-                    chat.unread_count += 1
                     chat.last_incoming_at = event_datetime
                     chat.updated_at = event_datetime
-                    existing_chat = chat
-                else:
-                    chat = db.query(Chat).filter(
-                        Chat.instagram_user_id == igsid,
-                        Chat.platform == MessagePlatform.INSTAGRAM,
-                        Chat.facebook_page_id == instagram_account_id
-                    ).first()
-
-                    if not chat:
-                        profile = profile_data
-                        if not profile:
-                            profile = await instagram_client.get_user_profile(
-                                page_access_token=page_access_token,
-                                user_id=igsid
-                            )
-                            if profile.get("success"):
-                                profile_cache[igsid] = profile
-                        username = (profile or {}).get("username") or (profile or {}).get("name") or f"IG User {igsid[:8]}"
-                        profile_pic_url = (profile or {}).get("profile_pic_url") or (profile or {}).get("profile_pic")
-                        if not profile_pic_url:
-                            profile_pic_url = f"https://via.placeholder.com/150?text={igsid[:8]}"
+                existing_chat = chat
+                lead_change = lead_scoring_routes.score_inbound_message(
+                    db, chat, new_message.content, new_message.metadata_json
+                )
+            else:
+                chat = db.query(Chat).filter(
+                    Chat.instagram_user_id == igsid,
+                    Chat.platform == MessagePlatform.INSTAGRAM,
+                    Chat.facebook_page_id == instagram_account_id
+                ).first() or trash_routes.reattach_trashed_chat(
+                    db, MessagePlatform.INSTAGRAM, instagram_account_id, instagram_user_id=igsid
+                )
 
-                        chat = Chat(
-                            instagram_user_id=igsid,
-                            username=username,
-                            profile_pic_url=profile_pic_url,
-                            platform=MessagePlatform.INSTAGRAM,
-                            facebook_page_id=instagram_account_id,
-                            status=ChatStatus.UNASSIGNED
+                if not chat:
+                    profile = profile_data
+                    if not profile:
+                        profile = await instagram_client.get_user_profile(
+                            page_access_token=page_access_token,
+                            user_id=igsid
                         )
-                        db.add(chat)
-                        db.flush()
+                        if profile.get("success"):
+                            profile_cache[igsid] = profile
+                    username = (profile or {}).get("username") or (profile or {}).get("name") or f"IG User {igsid[:8]}"
+                    profile_pic_url = (profile or {}).get("profile_pic_url") or (profile or {}).get("profile_pic")
+                    if not profile_pic_url:
+                        profile_pic_url = f"https://via.placeholder.com/150?text={igsid[:8]}"
+
+                    chat = Chat(
+                        instagram_user_id=igsid,
+                        username=username,
+                        profile_pic_url=profile_pic_url,
+                        platform=MessagePlatform.INSTAGRAM,
+                        facebook_page_id=instagram_account_id,
+                        status=ChatStatus.UNASSIGNED
+                    )
+                    db.add(chat)
+                    db.flush()
 
-                    outbound_preview = resolved_text or ("[attachment]" if attachments else "")
-                    inferred_type = MessageType.IMAGE if attachments else MessageType.TEXT
-
-                    def _ts_diff_seconds(lhs: Optional[datetime], rhs: Optional[datetime]) -> Optional[float]:
-                        if not lhs or not rhs:
-                            return None
-                        lhs_local = lhs if lhs.tzinfo else lhs.replace(tzinfo=timezone.utc)
-                        rhs_local = rhs if rhs.tzinfo else rhs.replace(tzinfo=timezone.utc)
-                        return abs((lhs_local - rhs_local).total_seconds())
-
-                    dedup_candidate: Optional[InstagramChatMessage] = None
-                    message_model = _message_model_for_platform(chat.platform)
-                    recent_agent_messages = (
-                        message_query_for_chat(db, chat)
-                        .filter(
-                            message_model.chat_id == chat.id,
-                            message_model.sender == MessageSender.AGENT,
-                            message_model.is_ticklegram.is_(True)
-                        )
-                        .order_by(message_model.timestamp.desc())
-                        .limit(5)
-                        .all()
+                outbound_preview = resolved_text or ("[attachment]" if attachments else "")
+                inferred_type = MessageType.IMAGE if attachments else MessageType.TEXT
+
+                def _ts_diff_seconds(lhs: Optional[datetime], rhs: Optional[datetime]) -> Optional[float]:
+                    if not lhs or not rhs:
+                        return None
+                    return abs((as_utc(lhs) - as_utc(rhs)).total_seconds())
+
+                dedup_candidate: Optional[InstagramChatMessage] = None
+                message_model = _message_model_for_platform(chat.platform)
+                recent_agent_messages = (
+                    message_query_for_chat(db, chat)
+                    .filter(
+                        message_model.chat_id == chat.id,
+                        message_model.sender == MessageSender.AGENT,
+                        message_model.is_ticklegram.is_(True)
                     )
-                    for candidate in recent_agent_messages:
-                        if (candidate.content or "").strip() != outbound_preview.strip():
-                            continue
-                        diff_seconds = _ts_diff_seconds(candidate.timestamp, event_datetime)
-                        if diff_seconds is not None and diff_seconds <= 30:
-                            dedup_candidate = candidate
-                            break
-
-                    if dedup_candidate:
-                        new_message = dedup_candidate
-                        new_message.attachments = attachments or getattr(new_message, "attachments", [])
-                        if attachments:
-                            new_message.attachments_json = _dump_attachments_json(attachments)
-                        if metadata_extra:
-                            new_message.metadata_json = _merge_message_metadata(
-                                new_message.metadata_json,
-                                extra=metadata_extra
-                            )
-                    else:
-                        new_message = create_chat_message_record(
-                            chat,
-                            sender=MessageSender.INSTAGRAM_PAGE,
-                            content=outbound_preview,
-                            message_type=inferred_type,
-                            timestamp=event_datetime,
-                            is_ticklegram=is_ticklegram_event,
-                            attachments_json=_dump_attachments_json(attachments),
-                            metadata_json=referral_metadata_json
+                    .order_by(message_model.timestamp.desc())
+                    .limit(5)
+                    .all()
+                )
+                for candidate in recent_agent_messages:
+                    if (candidate.content or "").strip() != outbound_preview.strip():
+                        continue
+                    diff_seconds = _ts_diff_seconds(candidate.timestamp, event_datetime)
+                    if diff_seconds is not None and diff_seconds <= 30:
+                        dedup_candidate = candidate
+                        break
+
+                if dedup_candidate:
+                    new_message = dedup_candidate
+                    if normalized_message_id and not new_message.mid:
+                        new_message.mid = normalized_message_id
+                    new_message.attachments = attachments or getattr(new_message, "attachments", [])
+                    if attachments:
+                        new_message.attachments_json = _dump_attachments_json(attachments)
+                    if metadata_extra:
+                        new_message.metadata_json = _merge_message_metadata(
+                            new_message.metadata_json,
+                            extra=metadata_extra
                         )
-                        new_message.attachments = attachments
-                        db.add(new_message)
-
-                    chat.last_message = outbound_preview
-                    chat.last_outgoing_at = event_datetime
-                    chat.updated_at = event_datetime
-                    existing_chat = chat
-
-                db.flush()
-                db.commit()
-
-                db.refresh(ig_message)
-                if new_message:
-                    db.refresh(new_message)
-
-                notify_users = set()
-                if direction == InstagramMessageDirection.INBOUND:
-                    notify_users = gather_dm_notify_users(db, chat)
                 else:
-                    if new_message:
-                        existing_chat = existing_chat or db.query(Chat).filter(
-                            Chat.instagram_user_id == igsid,
-                            Chat.platform == MessagePlatform.INSTAGRAM
-                        ).first()
-                        notify_users = gather_dm_notify_users(db, existing_chat)
-
-                # Broadcast legacy chat payload for inbound messages
-                if new_message and notify_users:
-                    message_payload = MessageResponse.model_validate(new_message).model_dump(mode="json")
-                    await ws_manager.broadcast_to_users(notify_users, {
-                        "type": "new_message",
-                        "chat_id": str((existing_chat or chat).id),
-                        "platform": (existing_chat or chat).platform.value,
-                        "sender_id": sender_id,
-                        "message": message_payload
-                    })
-
-                # Broadcast DM payload
-                dm_payload = {
-                    "type": "ig_dm",
-                    "legacy_type": "instagram_dm",
-                    "direction": direction.value,
-                    "igsid": igsid,
-                    "text": resolved_text,
-                    "attachments": attachments,
-                    "timestamp": timestamp_seconds,
-                    "message_id": ig_message.id,
-                    "page_id": instagram_account_id,
-                    "delivery_status": "received" if direction == InstagramMessageDirection.INBOUND else "delivered"
-                }
-                if referral_payload:
-                    dm_payload["referral"] = referral_payload
+                    reply_metadata = _build_inbound_reply_metadata(db, chat, processed_payload.get("reply_to"))
+                    new_message = create_chat_message_record(
+                        chat,
+                        sender=MessageSender.INSTAGRAM_PAGE,
+                        content=outbound_preview,
+                        message_type=inferred_type,
+                        timestamp=event_datetime,
+                        is_ticklegram=is_ticklegram_event,
+                        attachments_json=_dump_attachments_json(attachments),
+                        mid=normalized_message_id,
+                        is_reconciled=reconciled,
+                        metadata_json=_merge_message_metadata(referral_metadata_json, extra=reply_metadata or None)
+                    )
+                    new_message.attachments = attachments
+                    db.add(new_message)
 
-                if notify_users:
-                    await ws_manager.broadcast_to_users(notify_users, dm_payload)
+                chat.last_message = outbound_preview
+                chat.last_outgoing_at = event_datetime
+                chat.updated_at = event_datetime
+                existing_chat = chat
 
-                processed_events += 1
+            db.flush()
+            db.commit()
 
-            for change in entry.get("changes", []):
-                field = change.get("field")
-                value = change.get("value", {})
-                if field not in {"comments", "mention", "mentions"}:
-                    continue
+            db.refresh(ig_message)
+            if new_message:
+                db.refresh(new_message)
+            # Reconciled messages are old; routing on them could pull the chat away from its current agent.
+            if direction == InstagramMessageDirection.INBOUND and not reconciled:
+                await crm_routing_routes.route_to_crm_owner(db, chat, resolved_text)
 
-                comment_id = value.get("id") or value.get("comment_id")
-                media_id = value.get("media_id") or value.get("parent_id") or value.get("post_id")
-                if not comment_id or not media_id:
-                    logger.debug("Skipping comment webhook change lacking IDs: %s", value)
-                    continue
+            notify_users = set()
+            if direction == InstagramMessageDirection.INBOUND:
+                notify_users = gather_dm_notify_users(db, chat)
+            else:
+                if new_message:
+                    existing_chat = existing_chat or db.query(Chat).filter(
+                        Chat.instagram_user_id == igsid,
+                        Chat.platform == MessagePlatform.INSTAGRAM
+                    ).first()
+                    notify_users = gather_dm_notify_users(db, existing_chat)
+
+            # Broadcast legacy chat payload for inbound messages
+            if new_message and notify_users:
+                message_payload = MessageResponse.model_validate(new_message).model_dump(mode="json")
+                await ws_manager.broadcast_to_users(notify_users, {
+                    "type": "new_message",
+                    "chat_id": str((existing_chat or chat).id),
+                    "platform": (existing_chat or chat).platform.value,
+                    "sender_id": sender_id,
+                    "message": message_payload
+                })
+
+            # Broadcast DM payload
+            dm_payload = {
+                "type": "ig_dm",
+                "legacy_type": "instagram_dm",
+                "direction": direction.value,
+                "igsid": igsid,
+                "text": resolved_text,
+                "attachments": attachments,
+                "timestamp": timestamp_seconds,
+                "message_id": ig_message.id,
+                "page_id": instagram_account_id,
+                "delivery_status": "received" if direction == InstagramMessageDirection.INBOUND else "delivered"
+            }
+            if referral_payload:
+                dm_payload["referral"] = referral_payload
+
+            if notify_users:
+                await ws_manager.broadcast_to_users(notify_users, dm_payload)
+            if lead_change:
+                await lead_scoring_routes.announce_lead_change(db, chat, lead_change)
+            if direction == InstagramMessageDirection.INBOUND and not reconciled:
+                await emergency_mode_routes.send_emergency_auto_reply(db, chat)
+
+            processed_events += 1
+
+        for change in entry.get("changes", []):
+            field = change.get("field")
+            value = change.get("value", {})
+            if field not in {"comments", "mention", "mentions"}:
+                continue
 
-                verb = value.get("verb") or value.get("action") or "add"
-                action_map = {
-                    "add": InstagramCommentAction.CREATED,
-                    "edited": InstagramCommentAction.UPDATED,
-                    "update": InstagramCommentAction.UPDATED,
-                    "delete": InstagramCommentAction.DELETED,
-                    "remove": InstagramCommentAction.DELETED
-                }
-                action = action_map.get(verb.lower(), InstagramCommentAction.CREATED)
-
-                author_id = None
-                from_data = value.get("from") or value.get("user") or {}
-                if isinstance(from_data, dict):
-                    author_id = from_data.get("id")
-
-                mentioned_user_id = None
-                to_data = value.get("to") or {}
-                if isinstance(to_data, dict):
-                    mentioned_user_id = to_data.get("id")
-
-                text = value.get("text") or value.get("message")
-                hidden = bool(value.get("hidden", False))
-                raw_ts = value.get("timestamp") or value.get("created_time") or int(utc_now().timestamp() * 1000)
-                if raw_ts > 10**12:
-                    timestamp_seconds = int(raw_ts / 1000)
-                else:
-                    timestamp_seconds = int(raw_ts)
-                comment_ts = timestamp_seconds
+            comment_id = value.get("id") or value.get("comment_id")
+            media_id = value.get("media_id") or value.get("parent_id") or value.get("post_id")
+            if not comment_id or not media_id:
+                logger.debug("Skipping comment webhook change lacking IDs: %s", value)
+                continue
 
-                comment_record = upsert_instagram_comment(
-                    db=db,
-                    comment_id=comment_id,
-                    media_id=media_id,
-                    author_id=author_id,
-                    text=text,
-                    hidden=hidden,
-                    action=action,
-                    mentioned_user_id=mentioned_user_id,
-                    ts=comment_ts,
-                    attachments=None
-                )
-                db.commit()
-                db.refresh(comment_record)
-
-                comment_payload = {
-                    "type": "ig_comment",
-                    "action": comment_record.action.value,
-                    "media_id": comment_record.media_id,
-                    "comment_id": comment_record.id,
-                    "text": comment_record.text,
-                    "author_id": comment_record.author_id,
-                    "hidden": comment_record.hidden,
-                    "timestamp": comment_record.ts,
-                    "mentioned_user_id": comment_record.mentioned_user_id
-                }
+            verb = value.get("verb") or value.get("action") or "add"
+            action_map = {
+                "add": InstagramCommentAction.CREATED,
+                "edited": InstagramCommentAction.UPDATED,
+                "update": InstagramCommentAction.UPDATED,
+                "delete": InstagramCommentAction.DELETED,
+                "remove": InstagramCommentAction.DELETED
+            }
+            action = action_map.get(verb.lower(), InstagramCommentAction.CREATED)
+
+            author_id = None
+            from_data = value.get("from") or value.get("user") or {}
+            if isinstance(from_data, dict):
+                author_id = from_data.get("id")
+
+            mentioned_user_id = None
+            to_data = value.get("to") or {}
+            if isinstance(to_data, dict):
+                mentioned_user_id = to_data.get("id")
+
+            text = value.get("text") or value.get("message")
+            hidden = bool(value.get("hidden", False))
+            raw_ts = value.get("timestamp") or value.get("created_time") or int(utc_now().timestamp() * 1000)
+            if raw_ts > 10**12:
+                timestamp_seconds = int(raw_ts / 1000)
+            else:
+                timestamp_seconds = int(raw_ts)
+            comment_ts = timestamp_seconds
 
-                await ws_manager.broadcast_global(comment_payload)
-                processed_events += 1
+            comment_record = upsert_instagram_comment(
+                db=db,
+                comment_id=comment_id,
+                media_id=media_id,
+                author_id=author_id,
+                text=text,
+                hidden=hidden,
+                action=action,
+                mentioned_user_id=mentioned_user_id,
+                ts=comment_ts,
+                attachments=None
+            )
+            db.commit()
+            db.refresh(comment_record)
+
+            comment_payload = {
+                "type": "ig_comment",
+                "action": comment_record.action.value,
+                "media_id": comment_record.media_id,
+                "comment_id": comment_record.id,
+                "text": comment_record.text,
+                "author_id": comment_record.author_id,
+                "hidden": comment_record.hidden,
+                "timestamp": comment_record.ts,
+                "mentioned_user_id": comment_record.mentioned_user_id
+            }
 
-        return {"status": "received", "processed_events": processed_events}
+            await ws_manager.broadcast_global(comment_payload)
+            processed_events += 1
 
-    except HTTPException:
-        raise
-    except Exception as exc:
-        logger.error(f"Error processing Instagram webhook: {exc}", exc_info=True)
-        # Return 200 to avoid Facebook retrying
-        return {"status": "error", "message": str(exc)}
+    return {"status": "received", "processed_events": processed_events}
 
 @api_router.post("/webhooks/instagram")
 async def handle_instagram_webhook(request: Request, db: Session = Depends(get_db)):
@@ -3615,7 +3884,9 @@ async def instagram_dm_send(
     chat = db.query(Chat).filter(
         Chat.instagram_user_id == payload.igsid,
         Chat.platform == MessagePlatform.INSTAGRAM
-    ).first()
+    ).first() or trash_routes.reattach_trashed_chat(
+        db, MessagePlatform.INSTAGRAM, None, instagram_user_id=payload.igsid
+    )
 
     if chat and chat.facebook_page_id:
         page_id = chat.facebook_page_id
@@ -3724,6 +3995,7 @@ async def instagram_dm_send(
         timestamp=now_utc,
         is_ticklegram=True,
         attachments_json=attachments_json,
+        mid=normalize_message_mid(graph_message_id),
         metadata_json=_merge_message_metadata(None, sent_by=current_user)
     )
     message_record.attachments = payload.attachments or []
@@ -3784,6 +4056,9 @@ def list_chats(
     assigned_to: Optional[str] = None,
     unseen: Optional[bool] = None,
     not_replied: Optional[bool] = None,
+    min_lead_score: Optional[int] = None,
+    lead_tier: Optional[str] = None,
+    sort: Optional[str] = None,
     current_user: User = Depends(get_current_user),
     db: Session = Depends(get_db)
 ):
@@ -3830,11 +4105,21 @@ def list_chats(
             )
         )
     
+    if min_lead_score is not None:
+        query = query.filter(Chat.lead_score >= min_lead_score)
+    if lead_tier:
+        query = query.filter(Chat.lead_tier == lead_tier.lower())
+    
     # Filter by assigned to current user (for agents without wider visibility)
-    if assigned_to_me or not _user_can_view_all_chats(current_user):
+    if assigned_to_me or not user_can_view_all_chats(current_user):
         query = query.filter(Chat.assigned_to == current_user.id)
     
-    chats = query.order_by(Chat.updated_at.desc()).all()
+    if sort == "lead_score":
+        # Unscored chats last (NULLS LAST is not supported by MySQL)
+        query = query.order_by(Chat.lead_score.is_(None), Chat.lead_score.desc(), Chat.updated_at.desc())
+    else:
+        query = query.order_by(Chat.updated_at.desc())
+    chats = query.all()
 
     missing_instagram_ids = {
         chat.instagram_user_id
@@ -3895,7 +4180,7 @@ def get_chat(chat_id: str, current_user: User = Depends(get_current_user), db: S
     if not chat:
         raise HTTPException(status_code=404, detail="Chat not found")
     
-    _assert_chat_access(current_user, chat)
+    assert_chat_access(current_user, chat)
     
     # Mark messages as read by resetting unread count
     chat.unread_count = 0
@@ -3954,7 +4239,7 @@ def mark_chat_as_read(chat_id: str, current_user: User = Depends(get_current_use
     if not chat:
         raise HTTPException(status_code=404, detail="Chat not found")
     
-    _assert_chat_access(current_user, chat)
+    assert_chat_access(current_user, chat)
     
     # Reset unread count
     chat.unread_count = 0
@@ -3963,13 +4248,26 @@ def mark_chat_as_read(chat_id: str, current_user: User = Depends(get_current_use
     logger.info(f"Chat {chat_id} marked as read by user {current_user.id}")
     return {"success": True, "chat_id": chat_id}
 
+@api_router.delete("/chats/{chat_id}")
+def delete_chat(chat_id: str, current_user: User = Depends(get_admin_only_user), db: Session = Depends(get_db)):
+    """Move a chat to the trash (admin only). New messages from the customer bring it back."""
+    chat = db.query(Chat).filter(Chat.id == chat_id).first()
+    if not chat:
+        raise HTTPException(status_code=404, detail="Chat not found")
+    
+    trash_routes.soft_delete(db, chat, current_user, "chat", {"username": chat.username, "platform": chat.platform.value})
+    db.commit()
+    
+    logger.info(f"Chat {chat_id} moved to trash by user {current_user.id}")
+    return {"success": True, "chat_id": chat_id}
+
 @api_router.post("/chats/{chat_id}/message", response_model=MessageResponse)
 async def send_message(chat_id: str, message_data: MessageCreate, current_user: User = Depends(get_current_user), db: Session = Depends(get_db)):
     chat = db.query(Chat).filter(Chat.id == chat_id).first()
     if not chat:
         raise HTTPException(status_code=404, detail="Chat not found")
     
-    _assert_chat_access(current_user, chat)
+    assert_chat_access(current_user, chat)
     
     # Enforce Meta's 24-hour human agent policy (Facebook only)
     if chat.platform == MessagePlatform.FACEBOOK:
@@ -4040,6 +4338,7 @@ async def send_message(chat_id: str, message_data: MessageCreate, current_user:
         return new_message
     
     # For real mode, send through appropriate platform
+    sent_mid: Optional[str] = None
     if chat.platform == MessagePlatform.FACEBOOK:
         if not chat.facebook_user_id:
             raise HTTPException(status_code=400, detail="Facebook user reference missing for this chat")
@@ -4064,6 +4363,7 @@ async def send_message(chat_id: str, message_data: MessageCreate, current_user:
                         if not result.get("success"):
                             logger.error(f"Failed to send Facebook attachment: {result.get('error')}")
                             raise HTTPException(status_code=500, detail=f"Failed to send attachment: {result.get('error')}")
+                        sent_mid = result.get("message_id") or sent_mid
                 else:
                     result = await facebook_client.send_text_message(
                         page_access_token=fb_page.access_token,
@@ -4074,6 +4374,7 @@ async def send_message(chat_id: str, message_data: MessageCreate, current_user:
                     if not result.get("success"):
                         logger.error(f"Failed to send Facebook message: {result.get('error')}")
                         raise HTTPException(status_code=500, detail=f"Failed to send message: {result.get('error')}")
+                    sent_mid = result.get("message_id")
             else:
                 raise HTTPException(status_code=400, detail="Facebook page not found or inactive")
         else:
@@ -4093,6 +4394,7 @@ async def send_message(chat_id: str, message_data: MessageCreate, current_user:
                 if not result.get("success"):
                     logger.error(f"Failed to send Instagram message: {result.get('error')}")
                     raise HTTPException(status_code=500, detail=f"Failed to send message: {result.get('error')}")
+                sent_mid = result.get("message_id")
             else:
                 raise HTTPException(status_code=400, detail="Instagram account not found")
         else:
@@ -4107,6 +4409,7 @@ async def send_message(chat_id: str, message_data: MessageCreate, current_user:
         timestamp=event_time,
         is_ticklegram=True,
         attachments_json=_dump_attachments_json(attachments_payload),
+        mid=normalize_message_mid(sent_mid),
         metadata_json=_merge_message_metadata(
             None,
             sent_by=current_user,
@@ -4195,14 +4498,20 @@ def connect_facebook_page(
     db: Session = Depends(get_db)
 ):
     """Connect a Facebook page"""
-    # Check if page already exists
-    existing_page = db.query(FacebookPage).filter(FacebookPage.page_id == page_data.page_id).first()
+    # Check if page already exists (including a trashed connection, which is restored)
+    existing_page = trash_routes.include_deleted(db.query(FacebookPage)).filter(
+        FacebookPage.page_id == page_data.page_id
+    ).first()
     if existing_page:
         # Update existing page
         existing_page.page_name = page_data.page_name or existing_page.page_name
         existing_page.access_token = page_data.access_token
         existing_page.is_active = True
         existing_page.updated_at = utc_now()
+        if existing_page.is_deleted:
+            existing_page.deleted_at = None
+            existing_page.deleted_by = None
+            record_audit(db, current_user, "facebook_page.restore", "facebook_page", existing_page.id, {"reconnected": True})
         db.commit()
         db.refresh(existing_page)
         logger.info(f"Updated Facebook page: {page_data.page_id}")
@@ -4297,15 +4606,15 @@ def delete_facebook_page(
     current_user: User = Depends(get_admin_only_user),
     db: Session = Depends(get_db)
 ):
-    """Delete a Facebook page"""
+    """Delete a Facebook page (moved to the trash, restorable until purged)"""
     page = db.query(FacebookPage).filter(FacebookPage.page_id == page_id).first()
     if not page:
         raise HTTPException(status_code=404, detail="Facebook page not found")
     
-    db.delete(page)
+    trash_routes.soft_delete(db, page, current_user, "facebook_page", {"page_id": page.page_id, "page_name": page.page_name})
     db.commit()
     
-    logger.info(f"Deleted Facebook page: {page_id}")
+    logger.info(f"Moved Facebook page to trash: {page_id}")
     return {"success": True, "message": "Facebook page deleted"}
 
 # Template Endpoints
@@ -4498,15 +4807,18 @@ def delete_template(
     current_user: User = Depends(get_admin_only_user),
     db: Session = Depends(get_db)
 ):
-    """Delete a template (admin only)"""
+    """Delete a template (admin only; moved to the trash, restorable until purged)"""
     template = db.query(MessageTemplate).filter(MessageTemplate.id == template_id).first()
     if not template:
         raise HTTPException(status_code=404, detail="Template not found")
     
-    db.delete(template)
+    trash_routes.soft_delete(db, template, current_user, "message_template", {
+        "name": template.name,
+        "is_meta_approved": template.is_meta_approved,
+    })
     db.commit()
     
-    logger.info(f"Template deleted: {template_id} by user {current_user.email}")
+    logger.info(f"Template moved to trash: {template_id} by user {current_user.email}")
     return {"success": True, "message": "Template deleted"}
 
 @api_router.get("/templates/{template_id}/meta-status")
@@ -4575,7 +4887,7 @@ async def send_template(
     if not chat:
         raise HTTPException(status_code=404, detail="Chat not found")
     
-    _assert_chat_access(current_user, chat)
+    assert_chat_access(current_user, chat)
     
     # Check platform match
     if template.platform != chat.platform:
@@ -4634,6 +4946,7 @@ async def send_template(
     meta_template_tag = os.getenv("META_TEMPLATE_TAG", "ACCOUNT_UPDATE")
     use_meta_template = template.is_meta_approved
     event_time = utc_now()
+    sent_mid: Optional[str] = None
 
     if use_meta_template and not template.meta_template_id:
         raise HTTPException(
@@ -4689,6 +5002,7 @@ async def send_template(
                         )
                     if not result.get("success"):
                         raise HTTPException(status_code=500, detail=f"Failed to send message: {result.get('error')}")
+                    sent_mid = result.get("message_id")
                 else:
                     raise HTTPException(status_code=400, detail="Facebook page not found or inactive")
             else:
@@ -4714,6 +5028,7 @@ async def send_template(
                         )
                     if not result.get("success"):
                         raise HTTPException(status_code=500, detail=f"Failed to send message: {result.get('error')}")
+                    sent_mid = result.get("message_id")
                 else:
                     raise HTTPException(status_code=400, detail="Instagram account not found")
             else:
@@ -4726,6 +5041,7 @@ async def send_template(
         message_type=MessageType.TEXT,
         timestamp=event_time,
         is_ticklegram=True,
+        mid=normalize_message_mid(sent_mid),
         metadata_json=_merge_message_metadata(
             None,
             sent_by=current_user,
@@ -4789,6 +5105,23 @@ async def handle_facebook_webhook(request: Request, db: Session = Depends(get_db
         # Parse webhook data
         data = await request.json()
         logger.info(f"Received Facebook webhook: {data.get('object')}")
+        return await process_facebook_webhook_payload(db, data)
+    
+    except Exception as e:
+        logger.error(f"Error processing Facebook webhook: {e}")
+        # Return 200 to avoid Facebook retrying
+        return {"status": "error", "message": str(e)}
+
+
+async def process_facebook_webhook_payload(db: Session, data: Dict[str, Any], reconciled: bool = False) -> Dict[str, Any]:
+    """
+    Ingest a Messenger webhook payload.
+
+    Also used by webhook reconciliation to replay missed messages; those are
+    flagged as reconciled and keep their original timestamps.
+    """
+    webhook_event: Optional[FacebookWebhookEvent] = None
+    if not reconciled:
         event_entry = data.get("entry", [])
         first_entry = event_entry[0] if isinstance(event_entry, list) and event_entry else {}
         event_page_id = first_entry.get("id") if isinstance(first_entry, dict) else None
@@ -4799,234 +5132,255 @@ async def handle_facebook_webhook(request: Request, db: Session = Depends(get_db
         )
         db.add(webhook_event)
         db.flush()
-        processed_messaging_event = False
-        
-        # Process webhook entries
-        if data.get("object") == "page":
-            for entry in data.get("entry", []):
-                page_id = entry.get("id")
-                if not webhook_event.page_id and page_id:
-                    webhook_event.page_id = page_id
-                
-                # Get Facebook page from database
-                fb_page = db.query(FacebookPage).filter(FacebookPage.page_id == page_id).first()
-                if not fb_page:
-                    logger.warning(f"Received webhook for unknown page: {page_id}")
-                    continue
+    processed_messaging_event = False
+    
+    # Process webhook entries
+    if data.get("object") == "page":
+        for entry in data.get("entry", []):
+            page_id = entry.get("id")
+            if webhook_event is not None and not webhook_event.page_id and page_id:
+                webhook_event.page_id = page_id
+            
+            # Get Facebook page from database
+            fb_page = db.query(FacebookPage).filter(FacebookPage.page_id == page_id).first()
+            if not fb_page:
+                logger.warning(f"Received webhook for unknown page: {page_id}")
+                continue
+            
+            # Process messaging events
+            for messaging_event in entry.get("messaging", []):
+                sender_id = messaging_event.get("sender", {}).get("id")
+                recipient_id = messaging_event.get("recipient", {}).get("id")
                 
-                # Process messaging events
-                for messaging_event in entry.get("messaging", []):
-                    sender_id = messaging_event.get("sender", {}).get("id")
-                    recipient_id = messaging_event.get("recipient", {}).get("id")
+                # Handle message
+                if "message" in messaging_event:
+                    message_data = messaging_event["message"]
                     
-                    # Handle message
-                    if "message" in messaging_event:
-                        message_data = messaging_event["message"]
-                        
-                        # Process message
-                        processed = await facebook_client.process_webhook_message(
-                            sender_id=sender_id,
-                            recipient_id=recipient_id,
-                            message_data=message_data,
-                            page_id=page_id
-                        )
-                        fb_attachments = processed.get("attachments") or []
-                        if _is_useless_template_attachments(fb_attachments) and not (processed.get("text") or "").strip():
-                            logger.info(
-                                "Skipping template-only Facebook webhook message sender=%s page=%s",
-                                sender_id,
-                                page_id,
-                            )
-                            continue
-                        fb_referral_payload = _normalize_referral_payload(
-                            _extract_messaging_referral(messaging_event)
+                    # Process message
+                    processed = await facebook_client.process_webhook_message(
+                        sender_id=sender_id,
+                        recipient_id=recipient_id,
+                        message_data=message_data,
+                        page_id=page_id
+                    )
+                    fb_attachments = processed.get("attachments") or []
+                    if _is_useless_template_attachments(fb_attachments) and not (processed.get("text") or "").strip():
+                        logger.info(
+                            "Skipping template-only Facebook webhook message sender=%s page=%s",
+                            sender_id,
+                            page_id,
                         )
-                        fb_extra_meta: Dict[str, Any] = {}
-                        if fb_referral_payload:
-                            fb_extra_meta["referral"] = fb_referral_payload
-                        if processed.get("message_id"):
-                            fb_extra_meta["facebook_mid"] = processed.get("message_id")
-                        # include full raw webhook payload
-                        fb_extra_meta["raw_webhook"] = messaging_event
-                        fb_metadata_json = _merge_message_metadata(
-                            None,
-                            extra=fb_extra_meta or None
+                        continue
+                    fb_referral_payload = _normalize_referral_payload(
+                        _extract_messaging_referral(messaging_event)
+                    )
+                    fb_extra_meta: Dict[str, Any] = {}
+                    if fb_referral_payload:
+                        fb_extra_meta["referral"] = fb_referral_payload
+                    if processed.get("message_id"):
+                        fb_extra_meta["facebook_mid"] = processed.get("message_id")
+                    if reconciled:
+                        fb_extra_meta["reconciled"] = True
+                    # include full raw webhook payload
+                    fb_extra_meta["raw_webhook"] = messaging_event
+                    fb_metadata_json = _merge_message_metadata(
+                        None,
+                        extra=fb_extra_meta or None
+                    )
+
+                    lead_form = is_lead_form_message(processed.get("text"))
+
+                    chat = db.query(Chat).filter(
+                        Chat.facebook_user_id == sender_id,
+                        Chat.platform == MessagePlatform.FACEBOOK,
+                        Chat.facebook_page_id == page_id
+                    ).first() or trash_routes.reattach_trashed_chat(
+                        db, MessagePlatform.FACEBOOK, page_id, facebook_user_id=sender_id
+                    )
+                    if chat and find_message_by_mid(db, chat, processed.get("message_id")):
+                        logger.info(
+                            "Duplicate Facebook message %s for user %s; skipping event",
+                            processed.get("message_id"),
+                            sender_id
                         )
+                        continue
 
-                        lead_form = is_lead_form_message(processed.get("text"))
-
-                        chat = db.query(Chat).filter(
-                            Chat.facebook_user_id == sender_id,
-                            Chat.platform == MessagePlatform.FACEBOOK,
-                            Chat.facebook_page_id == page_id
-                        ).first()
-
-                        profile: Dict[str, Any] = {}
-                        need_profile = not chat or (chat and chat.username.startswith(("FB User", "User")))
-                        if need_profile:
-                            token, token_page_id, token_source = _resolve_facebook_profile_token(
-                                db,
-                                facebook_user_id=sender_id,
-                                preferred_page=fb_page
+                    profile: Dict[str, Any] = {}
+                    need_profile = not chat or (chat and chat.username.startswith(("FB User", "User")))
+                    if need_profile:
+                        token, token_page_id, token_source = _resolve_facebook_profile_token(
+                            db,
+                            facebook_user_id=sender_id,
+                            preferred_page=fb_page
+                        )
+                        if not token:
+                            logger.warning(
+                                "No access token available for Facebook profile lookup (user=%s)",
+                                sender_id
                             )
-                            if not token:
+                        else:
+                            profile_result = await facebook_client.get_user_profile(
+                                page_access_token=token,
+                                user_id=sender_id
+                            )
+                            if profile_result.get("success"):
+                                profile = profile_result
+                            else:
                                 logger.warning(
-                                    "No access token available for Facebook profile lookup (user=%s)",
-                                    sender_id
+                                    "Facebook profile lookup failed for %s via %s token (page=%s): %s",
+                                    sender_id,
+                                    token_source,
+                                    token_page_id or "n/a",
+                                    profile_result.get("error")
                                 )
+
+                    profile_name = profile.get("name") if isinstance(profile, dict) else None
+                    if not profile_name and isinstance(profile, dict):
+                        first_name = profile.get("first_name")
+                        last_name = profile.get("last_name")
+                        full_name = " ".join(part for part in [first_name, last_name] if part).strip()
+                        profile_name = full_name or None
+                    if not profile_name:
+                        if not FACEBOOK_ACCESS_TOKEN_BACKUP:
+                            logger.error("FACEBOOK_ACCESS_TOKEN_BACKUP is not set; cannot fetch Facebook user name via Graph API")
+                        else:
+                            graph_url = f"https://graph.facebook.com/{GRAPH_VERSION}/{sender_id}?fields=name&access_token={FACEBOOK_ACCESS_TOKEN_BACKUP}"
+                            try:
+                                graph_resp = requests.get(graph_url, timeout=5)
+                            except requests.RequestException as exc:
+                                logger.error("Graph API user name lookup failed for %s: %s", sender_id, exc)
                             else:
-                                profile_result = await facebook_client.get_user_profile(
-                                    page_access_token=token,
-                                    user_id=sender_id
-                                )
-                                if profile_result.get("success"):
-                                    profile = profile_result
+                                if graph_resp.status_code == 200:
+                                    profile_name = (graph_resp.json() or {}).get("name") or profile_name
                                 else:
                                     logger.warning(
-                                        "Facebook profile lookup failed for %s via %s token (page=%s): %s",
+                                        "Graph API user name lookup failed for %s: status=%s body=%s",
                                         sender_id,
-                                        token_source,
-                                        token_page_id or "n/a",
-                                        profile_result.get("error")
+                                        graph_resp.status_code,
+                                        graph_resp.text
                                     )
+                    profile_pic_url = profile.get("profile_pic") if isinstance(profile, dict) else None
+                    if not profile_pic_url and isinstance(profile, dict):
+                        profile_pic_url = profile.get("profile_pic_url")
+                    if not profile_pic_url:
+                        profile_pic_url = f"https://via.placeholder.com/150?text={sender_id[:8]}"
+                    computed_username = profile_name or processed.get("sender_name") or f"FB User {sender_id[:8]}"
+
+                    facebook_user = db.query(FacebookUser).filter(FacebookUser.id == sender_id).first()
+                    if not facebook_user:
+                        facebook_user = FacebookUser(
+                            id=sender_id,
+                            username=computed_username,
+                            name=profile_name,
+                            profile_pic_url=profile_pic_url,
+                            last_message=processed.get("text", ""),
+                        )
+                        db.add(facebook_user)
+                        db.flush()
+                    else:
+                        facebook_user.last_message = processed.get("text", "") or facebook_user.last_message
+                        facebook_user.last_seen_at = utc_now()
+                        if profile_name:
+                            facebook_user.name = profile_name
+                        if computed_username:
+                            facebook_user.username = computed_username
+                        if profile_pic_url:
+                            facebook_user.profile_pic_url = profile_pic_url
 
-                        profile_name = profile.get("name") if isinstance(profile, dict) else None
-                        if not profile_name and isinstance(profile, dict):
-                            first_name = profile.get("first_name")
-                            last_name = profile.get("last_name")
-                            full_name = " ".join(part for part in [first_name, last_name] if part).strip()
-                            profile_name = full_name or None
-                        if not profile_name:
-                            if not FACEBOOK_ACCESS_TOKEN_BACKUP:
-                                logger.error("FACEBOOK_ACCESS_TOKEN_BACKUP is not set; cannot fetch Facebook user name via Graph API")
-                            else:
-                                graph_url = f"https://graph.facebook.com/{GRAPH_VERSION}/{sender_id}?fields=name&access_token={FACEBOOK_ACCESS_TOKEN_BACKUP}"
-                                try:
-                                    graph_resp = requests.get(graph_url, timeout=5)
-                                except requests.RequestException as exc:
-                                    logger.error("Graph API user name lookup failed for %s: %s", sender_id, exc)
-                                else:
-                                    if graph_resp.status_code == 200:
-                                        profile_name = (graph_resp.json() or {}).get("name") or profile_name
-                                    else:
-                                        logger.warning(
-                                            "Graph API user name lookup failed for %s: status=%s body=%s",
-                                            sender_id,
-                                            graph_resp.status_code,
-                                            graph_resp.text
-                                        )
-                        profile_pic_url = profile.get("profile_pic") if isinstance(profile, dict) else None
-                        if not profile_pic_url and isinstance(profile, dict):
-                            profile_pic_url = profile.get("profile_pic_url")
-                        if not profile_pic_url:
-                            profile_pic_url = f"https://via.placeholder.com/150?text={sender_id[:8]}"
-                        computed_username = profile_name or processed.get("sender_name") or f"FB User {sender_id[:8]}"
-
-                        facebook_user = db.query(FacebookUser).filter(FacebookUser.id == sender_id).first()
-                        if not facebook_user:
-                            facebook_user = FacebookUser(
-                                id=sender_id,
-                                username=computed_username,
-                                name=profile_name,
-                                profile_pic_url=profile_pic_url,
-                                last_message=processed.get("text", ""),
-                            )
-                            db.add(facebook_user)
-                            db.flush()
-                        else:
-                            facebook_user.last_message = processed.get("text", "") or facebook_user.last_message
-                            facebook_user.last_seen_at = utc_now()
-                            if profile_name:
-                                facebook_user.name = profile_name
-                            if computed_username:
-                                facebook_user.username = computed_username
-                            if profile_pic_url:
-                                facebook_user.profile_pic_url = profile_pic_url
-
-                        if not chat:
-                            temp_instagram_id = facebook_user.id if _requires_sqlite_instagram_fallback(db) else None
-                            chat = Chat(
-                                facebook_user_id=facebook_user.id,
-                                instagram_user_id=temp_instagram_id,
-                                username=computed_username,
-                                profile_pic_url=profile_pic_url,
-                                platform=MessagePlatform.FACEBOOK,
-                                facebook_page_id=page_id,
-                                status=ChatStatus.UNASSIGNED
-                            )
-                            db.add(chat)
-                            db.flush()
-                            if not lead_form:
-                                assigned_agent = _assign_chat_round_robin(db, chat)
-                                if assigned_agent:
-                                    chat.assigned_agent = assigned_agent
-                        elif not chat.facebook_user_id:
-                            chat.facebook_user_id = facebook_user.id
-
-                        if need_profile:
-                            chat.username = computed_username
-                            chat.profile_pic_url = profile_pic_url
-
-
-                        event_timestamp = utc_now()
-                        new_message = create_chat_message_record(
-                            chat,
-                            sender=MessageSender.FACEBOOK_USER,
-                            content=processed.get("text", ""),
-                            message_type=MessageType.TEXT,
-                            timestamp=event_timestamp,
-                            is_ticklegram=False,
-                            is_lead_form_message=lead_form,
-                            metadata_json=fb_metadata_json
+                    if not chat:
+                        temp_instagram_id = facebook_user.id if _requires_sqlite_instagram_fallback(db) else None
+                        chat = Chat(
+                            facebook_user_id=facebook_user.id,
+                            instagram_user_id=temp_instagram_id,
+                            username=computed_username,
+                            profile_pic_url=profile_pic_url,
+                            platform=MessagePlatform.FACEBOOK,
+                            facebook_page_id=page_id,
+                            status=ChatStatus.UNASSIGNED
                         )
-                        new_message.attachments = []
-                        db.add(new_message)
+                        db.add(chat)
+                        db.flush()
+                        lead_scoring_routes.prescore_new_chat(db, chat, processed.get("text", ""), fb_metadata_json)
+                        if not lead_form:
+                            assigned_agent = _assign_chat_round_robin(db, chat)
+                            if assigned_agent:
+                                chat.assigned_agent = assigned_agent
+                    elif not chat.facebook_user_id:
+                        chat.facebook_user_id = facebook_user.id
+
+                    if need_profile:
+                        chat.username = computed_username
+                        chat.profile_pic_url = profile_pic_url
+
+
+                    event_timestamp = utc_now()
+                    if reconciled and messaging_event.get("timestamp"):
+                        event_timestamp = datetime.fromtimestamp(int(messaging_event["timestamp"]) / 1000, tz=timezone.utc)
+                    fb_reply_metadata = _build_inbound_reply_metadata(db, chat, processed.get("reply_to"))
+                    new_message = create_chat_message_record(
+                        chat,
+                        sender=MessageSender.FACEBOOK_USER,
+                        content=processed.get("text", ""),
+                        message_type=MessageType.TEXT,
+                        timestamp=event_timestamp,
+                        is_ticklegram=False,
+                        is_lead_form_message=lead_form,
+                        mid=normalize_message_mid(processed.get("message_id")),
+                        is_reconciled=reconciled,
+                        metadata_json=_merge_message_metadata(fb_metadata_json, extra=fb_reply_metadata or None)
+                    )
+                    new_message.attachments = []
+                    db.add(new_message)
 
-                        if lead_form:
-                            _clear_assignment_for_lead_form(chat)
+                    if lead_form:
+                        _clear_assignment_for_lead_form(chat)
 
-                        # Update chat
+                    # Update chat
+                    chat.unread_count += 1
+                    if _is_newer_or_unset(event_timestamp, chat.last_incoming_at):
                         chat.last_message = processed.get("text", "")
-                        chat.unread_count += 1
                         chat.last_incoming_at = event_timestamp
                         chat.updated_at = event_timestamp
-                        
-                        db.commit()
-                        db.refresh(new_message)
-                        processed_messaging_event = True
-                        logger.info(f"Processed Facebook message from {sender_id} on page {page_id}")
-                        
-                        # Notify relevant users about new message
-                        notify_users = set()
-                        
-                        # Add assigned agent if any
-                        if chat.assigned_to:
-                            notify_users.add(str(chat.assigned_to))
-                        
-                        # Add admin users
-                        admin_users = db.query(User).filter(User.role == UserRole.ADMIN).all()
-                        notify_users.update(str(user.id) for user in admin_users)
-                        
-                        message_payload = MessageResponse.model_validate(new_message).model_dump(mode="json")
-
-                        # Broadcast new message notification
-                        await ws_manager.broadcast_to_users(notify_users, {
-                            "type": "new_message",
-                            "chat_id": str(chat.id),
-                            "platform": chat.platform.value,
-                            "sender_id": sender_id,
-                            "message": message_payload
-                        })
-        
-        if not processed_messaging_event:
-            db.commit()
+                    lead_change = lead_scoring_routes.score_inbound_message(
+                        db, chat, new_message.content, new_message.metadata_json
+                    )
+                    
+                    db.commit()
+                    db.refresh(new_message)
+                    if not reconciled:
+                        await crm_routing_routes.route_to_crm_owner(db, chat, processed.get("text", ""))
+                    processed_messaging_event = True
+                    logger.info(f"Processed Facebook message from {sender_id} on page {page_id}")
+                    
+                    # Notify relevant users about new message
+                    notify_users = set()
+                    
+                    # Add assigned agent if any
+                    if chat.assigned_to:
+                        notify_users.add(str(chat.assigned_to))
+                    
+                    # Add admin users
+                    admin_users = db.query(User).filter(User.role == UserRole.ADMIN).all()
+                    notify_users.update(str(user.id) for user in admin_users)
+                    
+                    message_payload = MessageResponse.model_validate(new_message).model_dump(mode="json")
 
-        return {"status": "received"}
+                    # Broadcast new message notification
+                    await ws_manager.broadcast_to_users(notify_users, {
+                        "type": "new_message",
+                        "chat_id": str(chat.id),
+                        "platform": chat.platform.value,
+                        "sender_id": sender_id,
+                        "message": message_payload
+                    })
+                    await lead_scoring_routes.announce_lead_change(db, chat, lead_change)
+                    if not reconciled:
+                        await emergency_mode_routes.send_emergency_auto_reply(db, chat)
     
-    except Exception as e:
-        logger.error(f"Error processing Facebook webhook: {e}")
-        # Return 200 to avoid Facebook retrying
-        return {"status": "error", "message": str(e)}
+    if not processed_messaging_event:
+        db.commit()
+
+    return {"status": "received"}
 
 # --- Chat assignment helpers ---
 class AssignChatByEmployeeRequest(BaseModel):
@@ -5035,7 +5389,7 @@ class AssignChatByEmployeeRequest(BaseModel):
 
 
 @api_router.post("/admin/assign-chat")
-def assign_chat_by_employee(
+async def assign_chat_by_employee(
     payload: AssignChatByEmployeeRequest,
     current_user: User = Depends(get_current_user),
     db: Session = Depends(get_db),
@@ -5059,11 +5413,13 @@ def assign_chat_by_employee(
     if getattr(agent, "is_active", True) is False:
         raise HTTPException(status_code=400, detail="Agent is inactive")
 
+    previous = chat.assigned_to
     chat.assigned_to = agent.id
     chat.status = ChatStatus.ASSIGNED
     db.commit()
     db.refresh(chat)
     logger.info("Chat %s assigned by emp_id %s (user=%s)", payload.chat_id, payload.employee_id, current_user.id)
+    await broadcast_chat_assignment(db, chat, previous)
     return {"success": True, "chat": ChatResponse.model_validate(chat)}
 
 # ============= MOCK DATA GENERATOR =============
@@ -5183,8 +5539,28 @@ async def websocket_endpoint(
 # Include the router in the main app
 app.include_router(auth_routes.router, prefix="/api")
 app.include_router(user_routes.router, prefix="/api")
+app.include_router(payment_routes.router, prefix="/api")
+if PAYMENT_LOCAL_DEV_MODE:
+    app.include_router(payment_routes.local_checkout_router, prefix="/api")
+app.include_router(rate_limit_routes.router, prefix="/api")
+app.include_router(signup_approval_routes.router, prefix="/api")
+app.include_router(queue_notice_routes.router, prefix="/api")
+app.include_router(webhook_reconciliation_routes.router, prefix="/api")
+app.include_router(staffing_routes.router, prefix="/api")
+app.include_router(volume_anomaly_routes.router, prefix="/api")
+app.include_router(trash_routes.router, prefix="/api")
+app.include_router(data_export_routes.router, prefix="/api")
+app.include_router(field_encryption_routes.router, prefix="/api")
+app.include_router(lead_scoring_routes.router, prefix="/api")
+app.include_router(crm_routing_routes.router, prefix="/api")
+app.include_router(announcement_routes.router, prefix="/api")
+app.include_router(emergency_mode_routes.router, prefix="/api")
+app.include_router(workspace_config_routes.router, prefix="/api")
 app.include_router(api_router)
 
+# Rate limiting runs inside CORS so throttled responses still carry CORS headers
+app.add_middleware(RateLimitMiddleware)
+
 # Configure CORS
 # cors_origins = ["*"]  # Allow all origins
 
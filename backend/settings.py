@@ -8,6 +8,12 @@ load_dotenv(ROOT_DIR / ".env")
 
 ALLOW_PUBLIC_SIGNUP = os.getenv("ALLOW_PUBLIC_SIGNUP", "false").lower() in {"1", "true", "yes"}
 PASSWORD_RESET_TOKEN_LIFETIME_MINUTES = int(os.getenv("PASSWORD_RESET_TOKEN_MINUTES", "60"))
+SIGNUP_REQUIRE_APPROVAL = os.getenv("SIGNUP_REQUIRE_APPROVAL", "true").lower() in {"1", "true", "yes"}
+SIGNUP_AUTO_APPROVE_DOMAINS = {
+    domain.strip().lower().lstrip("@")
+    for domain in os.getenv("SIGNUP_AUTO_APPROVE_DOMAINS", "").split(",")
+    if domain.strip()
+}
 FRONTEND_BASE_URL = (
     os.getenv("FRONTEND_BASE_URL")
     or os.getenv("APP_BASE_URL")
@@ -19,3 +25,123 @@ PASSWORD_RESET_EMAIL_SUBJECT = os.getenv(
     "PASSWORD_RESET_EMAIL_SUBJECT", "Reset your TickleGram password"
 )
 PASSWORD_RESET_EMAIL_CONTACT = os.getenv("SUPPORT_CONTACT_EMAIL", "support@ticklegram.com")
+
+PAYMENT_DEFAULT_CURRENCY = os.getenv("PAYMENT_DEFAULT_CURRENCY", "INR")
+PAYMENT_LINK_EXPIRY_MINUTES = int(os.getenv("PAYMENT_LINK_EXPIRY_MINUTES", "1440"))
+PAYMENT_LINK_MESSAGE = os.getenv(
+    "PAYMENT_LINK_MESSAGE",
+    "Please pay the advance booking amount of {amount} {currency} using this link: {url} (Ref: {reference})",
+)
+PAYMENT_CONFIRMATION_MESSAGE = os.getenv(
+    "PAYMENT_CONFIRMATION_MESSAGE",
+    "We have received your payment of {amount} {currency} (Ref: {reference}). Thank you!",
+)
+PAYMENT_CRM_UPDATE_ROUTE = os.getenv("PAYMENT_CRM_UPDATE_ROUTE", "").strip()
+
+# Automatic queue position / expected wait messages
+QUEUE_NOTICE_ENABLED = os.getenv("QUEUE_NOTICE_ENABLED", "false").lower() in {"1", "true", "yes"}
+QUEUE_NOTICE_INITIAL_DELAY_SECONDS = int(os.getenv("QUEUE_NOTICE_INITIAL_DELAY_SECONDS", "120"))
+QUEUE_NOTICE_REPEAT_MINUTES = int(os.getenv("QUEUE_NOTICE_REPEAT_MINUTES", "20"))
+QUEUE_NOTICE_MAX_PER_WAIT = int(os.getenv("QUEUE_NOTICE_MAX_PER_WAIT", "2"))
+QUEUE_NOTICE_RATE_WINDOW_MINUTES = int(os.getenv("QUEUE_NOTICE_RATE_WINDOW_MINUTES", "60"))
+QUEUE_NOTICE_DEFAULT_HANDLE_MINUTES = int(os.getenv("QUEUE_NOTICE_DEFAULT_HANDLE_MINUTES", "6"))
+QUEUE_NOTICE_DEFAULT_LOCALE = os.getenv("QUEUE_NOTICE_DEFAULT_LOCALE", "en").strip().lower() or "en"
+# JSON object: {"<locale>": {"unassigned": "...", "agent_busy": "..."}}; merged over the built-in texts
+QUEUE_NOTICE_TEMPLATES = os.getenv("QUEUE_NOTICE_TEMPLATES", "").strip()
+# Open (awaiting reply) chats an agent can hold before new customers are told they are busy; 0 disables
+AGENT_CHAT_CAPACITY = int(os.getenv("AGENT_CHAT_CAPACITY", "0"))
+
+# Operational alerts (webhook gaps, anomalies)
+ALERT_EMAIL_ENABLED = os.getenv("ALERT_EMAIL_ENABLED", "true").lower() in {"1", "true", "yes"}
+# Comma-separated recipients; defaults to active admins when empty
+ALERT_EMAIL_RECIPIENTS = [
+    address.strip() for address in os.getenv("ALERT_EMAIL_RECIPIENTS", "").split(",") if address.strip()
+]
+# Slack / Teams / Google Chat incoming webhook; receives {"text": ...}
+ALERT_CHAT_WEBHOOK_URL = os.getenv("ALERT_CHAT_WEBHOOK_URL", "").strip()
+
+# Webhook gap reconciliation
+WEBHOOK_RECONCILE_ENABLED = os.getenv("WEBHOOK_RECONCILE_ENABLED", "false").lower() in {"1", "true", "yes"}
+WEBHOOK_RECONCILE_INTERVAL_MINUTES = int(os.getenv("WEBHOOK_RECONCILE_INTERVAL_MINUTES", "15"))
+WEBHOOK_RECONCILE_LOOKBACK_HOURS = int(os.getenv("WEBHOOK_RECONCILE_LOOKBACK_HOURS", "6"))
+# Silence alert: no webhook-delivered messages for this long while the baseline expects at least MIN_EXPECTED
+WEBHOOK_SILENCE_WINDOW_HOURS = int(os.getenv("WEBHOOK_SILENCE_WINDOW_HOURS", "3"))
+WEBHOOK_SILENCE_BASELINE_DAYS = int(os.getenv("WEBHOOK_SILENCE_BASELINE_DAYS", "14"))
+WEBHOOK_SILENCE_MIN_EXPECTED = float(os.getenv("WEBHOOK_SILENCE_MIN_EXPECTED", "5"))
+WEBHOOK_SILENCE_ALERT_COOLDOWN_HOURS = int(os.getenv("WEBHOOK_SILENCE_ALERT_COOLDOWN_HOURS", "6"))
+
+# Trash: soft-deleted templates, positions, pages, Instagram accounts and chats
+TRASH_RETENTION_DAYS = int(os.getenv("TRASH_RETENTION_DAYS", "30"))
+TRASH_PURGE_INTERVAL_HOURS = int(os.getenv("TRASH_PURGE_INTERVAL_HOURS", "6"))
+
+# Staffing forecast (Erlang-C)
+STAFFING_HISTORY_WEEKS = int(os.getenv("STAFFING_HISTORY_WEEKS", "8"))
+# Weight multiplier per week of age, so recent weeks count more
+STAFFING_WEEK_DECAY = float(os.getenv("STAFFING_WEEK_DECAY", "0.85"))
+# A customer message after this much silence in a chat starts a new contact
+STAFFING_SESSION_GAP_MINUTES = int(os.getenv("STAFFING_SESSION_GAP_MINUTES", "30"))
+STAFFING_DEFAULT_HANDLE_SECONDS = int(os.getenv("STAFFING_DEFAULT_HANDLE_SECONDS", "360"))
+STAFFING_MAX_HANDLE_MINUTES = int(os.getenv("STAFFING_MAX_HANDLE_MINUTES", "60"))
+STAFFING_TARGET_FIRST_RESPONSE_SECONDS = int(os.getenv("STAFFING_TARGET_FIRST_RESPONSE_SECONDS", "300"))
+STAFFING_TARGET_SERVICE_LEVEL = float(os.getenv("STAFFING_TARGET_SERVICE_LEVEL", "0.8"))
+STAFFING_MAX_OCCUPANCY = float(os.getenv("STAFFING_MAX_OCCUPANCY", "0.85"))
+# Chats an agent works in parallel; handle time is divided by this
+STAFFING_CHAT_CONCURRENCY = float(os.getenv("STAFFING_CHAT_CONCURRENCY", "1"))
+# JSON object {"YYYY-MM-DD": volume_factor} or a JSON list of dates (uses the default factor)
+STAFFING_HOLIDAYS = os.getenv("STAFFING_HOLIDAYS", "").strip()
+STAFFING_HOLIDAY_DEFAULT_FACTOR = float(os.getenv("STAFFING_HOLIDAY_DEFAULT_FACTOR", "0.3"))
+
+# Inbound volume anomaly detection (spikes / drops per page and platform)
+VOLUME_ANOMALY_ENABLED = os.getenv("VOLUME_ANOMALY_ENABLED", "false").lower() in {"1", "true", "yes"}
+VOLUME_ANOMALY_INTERVAL_MINUTES = int(os.getenv("VOLUME_ANOMALY_INTERVAL_MINUTES", "10"))
+VOLUME_ANOMALY_WINDOW_MINUTES = int(os.getenv("VOLUME_ANOMALY_WINDOW_MINUTES", "60"))
+VOLUME_ANOMALY_BASELINE_DAYS = int(os.getenv("VOLUME_ANOMALY_BASELINE_DAYS", "14"))
+# low | medium | high (z-score 4 / 3 / 2); can be overridden per scope
+VOLUME_ANOMALY_SENSITIVITY = os.getenv("VOLUME_ANOMALY_SENSITIVITY", "medium").strip().lower() or "medium"
+VOLUME_ANOMALY_MIN_SPIKE_COUNT = int(os.getenv("VOLUME_ANOMALY_MIN_SPIKE_COUNT", "20"))
+VOLUME_ANOMALY_MIN_DROP_EXPECTED = float(os.getenv("VOLUME_ANOMALY_MIN_DROP_EXPECTED", "10"))
+VOLUME_ANOMALY_ALERT_COOLDOWN_MINUTES = int(os.getenv("VOLUME_ANOMALY_ALERT_COOLDOWN_MINUTES", "120"))
+
+# Data subject access (DSAR) exports; archives contain personal data, keep the directory private
+DSAR_EXPORT_DIR = os.getenv("DSAR_EXPORT_DIR", "").strip() or str(ROOT_DIR / "exports")
+DSAR_EXPORT_RETENTION_DAYS = int(os.getenv("DSAR_EXPORT_RETENTION_DAYS", "7"))
+# Optional admin CRM route returning inquiries for {"mobile": ..., "email": ...}
+DSAR_CRM_INQUIRY_ROUTE = os.getenv("DSAR_CRM_INQUIRY_ROUTE", "").strip()
+
+# Field-level encryption of message content, attachment metadata and raw webhook payloads.
+# FIELD_ENCRYPTION_KEYS: {"<workspace>": {"active": "<key_id>", "keys": {"<key_id>": "<base64 32-byte key>"}}}
+FIELD_ENCRYPTION_ENABLED = os.getenv("FIELD_ENCRYPTION_ENABLED", "false").lower() in {"1", "true", "yes"}
+FIELD_ENCRYPTION_WORKSPACE = os.getenv("FIELD_ENCRYPTION_WORKSPACE", "").strip() or os.getenv("BID", "").strip() or "default"
+FIELD_ENCRYPTION_KEYS = os.getenv("FIELD_ENCRYPTION_KEYS", "").strip()
+# HMAC key for the searchable blind index; keep it separate from the encryption keys
+FIELD_BLIND_INDEX_KEY = os.getenv("FIELD_BLIND_INDEX_KEY", "").strip()
+
+# Lead scoring (rules are edited at /api/admin/lead-scoring/rules)
+LEAD_SCORE_RECOMPUTE_INTERVAL_MINUTES = int(os.getenv("LEAD_SCORE_RECOMPUTE_INTERVAL_MINUTES", "60"))
+LEAD_SCORE_ACTIVE_DAYS = int(os.getenv("LEAD_SCORE_ACTIVE_DAYS", "14"))
+# Send hot leads to the least-loaded agent with spare capacity instead of round-robin
+LEAD_PRIORITY_ROUTING_ENABLED = os.getenv("LEAD_PRIORITY_ROUTING_ENABLED", "false").lower() in {"1", "true", "yes"}
+
+# CRM-owner routing: a chat whose customer shares a phone number already in the admin CRM
+# goes to the employee owning that contact (matched on User.emp_id)
+CRM_OWNER_ROUTING_ENABLED = os.getenv("CRM_OWNER_ROUTING_ENABLED", "false").lower() in {"1", "true", "yes"}
+CRM_OWNER_COUNTRY_CODE = os.getenv("CRM_OWNER_COUNTRY_CODE", "+91").strip()
+CRM_OWNER_CACHE_MINUTES = int(os.getenv("CRM_OWNER_CACHE_MINUTES", "360"))
+# Numbers the CRM does not know are re-checked sooner
+CRM_OWNER_NEGATIVE_CACHE_MINUTES = int(os.getenv("CRM_OWNER_NEGATIVE_CACHE_MINUTES", "30"))
+
+# Emergency mode (toggled at /api/admin/emergency-mode)
+EMERGENCY_DEFAULT_AUTO_REPLY = os.getenv("EMERGENCY_DEFAULT_AUTO_REPLY", "").strip() or (
+    "Thanks for your message! We are temporarily unavailable and will get back to you as soon as possible."
+)
+# At most one auto-reply per chat within this window
+EMERGENCY_AUTO_REPLY_COOLDOWN_MINUTES = int(os.getenv("EMERGENCY_AUTO_REPLY_COOLDOWN_MINUTES", "60"))
+
+# Passwordless sign-in (magic link / 6-digit email code); also enable it per position
+PASSWORDLESS_LOGIN_ENABLED = os.getenv("PASSWORDLESS_LOGIN_ENABLED", "false").lower() in {"1", "true", "yes"}
+LOGIN_CODE_LIFETIME_MINUTES = int(os.getenv("LOGIN_CODE_LIFETIME_MINUTES", "10"))
+LOGIN_CODE_MAX_ATTEMPTS = int(os.getenv("LOGIN_CODE_MAX_ATTEMPTS", "5"))
+# At most LOGIN_CODE_MAX_REQUESTS codes/links per account within the window
+LOGIN_CODE_MAX_REQUESTS = int(os.getenv("LOGIN_CODE_MAX_REQUESTS", "3"))
+LOGIN_CODE_REQUEST_WINDOW_MINUTES = int(os.getenv("LOGIN_CODE_REQUEST_WINDOW_MINUTES", "15"))
+LOGIN_CODE_EMAIL_SUBJECT = os.getenv("LOGIN_CODE_EMAIL_SUBJECT", "").strip() or "Your TickleGram sign-in link"
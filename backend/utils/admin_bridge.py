@@ -0,0 +1,54 @@
+import logging
+import os
+from typing import Any, Dict, Optional
+
+import requests
+
+logger = logging.getLogger(__name__)
+
+
+def admin_bridge_configured() -> bool:
+    return bool(os.environ.get("ADMIN_URL") and os.environ.get("FORM_TOKEN"))
+
+
+def post_admin_route(route: str, data: Dict[str, Any], timeout: int = 10) -> Optional[Dict[str, Any]]:
+    """
+    POST to an admin CRM route (e.g. /routes/contactRoute.php?action=...) using the
+    bridge credentials from the environment. Returns the decoded JSON body, or None
+    when the bridge is not configured or the call fails.
+    """
+    admin_url = os.environ.get("ADMIN_URL")
+    form_token = os.environ.get("FORM_TOKEN")
+    if not admin_url or not form_token:
+        logger.info("Admin bridge not configured; skipping %s", route)
+        return None
+
+    bid = os.environ.get("BID") or ""
+    body = {"form_token": form_token, "bid": bid, **data}
+    headers = {
+        "uid": os.environ.get("UID") or "",
+        "bid": bid,
+        "Content-Type": "application/json",
+    }
+    authorization = os.environ.get("AUTHORIZATION")
+    if authorization:
+        headers["Authorization"] = authorization
+    admin_cookie = os.environ.get("ADMIN_COOKIE")
+    if admin_cookie:
+        headers["Cookie"] = admin_cookie
+
+    target = admin_url.rstrip("/") + "/" + route.lstrip("/")
+    try:
+        resp = requests.post(target, json=body, headers=headers, timeout=timeout)
+    except requests.RequestException as exc:
+        logger.warning("Admin bridge call %s failed: %s", route, exc)
+        return None
+    if resp.status_code >= 400:
+        logger.warning("Admin bridge call %s bad status %s: %s", route, resp.status_code, resp.text)
+        return None
+    try:
+        result = resp.json()
+    except ValueError:
+        logger.warning("Admin bridge call %s non-JSON response: %s", route, resp.text)
+        return None
+    return result if isinstance(result, dict) else {"data": result}
@@ -0,0 +1,95 @@
+import asyncio
+import logging
+from typing import Any, Dict, List, Optional, Set
+
+import httpx
+from sqlalchemy.orm import Session
+
+from models import User, UserRole
+from settings import ALERT_CHAT_WEBHOOK_URL, ALERT_EMAIL_ENABLED, ALERT_EMAIL_RECIPIENTS
+from utils.mailer import send_email
+from utils.timezone import utc_now
+from websocket_manager import manager as ws_manager
+
+logger = logging.getLogger(__name__)
+
+
+def _admin_users(db: Session) -> List[User]:
+    return (
+        db.query(User)
+        .filter(User.role == UserRole.ADMIN)
+        .filter(User.is_active.is_(True))
+        .all()
+    )
+
+
+def _format_alert_text(title: str, message: str, details: Optional[Dict[str, Any]]) -> str:
+    lines = [message, ""]
+    for key, value in (details or {}).items():
+        if isinstance(value, list):
+            lines.append(f"{key}:")
+            lines.extend(f"  - {item}" for item in value)
+        else:
+            lines.append(f"{key}: {value}")
+    return "\n".join(lines).strip()
+
+
+async def _post_chat_webhook(title: str, text: str, severity: str) -> None:
+    """Post to a Slack/Teams/Google Chat style incoming webhook ({"text": ...} payload)."""
+    if not ALERT_CHAT_WEBHOOK_URL:
+        return
+    icon = ":rotating_light:" if severity == "critical" else ":warning:"
+    try:
+        async with httpx.AsyncClient(timeout=10) as client:
+            response = await client.post(ALERT_CHAT_WEBHOOK_URL, json={"text": f"{icon} *{title}*\n{text}"})
+        if response.status_code >= 400:
+            logger.warning("Chat alert webhook returned %s: %s", response.status_code, response.text[:200])
+    except httpx.HTTPError as exc:
+        logger.warning("Chat alert webhook failed: %s", exc)
+
+
+async def send_admin_alert(
+    db: Session,
+    alert_type: str,
+    title: str,
+    message: str,
+    details: Optional[Dict[str, Any]] = None,
+    severity: str = "warning",
+) -> None:
+    """
+    Notify admins about an operational problem over WebSocket, the chat-tool
+    webhook (ALERT_CHAT_WEBHOOK_URL) and email.
+
+    Delivery failures are logged and never raised so background jobs keep running.
+    """
+    admins = _admin_users(db)
+    payload = {
+        "type": "admin_alert",
+        "alert_type": alert_type,
+        "severity": severity,
+        "title": title,
+        "message": message,
+        "details": details or {},
+        "created_at": utc_now().isoformat(),
+    }
+    user_ids: Set[str] = {str(admin.id) for admin in admins}
+    if user_ids:
+        await ws_manager.broadcast_to_users(user_ids, payload)
+
+    text = _format_alert_text(title, message, details)
+    await _post_chat_webhook(title, text, severity)
+
+    if not ALERT_EMAIL_ENABLED:
+        return
+    recipients = set(ALERT_EMAIL_RECIPIENTS) or {admin.email for admin in admins if admin.email}
+    if not recipients:
+        return
+    try:
+        await asyncio.to_thread(
+            send_email,
+            f"[TickleGram] {title}",
+            text,
+            sorted(recipients),
+        )
+    except Exception as exc:
+        logger.warning("Failed to email %s alert: %s", alert_type, exc)
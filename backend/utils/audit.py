@@ -0,0 +1,27 @@
+import json
+from typing import Any, Dict, Optional
+
+from sqlalchemy.orm import Session
+
+from models import AuditLog, User
+
+
+def record_audit(
+    db: Session,
+    actor: Optional[User],
+    action: str,
+    entity_type: str,
+    entity_id: Optional[str] = None,
+    details: Optional[Dict[str, Any]] = None,
+) -> AuditLog:
+    """Stage an audit trail entry on the session; the caller commits."""
+    entry = AuditLog(
+        actor_id=actor.id if actor else None,
+        actor_label=(actor.email or actor.name) if actor else "system",
+        action=action,
+        entity_type=entity_type,
+        entity_id=str(entity_id) if entity_id is not None else None,
+        details_json=json.dumps(details, default=str) if details else None,
+    )
+    db.add(entry)
+    return entry
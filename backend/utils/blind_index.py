@@ -0,0 +1,38 @@
+from typing import Iterable, List, Optional
+
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+
+from models import BlindIndexToken
+from utils.field_crypto import blind_token
+
+
+def _matching_ids(db: Session, entity_type: str, hashes: List[str]):
+    return (
+        db.query(BlindIndexToken.entity_id)
+        .filter(BlindIndexToken.entity_type == entity_type, BlindIndexToken.token_hash.in_(hashes))
+        .group_by(BlindIndexToken.entity_id)
+        .having(func.count(func.distinct(BlindIndexToken.token_hash)) == len(hashes))
+    )
+
+
+def blind_index_subquery(db: Session, entity_type: str, tokens: Iterable[str]):
+    """
+    Matching ids as a subquery (column `entity_id`) to join against, so the
+    caller can order and limit the joined rows; None when there are no tokens.
+    """
+    hashes = sorted({blind_token(token) for token in tokens})
+    if not hashes:
+        return None
+    return _matching_ids(db, entity_type, hashes).subquery()
+
+
+def find_by_blind_index(db: Session, entity_type: str, tokens: Iterable[str], limit: Optional[int] = None) -> List[str]:
+    """Ids of rows whose indexed columns contain every token (see field_crypto.index_tokens)."""
+    hashes = sorted({blind_token(token) for token in tokens})
+    if not hashes:
+        return []
+    query = _matching_ids(db, entity_type, hashes)
+    if limit:
+        query = query.limit(limit)
+    return [entity_id for (entity_id,) in query.all()]
@@ -0,0 +1,38 @@
+"""Phone number / email extraction shared by DSAR matching and the search index."""
+import re
+from typing import List, Optional
+
+PHONE_RUN_RE = re.compile(r"\+?\d[\d\s\-().]{6,}\d")
+EMAIL_RE = re.compile(r"[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}")
+PHONE_MATCH_DIGITS = 10
+
+
+def normalize_phone(value: Optional[str]) -> Optional[str]:
+    """Last 10 digits of a phone number, so +91 98661 18236 and 098661-18236 compare equal."""
+    digits = re.sub(r"\D", "", value or "")
+    if len(digits) < 7:
+        return None
+    return digits[-PHONE_MATCH_DIGITS:]
+
+
+def normalize_email(value: Optional[str]) -> Optional[str]:
+    value = (value or "").strip().lower()
+    return value if EMAIL_RE.fullmatch(value) else None
+
+
+def extract_phones(text: Optional[str]) -> List[str]:
+    phones: List[str] = []
+    for run in PHONE_RUN_RE.findall(text or ""):
+        phone = normalize_phone(run)
+        if phone and phone not in phones:
+            phones.append(phone)
+    return phones
+
+
+def extract_emails(text: Optional[str]) -> List[str]:
+    emails: List[str] = []
+    for found in EMAIL_RE.findall(text or ""):
+        found = found.lower()
+        if found not in emails:
+            emails.append(found)
+    return emails
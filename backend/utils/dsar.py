@@ -0,0 +1,453 @@
+"""
+Data subject access request (DSAR) exports.
+
+Collects everything stored about one contact (Instagram/Facebook user, or the
+contacts that shared a given phone number / email address) into a zip archive
+with JSON files per section and an index.html for humans.
+"""
+import hashlib
+import html
+import json
+import logging
+import zipfile
+from datetime import datetime
+from pathlib import Path
+from typing import Any, Dict, Iterable, List, Optional, Set
+
+from sqlalchemy import String, cast, func
+from sqlalchemy.orm import Session
+
+from models import (
+    Chat,
+    FacebookMessage,
+    FacebookUser,
+    FacebookWebhookEvent,
+    InstagramComment,
+    InstagramMarketingEvent,
+    InstagramMessage,
+    InstagramMessageLog,
+    InstagramUser,
+    MessageSender,
+    PaymentRequest,
+)
+from utils.admin_bridge import post_admin_route
+from utils.blind_index import find_by_blind_index
+from utils.contact_identifiers import extract_emails, extract_phones, normalize_email, normalize_phone
+from utils.field_crypto import blind_index_enabled, open_metadata, require_blind_index_for_search
+from utils.timezone import utc_now
+
+logger = logging.getLogger(__name__)
+
+SUBJECT_PLATFORM_USER = "platform_user"
+SUBJECT_PHONE = "phone"
+SUBJECT_EMAIL = "email"
+SUBJECT_TYPES = (SUBJECT_PLATFORM_USER, SUBJECT_PHONE, SUBJECT_EMAIL)
+
+CUSTOMER_SENDERS = (MessageSender.INSTAGRAM_USER, MessageSender.FACEBOOK_USER)
+
+# Sections this system has no storage for; they are listed in the index so the
+# requester can see they were checked.
+NOT_STORED_SECTIONS = {
+    "consent": "No consent history is stored by TickleGram; consent is captured in the CRM.",
+    "notes": "No contact notes are stored by TickleGram.",
+}
+
+
+def text_mentions_phone(text: Optional[str], phone: str) -> bool:
+    return phone in extract_phones(text)
+
+
+def text_mentions_email(text: Optional[str], email: str) -> bool:
+    return email in extract_emails(text)
+
+
+def hashed_identifiers(phone: Optional[str], email: Optional[str]) -> List[str]:
+    """SHA-256 forms used by Meta conversion events (user_data.ph / user_data.em)."""
+    hashes: List[str] = []
+    if email:
+        hashes.append(hashlib.sha256(email.encode("utf-8")).hexdigest())
+    if phone:
+        for candidate in {phone, f"91{phone}"}:
+            hashes.append(hashlib.sha256(candidate.encode("utf-8")).hexdigest())
+    return hashes
+
+
+def _iso(value: Optional[datetime]) -> Optional[str]:
+    return value.isoformat() if value else None
+
+
+def _loads(raw: Optional[str]) -> Any:
+    if not raw:
+        return None
+    try:
+        return json.loads(raw)
+    except (TypeError, ValueError):
+        return raw
+
+
+def _with_deleted(query):
+    # Trashed chats are still data we hold.
+    return query.execution_options(include_deleted=True)
+
+
+def resolve_contacts(db: Session, subject_type: str, subject_value: str) -> Dict[str, Set[str]]:
+    """Find the Instagram/Facebook user ids that belong to the subject."""
+    # Without the blind index, LIKE would silently run against ciphertext and miss data.
+    require_blind_index_for_search()
+    instagram_ids: Set[str] = set()
+    facebook_ids: Set[str] = set()
+    value = (subject_value or "").strip()
+
+    if subject_type == SUBJECT_PLATFORM_USER:
+        if db.query(InstagramUser.igsid).filter(InstagramUser.igsid == value).first():
+            instagram_ids.add(value)
+        if db.query(FacebookUser.id).filter(FacebookUser.id == value).first():
+            facebook_ids.add(value)
+        return {"instagram": instagram_ids, "facebook": facebook_ids}
+
+    if subject_type == SUBJECT_PHONE:
+        phone = normalize_phone(value)
+        if not phone:
+            raise ValueError("Phone number is too short")
+        # LIKE prefilter with common separators stripped, exact check in Python.
+        pattern = f"%{phone}%"
+        token = f"phone:{phone}"
+
+        def matches(text: Optional[str]) -> bool:
+            return text_mentions_phone(text, phone)
+    elif subject_type == SUBJECT_EMAIL:
+        email = normalize_email(value)
+        if not email:
+            raise ValueError("Invalid email address")
+        pattern = f"%{email}%"
+        token = f"email:{email}"
+
+        def matches(text: Optional[str]) -> bool:
+            return text_mentions_email(text, email)
+    else:
+        raise ValueError(f"Unknown subject type '{subject_type}'")
+
+    # Only what the customer wrote identifies them; agents may quote other numbers.
+    for model, user_column, target, entity_type in (
+        (InstagramMessage, InstagramMessage.instagram_user_id, instagram_ids, "instagram_message"),
+        (FacebookMessage, FacebookMessage.facebook_user_id, facebook_ids, "facebook_message"),
+    ):
+        query = db.query(user_column, model.content).filter(model.sender.in_(CUSTOMER_SENDERS))
+        if blind_index_enabled():
+            # Encrypted content cannot be LIKE-searched; rows written before encryption
+            # are indexed by the encryption backfill.
+            query = query.filter(model.id.in_(find_by_blind_index(db, entity_type, [token]) or [""]))
+        else:
+            searchable = func.lower(model.content)
+            if subject_type == SUBJECT_PHONE:
+                for separator in (" ", "-", ".", "(", ")"):
+                    searchable = func.replace(searchable, separator, "")
+            query = query.filter(searchable.like(pattern))
+        rows = query.all()
+        target.update(user_id for user_id, content in rows if user_id and matches(content))
+    return {"instagram": instagram_ids, "facebook": facebook_ids}
+
+
+def _message_dict(message: Any, attachment_files: List[Dict[str, Any]]) -> Dict[str, Any]:
+    attachments = _loads(message.attachments_json) or []
+    if isinstance(attachments, list):
+        for attachment in attachments:
+            if isinstance(attachment, dict) and attachment.get("local_path"):
+                attachment_files.append({"message_id": message.id, "local_path": attachment["local_path"]})
+    return {
+        "id": message.id,
+        "mid": message.mid,
+        "sender": message.sender.value,
+        "content": message.content,
+        "message_type": message.message_type.value if message.message_type else None,
+        "timestamp": _iso(message.timestamp),
+        "attachments": attachments,
+        "metadata": open_metadata(_loads(message.metadata_json)),
+    }
+
+
+def collect_subject_data(
+    db: Session,
+    subject_type: str,
+    subject_value: str,
+    crm_inquiry_route: Optional[str] = None,
+) -> Dict[str, Any]:
+    """Assemble every section of the export as plain JSON-serialisable data."""
+    contacts = resolve_contacts(db, subject_type, subject_value)
+    instagram_ids = sorted(contacts["instagram"])
+    facebook_ids = sorted(contacts["facebook"])
+    phone = normalize_phone(subject_value) if subject_type == SUBJECT_PHONE else None
+    email = normalize_email(subject_value) if subject_type == SUBJECT_EMAIL else None
+
+    profiles: List[Dict[str, Any]] = []
+    for user in db.query(InstagramUser).filter(InstagramUser.igsid.in_(instagram_ids or [""])).all():
+        profiles.append({
+            "platform": "instagram",
+            "id": user.igsid,
+            "username": user.username,
+            "name": user.name,
+            "first_seen_at": _iso(user.first_seen_at),
+            "last_seen_at": _iso(user.last_seen_at),
+            "last_message": user.last_message,
+        })
+    for user in db.query(FacebookUser).filter(FacebookUser.id.in_(facebook_ids or [""])).all():
+        profiles.append({
+            "platform": "facebook",
+            "id": user.id,
+            "username": user.username,
+            "name": user.name,
+            "profile_pic_url": user.profile_pic_url,
+            "first_seen_at": _iso(user.first_seen_at),
+            "last_seen_at": _iso(user.last_seen_at),
+            "last_message": user.last_message,
+        })
+
+    chats = _with_deleted(db.query(Chat)).filter(
+        (Chat.instagram_user_id.in_(instagram_ids or [""])) | (Chat.facebook_user_id.in_(facebook_ids or [""]))
+    ).order_by(Chat.created_at.asc()).all()
+    chat_ids = [chat.id for chat in chats]
+
+    attachment_files: List[Dict[str, Any]] = []
+    conversations: List[Dict[str, Any]] = []
+    for chat in chats:
+        model = InstagramMessage if chat.instagram_user_id else FacebookMessage
+        messages = db.query(model).filter(model.chat_id == chat.id).order_by(model.timestamp.asc()).all()
+        conversations.append({
+            "chat_id": chat.id,
+            "platform": chat.platform.value,
+            "page_id": chat.facebook_page_id,
+            "username": chat.username,
+            "status": chat.status.value if chat.status else None,
+            "created_at": _iso(chat.created_at),
+            "deleted_at": _iso(chat.deleted_at),
+            "messages": [_message_dict(message, attachment_files) for message in messages],
+        })
+
+    message_logs = [
+        {
+            "id": log.id,
+            "igsid": log.igsid,
+            "message_id": log.message_id,
+            "direction": log.direction.value,
+            "text": log.text,
+            "attachments": _loads(log.attachments_json),
+            "ts": log.ts,
+            "created_at": _iso(log.created_at),
+            "raw_payload": _loads(log.raw_payload_json),
+            "metadata": _loads(log.metadata_json),
+        }
+        for log in db.query(InstagramMessageLog)
+        .filter(InstagramMessageLog.igsid.in_(instagram_ids or [""]))
+        .order_by(InstagramMessageLog.ts.asc())
+        .all()
+    ]
+
+    webhook_events: List[Dict[str, Any]] = []
+    seen_webhook_events: Set[str] = set()
+    for facebook_id in facebook_ids:
+        query = db.query(FacebookWebhookEvent)
+        if blind_index_enabled():
+            event_ids = find_by_blind_index(db, "facebook_webhook_event", [facebook_id])
+            query = query.filter(FacebookWebhookEvent.id.in_(event_ids or [""]))
+        else:
+            query = query.filter(cast(FacebookWebhookEvent.payload, String).like(f'%"{facebook_id}"%'))
+        for event in query.order_by(FacebookWebhookEvent.received_at.asc()).all():
+            if event.id in seen_webhook_events:
+                continue
+            seen_webhook_events.add(event.id)
+            webhook_events.append({
+                "id": event.id,
+                "page_id": event.page_id,
+                "received_at": _iso(event.received_at),
+                "payload": event.payload,
+            })
+
+    comments = [
+        {
+            "id": comment.id,
+            "media_id": comment.media_id,
+            "text": comment.text,
+            "hidden": comment.hidden,
+            "action": comment.action.value if comment.action else None,
+            "attachments": _loads(comment.attachments_json),
+            "created_at": _iso(comment.created_at),
+        }
+        for comment in db.query(InstagramComment)
+        .filter(InstagramComment.author_id.in_(instagram_ids or [""]))
+        .order_by(InstagramComment.created_at.asc())
+        .all()
+    ]
+
+    payments = [
+        {
+            "id": payment.id,
+            "chat_id": payment.chat_id,
+            "reference": payment.reference,
+            "inquiry_id": payment.inquiry_id,
+            "amount": str(payment.amount),
+            "currency": payment.currency,
+            "description": payment.description,
+            "provider": payment.provider,
+            "status": payment.status.value,
+            "created_at": _iso(payment.created_at),
+            "paid_at": _iso(payment.paid_at),
+        }
+        for payment in db.query(PaymentRequest).filter(PaymentRequest.chat_id.in_(chat_ids or [""])).all()
+    ]
+
+    inquiries: Dict[str, Any] = {
+        "linked_inquiry_ids": sorted({p["inquiry_id"] for p in payments if p["inquiry_id"]}),
+        "crm_lookup": None,
+    }
+    if crm_inquiry_route and (phone or email):
+        inquiries["crm_lookup"] = post_admin_route(crm_inquiry_route, {"mobile": phone, "email": email})
+
+    marketing_needles = list(instagram_ids) + hashed_identifiers(phone, email)
+    if phone:
+        marketing_needles.append(phone)
+    if email:
+        marketing_needles.append(email)
+    marketing_events: List[Dict[str, Any]] = []
+    seen_events: Set[str] = set()
+    for needle in marketing_needles:
+        for event in (
+            db.query(InstagramMarketingEvent)
+            .filter(InstagramMarketingEvent.payload_json.like(f"%{needle}%"))
+            .all()
+        ):
+            if event.id in seen_events:
+                continue
+            seen_events.add(event.id)
+            marketing_events.append({
+                "id": event.id,
+                "event_name": event.event_name,
+                "value": event.value,
+                "currency": event.currency,
+                "status": event.status,
+                "ts": event.ts,
+                "payload": _loads(event.payload_json),
+                "created_at": _iso(event.created_at),
+            })
+
+    return {
+        "subject": {"type": subject_type, "value": subject_value},
+        "contacts": {"instagram": instagram_ids, "facebook": facebook_ids},
+        "profiles": profiles,
+        "conversations": conversations,
+        "instagram_message_logs": message_logs,
+        "facebook_webhook_events": webhook_events,
+        "comments": comments,
+        "inquiries": inquiries,
+        "payment_requests": payments,
+        "marketing_events": marketing_events,
+        "consent": [],
+        "notes": [],
+        "attachment_files": attachment_files,
+    }
+
+
+SECTION_FILES = (
+    ("profiles", "profiles.json", "Instagram / Facebook profile records"),
+    ("conversations", "conversations.json", "Chats with every message, attachment metadata and message metadata"),
+    ("instagram_message_logs", "instagram_message_logs.json", "Raw Instagram message log entries"),
+    ("facebook_webhook_events", "facebook_webhook_events.json", "Raw Facebook webhook payloads mentioning the contact"),
+    ("comments", "comments.json", "Instagram comments written by the contact"),
+    ("inquiries", "inquiries.json", "Inquiry references and CRM lookup"),
+    ("payment_requests", "payment_requests.json", "Payment links sent in the contact's chats"),
+    ("marketing_events", "marketing_events.json", "Conversion events sent to Meta for the contact"),
+    ("consent", "consent.json", "Consent history"),
+    ("notes", "notes.json", "Notes"),
+)
+
+
+def section_count(data: Any) -> int:
+    if isinstance(data, list):
+        return len(data)
+    if isinstance(data, dict):
+        return len(data.get("linked_inquiry_ids") or []) + (1 if data.get("crm_lookup") else 0)
+    return 0
+
+
+def render_index(data: Dict[str, Any], generated_at: datetime, export_id: str) -> str:
+    """Human-readable overview with a transcript of every conversation."""
+    esc = html.escape
+    rows = []
+    for key, filename, description in SECTION_FILES:
+        note = NOT_STORED_SECTIONS.get(key, "")
+        rows.append(
+            f"<tr><td>{esc(description)}</td><td><code>{esc(filename)}</code></td>"
+            f"<td>{section_count(data.get(key))}</td><td>{esc(note)}</td></tr>"
+        )
+    transcripts = []
+    for conversation in data["conversations"]:
+        lines = []
+        for message in conversation["messages"]:
+            who = "Customer" if message["sender"] in {s.value for s in CUSTOMER_SENDERS} else "Business"
+            extra = f" [{len(message['attachments'])} attachment(s)]" if message["attachments"] else ""
+            lines.append(
+                f"<li><small>{esc(message['timestamp'] or '')}</small> <b>{who}:</b> "
+                f"{esc(message['content'] or '')}{esc(extra)}</li>"
+            )
+        transcripts.append(
+            f"<h3>{esc(conversation['platform'].title())} chat with {esc(conversation['username'] or '')}"
+            f" <small>({esc(conversation['chat_id'])})</small></h3><ul>{''.join(lines)}</ul>"
+        )
+    contacts = data["contacts"]
+    return (
+        "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Data export</title></head><body>"
+        f"<h1>Personal data export</h1>"
+        f"<p>Export {esc(export_id)} generated {esc(generated_at.isoformat())} for "
+        f"{esc(data['subject']['type'])} <b>{esc(data['subject']['value'])}</b>.</p>"
+        f"<p>Matched Instagram ids: {esc(', '.join(contacts['instagram']) or 'none')}; "
+        f"Facebook ids: {esc(', '.join(contacts['facebook']) or 'none')}.</p>"
+        "<h2>Contents</h2><table border=\"1\" cellpadding=\"4\"><tr><th>Section</th><th>File</th><th>Records</th><th>Note</th></tr>"
+        f"{''.join(rows)}</table>"
+        "<p>Files stored by us are under <code>attachments/</code>; other attachments are listed by their original URL.</p>"
+        f"<h2>Conversations</h2>{''.join(transcripts) or '<p>No conversations.</p>'}"
+        "</body></html>"
+    )
+
+
+def write_export_archive(
+    data: Dict[str, Any],
+    target: Path,
+    attachments_root: Path,
+    export_id: str,
+    generated_at: Optional[datetime] = None,
+) -> Dict[str, int]:
+    """Write the zip and return per-section record counts."""
+    generated_at = generated_at or utc_now()
+    target.parent.mkdir(parents=True, exist_ok=True)
+    counts = {key: section_count(data.get(key)) for key, _, _ in SECTION_FILES}
+    counts["attachment_files"] = 0
+    with zipfile.ZipFile(target, "w", compression=zipfile.ZIP_DEFLATED) as archive:
+        archive.writestr("index.html", render_index(data, generated_at, export_id))
+        manifest = {
+            "export_id": export_id,
+            "generated_at": generated_at.isoformat(),
+            "subject": data["subject"],
+            "contacts": data["contacts"],
+            "sections": {key: {"file": filename, "records": counts[key]} for key, filename, _ in SECTION_FILES},
+            "not_stored": NOT_STORED_SECTIONS,
+        }
+        archive.writestr("manifest.json", json.dumps(manifest, indent=2, default=str))
+        for key, filename, _ in SECTION_FILES:
+            archive.writestr(filename, json.dumps(data.get(key), indent=2, default=str, ensure_ascii=False))
+        root = attachments_root.resolve()
+        for item in _unique_paths(data.get("attachment_files") or []):
+            source = (root / item).resolve()
+            # Never follow paths outside the attachments directory.
+            if root not in source.parents or not source.is_file():
+                continue
+            archive.write(source, f"attachments/{item}")
+            counts["attachment_files"] += 1
+    return counts
+
+
+def _unique_paths(files: Iterable[Dict[str, Any]]) -> List[str]:
+    seen: List[str] = []
+    for item in files:
+        path = str(item.get("local_path") or "").lstrip("/")
+        if path and path not in seen:
+            seen.append(path)
+    return seen
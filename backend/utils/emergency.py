@@ -0,0 +1,30 @@
+"""Emergency mode state shared by assignment (chat_helpers) and the emergency routes."""
+from datetime import datetime
+from typing import Optional
+
+from sqlalchemy.orm import Session
+
+from models import EmergencyModeState
+from utils.timezone import as_utc, utc_now
+
+
+def get_emergency_state(db: Session) -> Optional[EmergencyModeState]:
+    return db.query(EmergencyModeState).order_by(EmergencyModeState.id.asc()).first()
+
+
+def state_is_active(state: Optional[EmergencyModeState], now: Optional[datetime] = None) -> bool:
+    """Active and not past ends_at (the worker switches it off shortly after)."""
+    if not state or not state.active:
+        return False
+    ends_at = as_utc(state.ends_at)
+    return ends_at is None or ends_at > (now or utc_now())
+
+
+def active_emergency(db: Session) -> Optional[EmergencyModeState]:
+    state = get_emergency_state(db)
+    return state if state_is_active(state) else None
+
+
+def assignment_paused(db: Session) -> bool:
+    state = active_emergency(db)
+    return bool(state and state.pause_assignment)
@@ -0,0 +1,60 @@
+import math
+
+
+def erlang_c(traffic: float, agents: int) -> float:
+    """
+    Probability that a contact has to wait (Erlang C).
+
+    traffic is the offered load in Erlangs (arrivals per second * handle time).
+    Returns 1.0 when the agents cannot keep up with the load.
+    """
+    if traffic <= 0:
+        return 0.0
+    if agents <= traffic:
+        return 1.0
+    # Erlang B via the stable recurrence, then convert to Erlang C.
+    erlang_b = 1.0
+    for n in range(1, agents + 1):
+        erlang_b = traffic * erlang_b / (n + traffic * erlang_b)
+    return agents * erlang_b / (agents - traffic * (1 - erlang_b))
+
+
+def service_level(traffic: float, agents: int, handle_seconds: float, target_seconds: float) -> float:
+    """Share of contacts answered within target_seconds."""
+    if traffic <= 0:
+        return 1.0
+    if agents <= traffic or handle_seconds <= 0:
+        return 0.0
+    waiting = erlang_c(traffic, agents)
+    return 1.0 - waiting * math.exp(-(agents - traffic) * target_seconds / handle_seconds)
+
+
+def average_speed_of_answer(traffic: float, agents: int, handle_seconds: float) -> float:
+    """Expected wait in seconds before the first response."""
+    if traffic <= 0:
+        return 0.0
+    if agents <= traffic:
+        return math.inf
+    return erlang_c(traffic, agents) * handle_seconds / (agents - traffic)
+
+
+def required_agents(
+    contacts: float,
+    handle_seconds: float,
+    interval_seconds: int,
+    target_seconds: float,
+    target_service_level: float,
+    max_occupancy: float = 1.0,
+    max_agents: int = 1000,
+) -> int:
+    """Smallest agent count that meets the service level (and occupancy cap) for the interval."""
+    if contacts <= 0 or handle_seconds <= 0:
+        return 0
+    traffic = contacts * handle_seconds / float(interval_seconds)
+    agents = max(1, int(math.ceil(traffic)))
+    while agents < max_agents:
+        occupancy_ok = max_occupancy <= 0 or traffic / agents <= max_occupancy
+        if occupancy_ok and service_level(traffic, agents, handle_seconds, target_seconds) >= target_service_level:
+            break
+        agents += 1
+    return agents
@@ -0,0 +1,300 @@
+"""
+Application-level encryption for sensitive text columns.
+
+Values are encrypted with AES-256-GCM using the active key of the workspace and
+stored as ``enc:v1:<workspace>:<key_id>:<base64(nonce + ciphertext)>``, so rows
+written with older keys stay readable after a rotation. ``EncryptedText`` and
+``EncryptedJSON`` do this transparently in the ORM; plaintext rows (written
+before encryption was enabled) are returned unchanged. Message text kept in
+the plaintext ``metadata_json`` (reply previews) goes through ``seal_metadata``.
+
+Because encrypted columns cannot be searched with LIKE, selected columns also
+feed a blind index: HMAC-SHA256 of each word / phone number / email under a
+separate key (see ``BlindIndexToken``).
+"""
+import base64
+import hashlib
+import hmac
+import json
+import logging
+import os
+import re
+from typing import Any, Dict, List, Optional, Set, Tuple
+
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from sqlalchemy import JSON, Text
+from sqlalchemy.types import TypeDecorator
+
+from settings import (
+    FIELD_BLIND_INDEX_KEY,
+    FIELD_ENCRYPTION_ENABLED,
+    FIELD_ENCRYPTION_KEYS,
+    FIELD_ENCRYPTION_WORKSPACE,
+)
+from utils.contact_identifiers import extract_emails, extract_phones, normalize_email, normalize_phone
+
+logger = logging.getLogger(__name__)
+
+CIPHERTEXT_PREFIX = "enc:v1:"
+NONCE_BYTES = 12
+UNREADABLE_PLACEHOLDER = "[encrypted content unavailable]"
+WORD_RE = re.compile(r"[^\W_]{3,64}")
+MAX_INDEX_TOKENS = 300
+
+
+class FieldEncryptionError(Exception):
+    """Encryption is misconfigured or a value cannot be decrypted."""
+
+
+def _decode_key(value: str) -> bytes:
+    try:
+        key = base64.urlsafe_b64decode(value + "=" * (-len(value) % 4))
+    except (ValueError, TypeError) as exc:
+        raise FieldEncryptionError("Encryption keys must be base64 encoded") from exc
+    if len(key) not in (16, 24, 32):
+        raise FieldEncryptionError("Encryption keys must be 16, 24 or 32 bytes")
+    return key
+
+
+class FieldKeyring:
+    """Per-workspace data keys plus the blind index key."""
+
+    def __init__(self, workspaces: Dict[str, Dict[str, Any]], workspace: str, index_key: Optional[bytes] = None):
+        self.workspaces = workspaces
+        self.workspace = workspace
+        self.index_key = index_key
+
+    @classmethod
+    def from_config(cls, raw_keys: str, workspace: str, raw_index_key: str = "") -> "FieldKeyring":
+        workspaces: Dict[str, Dict[str, Any]] = {}
+        if raw_keys:
+            try:
+                config = json.loads(raw_keys)
+            except ValueError as exc:
+                raise FieldEncryptionError("FIELD_ENCRYPTION_KEYS is not valid JSON") from exc
+            if not isinstance(config, dict):
+                raise FieldEncryptionError("FIELD_ENCRYPTION_KEYS must be an object keyed by workspace")
+            for name, entry in config.items():
+                keys = (entry or {}).get("keys") or {}
+                if ":" in name or any(":" in key_id for key_id in keys):
+                    raise FieldEncryptionError("Workspace and key ids cannot contain ':'")
+                active = entry.get("active")
+                if active and active not in keys:
+                    raise FieldEncryptionError(f"Active key '{active}' of workspace '{name}' is not in its keys")
+                workspaces[name] = {
+                    "active": active,
+                    "keys": {key_id: _decode_key(value) for key_id, value in keys.items()},
+                }
+        index_key = _decode_key(raw_index_key) if raw_index_key else None
+        return cls(workspaces, workspace, index_key)
+
+    def active_key(self) -> Tuple[str, bytes]:
+        entry = self.workspaces.get(self.workspace) or {}
+        key_id = entry.get("active")
+        if not key_id:
+            raise FieldEncryptionError(f"No active encryption key for workspace '{self.workspace}'")
+        return key_id, entry["keys"][key_id]
+
+    def active_prefix(self) -> str:
+        key_id, _ = self.active_key()
+        return f"{CIPHERTEXT_PREFIX}{self.workspace}:{key_id}:"
+
+    def key(self, workspace: str, key_id: str) -> bytes:
+        key = ((self.workspaces.get(workspace) or {}).get("keys") or {}).get(key_id)
+        if key is None:
+            raise FieldEncryptionError(f"Unknown encryption key '{workspace}:{key_id}'")
+        return key
+
+    def has_key(self, workspace: str, key_id: str) -> bool:
+        return key_id in ((self.workspaces.get(workspace) or {}).get("keys") or {})
+
+
+_keyring: Optional[FieldKeyring] = None
+
+
+def get_keyring() -> FieldKeyring:
+    global _keyring
+    if _keyring is None:
+        _keyring = FieldKeyring.from_config(FIELD_ENCRYPTION_KEYS, FIELD_ENCRYPTION_WORKSPACE, FIELD_BLIND_INDEX_KEY)
+    return _keyring
+
+
+def encryption_enabled() -> bool:
+    return FIELD_ENCRYPTION_ENABLED
+
+
+def blind_index_enabled() -> bool:
+    return FIELD_ENCRYPTION_ENABLED and bool(FIELD_BLIND_INDEX_KEY)
+
+
+def require_blind_index_for_search() -> None:
+    """Encrypted content cannot be matched with LIKE, so searching it needs the blind index."""
+    if FIELD_ENCRYPTION_ENABLED and not FIELD_BLIND_INDEX_KEY:
+        raise FieldEncryptionError("Encrypted messages cannot be searched: FIELD_BLIND_INDEX_KEY is not set")
+
+
+def validate_configuration() -> None:
+    """Fail fast at startup instead of on the first write."""
+    if not FIELD_ENCRYPTION_ENABLED:
+        return
+    ring = get_keyring()
+    ring.active_key()
+    if not ring.index_key:
+        logger.warning("FIELD_BLIND_INDEX_KEY is not set; encrypted messages will not be searchable")
+
+
+def is_encrypted(value: Any) -> bool:
+    return isinstance(value, str) and value.startswith(CIPHERTEXT_PREFIX)
+
+
+def key_reference(value: Any) -> Optional[Tuple[str, str]]:
+    """(workspace, key_id) a stored value was encrypted with, or None for plaintext."""
+    if not is_encrypted(value):
+        return None
+    parts = value[len(CIPHERTEXT_PREFIX):].split(":", 2)
+    if len(parts) != 3:
+        return None
+    return parts[0], parts[1]
+
+
+def encrypt_value(plaintext: str, ring: Optional[FieldKeyring] = None) -> str:
+    ring = ring or get_keyring()
+    key_id, key = ring.active_key()
+    nonce = os.urandom(NONCE_BYTES)
+    aad = f"{ring.workspace}:{key_id}".encode("utf-8")
+    sealed = AESGCM(key).encrypt(nonce, plaintext.encode("utf-8"), aad)
+    encoded = base64.urlsafe_b64encode(nonce + sealed).decode("ascii")
+    return f"{CIPHERTEXT_PREFIX}{ring.workspace}:{key_id}:{encoded}"
+
+
+def decrypt_value(value: str, ring: Optional[FieldKeyring] = None) -> str:
+    ring = ring or get_keyring()
+    parts = value[len(CIPHERTEXT_PREFIX):].split(":", 2)
+    if len(parts) != 3:
+        raise FieldEncryptionError("Malformed encrypted value")
+    workspace, key_id, encoded = parts
+    key = ring.key(workspace, key_id)
+    try:
+        raw = base64.urlsafe_b64decode(encoded)
+        plaintext = AESGCM(key).decrypt(raw[:NONCE_BYTES], raw[NONCE_BYTES:], f"{workspace}:{key_id}".encode("utf-8"))
+    except Exception as exc:
+        raise FieldEncryptionError(f"Cannot decrypt value with key '{workspace}:{key_id}'") from exc
+    return plaintext.decode("utf-8")
+
+
+def encrypt_field(value: Optional[str]) -> Optional[str]:
+    """
+    Encrypt a value written by the application while encryption is on.
+
+    Values that already look like ciphertext are encrypted again: they are
+    customer or agent text, and only the backfill (which reads raw columns)
+    may leave stored ciphertext alone.
+    """
+    if value is None or not FIELD_ENCRYPTION_ENABLED:
+        return value
+    return encrypt_value(value)
+
+
+def decrypt_field(value: Any) -> Any:
+    if not is_encrypted(value):
+        return value
+    try:
+        return decrypt_value(value)
+    except FieldEncryptionError as exc:
+        logger.error("Field decryption failed: %s", exc)
+        return UNREADABLE_PLACEHOLDER
+
+
+# Message text copied into the (plaintext, LIKE-filtered) metadata_json of messages
+ENCRYPTED_METADATA_KEYS = ("reply_preview",)
+
+
+def seal_metadata(metadata: Dict[str, Any]) -> Dict[str, Any]:
+    """Encrypt the message text kept in a metadata document before it is stored."""
+    return {
+        key: encrypt_field(value) if key in ENCRYPTED_METADATA_KEYS and isinstance(value, str) else value
+        for key, value in metadata.items()
+    }
+
+
+def open_metadata(metadata: Any) -> Any:
+    """Decrypt the values seal_metadata encrypted; anything else is returned unchanged."""
+    if not isinstance(metadata, dict):
+        return metadata
+    return {
+        key: decrypt_field(value) if key in ENCRYPTED_METADATA_KEYS else value
+        for key, value in metadata.items()
+    }
+
+
+class EncryptedText(TypeDecorator):
+    """Text column encrypted at rest while FIELD_ENCRYPTION_ENABLED is on."""
+
+    impl = Text
+    cache_ok = True
+
+    def process_bind_param(self, value, dialect):
+        return encrypt_field(value)
+
+    def process_result_value(self, value, dialect):
+        return decrypt_field(value)
+
+    def coerce_compared_value(self, op, value):
+        # Comparisons (LIKE, =) run against the stored value, not an encrypted literal.
+        return Text()
+
+
+class EncryptedJSON(TypeDecorator):
+    """JSON column whose document is stored as an encrypted JSON string."""
+
+    impl = JSON
+    cache_ok = True
+
+    def process_bind_param(self, value, dialect):
+        if value is None or not FIELD_ENCRYPTION_ENABLED:
+            return value
+        return encrypt_value(json.dumps(value, default=str))
+
+    def process_result_value(self, value, dialect):
+        if not is_encrypted(value):
+            return value
+        try:
+            return json.loads(decrypt_value(value))
+        except (FieldEncryptionError, ValueError) as exc:
+            logger.error("Field decryption failed: %s", exc)
+            return None
+
+
+def index_tokens(value: Any) -> Set[str]:
+    """Plain tokens a value is searchable by: words, phone:<last 10 digits>, email:<address>."""
+    if value is None:
+        return set()
+    text = value if isinstance(value, str) else json.dumps(value, default=str)
+    text = text.lower()
+    tokens: Set[str] = set()
+    for word in WORD_RE.findall(text):
+        tokens.add(word)
+        if len(tokens) >= MAX_INDEX_TOKENS:
+            break
+    tokens.update(f"phone:{phone}" for phone in extract_phones(text))
+    tokens.update(f"email:{email}" for email in extract_emails(text))
+    return tokens
+
+
+def query_tokens(term: str) -> List[str]:
+    """Tokens a search term must all match."""
+    term = (term or "").strip()
+    email = normalize_email(term)
+    if email:
+        return [f"email:{email}"]
+    if re.fullmatch(r"[\d\s\-().+]+", term) and normalize_phone(term):
+        return [f"phone:{normalize_phone(term)}"]
+    return sorted(set(WORD_RE.findall(term.lower())))
+
+
+def blind_token(token: str, ring: Optional[FieldKeyring] = None) -> str:
+    ring = ring or get_keyring()
+    if not ring.index_key:
+        raise FieldEncryptionError("FIELD_BLIND_INDEX_KEY is not configured")
+    message = f"{ring.workspace}:{token}".encode("utf-8")
+    return hmac.new(ring.index_key, message, hashlib.sha256).hexdigest()
@@ -1,4 +1,5 @@
 from datetime import datetime, timezone
+from typing import Optional
 from zoneinfo import ZoneInfo
 
 IST = ZoneInfo("Asia/Kolkata")
@@ -9,6 +10,25 @@ def utc_now() -> datetime:
     return datetime.now(timezone.utc)
 
 
+def as_utc(value: Optional[datetime]) -> Optional[datetime]:
+    """Treat naive datetimes read back from the database as UTC."""
+    if value is None:
+        return None
+    return value if value.tzinfo else value.replace(tzinfo=timezone.utc)
+
+
 def now_ist() -> datetime:
     """Return current time in Asia/Kolkata timezone."""
     return utc_now().astimezone(IST)
+
+
+def parse_graph_timestamp(value) -> Optional[datetime]:
+    """Parse Graph API times such as 2024-05-01T10:15:00+0000 into aware UTC datetimes."""
+    if not value:
+        return None
+    for fmt in ("%Y-%m-%dT%H:%M:%S%z", "%Y-%m-%dT%H:%M:%S.%f%z"):
+        try:
+            return datetime.strptime(str(value), fmt).astimezone(timezone.utc)
+        except ValueError:
+            continue
+    return None
@@ -0,0 +1,116 @@
+import importlib
+import importlib.abc
+import importlib.util
+import sys
+from pathlib import Path
+
+import pytest
+
+BACKEND_DIR = Path(__file__).resolve().parent.parent / "backend"
+if str(BACKEND_DIR) not in sys.path:
+    sys.path.insert(0, str(BACKEND_DIR))
+
+
+class _BackendAlias(importlib.abc.MetaPathFinder, importlib.abc.Loader):
+    """
+    Backend modules import each other as top-level modules (`from models import ...`).
+    Resolve `backend.<name>` to those same module objects so the models are not
+    declared twice on one metadata.
+    """
+
+    prefix = "backend."
+
+    def find_spec(self, fullname, path=None, target=None):
+        if fullname.startswith(self.prefix):
+            return importlib.util.spec_from_loader(fullname, self)
+        return None
+
+    def create_module(self, spec):
+        return importlib.import_module(spec.name[len(self.prefix):])
+
+    def exec_module(self, module):
+        pass
+
+
+if not any(isinstance(finder, _BackendAlias) for finder in sys.meta_path):
+    sys.meta_path.insert(0, _BackendAlias())
+
+
+class FakeQuery:
+    """Chainable query over a fixed list of rows; filters are not evaluated."""
+
+    def __init__(self, rows):
+        self.rows = list(rows)
+
+    def _chain(self, *args, **kwargs):
+        return self
+
+    options = execution_options = filter = filter_by = join = order_by = limit = with_entities = _chain
+
+    def first(self):
+        return self.rows[0] if self.rows else None
+
+    def all(self):
+        return list(self.rows)
+
+    def count(self):
+        return len(self.rows)
+
+
+class FakeSession:
+    """
+    In-memory stand-in for a SQLAlchemy session.
+
+    query() answers with the next result queued with queue(), else the result
+    registered for the queried model in `results`, else the added rows that are
+    instances of the model. A list result is a list of rows; anything else is
+    a single row and None is no row.
+    """
+
+    def __init__(self):
+        self.queued = []
+        self.results = {}
+        self.added = []
+        self.deleted = []
+        self.commits = 0
+        self.rollbacks = 0
+
+    def queue(self, *results):
+        self.queued.extend(results)
+        return self
+
+    def query(self, entity, *more):
+        if self.queued:
+            result = self.queued.pop(0)
+        elif entity in self.results:
+            result = self.results[entity]
+        elif isinstance(entity, type):
+            result = [row for row in self.added if isinstance(row, entity)]
+        else:
+            result = None
+        if result is None:
+            return FakeQuery([])
+        return FakeQuery(result if isinstance(result, list) else [result])
+
+    def add(self, obj):
+        self.added.append(obj)
+
+    def delete(self, obj):
+        self.deleted.append(obj)
+
+    def flush(self):
+        pass
+
+    def commit(self):
+        self.commits += 1
+
+    def rollback(self):
+        self.rollbacks += 1
+
+    def refresh(self, obj):
+        pass
+
+
+@pytest.fixture
+def fake_db():
+    return FakeSession()
@@ -0,0 +1,130 @@
+import asyncio
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException
+
+from backend.routes import crm_routing
+from backend.routes.crm_routing import (
+    LOOKUP_FOUND,
+    LOOKUP_NOT_FOUND,
+    LOOKUP_UNAVAILABLE,
+    OUTCOME_ASSIGNED,
+    OUTCOME_NOT_IN_CRM,
+    OUTCOME_PAUSED,
+    apply_crm_owner,
+    clear_owner_cache,
+    lookup_crm_owner,
+    owner_emp_id_from_response,
+    route_chat_to_crm_owner,
+    route_to_crm_owner,
+)
+
+
+def test_owner_emp_id_from_response_shapes():
+    assert owner_emp_id_from_response({"data": [{"c_employee_id": " EMP12 "}]}) == "EMP12"
+    assert owner_emp_id_from_response({"data": {"employee_id": 44}}) == "44"
+    assert owner_emp_id_from_response({"error": 1, "emp_id": "E7", "data": []}) == "E7"
+    assert owner_emp_id_from_response({"data": []}) is None
+    assert owner_emp_id_from_response({"data": [{"c_employee_id": "0"}]}) is None
+    assert owner_emp_id_from_response(None) is None
+
+
+def test_lookup_is_cached_but_failures_are_not(monkeypatch):
+    clear_owner_cache()
+    calls = []
+    responses = [None, {"data": [{"emp_id": "EMP1"}]}]
+
+    def fake_post(route, data, timeout=10):
+        calls.append(data["mobile"])
+        return responses.pop(0)
+
+    monkeypatch.setattr(crm_routing, "post_admin_route", fake_post)
+    assert lookup_crm_owner("9866118236") == (LOOKUP_UNAVAILABLE, None)
+    assert lookup_crm_owner("9866118236") == (LOOKUP_FOUND, "EMP1")
+    assert lookup_crm_owner("9866118236") == (LOOKUP_FOUND, "EMP1")
+    assert calls == ["9866118236", "9866118236"]
+
+
+def test_unknown_numbers_are_cached_too(monkeypatch):
+    clear_owner_cache()
+    calls = []
+
+    def fake_post(route, data, timeout=10):
+        calls.append(data["mobile"])
+        return {"data": []}
+
+    monkeypatch.setattr(crm_routing, "post_admin_route", fake_post)
+    assert lookup_crm_owner("9000000001") == (LOOKUP_NOT_FOUND, None)
+    assert lookup_crm_owner("9000000001") == (LOOKUP_NOT_FOUND, None)
+    assert len(calls) == 1
+    assert clear_owner_cache() == 1
+
+
+def _route(monkeypatch, db, outcome):
+    broadcasts = []
+
+    def fake_apply(db, chat, phone, emp_id, actor=None, keep_engaged=True):
+        if outcome == OUTCOME_ASSIGNED:
+            chat.assigned_to = "owner-1"
+        return {"chat_id": chat.id, "phone": phone, "emp_id": emp_id, "outcome": outcome}
+
+    async def fake_broadcast(db, chat, previous_assigned_to=None):
+        broadcasts.append((chat.assigned_to, previous_assigned_to))
+
+    monkeypatch.setattr(crm_routing, "CRM_OWNER_ROUTING_ENABLED", True)
+    monkeypatch.setattr(crm_routing, "assignment_paused", lambda db: False)
+    monkeypatch.setattr(crm_routing, "lookup_crm_owner", lambda phone: (LOOKUP_FOUND, "EMP1"))
+    monkeypatch.setattr(crm_routing, "apply_crm_owner", fake_apply)
+    monkeypatch.setattr(crm_routing, "broadcast_chat_assignment", fake_broadcast)
+    chat = SimpleNamespace(id="chat-1", assigned_to="agent-1", crm_owner_phone=None)
+    result = asyncio.run(route_to_crm_owner(db, chat, "my number is 98661 18236"))
+    return result, broadcasts
+
+
+def test_crm_owner_assignment_is_broadcast(monkeypatch, fake_db):
+    result, broadcasts = _route(monkeypatch, fake_db, OUTCOME_ASSIGNED)
+    assert result["outcome"] == OUTCOME_ASSIGNED and fake_db.commits == 1
+    assert broadcasts == [("owner-1", "agent-1")]
+
+
+def test_no_broadcast_when_assignment_is_unchanged(monkeypatch, fake_db):
+    result, broadcasts = _route(monkeypatch, fake_db, OUTCOME_NOT_IN_CRM)
+    assert result["outcome"] == OUTCOME_NOT_IN_CRM and fake_db.commits == 1
+    assert broadcasts == []
+
+
+def test_paused_assignment_skips_crm_routing(monkeypatch, fake_db):
+    lookups = []
+    monkeypatch.setattr(crm_routing, "CRM_OWNER_ROUTING_ENABLED", True)
+    monkeypatch.setattr(crm_routing, "assignment_paused", lambda db: True)
+    monkeypatch.setattr(crm_routing, "lookup_crm_owner", lambda phone: lookups.append(phone))
+    chat = SimpleNamespace(id="chat-1", assigned_to=None, crm_owner_phone=None)
+
+    assert asyncio.run(route_to_crm_owner(fake_db, chat, "call 98661 18236")) is None
+    assert lookups == [] and chat.crm_owner_phone is None
+
+
+def test_paused_assignment_keeps_current_agent(monkeypatch, fake_db):
+    owner = SimpleNamespace(id="owner-1")
+    monkeypatch.setattr(crm_routing, "assignment_paused", lambda db: True)
+    monkeypatch.setattr(crm_routing, "find_owner_user", lambda db, emp_id: owner)
+    chat = SimpleNamespace(id="chat-1", assigned_to="agent-1", last_outgoing_at=None)
+
+    result = apply_crm_owner(fake_db, chat, "9866118236", "EMP1", keep_engaged=False)
+
+    assert result["outcome"] == OUTCOME_PAUSED and result["owner_id"] == "owner-1"
+    assert chat.assigned_to == "agent-1" and chat.crm_owner_emp_id == "EMP1"
+
+
+def test_manual_route_rejects_unreadable_phone(monkeypatch, fake_db):
+    lookups = []
+    monkeypatch.setattr(crm_routing, "lookup_crm_owner", lambda phone: lookups.append(phone))
+    monkeypatch.setattr(crm_routing, "latest_customer_phone", lambda db, chat: "9866118236")
+    fake_db.queue(SimpleNamespace(id="chat-1", assigned_to="agent-1"))
+
+    with pytest.raises(HTTPException) as exc_info:
+        asyncio.run(route_chat_to_crm_owner("chat-1", phone="not a number", current_user=None, db=fake_db))
+
+    assert exc_info.value.status_code == 400
+    assert lookups == [] and fake_db.commits == 0
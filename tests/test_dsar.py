@@ -0,0 +1,81 @@
+import hashlib
+import json
+import zipfile
+from datetime import datetime, timezone
+
+from backend.utils.dsar import (
+    hashed_identifiers,
+    normalize_email,
+    normalize_phone,
+    text_mentions_email,
+    text_mentions_phone,
+    write_export_archive,
+)
+
+
+def test_normalize_phone_compares_last_ten_digits():
+    assert normalize_phone("+91 98661-18236") == "9866118236"
+    assert normalize_phone("09866118236") == "9866118236"
+    assert normalize_phone("12345") is None
+
+
+def test_text_mentions_phone_ignores_other_numbers():
+    assert text_mentions_phone("call me on +91 98661 18236 after 5", "9866118236")
+    assert text_mentions_phone("my no. is 98661-18236", "9866118236")
+    assert not text_mentions_phone("order 9866118237 please", "9866118236")
+    assert not text_mentions_phone(None, "9866118236")
+
+
+def test_email_matching_is_exact_and_case_insensitive():
+    assert normalize_email(" Jane@Example.com ") == "jane@example.com"
+    assert normalize_email("not-an-email") is None
+    assert text_mentions_email("mail JANE@example.com thanks", "jane@example.com")
+    assert not text_mentions_email("mail xjane@example.com.au", "jane@example.com")
+
+
+def test_hashed_identifiers_match_meta_user_data():
+    hashes = hashed_identifiers("9866118236", "jane@example.com")
+    assert hashlib.sha256(b"jane@example.com").hexdigest() in hashes
+    assert hashlib.sha256(b"919866118236").hexdigest() in hashes
+
+
+def test_archive_contains_index_manifest_and_local_attachments(tmp_path):
+    attachments_root = tmp_path / "attachments"
+    (attachments_root / "instagram" / "u1").mkdir(parents=True)
+    (attachments_root / "instagram" / "u1" / "m1_0.jpg").write_bytes(b"img")
+    data = {
+        "subject": {"type": "phone", "value": "9866118236"},
+        "contacts": {"instagram": ["u1"], "facebook": []},
+        "profiles": [{"platform": "instagram", "id": "u1", "username": "jane"}],
+        "conversations": [{
+            "chat_id": "c1",
+            "platform": "INSTAGRAM",
+            "username": "jane",
+            "messages": [{
+                "sender": "INSTAGRAM_USER",
+                "content": "<b>hi</b> 98661 18236",
+                "timestamp": "2025-01-01T10:00:00+00:00",
+                "attachments": [{"local_path": "instagram/u1/m1_0.jpg"}],
+            }],
+        }],
+        "consent": [],
+        "notes": [],
+        "attachment_files": [
+            {"message_id": "m1", "local_path": "instagram/u1/m1_0.jpg"},
+            {"message_id": "m2", "local_path": "../../etc/passwd"},
+        ],
+    }
+    target = tmp_path / "out" / "export.zip"
+    counts = write_export_archive(data, target, attachments_root, "e1", datetime(2025, 1, 2, tzinfo=timezone.utc))
+
+    assert counts["conversations"] == 1
+    assert counts["attachment_files"] == 1
+    with zipfile.ZipFile(target) as archive:
+        names = set(archive.namelist())
+        assert {"index.html", "manifest.json", "profiles.json", "consent.json"} <= names
+        assert "attachments/instagram/u1/m1_0.jpg" in names
+        index = archive.read("index.html").decode()
+        assert "&lt;b&gt;hi&lt;/b&gt;" in index
+        manifest = json.loads(archive.read("manifest.json"))
+        assert manifest["sections"]["profiles"]["records"] == 1
+        assert "consent" in manifest["not_stored"]
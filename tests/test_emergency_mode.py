@@ -0,0 +1,217 @@
+import asyncio
+import json
+from datetime import timedelta
+from types import SimpleNamespace
+
+import pytest
+from sqlalchemy.exc import IntegrityError
+
+from backend.models import MessagePlatform
+from backend.routes import announcements as announcement_routes
+from backend.routes import chat_helpers
+from backend.routes import emergency_mode
+from backend.routes.announcements import announcement_is_live
+from backend.routes.emergency_mode import AUTO_REPLY_METADATA_KEY
+from backend.utils.emergency import state_is_active
+from backend.utils.timezone import utc_now
+
+
+def test_emergency_state_expires_at_end_time():
+    now = utc_now()
+    assert not state_is_active(None, now)
+    assert not state_is_active(SimpleNamespace(active=False, ends_at=None), now)
+    assert state_is_active(SimpleNamespace(active=True, ends_at=None), now)
+    assert state_is_active(SimpleNamespace(active=True, ends_at=now + timedelta(minutes=5)), now)
+    assert not state_is_active(SimpleNamespace(active=True, ends_at=now - timedelta(seconds=1)), now)
+    # Naive timestamps from the database are treated as UTC
+    naive_end = (now + timedelta(minutes=5)).replace(tzinfo=None)
+    assert state_is_active(SimpleNamespace(active=True, ends_at=naive_end), now)
+
+
+def test_announcement_live_until_cancelled_or_expired():
+    now = utc_now()
+    assert announcement_is_live(SimpleNamespace(cancelled_at=None, expires_at=None), now)
+    assert announcement_is_live(SimpleNamespace(cancelled_at=None, expires_at=now + timedelta(hours=1)), now)
+    assert not announcement_is_live(SimpleNamespace(cancelled_at=None, expires_at=now - timedelta(hours=1)), now)
+    assert not announcement_is_live(SimpleNamespace(cancelled_at=now, expires_at=None), now)
+
+
+def _emergency(**overrides):
+    values = {
+        "id": 1,
+        "active": True,
+        "auto_reply": "We are closed today due to an emergency.",
+        "pause_assignment": True,
+        "reason": "Flooding",
+        "started_at": utc_now() - timedelta(hours=2),
+        "ends_at": None,
+        "updated_by": None,
+    }
+    values.update(overrides)
+    return SimpleNamespace(**values)
+
+
+def _chat(chat_id="chat-1"):
+    return SimpleNamespace(id=chat_id, platform=MessagePlatform.INSTAGRAM, last_outgoing_at=None)
+
+
+def _patch_auto_reply(monkeypatch, state):
+    sent, broadcasts = [], []
+
+    async def fake_deliver(db, chat, text, **kwargs):
+        sent.append((text, kwargs))
+        return SimpleNamespace(id=f"msg-{len(sent)}")
+
+    async def fake_broadcast(db, chat, message, sender="agent"):
+        broadcasts.append((message.id, sender))
+
+    monkeypatch.setattr(emergency_mode, "active_emergency", lambda db: state)
+    monkeypatch.setattr(emergency_mode, "deliver_chat_text", fake_deliver)
+    monkeypatch.setattr(emergency_mode, "broadcast_chat_message", fake_broadcast)
+    return sent, broadcasts
+
+
+def test_auto_reply_is_sent_once_per_cooldown(monkeypatch, fake_db):
+    state = _emergency()
+    sent, broadcasts = _patch_auto_reply(monkeypatch, state)
+    chat = _chat()
+
+    fake_db.queue(None)
+    assert asyncio.run(emergency_mode.send_emergency_auto_reply(fake_db, chat))
+    text, kwargs = sent[0]
+    assert text == state.auto_reply and kwargs["automated"] is True
+    assert AUTO_REPLY_METADATA_KEY in kwargs["extra_metadata"]
+    assert broadcasts == [("msg-1", "system")] and fake_db.commits == 1
+
+    # An auto-reply inside the cooldown window is found and the next message gets none.
+    fake_db.queue(("msg-1",))
+    assert not asyncio.run(emergency_mode.send_emergency_auto_reply(fake_db, chat))
+    assert len(sent) == 1 and fake_db.commits == 1
+
+
+def test_stored_auto_reply_matches_cooldown_lookup():
+    # _recent_auto_reply finds earlier auto-replies with a LIKE on the plaintext metadata.
+    stored = chat_helpers._merge_message_metadata(
+        None, extra={AUTO_REPLY_METADATA_KEY: {"started_at": None}, "automated": True}
+    )
+    assert f'"{AUTO_REPLY_METADATA_KEY}"' in stored
+    assert json.loads(stored)["automated"] is True
+
+
+def test_no_auto_reply_without_active_emergency(monkeypatch, fake_db):
+    sent, _ = _patch_auto_reply(monkeypatch, None)
+    assert not asyncio.run(emergency_mode.send_emergency_auto_reply(fake_db, _chat()))
+    sent_without_text, _ = _patch_auto_reply(monkeypatch, _emergency(auto_reply=None))
+    assert not asyncio.run(emergency_mode.send_emergency_auto_reply(fake_db, _chat()))
+    assert sent == [] and sent_without_text == [] and fake_db.commits == 0
+
+
+def test_automated_replies_keep_chat_waiting_for_an_agent(monkeypatch, fake_db):
+    monkeypatch.setattr(chat_helpers, "instagram_client", SimpleNamespace(mode=chat_helpers.InstagramMode.MOCK))
+    monkeypatch.setattr(chat_helpers, "facebook_client", SimpleNamespace(mode=chat_helpers.FacebookMode.MOCK))
+    monkeypatch.setattr(chat_helpers, "create_chat_message_record", lambda chat, **kwargs: SimpleNamespace(**kwargs))
+    chat = _chat()
+
+    asyncio.run(chat_helpers.deliver_chat_text(fake_db, chat, "We are closed", automated=True))
+    assert chat.last_outgoing_at is None
+
+    message = asyncio.run(chat_helpers.deliver_chat_text(fake_db, chat, "Hi, I can help now"))
+    assert chat.last_outgoing_at == message.timestamp
+    assert len(fake_db.added) == 2
+
+
+def test_resume_assigns_waiting_chats_except_lead_forms(monkeypatch, fake_db):
+    assigned = []
+    # chat-3 has no agent available
+    monkeypatch.setattr(
+        emergency_mode,
+        "_assign_chat_round_robin",
+        lambda db, chat: assigned.append(chat.id) or chat.id != "chat-3",
+    )
+    waiting, lead_form, unassignable = _chat("chat-1"), _chat("chat-2"), _chat("chat-3")
+    # The chat list, then one lead-form lookup per chat
+    fake_db.queue([waiting, lead_form, unassignable], None, ("lead-msg",), None)
+
+    assert emergency_mode.resume_paused_chats(fake_db, utc_now() - timedelta(hours=1)) == 1
+    assert assigned == ["chat-1", "chat-3"]
+    assert emergency_mode.resume_paused_chats(fake_db, None) == 0
+
+
+def _patch_deactivate(monkeypatch):
+    audits, resumed_since = [], []
+    monkeypatch.setattr(
+        emergency_mode,
+        "record_audit",
+        lambda db, actor, action, entity_type, entity_id=None, details=None: audits.append((action, details)),
+    )
+    monkeypatch.setattr(emergency_mode, "resume_paused_chats", lambda db, since: resumed_since.append(since) or 2)
+    return audits, resumed_since
+
+
+def test_deactivate_resumes_only_paused_assignment(monkeypatch, fake_db):
+    audits, resumed_since = _patch_deactivate(monkeypatch)
+    admin = SimpleNamespace(id="admin-1")
+    state = _emergency()
+
+    assert emergency_mode.deactivate_emergency(fake_db, state, admin, "emergency_mode.deactivate") == 2
+    assert state.active is False and state.updated_by == "admin-1"
+    assert resumed_since == [state.started_at]
+    assert audits[0][0] == "emergency_mode.deactivate" and audits[0][1]["chats_assigned_on_resume"] == 2
+
+    unpaused = _emergency(pause_assignment=False)
+    assert emergency_mode.deactivate_emergency(fake_db, unpaused, admin, "emergency_mode.deactivate") == 0
+    assert len(resumed_since) == 1
+
+
+def test_expired_emergency_resumes_automatically(monkeypatch, fake_db):
+    audits, resumed_since = _patch_deactivate(monkeypatch)
+    broadcasts = []
+
+    async def fake_broadcast(state):
+        broadcasts.append(state.active)
+
+    now = utc_now()
+    state = _emergency(ends_at=now - timedelta(minutes=1))
+    monkeypatch.setattr(emergency_mode, "get_emergency_state", lambda db: state)
+    monkeypatch.setattr(emergency_mode, "broadcast_emergency_state", fake_broadcast)
+
+    assert asyncio.run(emergency_mode.expire_emergency_mode(fake_db, now))
+    assert state.active is False and fake_db.commits == 1
+    assert [action for action, _ in audits] == ["emergency_mode.auto_resume"]
+    assert resumed_since == [state.started_at] and broadcasts == [False]
+
+    # Still running: nothing happens
+    running = _emergency(ends_at=now + timedelta(minutes=1))
+    monkeypatch.setattr(emergency_mode, "get_emergency_state", lambda db: running)
+    assert not asyncio.run(emergency_mode.expire_emergency_mode(fake_db, now))
+    assert running.active is True and fake_db.commits == 1
+
+
+def test_concurrent_acknowledgements_return_the_stored_ack(monkeypatch, fake_db):
+    announcement = SimpleNamespace(id="ann-1")
+    existing = SimpleNamespace(id="ack-1", announcement_id="ann-1", user_id="agent-1")
+
+    def duplicate_commit():
+        raise IntegrityError("INSERT INTO announcement_acks", {}, Exception("uq_announcement_ack_user"))
+
+    monkeypatch.setattr(fake_db, "commit", duplicate_commit)
+    monkeypatch.setattr(announcement_routes, "announcement_response", lambda item, ack: (item, ack))
+    # Announcement, no ack yet, then the ack the other request stored
+    fake_db.queue(announcement, None, existing)
+
+    result = announcement_routes.acknowledge_announcement(
+        "ann-1", current_user=SimpleNamespace(id="agent-1"), db=fake_db
+    )
+
+    assert result == (announcement, existing) and fake_db.rollbacks == 1
+
+
+def test_acknowledgement_still_fails_when_no_ack_was_stored(monkeypatch, fake_db):
+    def broken_commit():
+        raise IntegrityError("INSERT INTO announcement_acks", {}, Exception("foreign key"))
+
+    monkeypatch.setattr(fake_db, "commit", broken_commit)
+    fake_db.queue(SimpleNamespace(id="ann-1"), None, None)
+
+    with pytest.raises(IntegrityError):
+        announcement_routes.acknowledge_announcement("ann-1", current_user=SimpleNamespace(id="agent-1"), db=fake_db)
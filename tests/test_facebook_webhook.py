@@ -0,0 +1,68 @@
+import asyncio
+from types import SimpleNamespace
+
+from backend import server
+
+
+class FakeFacebookClient:
+    async def process_webhook_message(self, sender_id, recipient_id, message_data, page_id):
+        return {
+            "sender_id": sender_id,
+            "page_id": page_id,
+            "message_id": message_data.get("mid"),
+            "text": message_data.get("text", ""),
+            "attachments": [],
+            "reply_to": None,
+        }
+
+
+def _payload(mid="m_known"):
+    return {
+        "object": "page",
+        "entry": [{
+            "id": "page-1",
+            "messaging": [{
+                "sender": {"id": "psid-1"},
+                "recipient": {"id": "page-1"},
+                "timestamp": 1735689600000,
+                "message": {"mid": mid, "text": "Hello again"},
+            }],
+        }],
+    }
+
+
+def _patch(monkeypatch, db, known_mids):
+    lookups = []
+
+    def fake_find(db, chat, mid):
+        lookups.append(mid)
+        return SimpleNamespace(id="msg-1") if mid in known_mids else None
+
+    def fail_create(chat, **kwargs):
+        raise AssertionError("duplicate message was stored")
+
+    monkeypatch.setattr(server, "facebook_client", FakeFacebookClient())
+    monkeypatch.setattr(server, "find_message_by_mid", fake_find)
+    monkeypatch.setattr(server, "create_chat_message_record", fail_create)
+    chat = SimpleNamespace(id="chat-1", username="Jane Doe", facebook_user_id="psid-1")
+    db.results = {server.FacebookPage: SimpleNamespace(page_id="page-1"), server.Chat: chat}
+    return lookups
+
+
+def test_live_webhook_skips_known_mid(monkeypatch, fake_db):
+    lookups = _patch(monkeypatch, fake_db, {"m_known"})
+
+    result = asyncio.run(server.process_facebook_webhook_payload(fake_db, _payload()))
+
+    assert result == {"status": "received"}
+    assert lookups == ["m_known"]
+    assert len(fake_db.added) == 1 and fake_db.commits == 1
+
+
+def test_reconciled_replay_skips_known_mid(monkeypatch, fake_db):
+    lookups = _patch(monkeypatch, fake_db, {"m_known"})
+
+    asyncio.run(server.process_facebook_webhook_payload(fake_db, _payload(), reconciled=True))
+
+    assert lookups == ["m_known"]
+    assert fake_db.added == [] and fake_db.commits == 1
@@ -0,0 +1,146 @@
+import base64
+import json
+import os
+
+import pytest
+
+from backend.utils import field_crypto
+from backend.utils.field_crypto import (
+    EncryptedJSON,
+    EncryptedText,
+    FieldEncryptionError,
+    FieldKeyring,
+    blind_token,
+    decrypt_value,
+    encrypt_value,
+    index_tokens,
+    is_encrypted,
+    key_reference,
+    open_metadata,
+    query_tokens,
+    require_blind_index_for_search,
+    seal_metadata,
+)
+
+
+def _key() -> str:
+    return base64.urlsafe_b64encode(os.urandom(32)).decode()
+
+
+def _ring(active: str, keys: dict, workspace: str = "acme") -> FieldKeyring:
+    config = {workspace: {"active": active, "keys": keys}}
+    return FieldKeyring.from_config(json.dumps(config), workspace, _key())
+
+
+def test_round_trip_and_key_reference():
+    ring = _ring("k1", {"k1": _key()})
+    stored = encrypt_value("call me on 98661 18236", ring)
+    assert is_encrypted(stored)
+    assert "98661" not in stored
+    assert key_reference(stored) == ("acme", "k1")
+    assert decrypt_value(stored, ring) == "call me on 98661 18236"
+
+
+def test_rotation_keeps_old_rows_readable():
+    old_key, new_key = _key(), _key()
+    before = _ring("k1", {"k1": old_key})
+    stored = encrypt_value("hello", before)
+    after = _ring("k2", {"k1": old_key, "k2": new_key})
+    assert decrypt_value(stored, after) == "hello"
+    assert key_reference(encrypt_value("hello", after)) == ("acme", "k2")
+    with pytest.raises(FieldEncryptionError):
+        decrypt_value(stored, _ring("k2", {"k2": new_key}))
+
+
+def test_ciphertext_is_bound_to_workspace_and_key():
+    key = _key()
+    stored = encrypt_value("hello", _ring("k1", {"k1": key}, workspace="acme"))
+    moved = stored.replace("enc:v1:acme:k1:", "enc:v1:other:k1:")
+    with pytest.raises(FieldEncryptionError):
+        decrypt_value(moved, _ring("k1", {"k1": key}, workspace="other"))
+
+
+def test_invalid_configuration_is_rejected():
+    with pytest.raises(FieldEncryptionError):
+        FieldKeyring.from_config(json.dumps({"acme": {"active": "k9", "keys": {"k1": _key()}}}), "acme")
+    with pytest.raises(FieldEncryptionError):
+        FieldKeyring.from_config(json.dumps({"acme": {"active": "k1", "keys": {"k1": "c2hvcnQ="}}}), "acme")
+
+
+def test_types_encrypt_only_when_enabled(monkeypatch):
+    ring = _ring("k1", {"k1": _key()})
+    monkeypatch.setattr(field_crypto, "_keyring", ring)
+    text_type, json_type = EncryptedText(), EncryptedJSON()
+
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", False)
+    assert text_type.process_bind_param("plain", None) == "plain"
+
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", True)
+    stored = text_type.process_bind_param("secret", None)
+    assert is_encrypted(stored)
+    assert text_type.process_result_value(stored, None) == "secret"
+    assert text_type.process_result_value("legacy plaintext", None) == "legacy plaintext"
+
+    document = {"entry": [{"id": "1"}]}
+    stored_json = json_type.process_bind_param(document, None)
+    assert is_encrypted(stored_json)
+    assert json_type.process_result_value(stored_json, None) == document
+
+
+
+def test_text_that_looks_like_ciphertext_is_still_encrypted(monkeypatch):
+    ring = _ring("k1", {"k1": _key()})
+    monkeypatch.setattr(field_crypto, "_keyring", ring)
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", True)
+    text_type = EncryptedText()
+
+    typed = "enc:v1:acme:k1:not really ciphertext"
+    stored = text_type.process_bind_param(typed, None)
+    assert stored != typed and is_encrypted(stored)
+    assert text_type.process_result_value(stored, None) == typed
+
+
+def test_reply_preview_is_sealed_inside_plaintext_metadata(monkeypatch):
+    ring = _ring("k1", {"k1": _key()})
+    monkeypatch.setattr(field_crypto, "_keyring", ring)
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", True)
+    metadata = {"reply_to": "msg-1", "reply_preview": "my number is 98661 18236", "referral": {"ref": "ad-1"}}
+
+    sealed = seal_metadata(metadata)
+    assert is_encrypted(sealed["reply_preview"]) and "98661" not in json.dumps(sealed)
+    assert sealed["reply_to"] == "msg-1" and sealed["referral"] == {"ref": "ad-1"}
+    assert open_metadata(sealed) == metadata
+    assert open_metadata(None) is None
+
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", False)
+    assert seal_metadata(metadata) == metadata
+
+
+def test_index_and_query_tokens():
+    tokens = index_tokens("Hi, I'm Jane: JANE@example.com / +91 98661-18236")
+    assert {"jane", "email:jane@example.com", "phone:9866118236"} <= tokens
+    assert query_tokens("98661 18236") == ["phone:9866118236"]
+    assert query_tokens("Jane@Example.com") == ["email:jane@example.com"]
+    assert query_tokens("goa trip") == ["goa", "trip"]
+
+
+def test_blind_token_is_keyed_per_workspace():
+    index_key = _key()
+    config = json.dumps({"a": {"active": None, "keys": {}}, "b": {"active": None, "keys": {}}})
+    ring_a = FieldKeyring.from_config(config, "a", index_key)
+    ring_b = FieldKeyring.from_config(config, "b", index_key)
+    assert blind_token("jane", ring_a) == blind_token("jane", ring_a)
+    assert blind_token("jane", ring_a) != blind_token("jane", ring_b)
+
+
+def test_search_requires_blind_index_when_encrypted(monkeypatch):
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", True)
+    monkeypatch.setattr(field_crypto, "FIELD_BLIND_INDEX_KEY", "")
+    with pytest.raises(FieldEncryptionError):
+        require_blind_index_for_search()
+
+    monkeypatch.setattr(field_crypto, "FIELD_BLIND_INDEX_KEY", _key())
+    require_blind_index_for_search()
+    monkeypatch.setattr(field_crypto, "FIELD_ENCRYPTION_ENABLED", False)
+    monkeypatch.setattr(field_crypto, "FIELD_BLIND_INDEX_KEY", "")
+    require_blind_index_for_search()
@@ -0,0 +1,149 @@
+import json
+from datetime import datetime, timedelta, timezone
+from types import SimpleNamespace
+
+from backend.routes import lead_scoring
+from backend.routes.lead_scoring import (
+    DEFAULT_LEAD_RULES,
+    LeadSignals,
+    add_message_signals,
+    keyword_classes,
+    lead_tier,
+    mark_inquiry_created,
+    merge_rules,
+    prescore_new_chat,
+    score_inbound_message,
+    score_lead,
+    tier_raised_to_notify,
+)
+from backend.utils.timezone import utc_now
+
+NOW = datetime(2025, 12, 26, 12, 0, tzinfo=timezone.utc)
+
+
+def test_keyword_classes_match_whole_words():
+    assert keyword_classes("What is the price for 12th Jan?", DEFAULT_LEAD_RULES) == {"prices", "dates"}
+    assert keyword_classes("How much for 20/02?", DEFAULT_LEAD_RULES) == {"prices", "dates"}
+    # "rate" must not match inside "celebrate"
+    assert keyword_classes("We want to celebrate", DEFAULT_LEAD_RULES) == set()
+
+
+def test_message_signals_from_referral_and_phone():
+    signals = LeadSignals()
+    ad_meta = json.dumps({"referral": {"source": "ADS", "ad_id": "9001"}})
+    add_message_signals(signals, "Hi, call me on 98661 18236", ad_meta, DEFAULT_LEAD_RULES)
+    add_message_signals(signals, "ok", json.dumps({"referral": {"source": "SHORTLINK"}}), DEFAULT_LEAD_RULES)
+    assert signals.ad_referral == "9001"
+    assert signals.phone_shared
+    assert signals.shortlink_referrals == 1
+    assert signals.customer_messages == 2
+
+
+def test_score_factors_and_tiers():
+    signals = LeadSignals(
+        ad_referral="9001",
+        phone_shared=True,
+        keyword_classes={"prices"},
+        customer_messages=3,
+        last_incoming_at=NOW,
+    )
+    score, factors = score_lead(signals, DEFAULT_LEAD_RULES, NOW)
+    # 25 ad + 20 phone + 15 prices + 6 replies + 20 recency
+    assert score == 86
+    assert factors[0]["signal"] == "ad_referral"
+    assert lead_tier(score, DEFAULT_LEAD_RULES) == "hot"
+
+    stale = LeadSignals(customer_messages=30, last_incoming_at=NOW - timedelta(hours=48))
+    score, factors = score_lead(stale, DEFAULT_LEAD_RULES, NOW)
+    assert {item["signal"]: item["points"] for item in factors} == {"replies": 20, "recency": 10.0}
+    assert lead_tier(score, DEFAULT_LEAD_RULES) == "cold"
+
+
+def test_score_is_capped_and_rules_override():
+    signals = LeadSignals(
+        ad_referral="1", link_clicks=10, phone_shared=True, keyword_classes={"dates", "prices"},
+        customer_messages=50, inquiry_created=True, last_incoming_at=NOW,
+    )
+    assert score_lead(signals, DEFAULT_LEAD_RULES, NOW)[0] == 100
+
+    rules = merge_rules({"tiers": {"hot": 90}, "keywords": {"prices": {"points": 40}}})
+    assert rules["keywords"]["prices"]["terms"] == DEFAULT_LEAD_RULES["keywords"]["prices"]["terms"]
+    assert rules["keywords"]["prices"]["points"] == 40
+    assert lead_tier(85, rules) == "warm"
+
+
+def test_notify_only_when_tier_rises():
+    assert tier_raised_to_notify({"previous_tier": "warm", "tier": "hot"}, DEFAULT_LEAD_RULES)
+    assert not tier_raised_to_notify({"previous_tier": "hot", "tier": "hot"}, DEFAULT_LEAD_RULES)
+    assert not tier_raised_to_notify({"previous_tier": None, "tier": "warm"}, DEFAULT_LEAD_RULES)
+    assert not tier_raised_to_notify(None, DEFAULT_LEAD_RULES)
+
+
+def _chat(**overrides):
+    values = {
+        "id": "chat-1",
+        "lead_signals_json": None,
+        "lead_score": None,
+        "lead_tier": None,
+        "lead_factors_json": None,
+        "lead_scored_at": None,
+        "link_click_count": 0,
+        "last_incoming_at": utc_now(),
+        "inquiry_created_at": None,
+    }
+    values.update(overrides)
+    return SimpleNamespace(**values)
+
+
+def _patch_scoring(monkeypatch, rescans):
+    def fake_collect(db, chat, rules):
+        rescans.append(chat.id)
+        return LeadSignals(inquiry_created=bool(chat.inquiry_created_at), last_incoming_at=chat.last_incoming_at)
+
+    monkeypatch.setattr(lead_scoring, "flag_modified", lambda obj, key: None)
+    monkeypatch.setattr(lead_scoring, "collect_signals", fake_collect)
+
+
+def test_inbound_message_is_folded_into_stored_signals(monkeypatch, fake_db):
+    rescans = []
+    _patch_scoring(monkeypatch, rescans)
+    chat = _chat()
+    ad_meta = json.dumps({"referral": {"source": "ADS", "ad_id": "9001"}})
+
+    prescore_new_chat(fake_db, chat, "What is the price?", ad_meta)
+    change = score_inbound_message(fake_db, chat, "Call me on 98661 18236", None)
+
+    assert rescans == []
+    assert json.loads(chat.lead_signals_json) == {
+        "ad_referral": "9001",
+        "shortlink_referrals": 0,
+        "phone_shared": True,
+        "keyword_classes": ["prices"],
+        "customer_messages": 2,
+    }
+    signals = {factor["signal"] for factor in json.loads(chat.lead_factors_json)}
+    assert signals == {"ad_referral", "phone_shared", "keywords:prices", "replies", "recency"}
+    assert change["previous_tier"] == "warm" and change["tier"] == "hot"
+
+
+def test_inbound_message_without_stored_signals_rescans(monkeypatch, fake_db):
+    rescans = []
+    _patch_scoring(monkeypatch, rescans)
+    chat = _chat()
+
+    score_inbound_message(fake_db, chat, "hello", None)
+
+    assert rescans == ["chat-1"]
+
+
+def test_mark_inquiry_created_returns_the_change(monkeypatch, fake_db):
+    rescans = []
+    _patch_scoring(monkeypatch, rescans)
+    chat = _chat(lead_score=20, lead_tier="cold")
+    fake_db.queue(chat)
+
+    change = mark_inquiry_created(fake_db, "chat-1")
+
+    assert chat.inquiry_created_at is not None and fake_db.commits == 1
+    assert change["previous_score"] == 20 and change["score"] == chat.lead_score
+    assert mark_inquiry_created(fake_db.queue(None), "missing") is None
@@ -0,0 +1,141 @@
+import asyncio
+from types import SimpleNamespace
+
+from backend import server
+from backend.instagram_api import InstagramClient, InstagramMode
+from backend.models import MessagePlatform, MessageSender
+from backend.routes.chat_helpers import MESSAGE_MID_MAX_LENGTH, normalize_message_mid
+from backend.schemas import MessageReplyInfo
+
+
+def _chat(platform=MessagePlatform.INSTAGRAM):
+    return SimpleNamespace(
+        id="chat-1",
+        platform=platform,
+        username="jane_doe",
+        facebook_user=None,
+        facebook_user_id=None,
+        instagram_user=SimpleNamespace(name="Jane Doe"),
+        instagram_user_id="igsid-1",
+    )
+
+
+def _message(sender, content="Original question"):
+    return SimpleNamespace(id="local-1", sender=sender, content=content, mid="m_abc", metadata_json=None)
+
+
+def test_reply_info_from_inbound_message_metadata():
+    info = MessageReplyInfo.from_metadata({
+        "reply_type": "message",
+        "reply_mid": "m_abc",
+        "reply_to": "local-1",
+        "reply_preview": "Hello",
+        "reply_sender": "You",
+        "reply_sender_type": "agent",
+    })
+    assert info.type == "message"
+    assert info.message_id == "local-1"
+    assert info.mid == "m_abc"
+    assert info.sender == "You"
+
+
+def test_reply_info_from_story_reply_metadata():
+    info = MessageReplyInfo.from_metadata({
+        "reply_type": "story",
+        "reply_story": {"id": "story-1", "url": "https://cdn.example/story.jpg"},
+    })
+    assert info.type == "story"
+    assert info.story_id == "story-1"
+    assert info.message_id is None
+
+
+def test_reply_info_absent_without_reply_metadata():
+    assert MessageReplyInfo.from_metadata({"sent_by": {"id": "u1"}}) is None
+    assert MessageReplyInfo.from_metadata(None) is None
+
+
+def test_inbound_reply_to_known_mid_links_local_message(monkeypatch):
+    lookups = []
+
+    def fake_find(db, chat, mid):
+        lookups.append(mid)
+        return _message(MessageSender.INSTAGRAM_USER)
+
+    monkeypatch.setattr(server, "find_message_by_mid", fake_find)
+    metadata = server._build_inbound_reply_metadata(None, _chat(), {"mid": "m_abc"})
+
+    assert lookups == ["m_abc"]
+    assert metadata == {
+        "reply_type": "message",
+        "reply_mid": "m_abc",
+        "reply_to": "local-1",
+        "reply_preview": "Original question",
+        "reply_sender": "Jane Doe",
+        "reply_sender_type": "instagram_user",
+    }
+
+
+def test_inbound_reply_to_agent_message_is_labelled_you(monkeypatch):
+    monkeypatch.setattr(server, "find_message_by_mid", lambda db, chat, mid: _message(MessageSender.AGENT, ""))
+    metadata = server._build_inbound_reply_metadata(None, _chat(MessagePlatform.FACEBOOK), {"mid": "m_abc"})
+
+    assert metadata["reply_sender"] == "You"
+    assert metadata["reply_sender_type"] == "agent"
+    assert metadata["reply_preview"] == "[attachment]"
+
+
+def test_inbound_reply_to_unknown_mid_keeps_mid_for_later(monkeypatch):
+    monkeypatch.setattr(server, "find_message_by_mid", lambda db, chat, mid: None)
+    metadata = server._build_inbound_reply_metadata(None, _chat(), {"mid": "m_missing"})
+
+    assert metadata == {"reply_type": "message", "reply_mid": "m_missing"}
+    info = MessageReplyInfo.from_metadata(metadata)
+    assert info.mid == "m_missing" and info.message_id is None
+
+
+def test_inbound_story_reply_does_not_look_up_messages(monkeypatch):
+    def fail_find(db, chat, mid):
+        raise AssertionError("story replies have no message mid")
+
+    monkeypatch.setattr(server, "find_message_by_mid", fail_find)
+    metadata = server._build_inbound_reply_metadata(
+        None, _chat(), {"story": {"id": "story-1", "url": "https://cdn.example/story.jpg"}}
+    )
+
+    assert metadata["reply_type"] == "story"
+    assert metadata["reply_story"] == {"id": "story-1", "url": "https://cdn.example/story.jpg"}
+    assert metadata["reply_sender_type"] == "instagram_page"
+
+
+def test_inbound_without_reply_reference_adds_nothing():
+    assert server._build_inbound_reply_metadata(None, _chat(), None) == {}
+    assert server._build_inbound_reply_metadata(None, _chat(), {}) == {}
+    assert server._build_inbound_reply_metadata(None, _chat(), {"mid": ""}) == {}
+
+
+def test_long_reply_previews_are_truncated():
+    preview = server._reply_preview_text("x" * 500)
+    assert len(preview) == 200 and preview.endswith("...")
+
+
+def test_overlong_mids_are_hashed_consistently():
+    long_mid = "m_" + "a" * MESSAGE_MID_MAX_LENGTH
+    assert normalize_message_mid(long_mid).startswith("hash:")
+    assert normalize_message_mid(long_mid) == normalize_message_mid(f" {long_mid} ")
+    assert normalize_message_mid(" m_abc ") == "m_abc"
+    assert normalize_message_mid("   ") is None
+
+
+def test_instagram_webhook_keeps_reply_reference():
+    client = InstagramClient(mode=InstagramMode.MOCK)
+    story = asyncio.run(client.process_webhook_message(
+        "igsid-1", "page-1", {"mid": "m_new", "text": "Nice!", "reply_to": {"story": {"id": "story-1"}}}, "acct-1"
+    ))
+    assert story["is_story_reply"] is True
+    assert story["reply_to"] == {"story": {"id": "story-1"}}
+
+    reply = asyncio.run(client.process_webhook_message(
+        "igsid-1", "page-1", {"mid": "m_new", "text": "Yes", "reply_to": {"mid": "m_abc"}}, "acct-1"
+    ))
+    assert reply["is_story_reply"] is False
+    assert reply["reply_to"] == {"mid": "m_abc"}
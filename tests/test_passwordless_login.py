@@ -0,0 +1,44 @@
+from types import SimpleNamespace
+
+from backend.models import UserApprovalStatus
+from backend.routes import auth as auth_routes
+from backend.routes.auth import generate_login_code, hash_login_code, passwordless_allowed
+
+
+def _user(enabled=True, **overrides):
+    values = {
+        "email": "agent@example.com",
+        "is_active": True,
+        "approval_status": UserApprovalStatus.APPROVED,
+        "position": SimpleNamespace(passwordless_login_enabled=enabled),
+    }
+    values.update(overrides)
+    return SimpleNamespace(**values)
+
+
+def test_login_codes_are_six_digits():
+    for _ in range(50):
+        code = generate_login_code()
+        assert len(code) == 6 and code.isdigit()
+
+
+def test_code_hash_is_salted_with_row_id():
+    digest = hash_login_code("row-1", "123456")
+    assert digest == hash_login_code("row-1", "123456")
+    assert digest != hash_login_code("row-2", "123456")
+    assert digest != hash_login_code("row-1", "123457")
+    assert "123456" not in digest
+
+
+def test_passwordless_requires_global_and_position_flag(monkeypatch):
+    monkeypatch.setattr(auth_routes, "PASSWORDLESS_LOGIN_ENABLED", True)
+    assert passwordless_allowed(_user())
+    assert not passwordless_allowed(_user(enabled=False))
+    assert not passwordless_allowed(_user(position=None))
+    assert not passwordless_allowed(_user(email=None))
+    assert not passwordless_allowed(_user(is_active=False))
+    assert not passwordless_allowed(_user(approval_status=UserApprovalStatus.PENDING))
+    assert not passwordless_allowed(None)
+
+    monkeypatch.setattr(auth_routes, "PASSWORDLESS_LOGIN_ENABLED", False)
+    assert not passwordless_allowed(_user())
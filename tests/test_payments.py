@@ -0,0 +1,173 @@
+import asyncio
+import json
+from decimal import Decimal
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException
+
+from backend.models import PaymentRequestStatus
+from backend.payment_providers import LocalPaymentProvider, PaymentWebhookError
+from backend.routes import payments as payment_routes
+from backend.routes.payments import apply_payment_update
+
+
+class FakeManager:
+    def __init__(self):
+        self.sent = []
+
+    async def broadcast_to_users(self, users, message):
+        self.sent.append((set(users), message))
+
+
+def _payment(**overrides):
+    values = {
+        "id": "pay-1",
+        "reference": "INQ42-ABC123",
+        "inquiry_id": "42",
+        "status": PaymentRequestStatus.PENDING,
+        "amount": Decimal("500.00"),
+        "amount_received": None,
+        "currency": "INR",
+        "paid_at": None,
+        "provider_payment_id": "local_1",
+        "payment_url": "http://localhost:8000/api/payments/local/INQ42-ABC123",
+        "confirmation_message_id": None,
+        "created_by": "agent-1",
+        "chat": SimpleNamespace(id="chat-1"),
+    }
+    values.update(overrides)
+    return SimpleNamespace(**values)
+
+
+def _patch(monkeypatch, payment):
+    delivered, synced = [], []
+    manager = FakeManager()
+
+    async def fake_deliver(db, chat, text, **kwargs):
+        delivered.append(text)
+        return SimpleNamespace(id="msg-1")
+
+    async def fake_broadcast(db, chat, message, sender=None):
+        pass
+
+    monkeypatch.setattr(payment_routes, "_find_payment", lambda db, reference, provider_payment_id: payment)
+    monkeypatch.setattr(payment_routes, "deliver_chat_text", fake_deliver)
+    monkeypatch.setattr(payment_routes, "broadcast_chat_message", fake_broadcast)
+    monkeypatch.setattr(payment_routes, "gather_dm_notify_users", lambda db, chat: {"admin-1"})
+    monkeypatch.setattr(payment_routes, "_payment_status_payload", lambda p: {"status": p.status.value})
+    monkeypatch.setattr(payment_routes, "_sync_inquiry_payment", lambda p: synced.append(p.reference))
+    monkeypatch.setattr(payment_routes, "ws_manager", manager)
+    return delivered, synced, manager
+
+
+def _update(status, amount="500.00"):
+    provider = LocalPaymentProvider(secret="s3cret")
+    body = provider.build_callback("INQ42-ABC123", "local_1", status, Decimal(amount), "INR")
+    return provider, provider.parse_webhook(json.loads(body)), body.decode("utf-8")
+
+
+def test_paid_webhook_confirms_and_syncs(monkeypatch, fake_db):
+    payment = _payment()
+    delivered, synced, manager = _patch(monkeypatch, payment)
+    provider, update, raw = _update("paid")
+
+    result, changed = asyncio.run(apply_payment_update(fake_db, provider, update, raw_payload=raw))
+
+    assert result is payment and changed
+    assert payment.status == PaymentRequestStatus.PAID
+    assert payment.amount_received == Decimal("500.00") and payment.paid_at is not None
+    assert payment.confirmation_message_id == "msg-1" and len(delivered) == 1
+    assert synced == ["INQ42-ABC123"]
+    assert manager.sent == [({"admin-1", "agent-1"}, {"status": "paid"})]
+    event = fake_db.added[0]
+    assert event.payment_request_id == "pay-1" and event.note is None
+
+
+def test_paid_with_different_amount_is_flagged(monkeypatch, fake_db):
+    payment = _payment()
+    _patch(monkeypatch, payment)
+    provider, update, raw = _update("paid", amount="450")
+
+    asyncio.run(apply_payment_update(fake_db, provider, update, raw_payload=raw))
+
+    assert payment.amount_received == Decimal("450.00")
+    assert fake_db.added[0].note == "amount mismatch"
+
+
+def test_terminal_payment_is_not_moved_back(monkeypatch, fake_db):
+    payment = _payment(status=PaymentRequestStatus.PAID)
+    delivered, synced, _ = _patch(monkeypatch, payment)
+    provider, update, raw = _update("failed")
+
+    result, changed = asyncio.run(apply_payment_update(fake_db, provider, update, raw_payload=raw))
+
+    assert result is payment and not changed
+    assert payment.status == PaymentRequestStatus.PAID
+    assert fake_db.added[0].note == "ignored; payment already paid"
+    assert delivered == [] and synced == []
+
+
+def test_unknown_reference_is_recorded_as_unmatched(monkeypatch, fake_db):
+    _patch(monkeypatch, None)
+    provider, update, raw = _update("paid")
+
+    assert asyncio.run(apply_payment_update(fake_db, provider, update, raw_payload=raw)) == (None, False)
+    assert fake_db.added[0].note == "unmatched" and fake_db.commits == 1
+
+
+def test_webhook_signature_checks():
+    provider = LocalPaymentProvider(secret="s3cret", dev_mode=False)
+    body = provider.build_callback("INQ42-ABC123", "local_1", "paid", Decimal("500"), "INR")
+    assert provider.verify_webhook(body, {"x-payment-signature": provider.sign(body)})
+    assert not provider.verify_webhook(body, {"x-payment-signature": "0" * 64})
+    assert not provider.verify_webhook(body + b" ", {"x-payment-signature": provider.sign(body)})
+    assert not provider.verify_webhook(body, {})
+
+
+def test_unsigned_webhooks_only_in_dev_mode():
+    body = b'{"reference": "INQ42-ABC123", "status": "paid"}'
+    assert not LocalPaymentProvider(secret="", dev_mode=False).verify_webhook(body, {})
+    assert LocalPaymentProvider(secret="", dev_mode=True).verify_webhook(body, {})
+
+
+def test_malformed_amount_is_rejected():
+    provider = LocalPaymentProvider(secret="s3cret")
+    for amount in ("12,50", "abc", "NaN"):
+        with pytest.raises(PaymentWebhookError):
+            provider.parse_webhook({"reference": "INQ42-ABC123", "status": "paid", "amount": amount})
+
+
+def test_webhook_with_malformed_amount_returns_400(monkeypatch, fake_db):
+    provider = LocalPaymentProvider(secret="s3cret")
+    body = json.dumps({"reference": "INQ42-ABC123", "status": "paid", "amount": "abc"}).encode("utf-8")
+
+    async def read_body():
+        return body
+
+    request = SimpleNamespace(body=read_body, headers={"x-payment-signature": provider.sign(body)})
+    monkeypatch.setattr(payment_routes, "get_payment_provider", lambda name=None: provider)
+
+    with pytest.raises(HTTPException) as exc_info:
+        asyncio.run(payment_routes.handle_payment_webhook("local", request, fake_db))
+    assert exc_info.value.status_code == 400
+    assert fake_db.added == [] and fake_db.commits == 0
+
+
+def test_pending_links_past_expiry_are_marked_expired(monkeypatch, fake_db):
+    payment = _payment()
+    _, synced, manager = _patch(monkeypatch, payment)
+    fake_db.queue([payment])
+
+    assert asyncio.run(payment_routes.expire_stale_payments(fake_db)) == 1
+    assert payment.status == PaymentRequestStatus.EXPIRED and fake_db.commits == 1
+    assert synced == ["INQ42-ABC123"]
+    assert manager.sent == [({"admin-1", "agent-1"}, {"status": "expired"})]
+
+
+def test_expiry_sweep_without_stale_links_does_nothing(monkeypatch, fake_db):
+    _, synced, manager = _patch(monkeypatch, None)
+    fake_db.queue([])
+
+    assert asyncio.run(payment_routes.expire_stale_payments(fake_db)) == 0
+    assert fake_db.commits == 0 and synced == [] and manager.sent == []
@@ -0,0 +1,18 @@
+from backend.routes.queue_notices import detect_locale, estimate_wait_minutes, load_queue_templates
+
+
+def test_estimate_wait_uses_recent_handling_rate():
+    # 12 chats answered in the last hour -> one every 5 minutes.
+    assert estimate_wait_minutes(3, 12 / 60.0, 2) == 15
+    assert estimate_wait_minutes(1, 12 / 60.0, 2) == 5
+
+
+def test_estimate_wait_falls_back_to_available_agents():
+    assert estimate_wait_minutes(4, 0, 2) == 15  # 4 * 6 / 2 = 12 -> rounded up
+    assert estimate_wait_minutes(4, 0, 0) == 25  # no agents counts as one
+
+
+def test_detect_locale_prefers_customer_script():
+    templates = load_queue_templates()
+    assert detect_locale("नमस्ते, मुझे जानकारी चाहिए", templates) == "hi"
+    assert detect_locale("Hello there", templates) == "en"
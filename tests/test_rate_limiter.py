@@ -0,0 +1,55 @@
+from types import SimpleNamespace
+
+from backend.rate_limiter import (
+    RateLimiter,
+    api_key_fingerprint,
+    api_key_rule_subject,
+    parse_limit,
+    resolve_route_group,
+    sliding_window_count,
+)
+
+
+def test_parse_limit():
+    limit = parse_limit("30/60")
+    assert limit.limit == 30
+    assert limit.window_seconds == 60
+    assert parse_limit("abc") is None
+    assert parse_limit("0/60") is None
+
+
+def test_sliding_window_weights_previous_window():
+    # Halfway through the window, half of the previous window still counts.
+    assert sliding_window_count(10, 4, 60, 30) == 9
+    assert sliding_window_count(10, 4, 60, 60) == 4
+
+
+def test_resolve_route_group():
+    assert resolve_route_group("POST", "/api/auth/login") == "auth"
+    assert resolve_route_group("POST", "/api/chats/abc/message") == "messaging"
+    assert resolve_route_group("GET", "/api/chats") == "chats"
+    assert resolve_route_group("GET", "/api/dashboard/stats") == "default"
+
+
+def _request(headers):
+    return SimpleNamespace(headers=headers, client=SimpleNamespace(host="203.0.113.7"))
+
+
+def test_unregistered_api_keys_fall_back_to_ip(monkeypatch):
+    limiter = RateLimiter(store=object())
+    registered = SimpleNamespace(scope="api_key", subject=api_key_fingerprint("partner-key"), route_group="*")
+    monkeypatch.setattr(limiter, "_load_rules", lambda: [registered])
+
+    for random_key in ("a1", "b2", "c3"):
+        identity = limiter.identify(_request({"x-api-key": random_key}))
+        assert identity.kind == "ip" and identity.key == "ip:203.0.113.7"
+
+    identity = limiter.identify(_request({"x-api-key": " partner-key "}))
+    assert identity.kind == "api_key"
+    assert identity.key == f"key:{api_key_fingerprint('partner-key')}"
+
+
+def test_api_key_rule_subject_is_the_fingerprint():
+    fingerprint = api_key_fingerprint("partner-key")
+    assert api_key_rule_subject(" partner-key ") == fingerprint
+    assert api_key_rule_subject(fingerprint) == fingerprint
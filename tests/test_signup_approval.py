@@ -0,0 +1,114 @@
+import asyncio
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException
+
+from backend.models import UserApprovalStatus, UserRole
+from backend.routes import auth as auth_routes
+from backend.routes import dependencies
+from backend.routes import signup_approvals as signup_routes
+
+
+def _user(status, **overrides):
+    values = {
+        "id": "user-1",
+        "email": "new.agent@example.com",
+        "name": "New Agent",
+        "password_hash": "hash",
+        "role": UserRole.AGENT,
+        "is_active": True,
+        "can_receive_new_chats": True,
+        "approval_status": status,
+        "approval_note": None,
+        "approval_decided_by": None,
+        "approval_decided_at": None,
+        "position_id": None,
+    }
+    values.update(overrides)
+    return SimpleNamespace(**values)
+
+
+def _login(monkeypatch, db, user):
+    monkeypatch.setattr(auth_routes, "_find_user_by_identifier", lambda db, identifier: user)
+    monkeypatch.setattr(auth_routes, "verify_password", lambda password, hashed: True)
+    monkeypatch.setattr(auth_routes, "_token_response", lambda u: SimpleNamespace(user=u))
+    credentials = SimpleNamespace(identifier=user.email, password="secret123")
+    return auth_routes.login(credentials, db)
+
+
+def _current_user(monkeypatch, db, user):
+    monkeypatch.setattr(dependencies, "decode_access_token", lambda token: {"user_id": user.id})
+    monkeypatch.setattr(dependencies, "_annotate_user", lambda u: u)
+    return asyncio.run(dependencies.get_current_user(authorization="Bearer token", db=db.queue(user)))
+
+
+def test_unapproved_users_cannot_log_in(monkeypatch, fake_db):
+    for status in (UserApprovalStatus.PENDING, UserApprovalStatus.REJECTED):
+        with pytest.raises(HTTPException) as exc:
+            _login(monkeypatch, fake_db, _user(status))
+        assert exc.value.status_code == 403
+
+
+def test_unapproved_users_tokens_are_refused(monkeypatch, fake_db):
+    for status in (UserApprovalStatus.PENDING, UserApprovalStatus.REJECTED):
+        with pytest.raises(HTTPException) as exc:
+            _current_user(monkeypatch, fake_db, _user(status))
+        assert exc.value.status_code == 403
+
+
+def test_approved_users_log_in_and_resolve(monkeypatch, fake_db):
+    user = _user(UserApprovalStatus.APPROVED)
+    assert _login(monkeypatch, fake_db, user).user is user
+    assert _current_user(monkeypatch, fake_db, user) is user
+
+
+def _decide(monkeypatch, user):
+    decisions, audits = [], []
+    monkeypatch.setattr(signup_routes, "_get_pending_user", lambda db, user_id: user)
+    monkeypatch.setattr(signup_routes, "_annotate_user", lambda u: u)
+    monkeypatch.setattr(signup_routes, "UserResponse", SimpleNamespace(model_validate=lambda u: u))
+    monkeypatch.setattr(signup_routes, "record_audit", lambda db, actor, action, *args: audits.append(action))
+    monkeypatch.setattr(
+        signup_routes,
+        "_notify_decision",
+        lambda u, approved, reason: decisions.append((approved, reason)),
+    )
+    return decisions, audits
+
+
+def test_approve_moves_pending_user_to_approved(monkeypatch, fake_db):
+    user = _user(UserApprovalStatus.PENDING)
+    decisions, audits = _decide(monkeypatch, user)
+    position = SimpleNamespace(id="pos-agent", slug="agent")
+    admin = SimpleNamespace(id="admin-1")
+    db = fake_db.queue(position)
+    payload = SimpleNamespace(position_id="pos-agent", note="welcome")
+
+    result = signup_routes.approve_signup("user-1", payload, current_user=admin, db=db)
+
+    assert result is user and db.commits == 1
+    assert user.approval_status == UserApprovalStatus.APPROVED
+    assert user.position_id == "pos-agent" and user.role == UserRole.AGENT
+    assert user.approval_decided_by == "admin-1" and user.approval_decided_at is not None
+    assert audits == ["signup.approve"] and decisions == [(True, None)]
+
+
+def test_reject_moves_pending_user_to_rejected(monkeypatch, fake_db):
+    user = _user(UserApprovalStatus.PENDING)
+    decisions, audits = _decide(monkeypatch, user)
+    admin = SimpleNamespace(id="admin-1")
+    payload = SimpleNamespace(reason="Unknown applicant")
+
+    signup_routes.reject_signup("user-1", payload, current_user=admin, db=fake_db)
+
+    assert user.approval_status == UserApprovalStatus.REJECTED
+    assert user.approval_note == "Unknown applicant" and user.can_receive_new_chats is False
+    assert audits == ["signup.reject"] and decisions == [(False, "Unknown applicant")]
+
+
+def test_decisions_only_apply_to_pending_signups(fake_db):
+    fake_db.queue(_user(UserApprovalStatus.APPROVED))
+    with pytest.raises(HTTPException) as exc:
+        signup_routes._get_pending_user(fake_db, "user-1")
+    assert exc.value.status_code == 400
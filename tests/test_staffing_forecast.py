@@ -0,0 +1,48 @@
+from datetime import date, datetime, timedelta, timezone
+
+from backend.routes.staffing import load_holidays, project_hourly, scheduled_agents, seasonal_profile, split_contacts
+from backend.utils.erlang import erlang_c, required_agents, service_level
+
+
+def test_erlang_c_textbook_example():
+    # 100 contacts per 30 min, 180s handle time -> 10 Erlangs.
+    assert round(erlang_c(10, 11), 3) == 0.682
+    assert round(service_level(10, 14, 180, 20), 3) == 0.888
+    assert required_agents(100, 180, 1800, 20, 0.8) == 14
+    assert required_agents(0, 180, 1800, 20, 0.8) == 0
+
+
+def test_split_contacts_uses_gap_and_agent_replies():
+    t0 = datetime(2025, 1, 6, 10, 0, tzinfo=timezone.utc)
+    events = [
+        ("c1", t0, True),
+        ("c1", t0 + timedelta(minutes=1), True),
+        ("c1", t0 + timedelta(minutes=4), False),
+        ("c1", t0 + timedelta(minutes=9), False),
+        ("c1", t0 + timedelta(hours=2), True),
+        ("c2", t0, False),
+    ]
+    contacts = split_contacts(events, gap_seconds=1800, max_handle_seconds=3600)
+    assert len(contacts) == 2
+    first = min(contacts, key=lambda c: c["start"])
+    assert first["first_response_seconds"] == 240
+    assert first["handle_seconds"] == 540
+    assert max(contacts, key=lambda c: c["start"])["first_response_seconds"] is None
+
+
+def test_profile_skips_holidays_and_projection_applies_factor():
+    # Mondays 2025-01-06 and 2025-01-13 (IST 10:00 == 04:30 UTC); the 13th is a holiday.
+    stamps = [datetime(2025, 1, 6, 4, 30, tzinfo=timezone.utc)] * 4 + [datetime(2025, 1, 13, 4, 30, tzinfo=timezone.utc)]
+    holidays = load_holidays('{"2025-01-13": 0.5, "2025-01-20": 0.5}')
+    profile = seasonal_profile(stamps, date(2025, 1, 6), date(2025, 1, 20), holidays, decay=1.0)
+    assert profile[(0, 10)] == 4.0
+
+    start = datetime(2025, 1, 20, 4, 30, tzinfo=timezone.utc)
+    (_, volume, factor), = project_hourly(profile, start, 1, holidays)
+    assert factor == 0.5 and volume == 2.0
+
+
+def test_scheduled_agents_counts_partial_overlap():
+    start = datetime(2025, 1, 6, 10, 0, tzinfo=timezone.utc)
+    shifts = [(start - timedelta(hours=1), start + timedelta(hours=1)), (start + timedelta(minutes=30), start + timedelta(hours=3))]
+    assert scheduled_agents(shifts, start, 3600) == 1.5
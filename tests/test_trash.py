@@ -0,0 +1,116 @@
+from datetime import datetime, timedelta, timezone
+from types import SimpleNamespace
+
+import pytest
+from fastapi import HTTPException
+
+from backend.models import Chat, MessagePlatform, UserRole
+from backend.routes import trash as trash_routes
+from backend.routes.trash import TRASH_TYPES, can_access_trash, purge_after
+from backend.settings import TRASH_RETENTION_DAYS
+from backend.utils.timezone import utc_now
+
+
+ADMIN = SimpleNamespace(id="admin-1", email="admin@example.com", role=UserRole.ADMIN, position=None)
+
+
+def _trashed_chat(**overrides):
+    values = {
+        "id": "chat-1",
+        "username": "Jane Doe",
+        "platform": MessagePlatform.FACEBOOK,
+        "facebook_user_id": "psid-1",
+        "instagram_user_id": None,
+        "facebook_page_id": "page-1",
+        "assigned_to": None,
+        "last_message": "Hello",
+        "deleted_at": utc_now() - timedelta(days=1),
+        "deleted_by": "admin-1",
+    }
+    values.update(overrides)
+    return Chat(**values)
+
+
+def _record_audits(monkeypatch):
+    audits = []
+    monkeypatch.setattr(
+        trash_routes,
+        "record_audit",
+        lambda db, actor, action, entity_type, entity_id=None, details=None: audits.append((action, entity_id)),
+    )
+    return audits
+
+
+def test_purge_after_uses_retention():
+    deleted_at = datetime(2025, 1, 1, tzinfo=timezone.utc)
+    assert purge_after(deleted_at) == deleted_at + timedelta(days=TRASH_RETENTION_DAYS)
+    assert purge_after(None) is None
+
+
+def test_admin_only_types_reject_agents():
+    agent = SimpleNamespace(role=UserRole.AGENT, position=None)
+    admin = SimpleNamespace(role=UserRole.ADMIN, position=None)
+    admin_only = [name for name, config in TRASH_TYPES.items() if config.get("admin_only")]
+    assert "templates" in admin_only and "chats" in admin_only
+    for entity_type in admin_only:
+        assert can_access_trash(admin, entity_type)
+        assert not can_access_trash(agent, entity_type)
+    assert can_access_trash(admin, "positions")
+
+
+def test_soft_delete_marks_row_and_audits(monkeypatch, fake_db):
+    audits = _record_audits(monkeypatch)
+    chat = _trashed_chat(deleted_at=None, deleted_by=None)
+
+    trash_routes.soft_delete(fake_db, chat, ADMIN, "chat", {"username": chat.username})
+
+    assert chat.deleted_at is not None and chat.deleted_by == "admin-1"
+    assert audits == [("chat.delete", "chat-1")]
+
+
+def test_restore_brings_chat_back(monkeypatch, fake_db):
+    audits = _record_audits(monkeypatch)
+    monkeypatch.setattr(trash_routes, "TrashItemResponse", SimpleNamespace)
+    chat = _trashed_chat()
+    db = fake_db.queue(chat, None)
+
+    item = trash_routes.restore_trash_item("chats", "chat-1", current_user=ADMIN, db=db)
+
+    assert item.id == "chat-1" and item.deleted_at is None
+    assert chat.deleted_at is None and chat.deleted_by is None
+    assert audits == [("chat.restore", "chat-1")] and db.commits == 1
+
+
+def test_restore_is_refused_when_customer_has_another_chat(monkeypatch, fake_db):
+    audits = _record_audits(monkeypatch)
+    chat = _trashed_chat()
+    db = fake_db.queue(chat, ("chat-2",))
+
+    with pytest.raises(HTTPException) as exc:
+        trash_routes.restore_trash_item("chats", "chat-1", current_user=ADMIN, db=db)
+
+    assert exc.value.status_code == 409
+    assert chat.deleted_at is not None and audits == [] and db.commits == 0
+
+
+def test_customer_writing_again_reattaches_trashed_chat(monkeypatch, fake_db):
+    audits = _record_audits(monkeypatch)
+    chat = _trashed_chat()
+
+    restored = trash_routes.reattach_trashed_chat(
+        fake_db.queue(chat), MessagePlatform.FACEBOOK, "page-1", facebook_user_id="psid-1"
+    )
+
+    assert restored is chat
+    assert chat.deleted_at is None and chat.deleted_by is None
+    assert audits == [("chat.restore", "chat-1")]
+
+
+def test_reattach_without_trashed_chat_does_nothing(monkeypatch, fake_db):
+    audits = _record_audits(monkeypatch)
+    missing = trash_routes.reattach_trashed_chat(
+        fake_db.queue(None), MessagePlatform.INSTAGRAM, "acct-1", instagram_user_id="igsid-1"
+    )
+    no_contact = trash_routes.reattach_trashed_chat(fake_db.queue(_trashed_chat()), MessagePlatform.FACEBOOK, "page-1")
+    assert missing is None and no_contact is None
+    assert audits == []
@@ -0,0 +1,52 @@
+from types import SimpleNamespace
+
+from backend.models import MessagePlatform
+from backend.routes.volume_anomalies import (
+    KIND_DROP,
+    KIND_SPIKE,
+    classify_anomaly,
+    parse_scope,
+    resolve_setting,
+    scope_key,
+    score_window,
+    sensitivity_threshold,
+)
+
+
+def test_scope_round_trip():
+    assert scope_key(MessagePlatform.FACEBOOK, "123") == "facebook:123"
+    assert parse_scope("facebook:123") == (MessagePlatform.FACEBOOK, "123")
+    assert parse_scope("instagram") == (MessagePlatform.INSTAGRAM, None)
+
+
+def test_sensitivity_presets_and_numbers():
+    assert sensitivity_threshold("high") == 2.0
+    assert sensitivity_threshold("2.5") == 2.5
+    assert sensitivity_threshold("bogus") == 3.0
+
+
+def test_spike_and_drop_detection():
+    baseline = [40, 44, 38, 42, 41, 39, 43]
+    expected, score = score_window(120, baseline)
+    assert classify_anomaly(120, expected, score, 3.0, min_spike_count=20) == KIND_SPIKE
+
+    expected, score = score_window(0, baseline)
+    assert classify_anomaly(0, expected, score, 3.0, min_drop_expected=10) == KIND_DROP
+    assert classify_anomaly(0, expected, score, 3.0, drops_enabled=False) is None
+
+
+def test_quiet_pages_do_not_alert():
+    expected, score = score_window(4, [0, 1, 0, 0, 1, 0, 0])
+    assert classify_anomaly(4, expected, score, 3.0, min_spike_count=20) is None
+    expected, score = score_window(0, [2, 1, 3, 2, 1, 2, 2])
+    assert classify_anomaly(0, expected, score, 3.0, min_drop_expected=10) is None
+
+
+def test_page_settings_fall_back_to_platform():
+    platform = SimpleNamespace(sensitivity="high", spikes_enabled=False, drops_enabled=True)
+    page = SimpleNamespace(sensitivity=None, spikes_enabled=None, drops_enabled=False)
+    assert resolve_setting("sensitivity", page, platform) == "high"
+    assert resolve_setting("spikes_enabled", page, platform, default=True) is False
+    assert resolve_setting("drops_enabled", page, platform, default=True) is False
+    assert resolve_setting("sensitivity", None, platform) == "high"
+    assert resolve_setting("drops_enabled", None, None, default=True) is True
@@ -0,0 +1,29 @@
+from datetime import datetime, timezone
+
+from backend.routes.webhook_reconciliation import expected_in_window, hourly_profile, is_unusually_silent
+
+
+def _at(day, hour, minute=0):
+    return datetime(2025, 1, day, hour, minute, tzinfo=timezone.utc)
+
+
+def test_hourly_profile_averages_over_baseline_days():
+    timestamps = [_at(1, 10), _at(1, 10, 30), _at(2, 10, 15), _at(2, 14)]
+    profile = hourly_profile(timestamps, baseline_days=2)
+    assert profile[10] == 1.5
+    assert profile[14] == 0.5
+    assert profile[3] == 0
+
+
+def test_expected_in_window_prorates_partial_hours():
+    profile = [0.0] * 24
+    profile[10] = 4.0
+    profile[11] = 2.0
+    assert expected_in_window(profile, _at(5, 10, 30), _at(5, 11, 30)) == 3.0
+    assert expected_in_window(profile, _at(5, 12), _at(5, 14)) == 0.0
+
+
+def test_silence_requires_meaningful_expected_volume():
+    assert is_unusually_silent(expected=6.0, observed=0, min_expected=5.0)
+    assert not is_unusually_silent(expected=6.0, observed=1, min_expected=5.0)
+    assert not is_unusually_silent(expected=2.0, observed=0, min_expected=5.0)
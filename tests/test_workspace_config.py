@@ -0,0 +1,82 @@
+import json
+from types import SimpleNamespace
+
+import pytest
+
+from backend.models import UserRole
+from backend.routes.workspace_config import (
+    ACTION_CREATE,
+    ACTION_UNCHANGED,
+    CONFIG_VERSION,
+    apply_config,
+    dump_config,
+    field_changes,
+    parse_config,
+    plan_config,
+    plan_summary,
+    select_sections,
+    validate_document,
+)
+
+
+def test_field_changes_only_lists_differences():
+    current = {"name": "Agent", "permissions": ["chat:message"], "passwordless_login_enabled": False}
+    desired = {"name": "Agent", "permissions": ["chat:assign", "chat:message"]}
+    assert field_changes(current, desired) == {
+        "permissions": {"from": ["chat:message"], "to": ["chat:assign", "chat:message"]}
+    }
+    assert field_changes(current, {"name": "Agent"}) == {}
+    # Explicit keys also report fields dropped from the desired values
+    assert field_changes({"a": 1, "b": 2}, {"a": 1}, ["a", "b"]) == {"b": {"from": 2, "to": None}}
+
+
+def test_json_round_trip_and_section_checks():
+    document = {"version": CONFIG_VERSION, "rate_limits": [{"route_group": "auth", "limit": 20, "window_seconds": 60}]}
+    assert parse_config(dump_config(document, "json")) == document
+    assert validate_document(json.loads(dump_config(document, "json"))) == {"rate_limits": document["rate_limits"]}
+    assert validate_document(document, ["positions"]) == {}
+
+    with pytest.raises(ValueError):
+        validate_document({"version": 2})
+    with pytest.raises(ValueError):
+        validate_document({"version": CONFIG_VERSION, "users": []})
+    with pytest.raises(ValueError):
+        validate_document({"version": CONFIG_VERSION, "positions": {"slug": "agent"}})
+    with pytest.raises(ValueError):
+        select_sections(["positions", "secrets"])
+
+
+def test_plan_summary_counts_actions():
+    entries = [{"action": "create"}, {"action": "unchanged"}, {"action": "unchanged"}]
+    assert plan_summary(entries) == {"create": 1, "unchanged": 2}
+
+
+def test_apply_then_replan_reports_only_unchanged(fake_db):
+    document = {
+        "version": CONFIG_VERSION,
+        "positions": [{
+            "slug": "support-lead",
+            "name": "Support Lead",
+            "description": "Handles escalations\n",
+            "permissions": ["chat:message", "chat:assign"],
+            "passwordless_login_enabled": True,
+        }],
+        "templates": [{"platform": "INSTAGRAM", "name": "Welcome", "category": "greeting", "content": "Hi there! "}],
+        "rate_limits": [{"route_group": "auth", "limit": 20, "window_seconds": 60, "note": "login attempts"}],
+    }
+    sections = ["positions", "templates", "rate_limits"]
+    actor = SimpleNamespace(id="admin-1", email="admin@example.com", name="Admin", role=UserRole.ADMIN, position=None)
+
+    assert {entry["action"] for entry in plan_config(fake_db, document, actor, sections)} == {ACTION_CREATE}
+    assert fake_db.added == []
+
+    # Rows added by apply are what the second plan reads back.
+    applied = apply_config(fake_db, document, actor, sections)
+    assert plan_summary(applied) == {ACTION_CREATE: 3} and fake_db.commits == 1
+
+    replanned = plan_config(fake_db, document, actor, sections)
+    assert [(entry["section"], entry["action"]) for entry in replanned] == [
+        ("positions", ACTION_UNCHANGED),
+        ("templates", ACTION_UNCHANGED),
+        ("rate_limits", ACTION_UNCHANGED),
+    ]